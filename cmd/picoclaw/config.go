@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func configHelp() {
+	fmt.Println("Usage: picoclaw config <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  validate [file]          Check config.json (or file) for unknown keys and invalid settings")
+	fmt.Println("  show                     Print the effective config, secrets masked")
+	fmt.Println("  get <path>               Print one setting, e.g. channels.telegram.enabled")
+	fmt.Println("  set <path> <value>       Change one setting in config.json")
+	fmt.Println("  schema                   Print the JSON Schema of config.json")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --reveal                 show, get: print secrets in full")
+	fmt.Println()
+	fmt.Println("Paths are dotted keys. Array indices and keys containing dots go in")
+	fmt.Println("brackets: agents.list[0].model, usage.prices[gpt-4.1].input_per_million.")
+	fmt.Println("Values are parsed as JSON when possible, so true, 8080 and [\"a\",\"b\"] keep")
+	fmt.Println("their types. A running gateway picks up changes made by set.")
+}
+
+func configCmd() {
+	if len(os.Args) < 3 {
+		configHelp()
+		return
+	}
+	subcommand := os.Args[2]
+
+	reveal := false
+	var positional []string
+	for _, arg := range os.Args[3:] {
+		switch arg {
+		case "--reveal":
+			reveal = true
+		case "-h", "--help":
+			configHelp()
+			return
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	wantArgs := map[string][2]int{"validate": {0, 1}, "show": {0, 0}, "get": {1, 1}, "set": {2, 2}, "schema": {0, 0}}
+	n, known := wantArgs[subcommand]
+	if !known {
+		fmt.Printf("Unknown config command: %s\n", subcommand)
+		configHelp()
+		return
+	}
+	if len(positional) < n[0] || len(positional) > n[1] {
+		usage := map[string]string{"validate": "[file]", "get": "<path> [--reveal]", "set": "<path> <value>"}
+		fmt.Printf("Usage: picoclaw config %s %s\n", subcommand, usage[subcommand])
+		os.Exit(1)
+	}
+
+	switch subcommand {
+	case "validate":
+		path := getConfigPath()
+		if len(positional) == 1 {
+			path = positional[0]
+		}
+		configValidateCmd(path)
+	case "show":
+		configShowCmd(reveal)
+	case "get":
+		configGetCmd(positional[0], reveal)
+	case "set":
+		configSetCmd(positional[0], positional[1])
+	case "schema":
+		data, err := json.MarshalIndent(config.ConfigSchema(), "", "  ")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	}
+}
+
+func configValidateCmd(path string) {
+	_, issues, err := config.ValidateFile(path)
+	if err != nil {
+		fmt.Printf("✗ %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	printIssues(issues)
+
+	errors := 0
+	for _, issue := range issues {
+		if issue.Severity == config.SeverityError {
+			errors++
+		}
+	}
+	if errors > 0 {
+		fmt.Printf("\n%s: %d errors, %d warnings\n", path, errors, len(issues)-errors)
+		os.Exit(1)
+	}
+	if len(issues) > 0 {
+		fmt.Printf("\n✓ %s is valid, with %d warnings\n", path, len(issues))
+		return
+	}
+	fmt.Printf("✓ %s is valid\n", path)
+}
+
+func printIssues(issues []config.Issue) {
+	for _, issue := range issues {
+		mark := "⚠"
+		if issue.Severity == config.SeverityError {
+			mark = "✗"
+		}
+		fmt.Printf("%s %s: %s\n", mark, issue.Path, issue.Message)
+	}
+}
+
+// effectiveConfig returns the loaded config, environment overrides
+// included, as a document with secrets masked unless reveal is set.
+func effectiveConfig(reveal bool) *config.Document {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	doc, err := config.NewDocument(cfg)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !reveal {
+		doc.MaskSecrets()
+	}
+	return doc
+}
+
+func configShowCmd(reveal bool) {
+	data, err := effectiveConfig(reveal).Marshal()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(string(data))
+}
+
+func configGetCmd(path string, reveal bool) {
+	if config.ConfigSchema().Lookup(path) == nil {
+		fmt.Printf("Error: unknown config key %s\n", path)
+		os.Exit(1)
+	}
+	value, err := effectiveConfig(reveal).Get(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// Strings are printed bare so scripts can use them directly
+	if s, ok := value.(string); ok {
+		fmt.Println(s)
+		return
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+// configSetCmd edits one setting in config.json, keeping the order of the
+// other keys. The change is refused if it makes the config invalid.
+func configSetCmd(path, text string) {
+	schema := config.ConfigSchema().Lookup(path)
+	if schema == nil {
+		fmt.Printf("Error: unknown config key %s\n", path)
+		os.Exit(1)
+	}
+
+	configPath := getConfigPath()
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		data, err = []byte("{}"), nil
+	}
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+	doc, err := config.ParseDocument(data)
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	value := config.ParseValue(text, schema)
+	if err := doc.Set(path, value); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	updated, err := doc.Marshal()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Only refuse problems the change introduces, not ones already there
+	known := make(map[string]bool)
+	if _, before, err := config.ValidateJSON(data); err == nil {
+		for _, issue := range before {
+			known[issue.String()] = true
+		}
+	}
+	_, after, err := config.ValidateJSON(updated)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	var introduced []config.Issue
+	for _, issue := range after {
+		if !known[issue.String()] {
+			introduced = append(introduced, issue)
+		}
+	}
+	printIssues(introduced)
+	if config.HasErrors(introduced) {
+		fmt.Println("Error: config not changed")
+		os.Exit(1)
+	}
+
+	if err := doc.Save(configPath); err != nil {
+		fmt.Printf("Error writing config: %v\n", err)
+		os.Exit(1)
+	}
+	shown, _ := json.Marshal(value)
+	if schema.Secret {
+		masked := "****"
+		if s, ok := value.(string); ok {
+			masked = config.MaskSecret(s)
+		}
+		shown, _ = json.Marshal(masked)
+	}
+	fmt.Printf("✓ Set %s = %s\n", path, shown)
+}
@@ -18,11 +18,15 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/sipeed/picoclaw/pkg/agent"
+	"github.com/sipeed/picoclaw/pkg/api"
+	"github.com/sipeed/picoclaw/pkg/approval"
 	"github.com/sipeed/picoclaw/pkg/auth"
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/channels"
@@ -35,8 +39,9 @@ import (
 	"github.com/sipeed/picoclaw/pkg/migrate"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/skills"
-	"github.com/sipeed/picoclaw/pkg/state"
+	"github.com/sipeed/picoclaw/pkg/storage"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
@@ -53,6 +58,10 @@ var (
 
 const logo = "🦞"
 
+// configWatchInterval is how often the gateway checks config.json for
+// changes when gateway.watch_config is set.
+const configWatchInterval = 2 * time.Second
+
 // formatVersion returns the version string with optional git commit
 func formatVersion() string {
 	v := version
@@ -142,6 +151,16 @@ func main() {
 		authCmd()
 	case "cron":
 		cronCmd()
+	case "usage":
+		usageCmd()
+	case "trace":
+		traceCmd()
+	case "sessions":
+		sessionsCmd()
+	case "config":
+		configCmd()
+	case "mcp":
+		mcpCmd()
 	case "skills":
 		if len(os.Args) < 3 {
 			skillsHelp()
@@ -158,6 +177,7 @@ func main() {
 
 		workspace := cfg.WorkspacePath()
 		installer := skills.NewSkillInstaller(workspace)
+		installer.SetRegistries(cfg.Skills.Registries, cfg.Skills.RegistryURL)
 		// 获取全局配置目录和内置 skills 目录
 		globalDir := filepath.Dir(getConfigPath())
 		globalSkillsDir := filepath.Join(globalDir, "skills")
@@ -166,9 +186,13 @@ func main() {
 
 		switch subcommand {
 		case "list":
-			skillsListCmd(skillsLoader)
+			skillsListCmd(skillsLoader, workspace)
 		case "install":
 			skillsInstallCmd(installer)
+		case "update":
+			skillsUpdateCmd(installer)
+		case "outdated":
+			skillsOutdatedCmd(installer)
 		case "remove", "uninstall":
 			if len(os.Args) < 4 {
 				fmt.Println("Usage: picoclaw skills remove <skill-name>")
@@ -211,6 +235,11 @@ func printHelp() {
 	fmt.Println("  gateway     Start picoclaw gateway")
 	fmt.Println("  status      Show picoclaw status")
 	fmt.Println("  cron        Manage scheduled tasks")
+	fmt.Println("  usage       Show token usage and cost")
+	fmt.Println("  trace       Inspect and replay agent turns")
+	fmt.Println("  sessions    List, show, export, delete and fork chat sessions")
+	fmt.Println("  config      Validate, show and edit config.json")
+	fmt.Println("  mcp         Offer the agent's tools to MCP clients (mcp serve)")
 	fmt.Println("  migrate     Migrate from OpenClaw to PicoClaw")
 	fmt.Println("  skills      Manage skills (install, list, remove)")
 	fmt.Println("  version     Show version information")
@@ -373,6 +402,7 @@ func migrateHelp() {
 func agentCmd() {
 	message := ""
 	sessionKey := "cli:default"
+	agentName := config.DefaultAgentName
 
 	args := os.Args[2:]
 	for i := 0; i < len(args); i++ {
@@ -390,6 +420,11 @@ func agentCmd() {
 				sessionKey = args[i+1]
 				i++
 			}
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentName = args[i+1]
+				i++
+			}
 		}
 	}
 
@@ -399,14 +434,35 @@ func agentCmd() {
 		os.Exit(1)
 	}
 
-	provider, err := providers.CreateProvider(cfg)
+	settings, ok := cfg.AgentSettings(agentName)
+	if !ok {
+		fmt.Printf("Error: agent %q is not configured in agents.list\n", agentName)
+		os.Exit(1)
+	}
+
+	provider, err := providers.CreateProviderFor(cfg, settings.Provider, settings.Model)
 	if err != nil {
 		fmt.Printf("Error creating provider: %v\n", err)
 		os.Exit(1)
 	}
 
 	msgBus := bus.NewMessageBus()
-	agentLoop := agent.NewAgentLoop(cfg, msgBus, provider)
+	var agentLoop *agent.AgentLoop
+	if agentName == config.DefaultAgentName {
+		agentLoop = agent.NewAgentLoop(cfg, msgBus, provider)
+	} else if agentLoop, err = agent.NewNamedAgentLoop(cfg, agentName, msgBus, provider); err != nil {
+		fmt.Printf("Error creating agent: %v\n", err)
+		os.Exit(1)
+	}
+
+	var approvals *approval.Manager
+	if cfg.Tools.Approval.Enabled {
+		approvals = newApprovalManager(cfg, msgBus)
+		agentLoop.SetApprovals(approvals)
+	}
+
+	mcpManager := startMCP(cfg, agentLoop)
+	defer mcpManager.Close()
 
 	// Print agent startup info (only for interactive mode)
 	startupInfo := agentLoop.GetStartupInfo()
@@ -418,6 +474,13 @@ func agentCmd() {
 		})
 
 	if message != "" {
+		if approvals != nil {
+			reader := bufio.NewReader(os.Stdin)
+			approvals.SetPrompter("cli", cliApprover(func(prompt string) (string, error) {
+				fmt.Print(prompt)
+				return reader.ReadString('\n')
+			}))
+		}
 		ctx := context.Background()
 		response, err := agentLoop.ProcessDirect(ctx, message, sessionKey)
 		if err != nil {
@@ -427,11 +490,11 @@ func agentCmd() {
 		fmt.Printf("\n%s %s\n", logo, response)
 	} else {
 		fmt.Printf("%s Interactive mode (Ctrl+C to exit)\n\n", logo)
-		interactiveMode(agentLoop, sessionKey)
+		interactiveMode(agentLoop, sessionKey, approvals)
 	}
 }
 
-func interactiveMode(agentLoop *agent.AgentLoop, sessionKey string) {
+func interactiveMode(agentLoop *agent.AgentLoop, sessionKey string, approvals *approval.Manager) {
 	prompt := fmt.Sprintf("%s You: ", logo)
 
 	rl, err := readline.NewEx(&readline.Config{
@@ -445,11 +508,19 @@ func interactiveMode(agentLoop *agent.AgentLoop, sessionKey string) {
 	if err != nil {
 		fmt.Printf("Error initializing readline: %v\n", err)
 		fmt.Println("Falling back to simple input mode...")
-		simpleInteractiveMode(agentLoop, sessionKey)
+		simpleInteractiveMode(agentLoop, sessionKey, approvals)
 		return
 	}
 	defer rl.Close()
 
+	if approvals != nil {
+		approvals.SetPrompter("cli", cliApprover(func(p string) (string, error) {
+			rl.SetPrompt(p)
+			defer rl.SetPrompt(prompt)
+			return rl.Readline()
+		}))
+	}
+
 	for {
 		line, err := rl.Readline()
 		if err != nil {
@@ -482,8 +553,14 @@ func interactiveMode(agentLoop *agent.AgentLoop, sessionKey string) {
 	}
 }
 
-func simpleInteractiveMode(agentLoop *agent.AgentLoop, sessionKey string) {
+func simpleInteractiveMode(agentLoop *agent.AgentLoop, sessionKey string, approvals *approval.Manager) {
 	reader := bufio.NewReader(os.Stdin)
+	if approvals != nil {
+		approvals.SetPrompter("cli", cliApprover(func(prompt string) (string, error) {
+			fmt.Print(prompt)
+			return reader.ReadString('\n')
+		}))
+	}
 	for {
 		fmt.Print(fmt.Sprintf("%s You: ", logo))
 		line, err := reader.ReadString('\n')
@@ -528,11 +605,16 @@ func gatewayCmd() {
 		}
 	}
 
-	cfg, err := loadConfig()
+	cfg, issues, err := config.ValidateFile(getConfigPath())
 	if err != nil {
 		fmt.Printf("Error loading config: %v\n", err)
 		os.Exit(1)
 	}
+	printIssues(issues)
+	if config.HasErrors(issues) {
+		fmt.Println("Error: invalid config, fix the settings above or run picoclaw config validate")
+		os.Exit(1)
+	}
 
 	provider, err := providers.CreateProvider(cfg)
 	if err != nil {
@@ -540,35 +622,61 @@ func gatewayCmd() {
 		os.Exit(1)
 	}
 
-	msgBus := bus.NewMessageBus()
+	msgBus, err := newGatewayBus(cfg)
+	if err != nil {
+		fmt.Printf("Error creating message bus: %v\n", err)
+		os.Exit(1)
+	}
 	agentLoop := agent.NewAgentLoop(cfg, msgBus, provider)
 
+	router := agent.NewRouter(msgBus, agentLoop, cfg.Agents.Routes)
+	if err := addNamedAgents(cfg, msgBus, router); err != nil {
+		fmt.Printf("Error creating agents: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg.Tools.Approval.Enabled {
+		approvals := newApprovalManager(cfg, msgBus)
+		for _, al := range router.Agents() {
+			al.SetApprovals(approvals)
+		}
+	}
+
+	mcpManager := startMCP(cfg, router.Agents()...)
+
 	// Print agent startup info
 	fmt.Println("\n📦 Agent Status:")
-	startupInfo := agentLoop.GetStartupInfo()
-	toolsInfo := startupInfo["tools"].(map[string]interface{})
-	skillsInfo := startupInfo["skills"].(map[string]interface{})
-	fmt.Printf("  • Tools: %d loaded\n", toolsInfo["count"])
-	fmt.Printf("  • Skills: %d/%d available\n",
-		skillsInfo["available"],
-		skillsInfo["total"])
-
-	// Log to file as well
-	logger.InfoCF("agent", "Agent initialized",
-		map[string]interface{}{
-			"tools_count":      toolsInfo["count"],
-			"skills_total":     skillsInfo["total"],
-			"skills_available": skillsInfo["available"],
-		})
+	for _, al := range router.Agents() {
+		startupInfo := al.GetStartupInfo()
+		toolsInfo := startupInfo["tools"].(map[string]interface{})
+		skillsInfo := startupInfo["skills"].(map[string]interface{})
+		if len(router.Agents()) > 1 {
+			fmt.Printf("  %s:\n", al.Name())
+		}
+		fmt.Printf("  • Tools: %d loaded\n", toolsInfo["count"])
+		fmt.Printf("  • Skills: %d/%d available\n",
+			skillsInfo["available"],
+			skillsInfo["total"])
+
+		// Log to file as well
+		logger.InfoCF("agent", "Agent initialized",
+			map[string]interface{}{
+				"agent":            al.Name(),
+				"tools_count":      toolsInfo["count"],
+				"skills_total":     skillsInfo["total"],
+				"skills_available": skillsInfo["available"],
+			})
+	}
 
 	// Setup cron tool and service
-	cronService := setupCronTool(agentLoop, msgBus, cfg.WorkspacePath(), cfg.Agents.Defaults.RestrictToWorkspace)
+	cronService := setupCronTool(router, msgBus)
 
 	heartbeatService := heartbeat.NewHeartbeatService(
 		cfg.WorkspacePath(),
 		cfg.Heartbeat.Interval,
 		cfg.Heartbeat.Enabled,
 	)
+	heartbeatService.SetStateManager(agentLoop.StateManager())
 	heartbeatService.SetBus(msgBus)
 	heartbeatService.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
 		// Use cli:direct as fallback if no valid channel
@@ -594,8 +702,10 @@ func gatewayCmd() {
 		os.Exit(1)
 	}
 
-	// Inject channel manager into agent loop for command handling
-	agentLoop.SetChannelManager(channelManager)
+	// Inject channel manager into agent loops for command handling
+	for _, al := range router.Agents() {
+		al.SetChannelManager(channelManager)
+	}
 
 	// Set up STT transcription: prefer local Whisper, fall back to Groq.
 	var transcriber voice.Transcriber
@@ -613,32 +723,14 @@ func gatewayCmd() {
 		logger.InfoC("voice", "Groq voice transcription enabled")
 	}
 
-	if transcriber != nil {
-		if telegramChannel, ok := channelManager.GetChannel("telegram"); ok {
-			if tc, ok := telegramChannel.(*channels.TelegramChannel); ok {
-				tc.SetTranscriber(transcriber)
-				logger.InfoC("voice", "Transcription attached to Telegram channel")
-			}
-		}
-		if discordChannel, ok := channelManager.GetChannel("discord"); ok {
-			if dc, ok := discordChannel.(*channels.DiscordChannel); ok {
-				dc.SetTranscriber(transcriber)
-				logger.InfoC("voice", "Transcription attached to Discord channel")
-			}
-		}
-		if slackChannel, ok := channelManager.GetChannel("slack"); ok {
-			if sc, ok := slackChannel.(*channels.SlackChannel); ok {
-				sc.SetTranscriber(transcriber)
-				logger.InfoC("voice", "Transcription attached to Slack channel")
-			}
-		}
-		if matrixChannel, ok := channelManager.GetChannel("matrix"); ok {
-			if mc, ok := matrixChannel.(*channels.MatrixChannel); ok {
-				mc.SetTranscriber(transcriber)
-				logger.InfoC("voice", "Transcription attached to Matrix channel")
-			}
-		}
+	for _, name := range channelManager.GetEnabledChannels() {
+		channel, _ := channelManager.GetChannel(name)
+		attachTranscriber(channel, transcriber)
 	}
+	// Channels recreated by a config reload need it as well
+	channelManager.SetChannelSetup(func(channel channels.Channel) {
+		attachTranscriber(channel, transcriber)
+	})
 
 	// Attach TTS synthesis callbacks to the message tool (enables voice=true).
 	if cfg.Tools.TTS.Enabled {
@@ -656,14 +748,16 @@ func gatewayCmd() {
 				"api_base": cfg.Tools.TTS.APIBase,
 				"voice":    cfg.Tools.TTS.Voice,
 			})
-			agentLoop.SetVoiceCallbacks(
-				func(ctx context.Context, text string) (string, error) {
-					return synthesizer.Synthesize(ctx, text)
-				},
-				func(ctx context.Context, channel, chatID string, filePaths []string) error {
-					return channelManager.SendFileToChannel(ctx, channel, chatID, filePaths)
-				},
-			)
+			for _, al := range router.Agents() {
+				al.SetVoiceCallbacks(
+					func(ctx context.Context, text string) (string, error) {
+						return synthesizer.Synthesize(ctx, text)
+					},
+					func(ctx context.Context, channel, chatID string, filePaths []string) error {
+						return channelManager.SendFileToChannel(ctx, channel, chatID, filePaths)
+					},
+				)
+			}
 		} else {
 			logger.WarnC("voice", "TTS configured but service not reachable — voice=true disabled")
 		}
@@ -692,11 +786,10 @@ func gatewayCmd() {
 	}
 	fmt.Println("✓ Heartbeat service started")
 
-	stateManager := state.NewManager(cfg.WorkspacePath())
 	deviceService := devices.NewService(devices.Config{
 		Enabled:    cfg.Devices.Enabled,
 		MonitorUSB: cfg.Devices.MonitorUSB,
-	}, stateManager)
+	}, agentLoop.StateManager())
 	deviceService.SetBus(msgBus)
 	if err := deviceService.Start(ctx); err != nil {
 		fmt.Printf("Error starting device service: %v\n", err)
@@ -709,18 +802,64 @@ func gatewayCmd() {
 	}
 
 	healthServer := health.NewServer(cfg.Gateway.Host, cfg.Gateway.Port)
+	if cfg.Gateway.APIEnabled {
+		agents := router.Agents()
+		others := make([]api.Agent, 0, len(agents)-1)
+		for _, al := range agents[1:] {
+			others = append(others, al)
+		}
+		healthServer.Handle("/v1/", api.NewHandler(cfg.Gateway.APITokens, agents[0], others...))
+		if len(cfg.Gateway.APITokens) == 0 {
+			fmt.Println("⚠ Warning: gateway API has no api_tokens, accepting loopback clients only")
+		}
+	}
+	if cfg.MCP.Serve.Enabled {
+		server := newMCPServer(cfg, agentLoop, cfg.MCP.Serve.Tools)
+		healthServer.Handle("/mcp", newMCPHandler(cfg, server))
+	}
 	go func() {
 		if err := healthServer.Start(); err != nil && err != http.ErrServerClosed {
 			logger.ErrorCF("health", "Health server error", map[string]interface{}{"error": err.Error()})
 		}
 	}()
 	fmt.Printf("✓ Health endpoints available at http://%s:%d/health and /ready\n", cfg.Gateway.Host, cfg.Gateway.Port)
+	if cfg.Gateway.APIEnabled {
+		fmt.Printf("✓ OpenAI-compatible API available at http://%s:%d/v1\n", cfg.Gateway.Host, cfg.Gateway.Port)
+	}
+	if cfg.MCP.Serve.Enabled {
+		fmt.Printf("✓ MCP server available at http://%s:%d/mcp\n", cfg.Gateway.Host, cfg.Gateway.Port)
+	}
 
-	go agentLoop.Run(ctx)
+	go router.Run(ctx)
+
+	reloader := &gatewayReloader{
+		ctx:       ctx,
+		cfg:       cfg,
+		router:    router,
+		channels:  channelManager,
+		heartbeat: heartbeatService,
+		mcp:       mcpManager,
+	}
+	var watchInterval time.Duration
+	if cfg.Gateway.WatchConfig {
+		watchInterval = configWatchInterval
+	}
+	watcher := config.NewWatcher(getConfigPath(), watchInterval, reloader.apply)
+	go watcher.Run(ctx)
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			logger.InfoC("config", "SIGHUP received, reloading config")
+			watcher.Reload()
+		}
+	}()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	<-sigChan
+	signal.Stop(hupChan)
 
 	fmt.Println("\nShutting down...")
 	cancel()
@@ -728,11 +867,153 @@ func gatewayCmd() {
 	deviceService.Stop()
 	heartbeatService.Stop()
 	cronService.Stop()
-	agentLoop.Stop()
+	router.Stop()
 	channelManager.StopAll(ctx)
+	mcpManager.Close()
+	msgBus.Close()
 	fmt.Println("✓ Gateway stopped")
 }
 
+// attachTranscriber gives a channel that accepts voice messages the
+// transcriber.
+func attachTranscriber(channel channels.Channel, transcriber voice.Transcriber) {
+	if transcriber == nil {
+		return
+	}
+	switch ch := channel.(type) {
+	case *channels.TelegramChannel:
+		ch.SetTranscriber(transcriber)
+	case *channels.DiscordChannel:
+		ch.SetTranscriber(transcriber)
+	case *channels.SlackChannel:
+		ch.SetTranscriber(transcriber)
+	case *channels.MatrixChannel:
+		ch.SetTranscriber(transcriber)
+	default:
+		return
+	}
+	logger.InfoCF("voice", "Transcription attached to channel", map[string]interface{}{
+		"channel": channel.Name(),
+	})
+}
+
+func usageHelp() {
+	fmt.Println("Usage: picoclaw usage [options]")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --days <n>        Number of days to include (default: 7)")
+	fmt.Println("  --by <key>        Breakdown by day, agent, session, channel or model (default: day)")
+	fmt.Println("  -a, --agent <n>   Only show usage of this agent (default: all agents)")
+}
+
+func usageCmd() {
+	days := 7
+	groupBy := "day"
+	agentName := ""
+
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--days":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n <= 0 {
+					fmt.Printf("Invalid --days value: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				days = n
+				i++
+			}
+		case "--by":
+			if i+1 < len(args) {
+				groupBy = args[i+1]
+				i++
+			}
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentName = args[i+1]
+				i++
+			}
+		case "-h", "--help":
+			usageHelp()
+			return
+		default:
+			fmt.Printf("Unknown flag: %s\n", args[i])
+			usageHelp()
+			os.Exit(1)
+		}
+	}
+
+	keys := map[string]func(usage.Record) string{
+		"day":     usage.ByDay,
+		"agent":   usage.ByAgent,
+		"session": usage.BySession,
+		"channel": usage.ByChannel,
+		"model":   usage.ByModel,
+	}
+	key, ok := keys[groupBy]
+	if !ok {
+		fmt.Printf("Unknown breakdown: %s (use day, agent, session, channel or model)\n", groupBy)
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if _, ok := cfg.AgentSettings(agentName); agentName != "" && !ok {
+		fmt.Printf("Error: agent %q is not configured in agents.list\n", agentName)
+		os.Exit(1)
+	}
+
+	// All agents share the ledger of the default workspace
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+	all, err := usage.Load(filepath.Join(cfg.WorkspacePath(), "usage"), since)
+	if err != nil {
+		fmt.Printf("Error reading usage ledger: %v\n", err)
+		os.Exit(1)
+	}
+	records := all
+	if agentName != "" {
+		records = nil
+		for _, rec := range all {
+			if usage.ByAgent(rec) == agentName {
+				records = append(records, rec)
+			}
+		}
+	}
+	if len(records) == 0 {
+		fmt.Printf("No usage recorded in the last %d days.\n", days)
+		return
+	}
+
+	var total usage.Totals
+	fmt.Printf("%-32s %8s %12s %12s %10s\n", strings.ToUpper(groupBy), "CALLS", "PROMPT", "COMPLETION", "COST")
+	for _, group := range usage.Breakdown(records, key) {
+		fmt.Printf("%-32s %8d %12d %12d %10s\n", group.Key, group.Calls,
+			group.PromptTokens, group.CompletionTokens, usage.FormatCost(group.Cost))
+		total.Calls += group.Calls
+		total.PromptTokens += group.PromptTokens
+		total.CompletionTokens += group.CompletionTokens
+		total.Cost += group.Cost
+	}
+	fmt.Printf("%-32s %8d %12d %12d %10s\n", "TOTAL", total.Calls,
+		total.PromptTokens, total.CompletionTokens, usage.FormatCost(total.Cost))
+
+	// The budgets cover all agents
+	var today usage.Totals
+	for _, rec := range all {
+		if usage.ByDay(rec) == now.Format("2006-01-02") {
+			today.Add(rec)
+		}
+	}
+	if over, reason := usage.BudgetStatus(cfg.Usage, today); over {
+		fmt.Printf("\n⚠ %s\n", reason)
+	}
+}
+
 func statusCmd() {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -1032,18 +1313,96 @@ func getConfigPath() string {
 	return filepath.Join(home, ".picoclaw", "config.json")
 }
 
-func setupCronTool(agentLoop *agent.AgentLoop, msgBus *bus.MessageBus, workspace string, restrict bool) *cron.CronService {
-	cronStorePath := filepath.Join(workspace, "cron", "jobs.json")
+// addNamedAgents creates an agent loop for every agents.list entry, each with
+// a provider for its own provider and model, and registers it with the router.
+func addNamedAgents(cfg *config.Config, msgBus *bus.MessageBus, router *agent.Router) error {
+	seen := map[string]bool{config.DefaultAgentName: true}
+	for _, entry := range cfg.Agents.List {
+		if entry.Name == "" || seen[entry.Name] {
+			return fmt.Errorf("agents.list: missing or duplicate agent name %q", entry.Name)
+		}
+		seen[entry.Name] = true
+		settings, _ := cfg.AgentSettings(entry.Name)
+
+		provider, err := providers.CreateProviderFor(cfg, settings.Provider, settings.Model)
+		if err != nil {
+			return fmt.Errorf("agent %q: %w", entry.Name, err)
+		}
+
+		al, err := agent.NewNamedAgentLoop(cfg, entry.Name, msgBus, provider)
+		if err != nil {
+			return err
+		}
+		router.AddAgent(al)
+	}
+	return router.CheckRoutes()
+}
+
+// newGatewayBus creates the gateway's message bus, journaled under the
+// workspace when bus.durable is set.
+func newGatewayBus(cfg *config.Config) (*bus.MessageBus, error) {
+	opts := bus.Options{
+		MaxAttempts:    cfg.Bus.MaxAttempts,
+		InitialBackoff: time.Duration(cfg.Bus.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(cfg.Bus.MaxBackoffMs) * time.Millisecond,
+		MaxPending:     cfg.Bus.MaxPending,
+		AckTimeout:     time.Duration(cfg.Bus.AckTimeoutMs) * time.Millisecond,
+		OnDeadLetter: func(msg bus.InboundMessage) {
+			agent.CleanupMedia(msg.Media)
+		},
+	}
+	if cfg.Bus.Durable {
+		opts.Dir = filepath.Join(cfg.WorkspacePath(), "bus")
+	}
+	return bus.OpenMessageBus(opts)
+}
+
+func newApprovalManager(cfg *config.Config, msgBus *bus.MessageBus) *approval.Manager {
+	return approval.NewManager(msgBus, cfg.Tools.Approval, filepath.Join(cfg.WorkspacePath(), "approvals"))
+}
+
+// cliApprover asks on the terminal whether a tool call that needs approval
+// may run.
+func cliApprover(readLine func(prompt string) (string, error)) approval.Prompter {
+	return func(ctx context.Context, id string, req tools.ApprovalRequest) (bool, error) {
+		fmt.Printf("\n%s\n", approval.Describe(id, req))
+		answer, err := readLine("Approve? [y/N]: ")
+		if err != nil {
+			return false, err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes", nil
+	}
+}
 
-	// Create cron service
-	cronService := cron.NewCronService(cronStorePath, nil)
+func setupCronTool(router *agent.Router, msgBus *bus.MessageBus) *cron.CronService {
+	// Create cron service, stored next to the default agent's sessions
+	defaultAgent := router.Agents()[0]
+	cronService := cron.NewCronServiceWithStore(defaultAgent.Storage(), nil)
 
-	// Create and register CronTool
-	cronTool := tools.NewCronTool(cronService, agentLoop, msgBus, workspace, restrict)
-	agentLoop.RegisterTool(cronTool)
+	// Create and register a CronTool per agent. Jobs record the agent that
+	// created them, and scheduled commands go through that agent's exec
+	// tool, with its sandbox and approval rules.
+	cronTools := make(map[string]*tools.CronTool)
+	for _, al := range router.Agents() {
+		cronTool := tools.NewCronTool(cronService, al, msgBus, al.ToolRegistry())
+		cronTool.SetAgent(al.Name())
+		al.RegisterTool(cronTool)
+		cronTools[al.Name()] = cronTool
+	}
 
 	// Set the onJob handler
 	cronService.SetOnJob(func(job *cron.CronJob) (string, error) {
+		cronTool, ok := cronTools[job.Payload.Agent]
+		if !ok {
+			if job.Payload.Agent != "" {
+				logger.WarnCF("cron", "Job agent not found, running it on the default agent", map[string]interface{}{
+					"job_id": job.ID,
+					"agent":  job.Payload.Agent,
+				})
+			}
+			cronTool = cronTools[defaultAgent.Name()]
+		}
 		result := cronTool.ExecuteJob(context.Background(), job)
 		return result, nil
 	})
@@ -1070,23 +1429,27 @@ func cronCmd() {
 		return
 	}
 
-	cronStorePath := filepath.Join(cfg.WorkspacePath(), "cron", "jobs.json")
+	store, err := storage.Open(cfg.Storage, cfg.WorkspacePath())
+	if err != nil {
+		fmt.Printf("Error opening storage: %v\n", err)
+		return
+	}
 
 	switch subcommand {
 	case "list":
-		cronListCmd(cronStorePath)
+		cronListCmd(store)
 	case "add":
-		cronAddCmd(cronStorePath)
+		cronAddCmd(store)
 	case "remove":
 		if len(os.Args) < 4 {
 			fmt.Println("Usage: picoclaw cron remove <job_id>")
 			return
 		}
-		cronRemoveCmd(cronStorePath, os.Args[3])
+		cronRemoveCmd(store, os.Args[3])
 	case "enable":
-		cronEnableCmd(cronStorePath, false)
+		cronEnableCmd(store, false)
 	case "disable":
-		cronEnableCmd(cronStorePath, true)
+		cronEnableCmd(store, true)
 	default:
 		fmt.Printf("Unknown cron command: %s\n", subcommand)
 		cronHelp()
@@ -1111,8 +1474,8 @@ func cronHelp() {
 	fmt.Println("  --channel        Channel for delivery")
 }
 
-func cronListCmd(storePath string) {
-	cs := cron.NewCronService(storePath, nil)
+func cronListCmd(store storage.DocumentStore) {
+	cs := cron.NewCronServiceWithStore(store, nil)
 	jobs := cs.ListJobs(true) // Show all jobs, including disabled
 
 	if len(jobs) == 0 {
@@ -1150,7 +1513,7 @@ func cronListCmd(storePath string) {
 	}
 }
 
-func cronAddCmd(storePath string) {
+func cronAddCmd(store storage.DocumentStore) {
 	name := ""
 	message := ""
 	var everySec *int64
@@ -1228,7 +1591,7 @@ func cronAddCmd(storePath string) {
 		}
 	}
 
-	cs := cron.NewCronService(storePath, nil)
+	cs := cron.NewCronServiceWithStore(store, nil)
 	job, err := cs.AddJob(name, schedule, message, deliver, channel, to)
 	if err != nil {
 		fmt.Printf("Error adding job: %v\n", err)
@@ -1238,8 +1601,8 @@ func cronAddCmd(storePath string) {
 	fmt.Printf("✓ Added job '%s' (%s)\n", job.Name, job.ID)
 }
 
-func cronRemoveCmd(storePath, jobID string) {
-	cs := cron.NewCronService(storePath, nil)
+func cronRemoveCmd(store storage.DocumentStore, jobID string) {
+	cs := cron.NewCronServiceWithStore(store, nil)
 	if cs.RemoveJob(jobID) {
 		fmt.Printf("✓ Removed job %s\n", jobID)
 	} else {
@@ -1247,14 +1610,14 @@ func cronRemoveCmd(storePath, jobID string) {
 	}
 }
 
-func cronEnableCmd(storePath string, disable bool) {
+func cronEnableCmd(store storage.DocumentStore, disable bool) {
 	if len(os.Args) < 4 {
 		fmt.Println("Usage: picoclaw cron enable/disable <job_id>")
 		return
 	}
 
 	jobID := os.Args[3]
-	cs := cron.NewCronService(storePath, nil)
+	cs := cron.NewCronServiceWithStore(store, nil)
 	enabled := !disable
 
 	job := cs.EnableJob(jobID, enabled)
@@ -1272,7 +1635,9 @@ func cronEnableCmd(storePath string, disable bool) {
 func skillsHelp() {
 	fmt.Println("\nSkills commands:")
 	fmt.Println("  list                    List installed skills")
-	fmt.Println("  install <repo>          Install skill from GitHub")
+	fmt.Println("  install <source>        Install a skill and the skills it depends on")
+	fmt.Println("  update [name...]        Update skills whose source has changed")
+	fmt.Println("  outdated                Show skills whose source has changed")
 	fmt.Println("  install-builtin          Install all builtin skills to workspace")
 	fmt.Println("  list-builtin             List available builtin skills")
 	fmt.Println("  remove <name>           Remove installed skill")
@@ -1281,14 +1646,23 @@ func skillsHelp() {
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  picoclaw skills list")
-	fmt.Println("  picoclaw skills install sipeed/picoclaw-skills/weather")
+	fmt.Println("  picoclaw skills install weather")
+	fmt.Println("  picoclaw skills install sipeed/picoclaw-skills/weather@v1.0.0")
+	fmt.Println("  picoclaw skills install https://example.com/weather.tar.gz")
+	fmt.Println("  picoclaw skills install ./my-skill")
+	fmt.Println("  picoclaw skills update --force weather")
 	fmt.Println("  picoclaw skills install-builtin")
 	fmt.Println("  picoclaw skills list-builtin")
 	fmt.Println("  picoclaw skills remove weather")
 }
 
-func skillsListCmd(loader *skills.SkillsLoader) {
+func skillsListCmd(loader *skills.SkillsLoader, workspace string) {
 	allSkills := loader.ListSkills()
+	lock, err := skills.ReadLock(workspace)
+	if err != nil {
+		fmt.Printf("⚠ Warning: %v\n", err)
+		lock = &skills.Lock{}
+	}
 
 	if len(allSkills) == 0 {
 		fmt.Println("No skills installed.")
@@ -1298,7 +1672,11 @@ func skillsListCmd(loader *skills.SkillsLoader) {
 	fmt.Println("\nInstalled Skills:")
 	fmt.Println("------------------")
 	for _, skill := range allSkills {
-		fmt.Printf("  ✓ %s (%s)\n", skill.Name, skill.Source)
+		if entry, ok := lock.Skills[skill.Name]; ok && skill.Source == "workspace" {
+			fmt.Printf("  ✓ %s (%s, %s from %s)\n", skill.Name, skill.Source, describeLockEntry(entry), entry.Source)
+		} else {
+			fmt.Printf("  ✓ %s (%s)\n", skill.Name, skill.Source)
+		}
 		if skill.Description != "" {
 			fmt.Printf("    %s\n", skill.Description)
 		}
@@ -1306,24 +1684,147 @@ func skillsListCmd(loader *skills.SkillsLoader) {
 }
 
 func skillsInstallCmd(installer *skills.SkillInstaller) {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: picoclaw skills install <github-repo>")
-		fmt.Println("Example: picoclaw skills install sipeed/picoclaw-skills/weather")
+	source := ""
+	force := false
+	for _, arg := range os.Args[3:] {
+		if arg == "-f" || arg == "--force" {
+			force = true
+		} else if source == "" {
+			source = arg
+		}
+	}
+	if source == "" {
+		fmt.Println("Usage: picoclaw skills install <source> [--force]")
+		fmt.Println()
+		fmt.Println("Sources:")
+		fmt.Println("  name[@version]             From skills.registries, then the skills index")
+		fmt.Println("  owner/repo[/path][@ref]    From GitHub, at a tag, branch or commit")
+		fmt.Println("  https://.../x.tar.gz[#dir] From a tarball")
+		fmt.Println("  ./dir                      From a local directory")
+		fmt.Println()
+		fmt.Println("--force replaces an installed skill of the same name.")
 		return
 	}
 
-	repo := os.Args[3]
-	fmt.Printf("Installing skill from %s...\n", repo)
+	fmt.Printf("Installing skill from %s...\n", source)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	if err := installer.InstallFromGitHub(ctx, repo); err != nil {
+	installed, err := installer.Install(ctx, source, force)
+	for _, skill := range installed {
+		fmt.Printf("✓ Skill '%s' installed (%s)\n", skill.Name, describeLockEntry(skill.LockEntry))
+	}
+	if err != nil {
 		fmt.Printf("✗ Failed to install skill: %v\n", err)
 		os.Exit(1)
 	}
+}
+
+func skillsUpdateCmd(installer *skills.SkillInstaller) {
+	var names []string
+	force := false
+	for _, arg := range os.Args[3:] {
+		if arg == "-f" || arg == "--force" {
+			force = true
+		} else {
+			names = append(names, arg)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	updates, installed, err := installer.Update(ctx, names, force)
+	if err != nil && len(updates) == 0 {
+		fmt.Printf("✗ Failed to update skills: %v\n", err)
+		os.Exit(1)
+	}
+	if len(updates) == 0 {
+		fmt.Printf("No skills recorded in %s.\n", skills.LockFile)
+		return
+	}
+
+	updated := make(map[string]skills.LockEntry)
+	for _, skill := range installed {
+		updated[skill.Name] = skill.LockEntry
+	}
+	failed := false
+	for _, u := range updates {
+		switch {
+		case u.Err != nil:
+			fmt.Printf("✗ %s: %v\n", u.Name, u.Err)
+			failed = true
+		case u.Outdated():
+			fmt.Printf("✓ %s updated (%s → %s)\n", u.Name, describeLockEntry(u.Current), describeLockEntry(u.Latest))
+		default:
+			fmt.Printf("  %s is up to date\n", u.Name)
+		}
+		delete(updated, u.Name)
+	}
+	for name, entry := range updated {
+		fmt.Printf("✓ %s installed as a dependency (%s)\n", name, describeLockEntry(entry))
+	}
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func skillsOutdatedCmd(installer *skills.SkillInstaller) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	updates, err := installer.Outdated(ctx)
+	if err != nil {
+		fmt.Printf("✗ Failed to check skills: %v\n", err)
+		os.Exit(1)
+	}
+	if len(updates) == 0 {
+		fmt.Printf("No skills recorded in %s.\n", skills.LockFile)
+		return
+	}
+
+	current := true
+	for _, u := range updates {
+		switch {
+		case u.Err != nil:
+			fmt.Printf("  ✗ %s: %v\n", u.Name, u.Err)
+			current = false
+		case u.Outdated():
+			fmt.Printf("  ↑ %s: %s → %s\n", u.Name, describeLockEntry(u.Current), describeLockEntry(u.Latest))
+			current = false
+		}
+		if u.Modified {
+			fmt.Printf("  ⚠ %s has local changes, update needs --force\n", u.Name)
+		}
+	}
+	if current {
+		fmt.Println("✓ All skills are up to date")
+	}
+}
 
-	fmt.Printf("✓ Skill '%s' installed successfully!\n", filepath.Base(repo))
+// describeLockEntry names the version of an install: its version and
+// commit, or its checksum.
+func describeLockEntry(e skills.LockEntry) string {
+	var parts []string
+	if e.Version != "" {
+		parts = append(parts, "version "+e.Version)
+	}
+	if e.Commit != "" {
+		parts = append(parts, "commit "+e.Commit[:7])
+	}
+	if len(parts) == 0 {
+		checksum := strings.TrimPrefix(e.Checksum, "sha256:")
+		if len(checksum) > 12 {
+			checksum = checksum[:12]
+		}
+		parts = append(parts, "checksum "+checksum)
+	}
+	return strings.Join(parts, ", ")
 }
 
 func skillsRemoveCmd(installer *skills.SkillInstaller, skillName string) {
@@ -1468,4 +1969,17 @@ func skillsShowCmd(loader *skills.SkillsLoader, skillName string) {
 	fmt.Printf("\n📦 Skill: %s\n", skillName)
 	fmt.Println("----------------------")
 	fmt.Println(content)
+
+	var declared []skills.SkillTool
+	for _, st := range loader.ListTools() {
+		if st.Skill == skillName {
+			declared = append(declared, st)
+		}
+	}
+	if len(declared) > 0 {
+		fmt.Println("\nTools:")
+		for _, st := range declared {
+			fmt.Printf("  %s - %s\n", st.Spec.Name, st.Spec.Description)
+		}
+	}
 }
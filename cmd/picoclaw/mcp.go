@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/agent"
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/mcp"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// startMCP connects the MCP servers of cfg and offers their tools to the
+// agents. It returns once every server has connected or failed once;
+// failed servers keep being retried in the background.
+func startMCP(cfg *config.Config, agents ...*agent.AgentLoop) *mcp.Manager {
+	manager := mcp.NewManager(cfg.WorkspacePath(), version)
+	for _, al := range agents {
+		al.SetMCP(manager)
+	}
+	manager.Start(context.Background(), cfg.MCP)
+	return manager
+}
+
+// newMCPServer offers the tools of al listed in mcp.serve.
+func newMCPServer(cfg *config.Config, al *agent.AgentLoop, allow []string) *mcp.Server {
+	server := mcp.NewServer(al.ToolRegistry(), allow, version)
+	server.SetDefaultChat(cfg.MCP.Serve.Channel, cfg.MCP.Serve.ChatID)
+	return server
+}
+
+// newMCPHandler serves server over HTTP. Without API tokens any local
+// process may call it, so only tools that can't run commands are offered.
+func newMCPHandler(cfg *config.Config, server *mcp.Server) *mcp.HTTPHandler {
+	if len(cfg.MCP.Serve.APITokens) == 0 {
+		fmt.Printf("⚠ Warning: mcp.serve has no api_tokens, accepting loopback clients only and offering only %s\n",
+			strings.Join(config.MCPOpenTools, ", "))
+	}
+	handler := mcp.NewHTTPHandler(server, cfg.MCP.Serve.APITokens)
+	handler.AllowHosts(cfg.MCP.Serve.AllowedHosts)
+	return handler
+}
+
+func mcpHelp() {
+	fmt.Println("Usage: picoclaw mcp serve [options]")
+	fmt.Println()
+	fmt.Println("Offers the agent's tools to MCP clients such as IDE agents and desktop")
+	fmt.Println("assistants, over stdio unless --http is given.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --http <addr>       Serve streamable HTTP at http://<addr>/mcp instead of stdio")
+	fmt.Println("  -a, --agent <n>     Offer the tools of a named agent from agents.list")
+	fmt.Println("  --tools <a,b>       Tools to offer (default: mcp.serve.tools)")
+	fmt.Println("  --standalone        Run the tools in this process even if the gateway serves /mcp")
+	fmt.Println()
+	fmt.Println("When the gateway is running with mcp.serve.enabled, stdio is relayed to its")
+	fmt.Println("/mcp endpoint, so message and cron use the gateway's channels. Otherwise")
+	fmt.Println("the tools run in this process, without message and cron.")
+}
+
+func mcpCmd() {
+	if len(os.Args) < 3 || os.Args[2] != "serve" {
+		if len(os.Args) >= 3 && os.Args[2] != "-h" && os.Args[2] != "--help" {
+			fmt.Printf("Unknown mcp command: %s\n", os.Args[2])
+		}
+		mcpHelp()
+		return
+	}
+
+	httpAddr := ""
+	agentName := config.DefaultAgentName
+	standalone := false
+	var toolNames []string
+
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--http":
+			if i+1 < len(args) {
+				httpAddr = args[i+1]
+				i++
+			}
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentName = args[i+1]
+				i++
+			}
+		case "--tools":
+			if i+1 < len(args) {
+				toolNames = strings.Split(args[i+1], ",")
+				i++
+			}
+		case "--standalone":
+			standalone = true
+		case "-h", "--help":
+			mcpHelp()
+			return
+		}
+	}
+
+	// Over stdio, stdout carries the protocol, so errors go to stderr
+	fail := func(format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, "Error: "+format+"\n", a...)
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fail("loading config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+
+	relay := httpAddr == "" && !standalone && toolNames == nil && agentName == config.DefaultAgentName
+	if relay && cfg.MCP.Serve.Enabled {
+		gatewayURL := gatewayMCPURL(cfg)
+		if gatewayRunning(cfg) {
+			headers := map[string]string{}
+			if len(cfg.MCP.Serve.APITokens) > 0 {
+				headers["Authorization"] = "Bearer " + cfg.MCP.Serve.APITokens[0]
+			}
+			logger.InfoCF("mcp", "Relaying to the gateway", map[string]interface{}{"url": gatewayURL})
+			if err := mcp.Relay(ctx, os.Stdin, os.Stdout, gatewayURL, headers); err != nil && ctx.Err() == nil {
+				fail("%v", err)
+			}
+			return
+		}
+	}
+
+	settings, ok := cfg.AgentSettings(agentName)
+	if !ok {
+		fail("agent %q is not configured in agents.list", agentName)
+	}
+	provider, err := providers.CreateProviderFor(cfg, settings.Provider, settings.Model)
+	if err != nil {
+		fail("creating provider: %v", err)
+	}
+	var agentLoop *agent.AgentLoop
+	if agentName == config.DefaultAgentName {
+		agentLoop = agent.NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	} else if agentLoop, err = agent.NewNamedAgentLoop(cfg, agentName, bus.NewMessageBus(), provider); err != nil {
+		fail("creating agent: %v", err)
+	}
+	// Without the gateway's channels messages would go nowhere
+	agentLoop.ToolRegistry().Unregister("message")
+
+	if toolNames == nil {
+		toolNames = cfg.MCP.Serve.Tools
+	}
+	server := newMCPServer(cfg, agentLoop, toolNames)
+
+	if httpAddr == "" {
+		if err := server.ServeStdio(ctx, os.Stdin, os.Stdout); err != nil && ctx.Err() == nil {
+			fail("%v", err)
+		}
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", newMCPHandler(cfg, server))
+	httpServer := &http.Server{Addr: httpAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+	fmt.Printf("✓ MCP server available at http://%s/mcp\n", httpAddr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fail("%v", err)
+	}
+}
+
+// gatewayMCPURL is where a local gateway serves MCP.
+func gatewayMCPURL(cfg *config.Config) string {
+	host := cfg.Gateway.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(cfg.Gateway.Port)) + "/mcp"
+}
+
+// gatewayRunning reports whether the gateway answers its health check.
+func gatewayRunning(cfg *config.Config) bool {
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(strings.TrimSuffix(gatewayMCPURL(cfg), "/mcp") + "/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
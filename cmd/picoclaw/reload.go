@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/agent"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/heartbeat"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/mcp"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// reloadableSections are the config sections a running gateway applies
+// without a restart, besides the channels and the agent fields below.
+var reloadableSections = map[string]bool{
+	"providers":  true,
+	"fallback":   true,
+	"generation": true,
+	"heartbeat":  true,
+	"mcp":        true,
+	"skills":     true,
+}
+
+// reloadableAgentFields are the fields of agents.defaults and agents.list
+// entries that AgentLoop.Reload applies to running agents. Other fields, such
+// as the workspace or the tools, and agents added to the list take effect
+// after a restart.
+var reloadableAgentFields = map[string]bool{
+	"provider":            true,
+	"model":               true,
+	"max_tokens":          true,
+	"temperature":         true,
+	"max_tool_iterations": true,
+}
+
+// gatewayReloader applies config changes to a running gateway.
+type gatewayReloader struct {
+	ctx       context.Context
+	cfg       *config.Config
+	router    *agent.Router
+	channels  *channels.Manager
+	heartbeat *heartbeat.HeartbeatService
+	mcp       *mcp.Manager
+}
+
+// apply switches the gateway to next. The providers of all agents are
+// created first, so a config they cannot be built from is rejected as a
+// whole and the running config stays in effect.
+func (r *gatewayReloader) apply(next *config.Config) {
+	changed := config.ChangedSections(r.cfg, next)
+	if len(changed) == 0 {
+		logger.InfoC("config", "Config reloaded, nothing changed")
+		return
+	}
+
+	agentProviders, err := createAgentProviders(next, r.router.Agents())
+	if err != nil {
+		logger.ErrorCF("config", "Config reload rejected, keeping the running config", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	restartedChannels := r.channels.Reload(r.ctx, next)
+
+	for _, al := range r.router.Agents() {
+		if err := al.Reload(next, agentProviders[al.Name()]); err != nil {
+			logger.ErrorCF("config", "Failed to reload agent", map[string]interface{}{
+				"agent": al.Name(),
+				"error": err.Error(),
+			})
+		}
+	}
+
+	r.heartbeat.SetInterval(next.Heartbeat.Interval, next.Heartbeat.Enabled)
+	r.mcp.Update(next.MCP)
+	previous := r.cfg
+	r.cfg = next
+
+	applied, pending := agentChanges(previous, next)
+	for _, section := range changed {
+		switch {
+		case section == "agents.defaults" || section == "agents.list":
+			// Reported field by field by agentChanges
+		case reloadableSections[section] || strings.HasPrefix(section, "channels."):
+			applied = append(applied, section)
+		default:
+			pending = append(pending, section)
+		}
+	}
+	logger.InfoCF("config", "Config reloaded", map[string]interface{}{
+		"applied":            applied,
+		"restarted_channels": restartedChannels,
+	})
+	if len(pending) > 0 {
+		logger.WarnCF("config", "Some config changes take effect after a gateway restart", map[string]interface{}{
+			"sections": pending,
+		})
+	}
+}
+
+// agentChanges splits the changed fields of agents.defaults and of the
+// agents.list entries into those running agents applied and those that need a
+// restart, e.g. "agents.defaults.model" and "agents.list.coder.workspace".
+func agentChanges(old, next *config.Config) (applied, pending []string) {
+	compare := func(prefix string, o, n interface{}) {
+		ov, nv := reflect.ValueOf(o), reflect.ValueOf(n)
+		for i := 0; i < ov.NumField(); i++ {
+			if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+				continue
+			}
+			name, _, _ := strings.Cut(ov.Type().Field(i).Tag.Get("json"), ",")
+			if reloadableAgentFields[name] {
+				applied = append(applied, prefix+name)
+			} else {
+				pending = append(pending, prefix+name)
+			}
+		}
+	}
+
+	compare("agents.defaults.", old.Agents.Defaults, next.Agents.Defaults)
+	running := make(map[string]config.AgentConfig, len(old.Agents.List))
+	for _, agent := range old.Agents.List {
+		running[agent.Name] = agent
+	}
+	for _, agent := range next.Agents.List {
+		if previous, ok := running[agent.Name]; ok {
+			compare("agents.list."+agent.Name+".", previous, agent)
+		} else {
+			pending = append(pending, "agents.list."+agent.Name)
+		}
+	}
+	return applied, pending
+}
+
+// createAgentProviders creates the provider of every running agent from cfg.
+func createAgentProviders(cfg *config.Config, agents []*agent.AgentLoop) (map[string]providers.LLMProvider, error) {
+	created := make(map[string]providers.LLMProvider, len(agents))
+	for _, al := range agents {
+		settings, ok := cfg.AgentSettings(al.Name())
+		if !ok {
+			return nil, fmt.Errorf("agent %q was removed from agents.list, restart the gateway to remove it", al.Name())
+		}
+		provider, err := providers.CreateProviderFor(cfg, settings.Provider, settings.Model)
+		if err != nil {
+			return nil, fmt.Errorf("agent %q: %w", al.Name(), err)
+		}
+		created[al.Name()] = provider
+	}
+	return created, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/session"
+	"github.com/sipeed/picoclaw/pkg/storage"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+func sessionsHelp() {
+	fmt.Println("Usage: picoclaw sessions <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  list                     List sessions, most recent first")
+	fmt.Println("  show <key>               Show a session's summary and messages")
+	fmt.Println("  export <key>             Export a session as Markdown or JSONL")
+	fmt.Println("  delete <key>             Delete a session")
+	fmt.Println("  fork <key> <new-key>     Copy a session to a new key")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  -a, --agent <n>          Use the sessions of a named agent from agents.list")
+	fmt.Println("  -n, --limit <n>          list: sessions to show; show: last messages to show (default: 20)")
+	fmt.Println("  -f, --format <f>         export: markdown or jsonl (default: markdown)")
+	fmt.Println("  -o, --output <file>      export: write to a file instead of stdout")
+	fmt.Println()
+	fmt.Println("Sessions are loaded when the gateway starts; changes made while it runs")
+	fmt.Println("take effect after a restart. Use /reset in a chat to clear it right away.")
+}
+
+func sessionsCmd() {
+	if len(os.Args) < 3 {
+		sessionsHelp()
+		return
+	}
+	subcommand := os.Args[2]
+
+	agentName := config.DefaultAgentName
+	limit := 20
+	format := session.FormatMarkdown
+	output := ""
+	var positional []string
+
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentName = args[i+1]
+				i++
+			}
+		case "-n", "--limit":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n <= 0 {
+					fmt.Printf("Invalid --limit value: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				limit = n
+				i++
+			}
+		case "-f", "--format":
+			if i+1 < len(args) {
+				format = args[i+1]
+				i++
+			}
+		case "-o", "--output":
+			if i+1 < len(args) {
+				output = args[i+1]
+				i++
+			}
+		case "-h", "--help":
+			sessionsHelp()
+			return
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				fmt.Printf("Unknown flag: %s\n", args[i])
+				sessionsHelp()
+				os.Exit(1)
+			}
+			positional = append(positional, args[i])
+		}
+	}
+
+	wantArgs := map[string]int{"list": 0, "show": 1, "export": 1, "delete": 1, "fork": 2}
+	n, known := wantArgs[subcommand]
+	if !known {
+		fmt.Printf("Unknown sessions command: %s\n", subcommand)
+		sessionsHelp()
+		return
+	}
+	if len(positional) != n {
+		usage := map[string]string{"show": "<key>", "export": "<key>", "delete": "<key>", "fork": "<key> <new-key>"}
+		fmt.Printf("Usage: picoclaw sessions %s %s\n", subcommand, usage[subcommand])
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	settings, ok := cfg.AgentSettings(agentName)
+	if !ok {
+		fmt.Printf("Error: agent %q is not configured in agents.list\n", agentName)
+		os.Exit(1)
+	}
+	store, err := storage.Open(cfg.Storage, settings.WorkspacePath())
+	if err != nil {
+		fmt.Printf("Error opening storage: %v\n", err)
+		os.Exit(1)
+	}
+	sm := session.NewSessionManagerWithStore(store)
+
+	switch subcommand {
+	case "list":
+		sessionsListCmd(sm, limit)
+	case "show":
+		sessionsShowCmd(sm, positional[0], limit)
+	case "export":
+		sessionsExportCmd(sm, positional[0], format, output)
+	case "delete":
+		if err := sm.Delete(positional[0]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Session %s deleted\n", positional[0])
+	case "fork":
+		if err := sm.Fork(positional[0], positional[1]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Session %s forked to %s\n", positional[0], positional[1])
+	}
+}
+
+func sessionsListCmd(sm *session.SessionManager, limit int) {
+	infos := sm.List()
+	if len(infos) == 0 {
+		fmt.Println("No sessions.")
+		return
+	}
+	if len(infos) > limit {
+		infos = infos[:limit]
+	}
+
+	fmt.Printf("%-32s %8s %8s  %s\n", "KEY", "MESSAGES", "SUMMARY", "UPDATED")
+	for _, info := range infos {
+		summary := "-"
+		if info.HasSummary {
+			summary = "yes"
+		}
+		fmt.Printf("%-32s %8d %8s  %s\n", utils.Truncate(info.Key, 32), info.Messages, summary,
+			info.Updated.Local().Format("2006-01-02 15:04"))
+	}
+}
+
+func sessionsShowCmd(sm *session.SessionManager, key string, limit int) {
+	s, ok := sm.Get(key)
+	if !ok {
+		fmt.Printf("Error: session %q not found\n", key)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Session %s\n", s.Key)
+	fmt.Printf("  Created:  %s\n", s.Created.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Updated:  %s\n", s.Updated.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Messages: %d\n", len(s.Messages))
+	if s.Summary != "" {
+		fmt.Printf("\nSummary:\n  %s\n", strings.ReplaceAll(strings.TrimSpace(s.Summary), "\n", "\n  "))
+	}
+
+	messages := s.Messages
+	if len(messages) > limit {
+		fmt.Printf("\n(%d earlier messages not shown, use -n to show more)\n", len(messages)-limit)
+		messages = messages[len(messages)-limit:]
+	}
+	for _, msg := range messages {
+		fmt.Printf("\n[%s]", msg.Role)
+		if msg.ToolCallID != "" {
+			fmt.Printf(" %s", msg.ToolCallID)
+		}
+		fmt.Println()
+		if content := strings.TrimSpace(msg.Content); content != "" {
+			fmt.Printf("  %s\n", strings.ReplaceAll(utils.Truncate(content, 2000), "\n", "\n  "))
+		}
+		for _, tc := range msg.ToolCalls {
+			fmt.Printf("  → %s\n", session.ToolCallName(tc))
+		}
+	}
+}
+
+func sessionsExportCmd(sm *session.SessionManager, key, format, output string) {
+	s, ok := sm.Get(key)
+	if !ok {
+		fmt.Printf("Error: session %q not found\n", key)
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	if err := session.Export(&buf, s, format); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if output == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(output, buf.Bytes(), 0644); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Exported %d messages to %s\n", len(s.Messages), output)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/agent"
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/trace"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+func traceHelp() {
+	fmt.Println("Usage: picoclaw trace <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  list                List recent turns")
+	fmt.Println("  show <id>           Show a turn step by step")
+	fmt.Println("  replay <id>         Run a turn again against another model or tool set")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  -a, --agent <n>     Use the traces of a named agent from agents.list")
+	fmt.Println("  -n, --limit <n>     list: number of turns to show (default: 20)")
+	fmt.Println("  --json              show: print the raw JSONL events")
+	fmt.Println("  --model <m>         replay: model to use (default: the agent's model)")
+	fmt.Println("  --tools <a,b>       replay: only offer these tools")
+	fmt.Println("  --live              replay: execute tool calls that have no recorded result")
+}
+
+func traceCmd() {
+	if len(os.Args) < 3 {
+		traceHelp()
+		return
+	}
+	subcommand := os.Args[2]
+
+	agentName := config.DefaultAgentName
+	limit := 20
+	asJSON, live := false, false
+	model := ""
+	var toolNames []string
+	var positional []string
+
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentName = args[i+1]
+				i++
+			}
+		case "-n", "--limit":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n <= 0 {
+					fmt.Printf("Invalid --limit value: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				limit = n
+				i++
+			}
+		case "--json":
+			asJSON = true
+		case "--model":
+			if i+1 < len(args) {
+				model = args[i+1]
+				i++
+			}
+		case "--tools":
+			if i+1 < len(args) {
+				for _, name := range strings.Split(args[i+1], ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						toolNames = append(toolNames, name)
+					}
+				}
+				i++
+			}
+		case "--live":
+			live = true
+		case "-h", "--help":
+			traceHelp()
+			return
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				fmt.Printf("Unknown flag: %s\n", args[i])
+				traceHelp()
+				os.Exit(1)
+			}
+			positional = append(positional, args[i])
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	settings, ok := cfg.AgentSettings(agentName)
+	if !ok {
+		fmt.Printf("Error: agent %q is not configured in agents.list\n", agentName)
+		os.Exit(1)
+	}
+	dir := filepath.Join(settings.WorkspacePath(), "traces")
+
+	switch subcommand {
+	case "list":
+		traceListCmd(dir, limit)
+	case "show", "replay":
+		if len(positional) != 1 {
+			fmt.Printf("Usage: picoclaw trace %s <id>\n", subcommand)
+			os.Exit(1)
+		}
+		t, err := trace.Load(dir, positional[0])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if subcommand == "show" {
+			traceShowCmd(dir, t, asJSON)
+		} else {
+			traceReplayCmd(cfg, agentName, settings, dir, t, model, toolNames, live)
+		}
+	default:
+		fmt.Printf("Unknown trace command: %s\n", subcommand)
+		traceHelp()
+	}
+}
+
+func traceListCmd(dir string, limit int) {
+	summaries, err := trace.List(dir, limit)
+	if err != nil {
+		fmt.Printf("Error reading traces: %v\n", err)
+		os.Exit(1)
+	}
+	if len(summaries) == 0 {
+		fmt.Println("No traces recorded.")
+		return
+	}
+
+	fmt.Printf("%-22s %-16s %-24s %5s %5s %8s  %s\n", "ID", "TIME", "SESSION", "LLM", "TOOLS", "DURATION", "MESSAGE")
+	for _, s := range summaries {
+		status := utils.Truncate(strings.Join(strings.Fields(s.UserMessage), " "), 48)
+		switch {
+		case s.Error != "":
+			status = "✗ " + status
+		case !s.Complete:
+			status = "… " + status
+		case s.ReplayOf != "":
+			status = "↻ " + status
+		}
+		fmt.Printf("%-22s %-16s %-24s %5d %5d %8s  %s\n", s.ID, s.Time.Local().Format("2006-01-02 15:04"),
+			utils.Truncate(s.SessionKey, 24), s.LLMCalls, s.ToolCalls, formatTraceDuration(s.Duration), status)
+	}
+}
+
+func traceShowCmd(dir string, t *trace.Trace, asJSON bool) {
+	if asJSON {
+		data, err := os.ReadFile(filepath.Join(dir, t.Info.ID+".jsonl"))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+
+	s := t.Summary()
+	fmt.Printf("Trace %s\n", t.Info.ID)
+	fmt.Printf("  Agent:    %s\n", t.Info.Agent)
+	fmt.Printf("  Session:  %s (%s:%s)\n", t.Info.SessionKey, t.Info.Channel, t.Info.ChatID)
+	fmt.Printf("  Model:    %s\n", t.Info.Model)
+	fmt.Printf("  Started:  %s\n", s.Time.Local().Format("2006-01-02 15:04:05"))
+	if s.Complete {
+		fmt.Printf("  Duration: %s, %d iterations\n", formatTraceDuration(s.Duration), s.Iterations)
+	} else {
+		fmt.Println("  Duration: unfinished")
+	}
+	if t.Info.ReplayOf != "" {
+		fmt.Printf("  Replay of: %s\n", t.Info.ReplayOf)
+	}
+	fmt.Printf("\nUser: %s\n", t.Info.UserMessage)
+	for _, m := range t.Info.Media {
+		fmt.Printf("  attachment: %s\n", m)
+	}
+
+	for _, ev := range t.Events {
+		switch ev.Type {
+		case trace.EventLLMRequest:
+			how := fmt.Sprintf("+%d messages", len(ev.Messages))
+			if ev.Reset {
+				how = fmt.Sprintf("%d messages", len(ev.Messages))
+			}
+			fmt.Printf("\n[%d] LLM request to %s (%s", ev.Iteration, ev.Model, how)
+			if ev.Tools != nil {
+				fmt.Printf(", %d tools", len(ev.Tools))
+			}
+			fmt.Println(")")
+		case trace.EventLLMResponse:
+			fmt.Printf("[%d] LLM response after %s", ev.Iteration, formatTraceDuration(time.Duration(ev.DurationMS)*time.Millisecond))
+			if ev.Error != "" {
+				fmt.Printf(": error: %s\n", ev.Error)
+				continue
+			}
+			resp := ev.Response
+			if resp == nil {
+				fmt.Println()
+				continue
+			}
+			if resp.Usage != nil {
+				fmt.Printf(" (%d prompt + %d completion tokens)", resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+			}
+			fmt.Println()
+			if resp.Content != "" {
+				fmt.Printf("    %s\n", indentTrace(utils.Truncate(resp.Content, 2000)))
+			}
+			for _, tc := range resp.ToolCalls {
+				fmt.Printf("    → %s %s\n", tc.Name, traceArgs(tc.Arguments, 200))
+			}
+		case trace.EventToolCall:
+			tc := ev.Tool
+			if tc == nil {
+				continue
+			}
+			status := "ok"
+			if tc.IsError {
+				status = "error"
+			}
+			fmt.Printf("[%d] Tool %s %s (%s, %s)\n", ev.Iteration, tc.Name, traceArgs(tc.Args, 2000), status,
+				formatTraceDuration(time.Duration(ev.DurationMS)*time.Millisecond))
+			if tc.ForLLM != "" {
+				fmt.Printf("    %s\n", indentTrace(utils.Truncate(tc.ForLLM, 1000)))
+			}
+			if ev.Error != "" && ev.Error != tc.ForLLM {
+				fmt.Printf("    error: %s\n", ev.Error)
+			}
+		case trace.EventTurnEnd:
+			if ev.Error != "" {
+				fmt.Printf("\nFailed: %s\n", ev.Error)
+			} else {
+				fmt.Printf("\nReply: %s\n", ev.Content)
+			}
+		}
+	}
+}
+
+func traceReplayCmd(cfg *config.Config, agentName string, settings config.AgentDefaults, dir string, t *trace.Trace, model string, toolNames []string, live bool) {
+	if model == "" {
+		model = settings.Model
+	}
+	provider, err := providers.CreateProviderFor(cfg, settings.Provider, model)
+	if err != nil {
+		fmt.Printf("Error creating provider: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts := trace.ReplayOptions{
+		Provider:      provider,
+		Model:         model,
+		Tools:         toolNames,
+		MaxIterations: settings.MaxToolIterations,
+		Recorder:      trace.NewRecorder(dir, agentName),
+	}
+	if live {
+		// The agent's registry, with its workspace restrictions and approval
+		// policy. Calls that need approval are denied: nobody is asked.
+		var agentLoop *agent.AgentLoop
+		if agentName == config.DefaultAgentName {
+			agentLoop = agent.NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+		} else if agentLoop, err = agent.NewNamedAgentLoop(cfg, agentName, bus.NewMessageBus(), provider); err != nil {
+			fmt.Printf("Error creating agent: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Registry = agentLoop.ToolRegistry()
+	}
+
+	fmt.Printf("Replaying %s with %s...\n", t.Info.ID, model)
+	result, err := trace.Replay(context.Background(), t, opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		if result == nil {
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println("\nTool calls:")
+	fmt.Printf("  original: %s\n", traceToolSequence(t.ToolCalls()))
+	var replayed []string
+	for _, c := range result.ToolCalls {
+		replayed = append(replayed, fmt.Sprintf("%s[%s]", c.Name, c.Source))
+	}
+	if len(replayed) == 0 {
+		replayed = []string{"none"}
+	}
+	fmt.Printf("  replay:   %s\n", strings.Join(replayed, ", "))
+
+	fmt.Printf("\nOriginal reply (%s):\n%s\n", t.Info.Model, t.FinalContent())
+	fmt.Printf("\nReplay reply (%s, %d iterations):\n%s\n", model, result.Iterations, result.Content)
+	if result.TraceID != "" {
+		fmt.Printf("\nRecorded as trace %s\n", result.TraceID)
+	}
+}
+
+func traceToolSequence(calls []trace.ToolCall) string {
+	if len(calls) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(calls))
+	for _, c := range calls {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func traceArgs(args map[string]interface{}, max int) string {
+	data, _ := json.Marshal(args)
+	return utils.Truncate(string(data), max)
+}
+
+func indentTrace(s string) string {
+	return strings.ReplaceAll(s, "\n", "\n    ")
+}
+
+func formatTraceDuration(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
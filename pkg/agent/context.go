@@ -1,14 +1,18 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/mcp"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/tools"
@@ -19,6 +23,17 @@ type ContextBuilder struct {
 	skillsLoader *skills.SkillsLoader
 	memory       *MemoryStore
 	tools        *tools.ToolRegistry // Direct reference to tool registry
+
+	memoryIndex *memory.Index // Retrieves relevant memories instead of including all of them
+	memoryTopK  int
+
+	vision        bool // Attach inbound media to the current user message
+	mediaMaxBytes int  // Per-attachment size limit
+
+	mcp *mcp.Manager // Describes the MCP servers' resources and prompts
+
+	activeSkills *activeSkills                    // Skills whose instructions are loaded, per session
+	activator    atomic.Pointer[skills.Activator] // Loads the skills a message matches
 }
 
 func getGlobalConfigDir() string {
@@ -40,6 +55,7 @@ func NewContextBuilder(workspace string) *ContextBuilder {
 		workspace:    workspace,
 		skillsLoader: skills.NewSkillsLoader(workspace, globalSkillsDir, builtinSkillsDir),
 		memory:       NewMemoryStore(workspace),
+		activeSkills: newActiveSkills(defaultMaxActiveSkills),
 	}
 }
 
@@ -48,6 +64,26 @@ func (cb *ContextBuilder) SetToolsRegistry(registry *tools.ToolRegistry) {
 	cb.tools = registry
 }
 
+// SetMediaOptions controls whether inbound media is sent to the model and
+// how large a single attachment may be.
+func (cb *ContextBuilder) SetMediaOptions(vision bool, maxBytes int) {
+	cb.vision = vision
+	cb.mediaMaxBytes = maxBytes
+}
+
+// SetMemoryIndex makes the system prompt include only the topK memory
+// snippets most relevant to the current message.
+func (cb *ContextBuilder) SetMemoryIndex(index *memory.Index, topK int) {
+	cb.memoryIndex = index
+	cb.memoryTopK = topK
+}
+
+// SetMCP adds the MCP servers' instructions, resources and prompts to the
+// system prompt.
+func (cb *ContextBuilder) SetMCP(m *mcp.Manager) {
+	cb.mcp = m
+}
+
 func (cb *ContextBuilder) getIdentity() string {
 	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
@@ -56,6 +92,11 @@ func (cb *ContextBuilder) getIdentity() string {
 	// Build tools section dynamically
 	toolsSection := cb.buildToolsSection()
 
+	memoryRule := fmt.Sprintf("When remembering something, write to %s/memory/MEMORY.md", workspacePath)
+	if cb.memoryIndex != nil {
+		memoryRule = "Only memories relevant to the current message are shown below. Use memory_search to recall anything else about the user or past conversations, and memory_save to remember something for later"
+	}
+
 	return fmt.Sprintf(`# picoclaw 🦞
 
 You are picoclaw, a helpful AI assistant.
@@ -80,8 +121,8 @@ Your workspace is at: %s
 
 2. **Be helpful and accurate** - When using tools, briefly explain what you're doing.
 
-3. **Memory** - When remembering something, write to %s/memory/MEMORY.md`,
-		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
+3. **Memory** - %s`,
+		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, memoryRule)
 }
 
 func (cb *ContextBuilder) buildToolsSection() string {
@@ -106,7 +147,9 @@ func (cb *ContextBuilder) buildToolsSection() string {
 	return sb.String()
 }
 
-func (cb *ContextBuilder) BuildSystemPrompt() string {
+// BuildSystemPrompt builds the system prompt. With a memory index, query
+// selects the memories included.
+func (cb *ContextBuilder) BuildSystemPrompt(ctx context.Context, query string) string {
 	parts := []string{}
 
 	// Core identity section
@@ -118,26 +161,64 @@ func (cb *ContextBuilder) BuildSystemPrompt() string {
 		parts = append(parts, bootstrapContent)
 	}
 
-	// Skills - show summary, AI can read full content with read_file tool
+	// Skills - show summary only, the agent loads the instructions it needs
+	// with load_skill and they are added by ApplyActiveSkills
 	skillsSummary := cb.skillsLoader.BuildSkillsSummary()
 	if skillsSummary != "" {
 		parts = append(parts, fmt.Sprintf(`# Skills
 
-The following skills extend your capabilities. To use a skill, read its SKILL.md file using the read_file tool.
+The following skills extend your capabilities. Before using a skill, load its instructions with the load_skill tool, unless they are already under Active Skills.
 
 %s`, skillsSummary))
 	}
 
+	if cb.mcp != nil {
+		if section := cb.mcp.PromptSection(); section != "" {
+			parts = append(parts, section)
+		}
+	}
+
 	// Memory context
-	memoryContext := cb.memory.GetMemoryContext()
+	var memoryContext string
+	if cb.memoryIndex != nil {
+		memoryContext = cb.relevantMemories(ctx, query)
+	} else {
+		memoryContext = cb.memory.GetMemoryContext()
+	}
 	if memoryContext != "" {
-		parts = append(parts, "# Memory\n\n"+memoryContext)
+		parts = append(parts, memoryContext)
 	}
 
 	// Join with "---" separator
 	return strings.Join(parts, "\n\n---\n\n")
 }
 
+// relevantMemories returns the memory snippets most relevant to query, from
+// the memory files and the summary of the session in ctx.
+func (cb *ContextBuilder) relevantMemories(ctx context.Context, query string) string {
+	if strings.TrimSpace(query) == "" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	results, err := cb.memoryIndex.Search(ctx, sessionKeyFrom(ctx), query, cb.memoryTopK)
+	if err != nil {
+		logger.WarnCF("agent", "Memory search failed", map[string]interface{}{"error": err.Error()})
+		return ""
+	}
+	if len(results) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Memory\n\nRelevant notes from your memory:\n")
+	for _, r := range results {
+		fmt.Fprintf(&sb, "\n## %s\n\n%s\n", r.Title(), r.Text)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
 func (cb *ContextBuilder) LoadBootstrapFiles() string {
 	bootstrapFiles := []string{
 		"AGENTS.md",
@@ -157,10 +238,18 @@ func (cb *ContextBuilder) LoadBootstrapFiles() string {
 	return result
 }
 
-func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary string, currentMessage string, media []string, channel, chatID string) []providers.Message {
+func (cb *ContextBuilder) BuildMessages(ctx context.Context, history []providers.Message, summary string, currentMessage string, media []string, channel, chatID string) []providers.Message {
 	messages := []providers.Message{}
 
-	systemPrompt := cb.BuildSystemPrompt()
+	// Memories are retrieved for the message being answered. On a retry it
+	// is already in the history.
+	query := currentMessage
+	for i := len(history) - 1; query == "" && i >= 0; i-- {
+		if history[i].Role == "user" {
+			query = history[i].Content
+		}
+	}
+	systemPrompt := cb.BuildSystemPrompt(ctx, query)
 
 	// Add Current Session info if provided
 	if channel != "" && chatID != "" {
@@ -207,10 +296,16 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 
 	messages = append(messages, history...)
 
-	messages = append(messages, providers.Message{
+	userMsg := providers.Message{
 		Role:    "user",
 		Content: currentMessage,
-	})
+	}
+	if cb.vision && len(media) > 0 {
+		if parts := loadMediaParts(media, cb.mediaMaxBytes); len(parts) > 0 {
+			userMsg.Parts = append([]providers.ContentPart{{Type: providers.PartText, Text: currentMessage}}, parts...)
+		}
+	}
+	messages = append(messages, userMsg)
 
 	return messages
 }
@@ -234,25 +329,6 @@ func (cb *ContextBuilder) AddAssistantMessage(messages []providers.Message, cont
 	return messages
 }
 
-func (cb *ContextBuilder) loadSkills() string {
-	allSkills := cb.skillsLoader.ListSkills()
-	if len(allSkills) == 0 {
-		return ""
-	}
-
-	var skillNames []string
-	for _, s := range allSkills {
-		skillNames = append(skillNames, s.Name)
-	}
-
-	content := cb.skillsLoader.LoadSkillsForContext(skillNames)
-	if content == "" {
-		return ""
-	}
-
-	return "# Skill Definitions\n\n" + content
-}
-
 // GetSkillsInfo returns information about loaded skills.
 func (cb *ContextBuilder) GetSkillsInfo() map[string]interface{} {
 	allSkills := cb.skillsLoader.ListSkills()
@@ -0,0 +1,140 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/approval"
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// sessionDispatcher runs inbound messages on per-session workers.
+//
+// Messages that share a session key are handled strictly in arrival order by a
+// single worker; different sessions run in parallel, bounded by a semaphore.
+// A worker exits as soon as its queue drains, so idle sessions cost nothing.
+type sessionDispatcher struct {
+	handle  func(ctx context.Context, msg bus.InboundMessage)
+	slots   chan struct{}
+	mu      sync.Mutex
+	pending map[string][]bus.InboundMessage // queued messages per active session
+	wg      sync.WaitGroup
+}
+
+func newSessionDispatcher(maxConcurrent int, handle func(ctx context.Context, msg bus.InboundMessage)) *sessionDispatcher {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &sessionDispatcher{
+		handle:  handle,
+		slots:   make(chan struct{}, maxConcurrent),
+		pending: make(map[string][]bus.InboundMessage),
+	}
+}
+
+// dispatchKey returns the ordering key for a message. Messages without a
+// session key (e.g. subagent announcements) are ordered per channel/chat.
+func dispatchKey(msg bus.InboundMessage) string {
+	if msg.SessionKey != "" {
+		return msg.SessionKey
+	}
+	return msg.Channel + ":" + msg.ChatID
+}
+
+// Dispatch queues msg behind any in-flight message for the same session,
+// starting a worker if the session is idle. It never blocks on processing.
+func (d *sessionDispatcher) Dispatch(ctx context.Context, msg bus.InboundMessage) {
+	key := dispatchKey(msg)
+
+	d.mu.Lock()
+	if queue, active := d.pending[key]; active {
+		d.pending[key] = append(queue, msg)
+		d.mu.Unlock()
+		return
+	}
+	d.pending[key] = []bus.InboundMessage{}
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go d.work(ctx, key, msg)
+}
+
+func (d *sessionDispatcher) work(ctx context.Context, key string, msg bus.InboundMessage) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			d.mu.Lock()
+			dropped := len(d.pending[key]) + 1
+			delete(d.pending, key)
+			d.mu.Unlock()
+			logger.WarnCF("agent", "Dropping queued messages on shutdown",
+				map[string]interface{}{
+					"session_key": key,
+					"count":       dropped,
+				})
+			return
+		}
+
+		d.handle(ctx, msg)
+		<-d.slots
+
+		d.mu.Lock()
+		queue := d.pending[key]
+		if len(queue) == 0 {
+			delete(d.pending, key)
+			d.mu.Unlock()
+			return
+		}
+		msg = queue[0]
+		d.pending[key] = queue[1:]
+		d.mu.Unlock()
+	}
+}
+
+// Wait blocks until all workers have exited.
+func (d *sessionDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// resolveApproval answers /approve and /deny replies on the spot. They can't
+// go through the dispatcher: the turn waiting for the decision holds the
+// session the reply would queue behind.
+func resolveApproval(mb *bus.MessageBus, approvals *approval.Manager, msg bus.InboundMessage) bool {
+	if approvals == nil {
+		return false
+	}
+	reply, ok := approvals.HandleReply(msg)
+	if !ok {
+		return false
+	}
+	mb.PublishOutbound(bus.OutboundMessage{
+		Channel:  msg.Channel,
+		ChatID:   msg.ChatID,
+		Content:  reply,
+		ReplyTo:  msg.ID,
+		Metadata: msg.Metadata,
+	})
+	mb.Ack(msg.ID)
+	return true
+}
+
+// ackInbound acknowledges a handled message and removes its media. A turn cut
+// short by shutdown is left unacknowledged, media included, so a durable bus
+// redelivers it on the next start.
+func ackInbound(ctx context.Context, mb *bus.MessageBus, msg bus.InboundMessage) {
+	if ctx.Err() != nil {
+		return
+	}
+	mb.Ack(msg.ID)
+	CleanupMedia(msg.Media)
+}
@@ -8,54 +8,82 @@ package agent
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
+	"github.com/sipeed/picoclaw/pkg/approval"
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/channels"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/mcp"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/session"
+	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
+	"github.com/sipeed/picoclaw/pkg/storage"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/trace"
+	"github.com/sipeed/picoclaw/pkg/usage"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
 type AgentLoop struct {
-	bus            *bus.MessageBus
-	provider       providers.LLMProvider
-	workspace      string
-	model          string
-	contextWindow  int // Maximum context window size in tokens
-	maxIterations  int
-	sessions       *session.SessionManager
-	state          *state.Manager
-	contextBuilder *ContextBuilder
-	tools          *tools.ToolRegistry
-	running        atomic.Bool
-	summarizing    sync.Map // Tracks which sessions are currently being summarized
-	channelManager *channels.Manager
+	name             string // Agent name, config.DefaultAgentName unless from agents.list
+	cfg              *config.Config
+	bus              *bus.MessageBus
+	provider         providers.LLMProvider
+	workspace        string
+	model            string
+	modelMu          sync.RWMutex // Guards cfg, provider, model, the limits and usageCfg, which /switch and config reloads can change mid-flight
+	contextWindow    int          // Maximum context window size in tokens
+	maxIterations    int
+	maxConcurrent    int  // Maximum number of sessions processed in parallel
+	streaming        bool // Stream replies into editable channel messages
+	maxParallelTools int  // Concurrent tool calls per LLM response
+	sessions         *session.SessionManager
+	state            *state.Manager
+	storage          storage.Backend
+	subagents        *tools.SubagentManager
+	contextBuilder   *ContextBuilder
+	tools            *tools.ToolRegistry
+	subagentTools    *tools.ToolRegistry
+	approvals        *approval.Manager // nil unless SetApprovals was called
+	running          atomic.Bool
+	summarizing      sync.Map // Tracks which sessions are currently being summarized
+	channelManager   *channels.Manager
+	usage            *usage.Ledger // nil when usage tracking is disabled
+	usageCfg         config.UsageConfig
+	traces           *trace.Recorder // nil when tracing is disabled
 }
 
 // processOptions configures how a message is processed
 type processOptions struct {
-	SessionKey      string // Session identifier for history/context
-	Channel         string // Target channel for tool execution
-	ChatID          string // Target chat ID for tool execution
-	UserMessage     string // User message content (may include prefix)
-	DefaultResponse string // Response when LLM returns empty
-	EnableSummary   bool   // Whether to trigger summarization
-	SendResponse    bool   // Whether to send response via bus
-	NoHistory       bool   // If true, don't load session history (for heartbeat)
+	SessionKey      string                  // Session identifier for history/context
+	Channel         string                  // Target channel for tool execution
+	ChatID          string                  // Target chat ID for tool execution
+	Model           string                  // Model snapshot for this turn (defaults to the current model)
+	Purpose         string                  // Generation profile purpose (defaults to config.PurposeChat)
+	UserMessage     string                  // User message content (may include prefix)
+	Media           []string                // Inbound attachments for the user message
+	DefaultResponse string                  // Response when LLM returns empty
+	EnableSummary   bool                    // Whether to trigger summarization
+	SendResponse    bool                    // Whether to send response via bus
+	NoHistory       bool                    // If true, don't load session history (for heartbeat)
+	History         []providers.Message     // Used instead of session history when NoHistory is set
+	Sessions        *session.SessionManager // Keeps the conversation instead of al.sessions, e.g. in memory only
 }
 
 // createToolRegistry creates a tool registry with common tools.
@@ -71,7 +99,24 @@ func createToolRegistry(workspace string, restrict bool, cfg *config.Config, msg
 	registry.Register(tools.NewAppendFileTool(workspace, restrict))
 
 	// Shell execution
-	registry.Register(tools.NewExecTool(workspace, restrict))
+	execTool := tools.NewExecTool(workspace, restrict)
+	if err := execTool.SetSandbox(cfg.Tools.Exec.Sandbox); err != nil {
+		logger.ErrorCF("agent", "Exec sandbox unavailable, commands will be refused", map[string]interface{}{
+			"mode":  cfg.Tools.Exec.Sandbox.Mode,
+			"error": err.Error(),
+		})
+	}
+	registry.Register(execTool)
+
+	if cfg.Tools.Approval.Enabled {
+		policy, err := tools.NewApprovalPolicy(cfg.Tools.Approval.Rules)
+		if err != nil {
+			logger.ErrorCF("agent", "Invalid approval rule, it will match every call of its tool", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		registry.SetApprovalPolicy(policy)
+	}
 
 	if searchTool := tools.NewWebSearchTool(tools.WebSearchToolOptions{
 		BraveAPIKey:          cfg.Tools.Web.Brave.APIKey,
@@ -104,20 +149,106 @@ func createToolRegistry(workspace string, restrict bool, cfg *config.Config, msg
 	return registry
 }
 
+// registerSkillTools registers the tools declared by skills in registries.
+// Built-in tools keep their names; skill commands run in the exec sandbox.
+func registerSkillTools(loader *skills.SkillsLoader, cfg *config.Config, restrict bool, registries ...*tools.ToolRegistry) {
+	for _, tool := range loader.LoadTools(restrict) {
+		if _, taken := registries[0].Get(tool.Name()); taken {
+			logger.WarnCF("agent", "Skill tool shadows a built-in tool, skipped", map[string]interface{}{"tool": tool.Name()})
+			continue
+		}
+		if err := tool.SetSandbox(cfg.Tools.Exec.Sandbox); err != nil {
+			logger.ErrorCF("agent", "Exec sandbox unavailable, skill commands will be refused", map[string]interface{}{
+				"tool":  tool.Name(),
+				"error": err.Error(),
+			})
+		}
+		for _, registry := range registries {
+			registry.Register(tool)
+		}
+	}
+}
+
+// newMemoryIndex creates the index over the agent's memory files and session
+// summaries, with embeddings when an embedding model is configured.
+func newMemoryIndex(cfg *config.Config, settings config.AgentDefaults, workspace string, sessions *session.SessionManager) *memory.Index {
+	opts := memory.Options{Summaries: sessions.Summaries}
+	if model := cfg.Memory.EmbeddingModel; model != "" {
+		providerName := cfg.Memory.EmbeddingProvider
+		if providerName == "" {
+			providerName = settings.Provider
+		}
+		embedder, err := providers.CreateEmbeddingProvider(cfg, providerName, model)
+		if err != nil {
+			logger.WarnCF("agent", "Embeddings unavailable, memory search uses BM25 only", map[string]interface{}{
+				"model": model,
+				"error": err.Error(),
+			})
+		} else {
+			opts.Embedder = embedder
+			opts.EmbeddingModel = model
+		}
+	}
+	return memory.NewIndex(workspace, opts)
+}
+
+// openStorage opens the configured storage backend for workspace, falling
+// back to the JSON files if it is unavailable.
+func openStorage(cfg *config.Config, workspace string) storage.Backend {
+	store, err := storage.Open(cfg.Storage, workspace)
+	if err != nil {
+		logger.ErrorCF("agent", "Storage backend unavailable, using JSON files", map[string]interface{}{
+			"backend": cfg.Storage.Backend,
+			"error":   err.Error(),
+		})
+		return storage.NewJSONBackend(workspace)
+	}
+	return store
+}
+
+// NewAgentLoop creates the default agent, configured by agents.defaults.
 func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider) *AgentLoop {
-	workspace := cfg.WorkspacePath()
+	return newAgentLoop(cfg, config.DefaultAgentName, cfg.Agents.Defaults, msgBus, provider)
+}
+
+// NewNamedAgentLoop creates an agent from its agents.list entry. Each named
+// agent has its own workspace, sessions and tool registry; the provider should
+// be created for the agent's provider and model.
+func NewNamedAgentLoop(cfg *config.Config, name string, msgBus *bus.MessageBus, provider providers.LLMProvider) (*AgentLoop, error) {
+	settings, ok := cfg.AgentSettings(name)
+	if !ok {
+		return nil, fmt.Errorf("agent %q is not configured in agents.list", name)
+	}
+	return newAgentLoop(cfg, name, settings, msgBus, provider), nil
+}
+
+func newAgentLoop(cfg *config.Config, name string, settings config.AgentDefaults, msgBus *bus.MessageBus, provider providers.LLMProvider) *AgentLoop {
+	workspace := settings.WorkspacePath()
 	os.MkdirAll(workspace, 0755)
 
-	restrict := cfg.Agents.Defaults.RestrictToWorkspace
+	restrict := settings.RestrictToWorkspace
+
+	// Record the usage of every LLM call, including summaries and subagents.
+	// All agents share the ledger of the default workspace, so the daily
+	// budgets cover them together.
+	var ledger *usage.Ledger
+	if cfg.Usage.Enabled {
+		ledger = usage.OpenLedger(filepath.Join(cfg.WorkspacePath(), "usage"), cfg.Usage.Prices)
+		provider = usage.NewProvider(provider, ledger, name, cfg.Usage)
+	}
 
 	// Create tool registry for main agent
 	toolsRegistry := createToolRegistry(workspace, restrict, cfg, msgBus)
+	toolsRegistry.SetAllowlist(settings.Tools)
 
 	// Create subagent manager with its own tool registry
-	subagentManager := tools.NewSubagentManager(provider, cfg.Agents.Defaults.Model, workspace, msgBus)
+	subagentManager := tools.NewSubagentManager(provider, settings.Model, workspace, msgBus)
 	subagentTools := createToolRegistry(workspace, restrict, cfg, msgBus)
+	subagentTools.SetAllowlist(settings.Tools)
 	// Subagent doesn't need spawn/subagent tools to avoid recursion
 	subagentManager.SetTools(subagentTools)
+	subagentManager.SetMaxParallelTools(settings.MaxParallelTools)
+	subagentManager.SetLLMOptions(cfg.GenerationProfile(config.PurposeSubagent, "", "").Options())
 
 	// Register spawn tool (for main agent)
 	spawnTool := tools.NewSpawnTool(subagentManager)
@@ -127,33 +258,83 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	subagentTool := tools.NewSubagentTool(subagentManager)
 	toolsRegistry.Register(subagentTool)
 
-	sessionsManager := session.NewSessionManager(filepath.Join(workspace, "sessions"))
+	store := openStorage(cfg, workspace)
+	sessionsManager := session.NewSessionManagerWithStore(store)
+
+	var memoryIndex *memory.Index
+	if cfg.Memory.Search {
+		memoryIndex = newMemoryIndex(cfg, settings, workspace, sessionsManager)
+		toolsRegistry.Register(tools.NewMemorySearchTool(memoryIndex, cfg.Memory.TopK))
+		toolsRegistry.Register(tools.NewMemorySaveTool(memoryIndex))
+	}
+
+	var traces *trace.Recorder
+	if cfg.Trace.Enabled {
+		traces = trace.NewRecorder(filepath.Join(workspace, "traces"), name)
+		maxAge := time.Duration(cfg.Trace.RetentionDays) * 24 * time.Hour
+		if n, err := traces.Prune(maxAge); err != nil {
+			logger.WarnCF("agent", "Failed to prune old traces", map[string]interface{}{"error": err.Error()})
+		} else if n > 0 {
+			logger.InfoCF("agent", "Pruned old traces", map[string]interface{}{"removed": n})
+		}
+	}
 
 	// Create state manager for atomic state persistence
-	stateManager := state.NewManager(workspace)
+	stateManager := state.NewManagerWithStore(store)
 
 	// Create context builder and set tools registry
 	contextBuilder := NewContextBuilder(workspace)
 	contextBuilder.SetToolsRegistry(toolsRegistry)
+	contextBuilder.SetMediaOptions(settings.Vision, settings.MediaMaxBytes)
+	if memoryIndex != nil {
+		contextBuilder.SetMemoryIndex(memoryIndex, cfg.Memory.TopK)
+	}
+	contextBuilder.SetSkillActivation(newSkillActivator(cfg, settings, contextBuilder.skillsLoader), cfg.Skills.MaxActive)
+	toolsRegistry.Register(newLoadSkillTool(contextBuilder))
+	registerSkillTools(contextBuilder.skillsLoader, cfg, restrict, toolsRegistry, subagentTools)
 
 	return &AgentLoop{
-		bus:            msgBus,
-		provider:       provider,
-		workspace:      workspace,
-		model:          cfg.Agents.Defaults.Model,
-		contextWindow:  cfg.Agents.Defaults.MaxTokens, // Restore context window for summarization
-		maxIterations:  cfg.Agents.Defaults.MaxToolIterations,
-		sessions:       sessionsManager,
-		state:          stateManager,
-		contextBuilder: contextBuilder,
-		tools:          toolsRegistry,
-		summarizing:    sync.Map{},
+		name:             name,
+		cfg:              cfg,
+		bus:              msgBus,
+		provider:         provider,
+		workspace:        workspace,
+		model:            settings.Model,
+		contextWindow:    settings.MaxTokens, // Restore context window for summarization
+		maxIterations:    settings.MaxToolIterations,
+		maxConcurrent:    settings.MaxConcurrentTurns,
+		streaming:        settings.Streaming,
+		maxParallelTools: settings.MaxParallelTools,
+		sessions:         sessionsManager,
+		state:            stateManager,
+		storage:          store,
+		subagents:        subagentManager,
+		contextBuilder:   contextBuilder,
+		tools:            toolsRegistry,
+		subagentTools:    subagentTools,
+		summarizing:      sync.Map{},
+		usage:            ledger,
+		usageCfg:         cfg.Usage,
+		traces:           traces,
 	}
 }
 
+// Run consumes inbound messages until ctx is cancelled or Stop is called.
+// Messages for the same session are processed in order; different sessions
+// run concurrently, up to the configured max_concurrent_turns.
 func (al *AgentLoop) Run(ctx context.Context) error {
 	al.running.Store(true)
 
+	maxConcurrent := al.maxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	dispatcher := newSessionDispatcher(maxConcurrent, func(ctx context.Context, msg bus.InboundMessage) {
+		al.handleInbound(ctx, msg)
+		ackInbound(ctx, al.bus, msg)
+	})
+	defer dispatcher.Wait()
+
 	for al.running.Load() {
 		select {
 		case <-ctx.Done():
@@ -164,43 +345,88 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 				continue
 			}
 
-			response, err := al.processMessage(ctx, msg)
-			if err != nil {
-				response = fmt.Sprintf("Error processing message: %v", err)
+			if resolveApproval(al.bus, al.approvals, msg) {
+				continue
 			}
+			dispatcher.Dispatch(ctx, msg)
+		}
+	}
 
-			if response != "" {
-				// Check if the message tool already sent a response during this round.
-				// If so, skip publishing to avoid duplicate messages to the user.
-				alreadySent := false
-				if tool, ok := al.tools.Get("message"); ok {
-					if mt, ok := tool.(*tools.MessageTool); ok {
-						alreadySent = mt.HasSentInRound()
-					}
-				}
+	return nil
+}
 
-				if !alreadySent {
-					al.bus.PublishOutbound(bus.OutboundMessage{
-						Channel: msg.Channel,
-						ChatID:  msg.ChatID,
-						Content: response,
-					})
-				}
-			}
+// handleInbound processes a single inbound message and publishes the reply.
+func (al *AgentLoop) handleInbound(ctx context.Context, msg bus.InboundMessage) {
+	execCtx := tools.NewExecutionContext(msg.Channel, msg.ChatID)
+	ctx = tools.WithExecutionContext(ctx, execCtx)
+
+	var streamer *replyStreamer
+	if al.streaming && al.channelManager != nil {
+		if editor, ok := al.channelManager.GetEditor(msg.Channel); ok {
+			streamer = newReplyStreamer(ctx, editor, msg.ChatID)
+			ctx = withReplyStreamer(ctx, streamer)
 		}
 	}
 
-	return nil
+	response, err := al.processMessage(ctx, msg)
+	if err != nil {
+		response = fmt.Sprintf("Error processing message: %v", err)
+	}
+
+	// Skip publishing if the message tool already sent a response during
+	// this turn, to avoid duplicate messages to the user.
+	if response != "" && !execCtx.MessageSent() {
+		if streamer != nil && streamer.Finish(response) {
+			return
+		}
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			Channel:  msg.Channel,
+			ChatID:   msg.ChatID,
+			Content:  response,
+			ReplyTo:  msg.ID,
+			Metadata: msg.Metadata,
+		})
+	}
 }
 
 func (al *AgentLoop) Stop() {
 	al.running.Store(false)
 }
 
+// Name returns the agent's name from agents.list, or config.DefaultAgentName.
+func (al *AgentLoop) Name() string {
+	return al.name
+}
+
 func (al *AgentLoop) RegisterTool(tool tools.Tool) {
 	al.tools.Register(tool)
 }
 
+// ToolRegistry returns the registry the agent executes tool calls with.
+func (al *AgentLoop) ToolRegistry() *tools.ToolRegistry {
+	return al.tools
+}
+
+// SetApprovals sends tool calls that the approval policy matches, including
+// those of subagents, to m and lets Run resolve /approve and /deny replies.
+func (al *AgentLoop) SetApprovals(m *approval.Manager) {
+	var approver tools.Approver
+	if m != nil {
+		approver = m
+	}
+	al.approvals = m
+	al.tools.SetApprover(approver)
+	al.subagentTools.SetApprover(approver)
+}
+
+// SetMCP offers the tools of the MCP servers m manages to the agent and its
+// subagents, and describes the servers in the system prompt.
+func (al *AgentLoop) SetMCP(m *mcp.Manager) {
+	m.Attach(al.tools)
+	m.Attach(al.subagentTools)
+	al.contextBuilder.SetMCP(m)
+}
+
 // SetVoiceCallbacks attaches TTS synthesis and media-send callbacks to the
 // message tool so it can handle voice=true calls. Safe to call after init.
 func (al *AgentLoop) SetVoiceCallbacks(synth tools.SynthesizeCallback, sendMedia tools.SendMediaCallback) {
@@ -216,6 +442,16 @@ func (al *AgentLoop) SetChannelManager(cm *channels.Manager) {
 	al.channelManager = cm
 }
 
+// Storage returns the backend the agent keeps its sessions and state in.
+func (al *AgentLoop) Storage() storage.Backend {
+	return al.storage
+}
+
+// StateManager returns the agent's workspace state.
+func (al *AgentLoop) StateManager() *state.Manager {
+	return al.state
+}
+
 // RecordLastChannel records the last active channel for this workspace.
 // This uses the atomic state save mechanism to prevent data loss on crash.
 func (al *AgentLoop) RecordLastChannel(channel string) error {
@@ -244,6 +480,54 @@ func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sess
 	return al.processMessage(ctx, msg)
 }
 
+// ProcessAPIRequest runs one turn for the gateway's OpenAI-compatible API.
+// With a session key the conversation continues from the session history.
+// Without one the request is stateless: history holds the client's earlier
+// messages and nothing is kept for the next request. onDelta, if set,
+// receives the reply text as it streams.
+func (al *AgentLoop) ProcessAPIRequest(ctx context.Context, sessionKey, chatID, content string, history []providers.Message, onDelta func(string)) (string, error) {
+	if onDelta != nil {
+		ctx = withReplyStreamer(ctx, newCallbackStreamer(onDelta))
+	}
+
+	if sessionKey != "" {
+		return al.processMessage(ctx, bus.InboundMessage{
+			Channel:    "api",
+			SenderID:   chatID,
+			ChatID:     chatID,
+			Content:    content,
+			SessionKey: sessionKey,
+		})
+	}
+
+	// A stateless request's conversation lives in memory for this call only,
+	// under a key of its own, so concurrent requests never see each other's
+	// messages
+	id := make([]byte, 8)
+	rand.Read(id)
+	key := "api:stateless:" + hex.EncodeToString(id)
+	sessions := session.NewSessionManager("")
+	sessions.GetOrCreate(key)
+	sessions.SetHistory(key, history)
+
+	return al.runAgentLoop(ctx, processOptions{
+		SessionKey:      key,
+		Channel:         "api",
+		ChatID:          chatID,
+		UserMessage:     content,
+		DefaultResponse: "I've completed processing but have no response to give.",
+		Sessions:        sessions,
+	})
+}
+
+// sessionsFor returns where the conversation of a turn is kept.
+func (al *AgentLoop) sessionsFor(opts processOptions) *session.SessionManager {
+	if opts.Sessions != nil {
+		return opts.Sessions
+	}
+	return al.sessions
+}
+
 // ProcessHeartbeat processes a heartbeat request without session history.
 // Each heartbeat is independent and doesn't accumulate context.
 func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, chatID string) (string, error) {
@@ -251,6 +535,7 @@ func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, cha
 		SessionKey:      "heartbeat",
 		Channel:         channel,
 		ChatID:          chatID,
+		Purpose:         config.PurposeHeartbeat,
 		UserMessage:     content,
 		DefaultResponse: "I've completed processing but have no response to give.",
 		EnableSummary:   false,
@@ -291,6 +576,7 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		Channel:         msg.Channel,
 		ChatID:          msg.ChatID,
 		UserMessage:     msg.Content,
+		Media:           msg.Media,
 		DefaultResponse: "I've completed processing but have no response to give.",
 		EnableSummary:   true,
 		SendResponse:    false,
@@ -349,9 +635,106 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 	return "", nil
 }
 
+// currentModel returns the model used for new turns.
+func (al *AgentLoop) currentModel() string {
+	al.modelMu.RLock()
+	defer al.modelMu.RUnlock()
+	return al.model
+}
+
+// setModel replaces the model used for new turns and returns the previous one.
+func (al *AgentLoop) setModel(model string) string {
+	al.modelMu.Lock()
+	defer al.modelMu.Unlock()
+	old := al.model
+	al.model = model
+	return old
+}
+
+// currentProvider returns the provider used for new LLM calls.
+func (al *AgentLoop) currentProvider() providers.LLMProvider {
+	al.modelMu.RLock()
+	defer al.modelMu.RUnlock()
+	return al.provider
+}
+
+// limits returns the context window and the tool iteration limit.
+func (al *AgentLoop) limits() (contextWindow, maxIterations int) {
+	al.modelMu.RLock()
+	defer al.modelMu.RUnlock()
+	return al.contextWindow, al.maxIterations
+}
+
+// Reload applies a new config and provider. New turns use the configured
+// model, limits, generation profiles, prices and budgets; turns in flight
+// finish with the old ones. A model picked with /switch is kept unless the configured model
+// changed. Tools, workspace and storage are only set up at startup.
+func (al *AgentLoop) Reload(cfg *config.Config, provider providers.LLMProvider) error {
+	settings, ok := cfg.AgentSettings(al.name)
+	if !ok {
+		return fmt.Errorf("agent %q is no longer configured", al.name)
+	}
+
+	if al.usage != nil {
+		al.usage.SetPrices(cfg.Usage.Prices)
+		provider = usage.NewProvider(provider, al.usage, al.name, cfg.Usage)
+	}
+
+	al.modelMu.Lock()
+	previous, _ := al.cfg.AgentSettings(al.name)
+	al.cfg = cfg
+	al.provider = provider
+	if settings.Model != previous.Model {
+		al.model = settings.Model
+	}
+	al.contextWindow = settings.MaxTokens
+	al.maxIterations = settings.MaxToolIterations
+	al.usageCfg = cfg.Usage
+	al.modelMu.Unlock()
+
+	al.subagents.SetProvider(provider, settings.Model)
+	al.subagents.SetLLMOptions(cfg.GenerationProfile(config.PurposeSubagent, "", "").Options())
+	al.contextBuilder.SetSkillActivation(newSkillActivator(cfg, settings, al.contextBuilder.skillsLoader), cfg.Skills.MaxActive)
+	return nil
+}
+
 // runAgentLoop is the core message processing logic.
 // It handles context building, LLM calls, tool execution, and response handling.
 func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (string, error) {
+	if opts.Model == "" {
+		opts.Model = al.currentModel()
+	}
+	sessions := al.sessionsFor(opts)
+
+	purpose := opts.Purpose
+	if purpose == "" {
+		purpose = config.PurposeChat
+	}
+	ctx = usage.WithScope(ctx, usage.Scope{
+		SessionKey: opts.SessionKey,
+		Channel:    opts.Channel,
+		ChatID:     opts.ChatID,
+		Purpose:    purpose,
+	})
+	ctx = withSession(ctx, sessions, opts.SessionKey)
+
+	// Enforce the daily usage budget before spending anything on this turn
+	if refusal, ok := al.applyBudget(&opts); !ok {
+		return refusal, nil
+	}
+
+	// Trace the turn, from the inbound message to the final reply
+	turn := al.traces.Start(trace.TurnInfo{
+		SessionKey:  opts.SessionKey,
+		Channel:     opts.Channel,
+		ChatID:      opts.ChatID,
+		Model:       opts.Model,
+		Purpose:     purpose,
+		UserMessage: opts.UserMessage,
+		Media:       opts.Media,
+	})
+	ctx = trace.WithTurn(ctx, turn)
+
 	// 0. Record last channel for heartbeat notifications (skip internal channels)
 	if opts.Channel != "" && opts.ChatID != "" {
 		// Don't record internal channels (cli, system, subagent)
@@ -363,31 +746,46 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		}
 	}
 
-	// 1. Update tool contexts
-	al.updateToolContexts(opts.Channel, opts.ChatID)
+	// 1. Attach per-turn tool context so shared tools target this chat
+	ec := tools.ExecutionContextFrom(ctx)
+	if ec == nil || ec.Channel != opts.Channel || ec.ChatID != opts.ChatID {
+		ec = tools.NewExecutionContext(opts.Channel, opts.ChatID)
+		ctx = tools.WithExecutionContext(ctx, ec)
+	}
+	ec.SessionKey = opts.SessionKey
 
 	// 2. Build messages (skip history for heartbeat)
 	var history []providers.Message
 	var summary string
 	if !opts.NoHistory {
-		history = al.sessions.GetHistory(opts.SessionKey)
-		summary = al.sessions.GetSummary(opts.SessionKey)
+		history = sessions.GetHistory(opts.SessionKey)
+		summary = sessions.GetSummary(opts.SessionKey)
+	} else {
+		history = opts.History
+	}
+	if activated := al.contextBuilder.AutoActivateSkills(ctx, sessions, opts.SessionKey, opts.UserMessage); len(activated) > 0 {
+		logger.InfoCF("agent", "Skills activated by the message", map[string]interface{}{
+			"session_key": opts.SessionKey,
+			"skills":      activated,
+		})
 	}
 	messages := al.contextBuilder.BuildMessages(
+		ctx,
 		history,
 		summary,
 		opts.UserMessage,
-		nil,
+		opts.Media,
 		opts.Channel,
 		opts.ChatID,
 	)
 
 	// 3. Save user message to session
-	al.sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
+	sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
 
 	// 4. Run LLM iteration loop
 	finalContent, iteration, err := al.runLLMIteration(ctx, messages, opts)
 	if err != nil {
+		turn.End("", iteration, err)
 		return "", err
 	}
 
@@ -399,9 +797,11 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		finalContent = opts.DefaultResponse
 	}
 
+	turn.End(finalContent, iteration, nil)
+
 	// 6. Save final assistant message to session
-	al.sessions.AddMessage(opts.SessionKey, "assistant", finalContent)
-	al.sessions.Save(opts.SessionKey)
+	sessions.AddMessage(opts.SessionKey, "assistant", finalContent)
+	sessions.Save(opts.SessionKey)
 
 	// 7. Optional: summarization
 	if opts.EnableSummary {
@@ -434,28 +834,32 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.Message, opts processOptions) (string, int, error) {
 	iteration := 0
 	var finalContent string
+	sessions := al.sessionsFor(opts)
+	turn := trace.FromContext(ctx)
+	_, maxIterations := al.limits()
 
-	for iteration < al.maxIterations {
+	for iteration < maxIterations {
 		iteration++
 
 		logger.DebugCF("agent", "LLM iteration",
 			map[string]interface{}{
 				"iteration": iteration,
-				"max":       al.maxIterations,
+				"max":       maxIterations,
 			})
 
 		// Build tool definitions
 		providerToolDefs := al.tools.ToProviderDefs()
+		llmOptions := al.generationOptions(opts.Purpose, opts.Channel, opts.ChatID)
 
 		// Log LLM request details
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
 				"iteration":         iteration,
-				"model":             al.model,
+				"model":             opts.Model,
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
-				"max_tokens":        8192,
-				"temperature":       0.7,
+				"max_tokens":        llmOptions["max_tokens"],
+				"temperature":       llmOptions["temperature"],
 				"system_prompt_len": len(messages[0].Content),
 			})
 
@@ -473,21 +877,35 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		// Retry loop for context/token errors
 		maxRetries := 2
 		for retry := 0; retry <= maxRetries; retry++ {
-			response, err = al.provider.Chat(ctx, messages, providerToolDefs, al.model, map[string]interface{}{
-				"max_tokens":  8192,
-				"temperature": 0.7,
-			})
+			// Skills loaded by the previous tool calls apply from this call on
+			messages = al.contextBuilder.ApplyActiveSkills(messages, sessions, opts.SessionKey)
+			turn.LLMRequest(iteration, opts.Model, messages, providerToolDefs, llmOptions)
+			start := time.Now()
+			response, err = al.callLLM(ctx, messages, providerToolDefs, opts.Model, llmOptions)
+			turn.LLMResponse(iteration, response, err, time.Since(start))
 
 			if err == nil {
 				break // Success
 			}
 
+			// Fall back to the text-only message if the model can't take media
+			if hasMedia(messages) && isMediaUnsupportedError(err) && retry < maxRetries {
+				logger.WarnCF("agent", "Model rejected media input, retrying without attachments", map[string]interface{}{
+					"error": err.Error(),
+					"model": opts.Model,
+				})
+				messages = stripMedia(messages)
+				continue
+			}
+
 			errMsg := strings.ToLower(err.Error())
-			// Check for context window errors (provider specific, but usually contain "token" or "invalid")
-			isContextError := strings.Contains(errMsg, "token") ||
-				strings.Contains(errMsg, "context") ||
-				strings.Contains(errMsg, "invalidparameter") ||
-				strings.Contains(errMsg, "length")
+			// Check for context window errors (provider specific, but usually contain "token" or "invalid").
+			// Rate limits and outages often mention tokens too, but compressing won't help those.
+			isContextError := !providers.IsTransientError(err) &&
+				(strings.Contains(errMsg, "token") ||
+					strings.Contains(errMsg, "context") ||
+					strings.Contains(errMsg, "invalidparameter") ||
+					strings.Contains(errMsg, "length"))
 
 			if isContextError && retry < maxRetries {
 				logger.WarnCF("agent", "Context window error detected, attempting compression", map[string]interface{}{
@@ -505,16 +923,17 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				}
 
 				// Force compression
-				al.forceCompression(opts.SessionKey)
+				al.forceCompression(sessions, opts.SessionKey)
 
 				// Rebuild messages with compressed history
 				// Note: We need to reload history from session manager because forceCompression changed it
-				newHistory := al.sessions.GetHistory(opts.SessionKey)
-				newSummary := al.sessions.GetSummary(opts.SessionKey)
+				newHistory := sessions.GetHistory(opts.SessionKey)
+				newSummary := sessions.GetSummary(opts.SessionKey)
 
 				// Re-create messages for the next attempt
 				// We keep the current user message (opts.UserMessage) effectively
 				messages = al.contextBuilder.BuildMessages(
+					ctx,
 					newHistory,
 					newSummary,
 					opts.UserMessage,
@@ -578,6 +997,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				// because the "current message" is already saved in history (step 3).
 
 				messages = al.contextBuilder.BuildMessages(
+					ctx,
 					newHistory,
 					newSummary,
 					"", // Empty because history already contains the relevant messages
@@ -609,6 +1029,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				map[string]interface{}{
 					"iteration":     iteration,
 					"content_chars": len(finalContent),
+					"backend":       response.Backend,
 				})
 			break
 		}
@@ -644,10 +1065,10 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		messages = append(messages, assistantMsg)
 
 		// Save assistant message with tool calls to session
-		al.sessions.AddFullMessage(opts.SessionKey, assistantMsg)
+		sessions.AddFullMessage(opts.SessionKey, assistantMsg)
 
-		// Execute tool calls
-		for _, tc := range response.ToolCalls {
+		// Execute tool calls, concurrently where the tools allow it
+		results := al.tools.ExecuteToolCalls(ctx, response.ToolCalls, al.maxParallelTools, func(ctx context.Context, tc providers.ToolCall) *tools.ToolResult {
 			// Log tool call with arguments preview
 			argsJSON, _ := json.Marshal(tc.Arguments)
 			argsPreview := utils.Truncate(string(argsJSON), 200)
@@ -673,7 +1094,15 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				}
 			}
 
-			toolResult := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback)
+			start := time.Now()
+			result := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback)
+			turn.ToolCall(iteration, tc, result, time.Since(start))
+			return result
+		})
+
+		// Handle results in call order so ToolCallIDs pair up with the assistant message
+		for i, tc := range response.ToolCalls {
+			toolResult := results[i]
 
 			// Send ForUser content to user immediately if not Silent
 			if !toolResult.Silent && toolResult.ForUser != "" && opts.SendResponse {
@@ -703,38 +1132,19 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			messages = append(messages, toolResultMsg)
 
 			// Save tool result message to session
-			al.sessions.AddFullMessage(opts.SessionKey, toolResultMsg)
+			sessions.AddFullMessage(opts.SessionKey, toolResultMsg)
 		}
 	}
 
 	return finalContent, iteration, nil
 }
 
-// updateToolContexts updates the context for tools that need channel/chatID info.
-func (al *AgentLoop) updateToolContexts(channel, chatID string) {
-	// Use ContextualTool interface instead of type assertions
-	if tool, ok := al.tools.Get("message"); ok {
-		if mt, ok := tool.(tools.ContextualTool); ok {
-			mt.SetContext(channel, chatID)
-		}
-	}
-	if tool, ok := al.tools.Get("spawn"); ok {
-		if st, ok := tool.(tools.ContextualTool); ok {
-			st.SetContext(channel, chatID)
-		}
-	}
-	if tool, ok := al.tools.Get("subagent"); ok {
-		if st, ok := tool.(tools.ContextualTool); ok {
-			st.SetContext(channel, chatID)
-		}
-	}
-}
-
 // maybeSummarize triggers summarization if the session history exceeds thresholds.
 func (al *AgentLoop) maybeSummarize(sessionKey, channel, chatID string) {
 	newHistory := al.sessions.GetHistory(sessionKey)
 	tokenEstimate := al.estimateTokens(newHistory)
-	threshold := al.contextWindow * 75 / 100
+	contextWindow, _ := al.limits()
+	threshold := contextWindow * 75 / 100
 
 	if len(newHistory) > 20 || tokenEstimate > threshold {
 		if _, loading := al.summarizing.LoadOrStore(sessionKey, true); !loading {
@@ -756,8 +1166,8 @@ func (al *AgentLoop) maybeSummarize(sessionKey, channel, chatID string) {
 
 // forceCompression aggressively reduces context when the limit is hit.
 // It drops the oldest 50% of messages (keeping system prompt and last user message).
-func (al *AgentLoop) forceCompression(sessionKey string) {
-	history := al.sessions.GetHistory(sessionKey)
+func (al *AgentLoop) forceCompression(sessions *session.SessionManager, sessionKey string) {
+	history := sessions.GetHistory(sessionKey)
 	if len(history) <= 4 {
 		return
 	}
@@ -804,8 +1214,8 @@ func (al *AgentLoop) forceCompression(sessionKey string) {
 	newHistory = append(newHistory, history[len(history)-1]) // Last message
 
 	// Update session
-	al.sessions.SetHistory(sessionKey, newHistory)
-	al.sessions.Save(sessionKey)
+	sessions.SetHistory(sessionKey, newHistory)
+	sessions.Save(sessionKey)
 
 	logger.WarnCF("agent", "Forced compression executed", map[string]interface{}{
 		"session_key":  sessionKey,
@@ -831,6 +1241,19 @@ func (al *AgentLoop) GetStartupInfo() map[string]interface{} {
 	return info
 }
 
+// callLLM sends one request to the provider, streaming the reply into the
+// turn's editable channel message when both sides support it.
+func (al *AgentLoop) callLLM(ctx context.Context, messages []providers.Message, toolDefs []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	provider := al.currentProvider()
+	if streamer := replyStreamerFrom(ctx); streamer != nil {
+		if sp, ok := provider.(providers.StreamingProvider); ok {
+			streamer.Reset()
+			return sp.ChatStream(ctx, messages, toolDefs, model, options, streamer.OnDelta)
+		}
+	}
+	return provider.Chat(ctx, messages, toolDefs, model, options)
+}
+
 // formatMessagesForLog formats messages for logging
 func formatMessagesForLog(messages []providers.Message) string {
 	if len(messages) == 0 {
@@ -854,6 +1277,9 @@ func formatMessagesForLog(messages []providers.Message) string {
 			content := utils.Truncate(msg.Content, 200)
 			result += fmt.Sprintf("  Content: %s\n", content)
 		}
+		if len(msg.Parts) > 0 {
+			result += fmt.Sprintf("  Parts: %d\n", len(msg.Parts))
+		}
 		if msg.ToolCallID != "" {
 			result += fmt.Sprintf("  ToolCallID: %s\n", msg.ToolCallID)
 		}
@@ -886,6 +1312,7 @@ func formatToolsForLog(tools []providers.ToolDefinition) string {
 func (al *AgentLoop) summarizeSession(sessionKey string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
+	ctx = usage.WithScope(ctx, usage.Scope{SessionKey: sessionKey, Purpose: config.PurposeSummarize})
 
 	history := al.sessions.GetHistory(sessionKey)
 	summary := al.sessions.GetSummary(sessionKey)
@@ -899,7 +1326,8 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 	// Oversized Message Guard
 	// Skip messages larger than 50% of context window to prevent summarizer overflow
-	maxMessageTokens := al.contextWindow / 2
+	contextWindow, _ := al.limits()
+	maxMessageTokens := contextWindow / 2
 	validMessages := make([]providers.Message, 0)
 	omitted := false
 
@@ -933,10 +1361,8 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 		// Merge them
 		mergePrompt := fmt.Sprintf("Merge these two conversation summaries into one cohesive summary:\n\n1: %s\n\n2: %s", s1, s2)
-		resp, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, al.model, map[string]interface{}{
-			"max_tokens":  1024,
-			"temperature": 0.3,
-		})
+		resp, err := al.currentProvider().Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, al.currentModel(),
+			al.generationOptions(config.PurposeSummarize, "", ""))
 		if err == nil {
 			finalSummary = resp.Content
 		} else {
@@ -968,16 +1394,134 @@ func (al *AgentLoop) summarizeBatch(ctx context.Context, batch []providers.Messa
 		prompt += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
 	}
 
-	response, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, al.model, map[string]interface{}{
-		"max_tokens":  1024,
-		"temperature": 0.3,
-	})
+	response, err := al.currentProvider().Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, al.currentModel(),
+		al.generationOptions(config.PurposeSummarize, "", ""))
 	if err != nil {
 		return "", err
 	}
 	return response.Content, nil
 }
 
+// usageConfig returns the budgets and prices of the current config.
+func (al *AgentLoop) usageConfig() config.UsageConfig {
+	al.modelMu.RLock()
+	defer al.modelMu.RUnlock()
+	return al.usageCfg
+}
+
+// applyBudget checks today's usage against the configured budget. Over
+// budget, it either switches the turn to the downgrade model or returns a
+// refusal message and false.
+func (al *AgentLoop) applyBudget(opts *processOptions) (string, bool) {
+	if al.usage == nil {
+		return "", true
+	}
+	usageCfg := al.usageConfig()
+	over, reason := usage.BudgetStatus(usageCfg, al.usage.Today())
+	if !over {
+		return "", true
+	}
+
+	if usageCfg.BudgetAction == "downgrade" && usageCfg.DowngradeModel != "" {
+		if opts.Model != usageCfg.DowngradeModel {
+			logger.WarnCF("agent", "Usage budget exceeded, downgrading model", map[string]interface{}{
+				"reason": reason,
+				"from":   opts.Model,
+				"to":     usageCfg.DowngradeModel,
+			})
+			opts.Model = usageCfg.DowngradeModel
+		}
+		return "", true
+	}
+
+	logger.WarnCF("agent", "Usage budget exceeded, refusing turn", map[string]interface{}{
+		"reason":      reason,
+		"session_key": opts.SessionKey,
+	})
+	return fmt.Sprintf("⚠️ Usage limit: %s. Try again tomorrow.", reason), false
+}
+
+// usageReport formats today's usage for the /usage command.
+func (al *AgentLoop) usageReport(sessionKey string) string {
+	if al.usage == nil {
+		return "Usage tracking is disabled"
+	}
+
+	usageCfg := al.usageConfig()
+	today := al.usage.Today()
+	report := fmt.Sprintf("Usage today: %d calls, %d tokens, %s",
+		today.Calls, today.TotalTokens, usage.FormatCost(today.Cost))
+	if usageCfg.DailyBudgetUSD > 0 {
+		report += fmt.Sprintf(" of %s budget", usage.FormatCost(usageCfg.DailyBudgetUSD))
+	}
+	if usageCfg.DailyTokenBudget > 0 {
+		report += fmt.Sprintf(" (token budget %d)", usageCfg.DailyTokenBudget)
+	}
+
+	now := time.Now()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	records, err := usage.Load(al.usage.Dir(), startOfDay)
+	if err != nil {
+		return report
+	}
+	var session usage.Totals
+	for _, rec := range records {
+		if rec.SessionKey == sessionKey && usage.ByAgent(rec) == al.name {
+			session.Add(rec)
+		}
+	}
+	return report + fmt.Sprintf("\nThis chat today: %d calls, %d tokens, %s",
+		session.Calls, session.TotalTokens, usage.FormatCost(session.Cost))
+}
+
+// maxHistoryReport caps the number of messages /history shows.
+const maxHistoryReport = 50
+
+// historyReport lists the last n user and assistant messages of a session.
+func (al *AgentLoop) historyReport(sessionKey string, n int) string {
+	if n > maxHistoryReport {
+		n = maxHistoryReport
+	}
+
+	var lines []string
+	history := al.sessions.GetHistory(sessionKey)
+	for i := len(history) - 1; i >= 0 && len(lines) < n; i-- {
+		msg := history[i]
+		if msg.Role != "user" && msg.Role != "assistant" {
+			continue
+		}
+		line := msg.Role + ":"
+		if content := strings.Join(strings.Fields(msg.Content), " "); content != "" {
+			line += " " + utils.Truncate(content, 200)
+		}
+		for _, tc := range msg.ToolCalls {
+			line += fmt.Sprintf(" [→ %s]", session.ToolCallName(tc))
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No messages in this conversation yet."
+	}
+
+	// Collected newest first
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return fmt.Sprintf("Recent messages (%d):\n\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// generationOptions resolves the LLM options for a call from the configured
+// generation profiles.
+func (al *AgentLoop) generationOptions(purpose, channel, chatID string) map[string]interface{} {
+	if purpose == "" {
+		purpose = config.PurposeChat
+	}
+	al.modelMu.RLock()
+	cfg := al.cfg
+	al.modelMu.RUnlock()
+	return cfg.GenerationProfile(purpose, channel, chatID).Options()
+}
+
 // estimateTokens estimates the number of tokens in a message list.
 // Uses a safe heuristic of 2.5 characters per token to account for CJK and other
 // overheads better than the previous 3 chars/token.
@@ -1011,7 +1555,7 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 		}
 		switch args[0] {
 		case "model":
-			return fmt.Sprintf("Current model: %s", al.model), true
+			return fmt.Sprintf("Current model: %s", al.currentModel()), true
 		case "channel":
 			return fmt.Sprintf("Current channel: %s", msg.Channel), true
 		default:
@@ -1039,6 +1583,34 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 			return fmt.Sprintf("Unknown list target: %s", args[0]), true
 		}
 
+	case "/usage":
+		return al.usageReport(msg.SessionKey), true
+
+	case "/reset":
+		if err := al.sessions.Reset(msg.SessionKey); err != nil {
+			return fmt.Sprintf("Failed to reset the conversation: %v", err), true
+		}
+		logger.InfoCF("agent", "Session reset", map[string]interface{}{"session_key": msg.SessionKey})
+		return "Conversation history cleared. Memories are kept.", true
+
+	case "/history":
+		n := 10
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v <= 0 {
+				return "Usage: /history [count]", true
+			}
+			n = v
+		}
+		return al.historyReport(msg.SessionKey, n), true
+
+	case "/summary":
+		summary := al.sessions.GetSummary(msg.SessionKey)
+		if summary == "" {
+			return "No summary yet. Conversations are summarized once they grow long.", true
+		}
+		return "Summary of earlier messages:\n\n" + summary, true
+
 	case "/switch":
 		if len(args) < 3 || args[1] != "to" {
 			return "Usage: /switch [model|channel] to <name>", true
@@ -1048,8 +1620,7 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 
 		switch target {
 		case "model":
-			oldModel := al.model
-			al.model = value
+			oldModel := al.setModel(value)
 			return fmt.Sprintf("Switched model from %s to %s", oldModel, value), true
 		case "channel":
 			// This changes the 'default' channel for some operations, or effectively redirects output?
@@ -2,16 +2,22 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/trace"
+	"github.com/sipeed/picoclaw/pkg/usage"
 )
 
 // mockProvider is a simple mock LLM provider for testing
@@ -624,3 +630,1014 @@ func TestAgentLoop_ContextExhaustionRetry(t *testing.T) {
 		t.Errorf("Expected history to be compressed (len < 8), got %d", len(finalHistory))
 	}
 }
+
+// blockingMockProvider blocks on the "slow" prompt until released
+type blockingMockProvider struct {
+	release chan struct{}
+}
+
+func (m *blockingMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	last := messages[len(messages)-1].Content
+	if last == "slow" {
+		select {
+		case <-m.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &providers.LLMResponse{Content: "reply: " + last}, nil
+}
+
+func (m *blockingMockProvider) GetDefaultModel() string {
+	return "mock-blocking-model"
+}
+
+// TestAgentLoop_Run_SessionsRunConcurrently verifies a slow turn in one session
+// does not stall other sessions, while turns within a session stay ordered
+func TestAgentLoop_Run_SessionsRunConcurrently(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:          tmpDir,
+				Model:              "test-model",
+				MaxTokens:          4096,
+				MaxToolIterations:  10,
+				MaxConcurrentTurns: 2,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	provider := &blockingMockProvider{release: make(chan struct{})}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "test", ChatID: "a", Content: "slow", SessionKey: "test:a"})
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "test", ChatID: "a", Content: "after slow", SessionKey: "test:a"})
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "test", ChatID: "b", Content: "fast", SessionKey: "test:b"})
+
+	next := func() bus.OutboundMessage {
+		outCtx, outCancel := context.WithTimeout(ctx, responseTimeout)
+		defer outCancel()
+		msg, ok := msgBus.SubscribeOutbound(outCtx)
+		if !ok {
+			t.Fatal("Timed out waiting for outbound message")
+		}
+		return msg
+	}
+
+	if msg := next(); msg.ChatID != "b" || msg.Content != "reply: fast" {
+		t.Fatalf("Expected session b to finish first, got %s: %q", msg.ChatID, msg.Content)
+	}
+
+	close(provider.release)
+
+	if msg := next(); msg.Content != "reply: slow" {
+		t.Errorf("Expected 'reply: slow', got %q", msg.Content)
+	}
+	if msg := next(); msg.Content != "reply: after slow" {
+		t.Errorf("Expected 'reply: after slow', got %q", msg.Content)
+	}
+}
+
+// mediaRecordingProvider records the user message of every call and rejects
+// media the first time if rejectMedia is set
+type mediaRecordingProvider struct {
+	rejectMedia bool
+	calls       []providers.Message
+}
+
+func (m *mediaRecordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	last := messages[len(messages)-1]
+	m.calls = append(m.calls, last)
+	if m.rejectMedia && last.HasMedia() {
+		return nil, fmt.Errorf("API request failed: model does not support image input")
+	}
+	return &providers.LLMResponse{Content: "seen"}, nil
+}
+
+func (m *mediaRecordingProvider) GetDefaultModel() string {
+	return "mock-vision-model"
+}
+
+func TestIsMediaUnsupportedError(t *testing.T) {
+	tests := map[string]bool{
+		"Invalid content type. image_url is only supported by certain models.":        true,
+		"No endpoints found that support image input":                                 true,
+		"failed to deserialize: unknown variant `image_url`, expected `text`":         true,
+		"API request failed: model does not support image input":                      true,
+		"InvalidParameter: Total tokens of image and text exceed max message tokens":  false,
+		"Invalid image: the image exceeds 5 MB":                                       false,
+		"vision-preview is deprecated, use another model":                             false,
+		"API request failed:\n  Status: 503\n  Body:   multimodal backend overloaded": false,
+	}
+	for msg, want := range tests {
+		if got := isMediaUnsupportedError(errors.New(msg)); got != want {
+			t.Errorf("isMediaUnsupportedError(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
+
+// TestAgentLoop_InboundMediaAttached verifies inbound images reach the provider
+// as content parts and are removed once the message is acknowledged
+func TestAgentLoop_InboundMediaAttached(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imagePath := filepath.Join(tmpDir, "photo.png")
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(imagePath, pngHeader, 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Vision:            true,
+			},
+		},
+	}
+
+	provider := &mediaRecordingProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	msg := bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "what is this?\n[image: photo]",
+		Media:      []string{imagePath},
+		SessionKey: "telegram:chat1",
+	}
+	al.handleInbound(context.Background(), msg)
+
+	if len(provider.calls) != 1 {
+		t.Fatalf("Expected 1 provider call, got %d", len(provider.calls))
+	}
+	parts := provider.calls[0].Parts
+	if len(parts) != 2 {
+		t.Fatalf("Expected text + image parts, got %d", len(parts))
+	}
+	if parts[1].Type != providers.PartImage || parts[1].MIMEType != "image/png" {
+		t.Errorf("Expected image/png part, got %s %s", parts[1].Type, parts[1].MIMEType)
+	}
+
+	// A turn cut short by shutdown is redelivered, so its media must survive
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ackInbound(cancelled, al.bus, msg)
+	if _, err := os.Stat(imagePath); err != nil {
+		t.Errorf("Expected media file to be kept for redelivery: %v", err)
+	}
+
+	ackInbound(context.Background(), al.bus, msg)
+	if _, err := os.Stat(imagePath); !os.IsNotExist(err) {
+		t.Error("Expected media file to be removed after the ack")
+	}
+
+	// Session history stays text-only
+	for _, msg := range al.sessions.GetHistory("telegram:chat1") {
+		if len(msg.Parts) > 0 {
+			t.Error("Expected session history to contain no content parts")
+		}
+	}
+}
+
+// TestAgentLoop_MediaRejectedFallsBackToText verifies a model that rejects
+// image input is retried with the text-only message
+func TestAgentLoop_MediaRejectedFallsBackToText(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imagePath := filepath.Join(tmpDir, "photo.jpg")
+	if err := os.WriteFile(imagePath, []byte("\xff\xd8\xff\xe0"), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Vision:            true,
+			},
+		},
+	}
+
+	provider := &mediaRecordingProvider{rejectMedia: true}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	response, err := al.processMessage(context.Background(), bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "[image: photo]",
+		Media:      []string{imagePath},
+		SessionKey: "telegram:chat1",
+	})
+	if err != nil {
+		t.Fatalf("Expected fallback to succeed, got error: %v", err)
+	}
+	if response != "seen" {
+		t.Errorf("Expected 'seen', got %q", response)
+	}
+	if len(provider.calls) != 2 {
+		t.Fatalf("Expected 2 provider calls, got %d", len(provider.calls))
+	}
+	if provider.calls[1].HasMedia() || provider.calls[1].Content != "[image: photo]" {
+		t.Errorf("Expected text-only retry, got %+v", provider.calls[1])
+	}
+}
+
+// streamingMockProvider streams its reply in fixed fragments
+type streamingMockProvider struct {
+	fragments []string
+}
+
+func (m *streamingMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	return m.ChatStream(ctx, messages, tools, model, opts, nil)
+}
+
+func (m *streamingMockProvider) ChatStream(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}, onDelta providers.StreamCallback) (*providers.LLMResponse, error) {
+	content := ""
+	for _, fragment := range m.fragments {
+		content += fragment
+		if onDelta != nil {
+			onDelta(fragment)
+		}
+	}
+	return &providers.LLMResponse{Content: content}, nil
+}
+
+func (m *streamingMockProvider) GetDefaultModel() string {
+	return "mock-streaming-model"
+}
+
+// editableChannel is a fake channel that records editable sends, edits and
+// deletions
+type editableChannel struct {
+	sends   []string
+	edits   []string
+	deletes []string
+
+	failFinalEdit string // edits to this content fail
+}
+
+func (c *editableChannel) Name() string                                            { return "editable" }
+func (c *editableChannel) Start(ctx context.Context) error                         { return nil }
+func (c *editableChannel) Stop(ctx context.Context) error                          { return nil }
+func (c *editableChannel) IsRunning() bool                                         { return true }
+func (c *editableChannel) IsAllowed(senderID string) bool                          { return true }
+func (c *editableChannel) Send(ctx context.Context, msg bus.OutboundMessage) error { return nil }
+
+func (c *editableChannel) SendEditable(ctx context.Context, chatID, content string) (string, error) {
+	c.sends = append(c.sends, content)
+	return "msg-1", nil
+}
+
+func (c *editableChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
+	if content == c.failFinalEdit {
+		return fmt.Errorf("message is too long")
+	}
+	c.edits = append(c.edits, content)
+	return nil
+}
+
+func (c *editableChannel) DeleteMessage(ctx context.Context, chatID, messageID string) error {
+	c.deletes = append(c.deletes, messageID)
+	return nil
+}
+
+// TestAgentLoop_StreamsIntoEditableMessage verifies streamed replies are
+// posted once and finalized with an edit instead of a second message
+func TestAgentLoop_StreamsIntoEditableMessage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Streaming:         true,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &streamingMockProvider{fragments: []string{"Hello", ", ", "world"}})
+
+	cm, err := channels.NewManager(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("Failed to create channel manager: %v", err)
+	}
+	ch := &editableChannel{}
+	cm.RegisterChannel("editable", ch)
+	al.SetChannelManager(cm)
+
+	al.handleInbound(context.Background(), bus.InboundMessage{
+		Channel:    "editable",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "hi",
+		SessionKey: "editable:chat1",
+	})
+
+	if len(ch.sends) != 1 || ch.sends[0] != "Hello" {
+		t.Errorf("Expected one placeholder send with the first fragment, got %v", ch.sends)
+	}
+	if len(ch.edits) == 0 || ch.edits[len(ch.edits)-1] != "Hello, world" {
+		t.Errorf("Expected final edit with the full reply, got %v", ch.edits)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if msg, ok := msgBus.SubscribeOutbound(ctx); ok {
+		t.Errorf("Expected no outbound message after streaming, got %q", msg.Content)
+	}
+}
+
+// TestAgentLoop_StreamingFinalEditFails verifies a preview whose final edit
+// fails is deleted before the reply is sent as a regular message
+func TestAgentLoop_StreamingFinalEditFails(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Streaming:         true,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &streamingMockProvider{fragments: []string{"Hello", ", ", "world"}})
+
+	cm, err := channels.NewManager(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("Failed to create channel manager: %v", err)
+	}
+	ch := &editableChannel{failFinalEdit: "Hello, world"}
+	cm.RegisterChannel("editable", ch)
+	al.SetChannelManager(cm)
+
+	al.handleInbound(context.Background(), bus.InboundMessage{
+		Channel:    "editable",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "hi",
+		SessionKey: "editable:chat1",
+	})
+
+	if len(ch.deletes) != 1 || ch.deletes[0] != "msg-1" {
+		t.Errorf("Expected the preview to be deleted, got %v", ch.deletes)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if msg, ok := msgBus.SubscribeOutbound(ctx); !ok || msg.Content != "Hello, world" {
+		t.Errorf("Expected the full reply as a regular message, got %q", msg.Content)
+	}
+}
+
+// optionsRecordingProvider records the options of every call
+type optionsRecordingProvider struct {
+	mu      sync.Mutex
+	options []map[string]interface{}
+}
+
+func (m *optionsRecordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.options = append(m.options, opts)
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (m *optionsRecordingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestAgentLoop_GenerationProfiles verifies replies use the agent defaults
+// and the channel's profile instead of hard-coded options
+func TestAgentLoop_GenerationProfiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         2048,
+				Temperature:       0.4,
+				MaxToolIterations: 10,
+			},
+		},
+		Generation: config.GenerationConfig{
+			Profiles: map[string]config.GenerationProfile{
+				"terse": {MaxTokens: 256, Stop: []string{"\n\n"}},
+			},
+			Channels: map[string]string{"slack": "terse"},
+		},
+	}
+
+	provider := &optionsRecordingProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	if _, err := al.ProcessDirectWithChannel(context.Background(), "hi", "cli:1", "cli", "1"); err != nil {
+		t.Fatalf("ProcessDirectWithChannel failed: %v", err)
+	}
+	if _, err := al.ProcessDirectWithChannel(context.Background(), "hi", "slack:1", "slack", "1"); err != nil {
+		t.Fatalf("ProcessDirectWithChannel failed: %v", err)
+	}
+
+	if len(provider.options) != 2 {
+		t.Fatalf("Expected 2 provider calls, got %d", len(provider.options))
+	}
+	if got := provider.options[0]; got["max_tokens"] != 2048 || got["temperature"] != 0.4 {
+		t.Errorf("Expected agent defaults, got %v", got)
+	}
+	if got := provider.options[1]; got["max_tokens"] != 256 || got["temperature"] != 0.4 || got["stop"] == nil {
+		t.Errorf("Expected the slack profile on top of the defaults, got %v", got)
+	}
+}
+
+// TestAgentLoop_UsageBudget verifies turns are recorded in the ledger and
+// refused once the daily token budget is used up
+func TestAgentLoop_UsageBudget(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Usage: config.UsageConfig{
+			Enabled:          true,
+			DailyTokenBudget: 100,
+			BudgetAction:     "refuse",
+		},
+	}
+
+	provider := &optionsRecordingProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	// Simulate earlier spend today
+	al.usage.Add(usage.Record{Model: "test-model", PromptTokens: 80, CompletionTokens: 10})
+
+	if _, err := al.ProcessDirectWithChannel(context.Background(), "hi", "cli:1", "cli", "1"); err != nil {
+		t.Fatalf("ProcessDirectWithChannel failed: %v", err)
+	}
+	if got := al.usage.Today().Calls; got != 2 {
+		t.Errorf("Expected the turn to be recorded, ledger has %d calls", got)
+	}
+
+	al.usage.Add(usage.Record{Model: "test-model", PromptTokens: 50})
+	response, err := al.ProcessDirectWithChannel(context.Background(), "hi again", "cli:1", "cli", "1")
+	if err != nil {
+		t.Fatalf("ProcessDirectWithChannel failed: %v", err)
+	}
+	if !strings.Contains(response, "Usage limit") {
+		t.Errorf("Expected a budget refusal, got %q", response)
+	}
+	if len(provider.options) != 1 {
+		t.Errorf("Expected no LLM call over budget, provider called %d times", len(provider.options))
+	}
+
+	if report := al.usageReport("cli:1"); !strings.Contains(report, "This chat today: 1 calls") {
+		t.Errorf("Unexpected /usage report: %q", report)
+	}
+}
+
+// messagesStreamingProvider streams its reply and records the messages of
+// every call
+type messagesStreamingProvider struct {
+	streamingMockProvider
+	calls [][]providers.Message
+}
+
+func (m *messagesStreamingProvider) ChatStream(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}, onDelta providers.StreamCallback) (*providers.LLMResponse, error) {
+	m.calls = append(m.calls, messages)
+	return m.streamingMockProvider.ChatStream(ctx, messages, tools, model, opts, onDelta)
+}
+
+func (m *messagesStreamingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	return m.ChatStream(ctx, messages, tools, model, opts, nil)
+}
+
+// TestAgentLoop_ProcessAPIRequest verifies stateless API requests use the
+// client's history without keeping it, and session requests keep theirs
+func TestAgentLoop_ProcessAPIRequest(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+
+	provider := &messagesStreamingProvider{streamingMockProvider: streamingMockProvider{fragments: []string{"Hi", " there"}}}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	history := []providers.Message{
+		{Role: "user", Content: "my name is Ada"},
+		{Role: "assistant", Content: "Hello Ada"},
+	}
+	var streamed strings.Builder
+	reply, err := al.ProcessAPIRequest(context.Background(), "", "stateless", "what is my name?", history, func(delta string) {
+		streamed.WriteString(delta)
+	})
+	if err != nil {
+		t.Fatalf("ProcessAPIRequest failed: %v", err)
+	}
+	if reply != "Hi there" || streamed.String() != "Hi there" {
+		t.Errorf("reply = %q, streamed = %q", reply, streamed.String())
+	}
+
+	sent := provider.calls[0]
+	found := false
+	for _, msg := range sent {
+		if msg.Content == "my name is Ada" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the client history to reach the provider")
+	}
+	if list := al.sessions.List(); len(list) != 0 {
+		t.Errorf("Expected stateless requests to leave no sessions, got %+v", list)
+	}
+
+	if _, err := al.ProcessAPIRequest(context.Background(), "api:ide", "ide", "remember me", nil, nil); err != nil {
+		t.Fatalf("ProcessAPIRequest failed: %v", err)
+	}
+	if n := len(al.sessions.GetHistory("api:ide")); n != 2 {
+		t.Errorf("Expected session history of 2 messages, got %d", n)
+	}
+}
+
+// firstMessageProvider answers with the first message after the system prompt
+type firstMessageProvider struct{}
+
+func (m *firstMessageProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	time.Sleep(time.Millisecond)
+	return &providers.LLMResponse{Content: messages[1].Content}, nil
+}
+
+func (m *firstMessageProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestProcessAPIRequest_ConcurrentStateless verifies concurrent stateless
+// requests only see their own history
+func TestProcessAPIRequest_ConcurrentStateless(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &firstMessageProvider{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			own := fmt.Sprintf("client %d", i)
+			history := []providers.Message{{Role: "user", Content: own}, {Role: "assistant", Content: "ok"}}
+			reply, err := al.ProcessAPIRequest(context.Background(), "", "stateless", "who am I?", history, nil)
+			if err != nil || reply != own {
+				t.Errorf("request %d got %q, %v", i, reply, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if list := al.sessions.List(); len(list) != 0 {
+		t.Errorf("stateless requests left sessions %+v", list)
+	}
+}
+
+// toolThenAnswerProvider asks for mock_custom once, then answers
+type toolThenAnswerProvider struct {
+	calls int
+}
+
+func (m *toolThenAnswerProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.calls++
+	if m.calls == 1 {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{
+			ID:        "call_1",
+			Name:      "mock_custom",
+			Arguments: map[string]interface{}{"path": "notes.md"},
+		}}}, nil
+	}
+	return &providers.LLMResponse{Content: "All done", Usage: &providers.UsageInfo{PromptTokens: 10, CompletionTokens: 2}}, nil
+}
+
+func (m *toolThenAnswerProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestAgentLoop_RecordsTrace verifies a turn is traced from the user message
+// through LLM calls and tool calls to the reply
+func TestAgentLoop_RecordsTrace(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Trace: config.TraceConfig{Enabled: true},
+	}
+
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &toolThenAnswerProvider{})
+	al.RegisterTool(&mockCustomTool{})
+
+	reply, err := al.ProcessDirectWithChannel(context.Background(), "tidy my notes", "test-session", "telegram", "42")
+	if err != nil || reply != "All done" {
+		t.Fatalf("reply = %q, err = %v", reply, err)
+	}
+
+	summaries, err := trace.List(filepath.Join(tmpDir, "traces"), 0)
+	if err != nil || len(summaries) != 1 {
+		t.Fatalf("traces = %+v, err = %v", summaries, err)
+	}
+	tr, err := trace.Load(filepath.Join(tmpDir, "traces"), summaries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.Info.UserMessage != "tidy my notes" || tr.Info.SessionKey != "test-session" || tr.Info.Model != "test-model" {
+		t.Errorf("turn info = %+v", tr.Info)
+	}
+	var types []string
+	for _, ev := range tr.Events {
+		types = append(types, ev.Type)
+	}
+	want := "turn_start llm_request llm_response tool_call llm_request llm_response turn_end"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+
+	calls := tr.ToolCalls()
+	if len(calls) != 1 || calls[0].Name != "mock_custom" || calls[0].Args["path"] != "notes.md" || calls[0].ForLLM != "Custom tool executed" {
+		t.Errorf("tool calls = %+v", calls)
+	}
+	// The second request only carries the assistant tool call and its result
+	if second := tr.Events[4]; second.Reset || len(second.Messages) != 2 {
+		t.Errorf("second request: reset = %v, %d messages", second.Reset, len(second.Messages))
+	}
+	if tr.FinalContent() != "All done" || !summaries[0].Complete || summaries[0].Iterations != 2 {
+		t.Errorf("summary = %+v, final = %q", summaries[0], tr.FinalContent())
+	}
+}
+
+type systemPromptProvider struct {
+	prompts []string
+	tools   []string
+}
+
+func (m *systemPromptProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.prompts = append(m.prompts, messages[0].Content)
+	m.tools = m.tools[:0]
+	for _, def := range tools {
+		m.tools = append(m.tools, def.Function.Name)
+	}
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (m *systemPromptProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestAgentLoop_MemoryRetrieval verifies only the memories relevant to the
+// message are put in the system prompt when memory search is enabled
+func TestAgentLoop_MemoryRetrieval(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "memory"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "memory", "MEMORY.md"), []byte(
+		"# Memory\n\n## Coffee\n\nThe user drinks oat milk flat whites.\n\n## Car\n\nThe user drives a blue hatchback.\n"), 0644)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Memory: config.MemoryConfig{Search: true, TopK: 1},
+	}
+
+	provider := &systemPromptProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	if _, err := al.ProcessDirect(context.Background(), "order me a coffee", "test-session"); err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := provider.prompts[0]
+	if !strings.Contains(prompt, "## memory/MEMORY.md › Memory > Coffee\n\nThe user drinks oat milk flat whites.") {
+		t.Errorf("relevant memory missing from system prompt:\n%s", prompt)
+	}
+	if strings.Contains(prompt, "hatchback") {
+		t.Error("unrelated memory should not be in the system prompt")
+	}
+	if tools := strings.Join(provider.tools, ","); !strings.Contains(tools, "memory_search") || !strings.Contains(tools, "memory_save") {
+		t.Errorf("memory tools not offered: %s", tools)
+	}
+}
+
+// TestHandleCommand_SessionCommands verifies /history, /summary and /reset
+// operate on the chat's own session
+func TestHandleCommand_SessionCommands(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &mockProvider{})
+	key := "telegram:42"
+	al.sessions.AddMessage(key, "user", "first question")
+	al.sessions.AddFullMessage(key, providers.Message{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "c1", Name: "web_search"}}})
+	al.sessions.AddFullMessage(key, providers.Message{Role: "tool", Content: "results", ToolCallID: "c1"})
+	al.sessions.AddMessage(key, "assistant", "first answer")
+	al.sessions.AddMessage("telegram:7", "user", "another chat")
+
+	run := func(content string) string {
+		reply, handled := al.handleCommand(context.Background(), bus.InboundMessage{Channel: "telegram", ChatID: "42", SessionKey: key, Content: content})
+		if !handled {
+			t.Fatalf("%s was not handled", content)
+		}
+		return reply
+	}
+
+	if got := run("/history"); got != "Recent messages (3):\n\nuser: first question\nassistant: [→ web_search]\nassistant: first answer" {
+		t.Errorf("/history = %q", got)
+	}
+	if got := run("/history 1"); got != "Recent messages (1):\n\nassistant: first answer" {
+		t.Errorf("/history 1 = %q", got)
+	}
+	if got := run("/summary"); !strings.HasPrefix(got, "No summary yet") {
+		t.Errorf("/summary = %q", got)
+	}
+
+	al.sessions.SetSummary(key, "Asked about things.")
+	if got := run("/summary"); !strings.HasSuffix(got, "Asked about things.") {
+		t.Errorf("/summary = %q", got)
+	}
+
+	run("/reset")
+	if len(al.sessions.GetHistory(key)) != 0 || al.sessions.GetSummary(key) != "" {
+		t.Error("/reset should clear history and summary")
+	}
+	if len(al.sessions.GetHistory("telegram:7")) != 1 {
+		t.Error("/reset should not touch other chats")
+	}
+	if got := run("/history"); got != "No messages in this conversation yet." {
+		t.Errorf("/history after reset = %q", got)
+	}
+}
+
+func TestAgentLoop_Reload(t *testing.T) {
+	newConfig := func(model string, maxTokens int) *config.Config {
+		cfg := config.DefaultConfig()
+		cfg.Agents.Defaults.Workspace = t.TempDir()
+		cfg.Agents.Defaults.Model = model
+		cfg.Agents.Defaults.MaxTokens = maxTokens
+		return cfg
+	}
+
+	cfg := newConfig("old-model", 4096)
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "old"})
+
+	next := newConfig("new-model", 8192)
+	next.Agents.Defaults.Workspace = cfg.Agents.Defaults.Workspace
+	if err := al.Reload(next, &simpleMockProvider{response: "new"}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if al.currentModel() != "new-model" {
+		t.Errorf("model = %q, want new-model", al.currentModel())
+	}
+	if contextWindow, _ := al.limits(); contextWindow != 8192 {
+		t.Errorf("context window = %d, want 8192", contextWindow)
+	}
+	reply, err := al.ProcessDirect(context.Background(), "hi", "cli:reload")
+	if err != nil || reply != "new" {
+		t.Errorf("reply = %q, %v; want the new provider's", reply, err)
+	}
+
+	// A /switch survives reloads that keep the configured model
+	al.setModel("switched")
+	if err := al.Reload(next, &simpleMockProvider{response: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if al.currentModel() != "switched" {
+		t.Errorf("model = %q, want the switched one", al.currentModel())
+	}
+
+	other := config.DefaultConfig()
+	named := &AgentLoop{name: "ops", cfg: cfg}
+	if err := named.Reload(other, &simpleMockProvider{}); err == nil {
+		t.Error("reloading an agent that is no longer configured should fail")
+	}
+}
+
+// loadSkillProvider calls load_skill when asked to "load <name>", then answers
+type loadSkillProvider struct {
+	prompts []string
+}
+
+func (m *loadSkillProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.prompts = append(m.prompts, messages[0].Content)
+	last := messages[len(messages)-1]
+	if name, ok := strings.CutPrefix(last.Content, "load "); ok && last.Role == "user" {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{
+			ID:        "call_1",
+			Name:      "load_skill",
+			Arguments: map[string]interface{}{"name": name},
+		}}}, nil
+	}
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (m *loadSkillProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+func newSkillsWorkspace(t *testing.T) string {
+	t.Helper()
+	workspace := t.TempDir()
+	for name, skill := range map[string]string{
+		"weather": "---\nname: weather\ndescription: Weather forecasts\nkeywords: [forecast, rain]\n---\n# Weather\n\nCall wttr.in for forecasts.\n",
+		"notes":   "---\nname: notes\ndescription: Take notes\n---\n# Notes\n\nAppend notes to notes.md.\n",
+	} {
+		dir := filepath.Join(workspace, "skills", name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "SKILL.md"), []byte(skill), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workspace
+}
+
+// TestAgentLoop_LoadSkill verifies only the skills index is in the system
+// prompt until load_skill loads a skill's instructions into the session
+func TestAgentLoop_LoadSkill(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         newSkillsWorkspace(t),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Skills: config.SkillsConfig{MaxActive: 1},
+	}
+	provider := &loadSkillProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	ctx := context.Background()
+
+	if _, err := al.ProcessDirect(ctx, "hello", "cli:a"); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := provider.prompts[0]; !strings.Contains(prompt, "<name>weather</name>") || strings.Contains(prompt, "wttr.in") {
+		t.Errorf("system prompt should list the skills without their instructions:\n%s", prompt)
+	}
+
+	// The instructions apply from the call after load_skill on
+	if _, err := al.ProcessDirect(ctx, "load weather", "cli:a"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(provider.prompts[1], "wttr.in") || !strings.Contains(provider.prompts[2], "# Active Skills") || !strings.Contains(provider.prompts[2], "Call wttr.in for forecasts.") {
+		t.Errorf("skill not loaded after load_skill:\n%s", provider.prompts[2])
+	}
+	history := al.sessions.GetHistory("cli:a")
+	if result := history[len(history)-2]; result.Role != "tool" || !strings.HasPrefix(result.Content, `Loaded skill "weather"`) {
+		t.Errorf("tool result = %+v", result)
+	}
+
+	// Active skills belong to the session and stay for the next turns
+	al.ProcessDirect(ctx, "hello", "cli:a")
+	al.ProcessDirect(ctx, "hello", "cli:b")
+	if n := len(provider.prompts); !strings.Contains(provider.prompts[n-2], "wttr.in") || strings.Contains(provider.prompts[n-1], "wttr.in") {
+		t.Error("active skills should be kept per session")
+	}
+
+	// max_active 1: loading notes unloads weather
+	al.ProcessDirect(ctx, "load notes", "cli:a")
+	history = al.sessions.GetHistory("cli:a")
+	if result := history[len(history)-2]; !strings.HasSuffix(result.Content, "Unloaded weather to make room.") {
+		t.Errorf("tool result = %q", result.Content)
+	}
+	if prompt := provider.prompts[len(provider.prompts)-1]; strings.Contains(prompt, "wttr.in") || strings.Count(prompt, "# Active Skills") != 1 {
+		t.Errorf("system prompt after eviction:\n%s", prompt)
+	}
+
+	al.ProcessDirect(ctx, "load calendar", "cli:a")
+	history = al.sessions.GetHistory("cli:a")
+	if result := history[len(history)-2]; !strings.HasPrefix(result.Content, `Unknown skill "calendar". Available skills: notes, weather`) {
+		t.Errorf("tool result = %q", result.Content)
+	}
+
+	// They are saved with the session and restored after a restart
+	restarted := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	if active := restarted.contextBuilder.ActiveSkills(restarted.sessions, "cli:a"); len(active) != 1 || active[0] != "notes" {
+		t.Errorf("active skills after restart = %v", active)
+	}
+
+	al.handleCommand(ctx, bus.InboundMessage{Channel: "cli", ChatID: "a", SessionKey: "cli:a", Content: "/reset"})
+	if active := al.contextBuilder.ActiveSkills(al.sessions, "cli:a"); len(active) != 0 {
+		t.Errorf("/reset kept active skills %v", active)
+	}
+}
+
+// TestAgentLoop_AutoActivateSkills verifies keyword auto-activation loads
+// the skills a message mentions
+func TestAgentLoop_AutoActivateSkills(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         newSkillsWorkspace(t),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Skills: config.SkillsConfig{AutoActivate: "keywords"},
+	}
+	provider := &loadSkillProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	al.ProcessDirect(context.Background(), "Will it rain tomorrow?", "cli:a")
+	if prompt := provider.prompts[0]; !strings.Contains(prompt, "Call wttr.in for forecasts.") || strings.Contains(prompt, "Append notes") {
+		t.Errorf("expected only the weather skill to be active:\n%s", prompt)
+	}
+	if active := al.contextBuilder.ActiveSkills(al.sessions, "cli:a"); len(active) != 1 || active[0] != "weather" {
+		t.Errorf("active skills = %v", active)
+	}
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// defaultMediaMaxBytes caps a single attachment sent to the model. Most
+// vision APIs reject images above ~5MB anyway.
+const defaultMediaMaxBytes = 5 * 1024 * 1024
+
+// maxInlineTextBytes caps text attachments that are inlined into the prompt.
+const maxInlineTextBytes = 64 * 1024
+
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// loadMediaParts turns inbound attachments into content parts. Images and
+// PDFs become base64 parts, small text files are inlined; anything else
+// (audio, video, oversized files) is skipped and left to the text markers the
+// channel already put into the message.
+func loadMediaParts(media []string, maxBytes int) []providers.ContentPart {
+	if maxBytes <= 0 {
+		maxBytes = defaultMediaMaxBytes
+	}
+
+	var parts []providers.ContentPart
+	for _, ref := range media {
+		localPath, name := ref, filepath.Base(ref)
+		if isRemoteMedia(ref) {
+			if u, err := url.Parse(ref); err == nil {
+				name = path.Base(u.Path)
+			}
+			localPath = utils.DownloadFile(ref, name, utils.DownloadOptions{LoggerPrefix: "agent"})
+			if localPath == "" {
+				continue
+			}
+		}
+
+		part, err := loadMediaPart(localPath, name, maxBytes)
+		if localPath != ref {
+			os.Remove(localPath)
+		}
+		if err != nil {
+			logger.WarnCF("agent", "Skipping attachment", map[string]interface{}{
+				"media": ref,
+				"error": err.Error(),
+			})
+			continue
+		}
+		if part != nil {
+			parts = append(parts, *part)
+		}
+	}
+	return parts
+}
+
+func loadMediaPart(localPath, name string, maxBytes int) (*providers.ContentPart, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > int64(maxBytes) {
+		return nil, fmt.Errorf("file is %d bytes, limit is %d", info.Size(), maxBytes)
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	mimeType := detectMIMEType(localPath, data)
+	switch {
+	case supportedImageTypes[mimeType]:
+		return &providers.ContentPart{
+			Type:     providers.PartImage,
+			MIMEType: mimeType,
+			Data:     base64.StdEncoding.EncodeToString(data),
+			Filename: name,
+		}, nil
+	case mimeType == "application/pdf":
+		return &providers.ContentPart{
+			Type:     providers.PartFile,
+			MIMEType: mimeType,
+			Data:     base64.StdEncoding.EncodeToString(data),
+			Filename: name,
+		}, nil
+	case strings.HasPrefix(mimeType, "text/") || mimeType == "application/json":
+		if len(data) > maxInlineTextBytes {
+			return nil, fmt.Errorf("text file is %d bytes, inline limit is %d", len(data), maxInlineTextBytes)
+		}
+		return &providers.ContentPart{
+			Type: providers.PartText,
+			Text: fmt.Sprintf("[file: %s]\n%s", name, string(data)),
+		}, nil
+	}
+	return nil, nil
+}
+
+// detectMIMEType prefers the file extension and falls back to sniffing,
+// since some channels store downloads without one.
+func detectMIMEType(localPath string, data []byte) string {
+	if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(localPath))); byExt != "" {
+		mediaType, _, _ := mime.ParseMediaType(byExt)
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
+
+func isRemoteMedia(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
+
+// stripMedia returns a copy of messages with all content parts removed, so
+// only the text fallback is sent. Used when the model rejects media input.
+func stripMedia(messages []providers.Message) []providers.Message {
+	stripped := make([]providers.Message, len(messages))
+	for i, msg := range messages {
+		msg.Parts = nil
+		stripped[i] = msg
+	}
+	return stripped
+}
+
+func hasMedia(messages []providers.Message) bool {
+	for _, msg := range messages {
+		if msg.HasMedia() {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaUnsupportedErrors are what providers answer, lower-cased, when the
+// model can't take image or file input.
+var mediaUnsupportedErrors = []string{
+	"image_url is only supported by certain models", // OpenAI
+	"no endpoints found that support image input",   // OpenRouter
+	"image input modality is not enabled",           // Gemini
+	"unknown variant `image_url`",                   // DeepSeek and other text-only OpenAI-compatible APIs
+	"is not a multimodal model",                     // vLLM
+	"does not support image",                        // Ollama, LM Studio
+}
+
+// isMediaUnsupportedError reports whether a provider error indicates the
+// model cannot take image or file input. Other errors that mention images,
+// such as a request over the context window, are left to their own handling.
+func isMediaUnsupportedError(err error) bool {
+	if providers.IsTransientError(err) {
+		return false
+	}
+	errMsg := strings.ToLower(err.Error())
+	for _, known := range mediaUnsupportedErrors {
+		if strings.Contains(errMsg, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanupMedia removes the attachments of a message once it is acknowledged or
+// dead-lettered. Only files under the system temp dir are touched; remote URLs
+// and user paths are left alone.
+func CleanupMedia(media []string) {
+	tmpDir := filepath.Clean(os.TempDir()) + string(filepath.Separator)
+	for _, ref := range media {
+		if isRemoteMedia(ref) || !strings.HasPrefix(filepath.Clean(ref), tmpDir) {
+			continue
+		}
+		if err := os.Remove(ref); err != nil && !os.IsNotExist(err) {
+			logger.DebugCF("agent", "Failed to cleanup media file", map[string]interface{}{
+				"file":  ref,
+				"error": err.Error(),
+			})
+		}
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
 )
 
 // MemoryStore manages persistent memory for the agent.
@@ -40,10 +42,7 @@ func NewMemoryStore(workspace string) *MemoryStore {
 
 // getTodayFile returns the path to today's daily note file (memory/YYYYMM/YYYYMMDD.md).
 func (ms *MemoryStore) getTodayFile() string {
-	today := time.Now().Format("20060102") // YYYYMMDD
-	monthDir := today[:6]                  // YYYYMM
-	filePath := filepath.Join(ms.memoryDir, monthDir, today+".md")
-	return filePath
+	return memory.DailyNotePath(ms.memoryDir, time.Now())
 }
 
 // ReadLongTerm reads the long-term memory (MEMORY.md).
@@ -103,10 +102,7 @@ func (ms *MemoryStore) GetRecentDailyNotes(days int) string {
 	var notes []string
 
 	for i := 0; i < days; i++ {
-		date := time.Now().AddDate(0, 0, -i)
-		dateStr := date.Format("20060102") // YYYYMMDD
-		monthDir := dateStr[:6]            // YYYYMM
-		filePath := filepath.Join(ms.memoryDir, monthDir, dateStr+".md")
+		filePath := memory.DailyNotePath(ms.memoryDir, time.Now().AddDate(0, 0, -i))
 
 		if data, err := os.ReadFile(filePath); err == nil {
 			notes = append(notes, string(data))
@@ -0,0 +1,130 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// Router consumes inbound messages and hands each one to the agent selected
+// by the agents.routes rules. Messages that match no route go to the default
+// agent. All agents share one session dispatcher, so per-session ordering and
+// the max_concurrent_turns limit apply across agents.
+type Router struct {
+	bus          *bus.MessageBus
+	defaultAgent *AgentLoop
+	agents       map[string]*AgentLoop
+	order        []*AgentLoop // Registration order, default agent first
+	routes       []config.AgentRoute
+	running      atomic.Bool
+}
+
+func NewRouter(msgBus *bus.MessageBus, defaultAgent *AgentLoop, routes []config.AgentRoute) *Router {
+	return &Router{
+		bus:          msgBus,
+		defaultAgent: defaultAgent,
+		agents:       map[string]*AgentLoop{defaultAgent.Name(): defaultAgent},
+		order:        []*AgentLoop{defaultAgent},
+		routes:       routes,
+	}
+}
+
+// AddAgent registers a named agent. It must be called before Run.
+func (r *Router) AddAgent(al *AgentLoop) {
+	r.agents[al.Name()] = al
+	r.order = append(r.order, al)
+}
+
+// Agents returns every registered agent, the default agent first.
+func (r *Router) Agents() []*AgentLoop {
+	return r.order
+}
+
+// CheckRoutes returns an error if a route points at an unknown agent.
+func (r *Router) CheckRoutes() error {
+	for i, route := range r.routes {
+		if _, ok := r.agents[route.Agent]; !ok {
+			return fmt.Errorf("agents.routes[%d]: unknown agent %q", i, route.Agent)
+		}
+	}
+	return nil
+}
+
+// Route returns the agent that should handle msg.
+func (r *Router) Route(msg bus.InboundMessage) *AgentLoop {
+	channel, chatID := msg.Channel, msg.ChatID
+	// Subagent results arrive on the system channel with the origin encoded
+	// in the chat ID ("channel:chat_id"); route them like the original message.
+	if channel == "system" {
+		if idx := strings.Index(chatID, ":"); idx > 0 {
+			channel, chatID = chatID[:idx], chatID[idx+1:]
+		}
+	}
+
+	for _, route := range r.routes {
+		if !route.Matches(channel, chatID, msg.SenderID) {
+			continue
+		}
+		if al, ok := r.agents[route.Agent]; ok {
+			return al
+		}
+		logger.WarnCF("agent", "Route points at unknown agent, using default", map[string]interface{}{
+			"agent":   route.Agent,
+			"channel": channel,
+		})
+		break
+	}
+	return r.defaultAgent
+}
+
+// Run consumes inbound messages until ctx is cancelled or Stop is called.
+func (r *Router) Run(ctx context.Context) error {
+	r.running.Store(true)
+
+	dispatcher := newSessionDispatcher(r.defaultAgent.maxConcurrent, func(ctx context.Context, msg bus.InboundMessage) {
+		al := r.Route(msg)
+		logger.DebugCF("agent", "Routed message", map[string]interface{}{
+			"agent":     al.Name(),
+			"channel":   msg.Channel,
+			"chat_id":   msg.ChatID,
+			"sender_id": msg.SenderID,
+		})
+		al.handleInbound(ctx, msg)
+		ackInbound(ctx, r.bus, msg)
+	})
+	defer dispatcher.Wait()
+
+	for r.running.Load() {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			msg, ok := r.bus.ConsumeInbound(ctx)
+			if !ok {
+				continue
+			}
+
+			if resolveApproval(r.bus, r.defaultAgent.approvals, msg) {
+				continue
+			}
+			dispatcher.Dispatch(ctx, msg)
+		}
+	}
+
+	return nil
+}
+
+func (r *Router) Stop() {
+	r.running.Store(false)
+}
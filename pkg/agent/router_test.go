@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/usage"
+)
+
+func newRouterTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	restrict := true
+	return &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         filepath.Join(tmpDir, "home"),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+			List: []config.AgentConfig{
+				{
+					Name:                "ops",
+					Workspace:           filepath.Join(tmpDir, "ops"),
+					Model:               "ops-model",
+					RestrictToWorkspace: &restrict,
+					Tools:               []string{"read_file", "exec"},
+				},
+			},
+			Routes: []config.AgentRoute{
+				{Agent: "ops", Channel: "slack"},
+				{Agent: "ops", Channel: "telegram", SenderID: "admin"},
+			},
+		},
+	}
+}
+
+func newTestRouter(t *testing.T, cfg *config.Config, msgBus *bus.MessageBus) (*Router, *AgentLoop, *AgentLoop) {
+	t.Helper()
+	home := NewAgentLoop(cfg, msgBus, &simpleMockProvider{response: "from home"})
+	ops, err := NewNamedAgentLoop(cfg, "ops", msgBus, &simpleMockProvider{response: "from ops"})
+	if err != nil {
+		t.Fatalf("NewNamedAgentLoop failed: %v", err)
+	}
+	router := NewRouter(msgBus, home, cfg.Agents.Routes)
+	router.AddAgent(ops)
+	if err := router.CheckRoutes(); err != nil {
+		t.Fatalf("CheckRoutes failed: %v", err)
+	}
+	return router, home, ops
+}
+
+func TestRouter_Route(t *testing.T) {
+	cfg := newRouterTestConfig(t)
+	router, home, ops := newTestRouter(t, cfg, bus.NewMessageBus())
+
+	tests := []struct {
+		name string
+		msg  bus.InboundMessage
+		want *AgentLoop
+	}{
+		{"channel rule", bus.InboundMessage{Channel: "slack", ChatID: "C1", SenderID: "bob"}, ops},
+		{"sender rule", bus.InboundMessage{Channel: "telegram", ChatID: "1", SenderID: "admin"}, ops},
+		{"unmatched sender", bus.InboundMessage{Channel: "telegram", ChatID: "1", SenderID: "alice"}, home},
+		{"system message follows origin", bus.InboundMessage{Channel: "system", ChatID: "slack:C1", SenderID: "subagent:1"}, ops},
+	}
+	for _, tt := range tests {
+		if got := router.Route(tt.msg); got != tt.want {
+			t.Errorf("%s: routed to %q, want %q", tt.name, got.Name(), tt.want.Name())
+		}
+	}
+}
+
+// TestNamedAgent_OwnSettings verifies a named agent gets its own workspace,
+// model and tool allowlist
+func TestNamedAgent_OwnSettings(t *testing.T) {
+	cfg := newRouterTestConfig(t)
+	_, home, ops := newTestRouter(t, cfg, bus.NewMessageBus())
+
+	if ops.workspace == home.workspace {
+		t.Error("Expected the ops agent to have its own workspace")
+	}
+	if ops.currentModel() != "ops-model" || home.currentModel() != "test-model" {
+		t.Errorf("Unexpected models: ops=%q home=%q", ops.currentModel(), home.currentModel())
+	}
+
+	names := ops.tools.List()
+	if len(names) != 2 {
+		t.Errorf("Expected only the allowlisted tools, got %v", names)
+	}
+	if _, ok := home.tools.Get("write_file"); !ok {
+		t.Error("Expected the default agent to keep all tools")
+	}
+}
+
+func TestNamedAgent_SharesUsageBudget(t *testing.T) {
+	cfg := newRouterTestConfig(t)
+	cfg.Usage = config.UsageConfig{Enabled: true, DailyTokenBudget: 100, BudgetAction: "refuse"}
+	_, home, ops := newTestRouter(t, cfg, bus.NewMessageBus())
+
+	if ops.usage != home.usage {
+		t.Fatal("Expected the agents to share one usage ledger")
+	}
+	if _, err := ops.ProcessDirectWithChannel(context.Background(), "hi", "slack:1", "slack", "1"); err != nil {
+		t.Fatalf("ProcessDirectWithChannel failed: %v", err)
+	}
+	records, _ := usage.Load(home.usage.Dir(), time.Now().Add(-time.Hour))
+	if len(records) != 1 || records[0].Agent != "ops" {
+		t.Errorf("Expected one record of the ops agent, got %+v", records)
+	}
+
+	// Spend by the default agent counts against the ops agent too
+	home.usage.Add(usage.Record{Agent: config.DefaultAgentName, Model: "test-model", PromptTokens: 100})
+	response, err := ops.ProcessDirectWithChannel(context.Background(), "hi again", "slack:1", "slack", "1")
+	if err != nil {
+		t.Fatalf("ProcessDirectWithChannel failed: %v", err)
+	}
+	if !strings.Contains(response, "Usage limit") {
+		t.Errorf("Expected a budget refusal, got %q", response)
+	}
+}
+
+func TestRouter_RunDispatchesToAgent(t *testing.T) {
+	cfg := newRouterTestConfig(t)
+	msgBus := bus.NewMessageBus()
+	router, _, _ := newTestRouter(t, cfg, msgBus)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "slack", ChatID: "C1", SenderID: "bob", Content: "hi", SessionKey: "slack:C1"})
+
+	readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer readCancel()
+	out, ok := msgBus.SubscribeOutbound(readCtx)
+	if !ok {
+		t.Fatal("Timed out waiting for reply")
+	}
+	if out.Content != "from ops" {
+		t.Errorf("Expected reply from the ops agent, got %q", out.Content)
+	}
+}
+
+func TestRouter_CheckRoutesUnknownAgent(t *testing.T) {
+	cfg := newRouterTestConfig(t)
+	msgBus := bus.NewMessageBus()
+	router := NewRouter(msgBus, NewAgentLoop(cfg, msgBus, &simpleMockProvider{}), cfg.Agents.Routes)
+	if err := router.CheckRoutes(); err == nil {
+		t.Error("Expected an error for routes to an unregistered agent")
+	}
+}
@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/session"
+	"github.com/sipeed/picoclaw/pkg/skills"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+const defaultMaxActiveSkills = 3
+
+// activeSkillsHeader starts the system prompt section holding the
+// instructions of the session's active skills. It is always the last section.
+const activeSkillsHeader = "\n\n---\n\n# Active Skills\n\nInstructions of the skills loaded for this conversation:\n\n"
+
+// activeSkillsMetadata is the session metadata entry listing the active
+// skills of the session, comma separated, least recently used first.
+const activeSkillsMetadata = "active_skills"
+
+// activeSkills tracks the skills loaded in each session in the session's
+// metadata, so they are saved and restored with it. Only max skills stay
+// active per session.
+type activeSkills struct {
+	mu  sync.Mutex // serializes updates of the metadata entry
+	max int
+}
+
+func newActiveSkills(max int) *activeSkills {
+	return &activeSkills{max: max}
+}
+
+func (a *activeSkills) setMax(max int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.max = max
+}
+
+func (a *activeSkills) limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.max
+}
+
+// activate makes name the most recently used skill of the session and
+// returns the skills that no longer fit.
+func (a *activeSkills) activate(sessions *session.SessionManager, sessionKey, name string) (evicted []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	names := a.list(sessions, sessionKey)
+	for i, n := range names {
+		if n == name {
+			names = append(names[:i:i], names[i+1:]...)
+			break
+		}
+	}
+	names = append(names, name)
+	if len(names) > a.max {
+		evicted = append(evicted, names[:len(names)-a.max]...)
+		names = names[len(names)-a.max:]
+	}
+	sessions.SetMetadata(sessionKey, activeSkillsMetadata, strings.Join(names, ","))
+	return evicted
+}
+
+func (a *activeSkills) isActive(sessions *session.SessionManager, sessionKey, name string) bool {
+	for _, n := range a.list(sessions, sessionKey) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *activeSkills) list(sessions *session.SessionManager, sessionKey string) []string {
+	if value := sessions.GetMetadata(sessionKey, activeSkillsMetadata); value != "" {
+		return strings.Split(value, ",")
+	}
+	return nil
+}
+
+// SetSkillActivation sets how many skills a session keeps loaded, and the
+// activator loading the skills a message matches. A nil activator leaves it
+// to the load_skill tool.
+func (cb *ContextBuilder) SetSkillActivation(activator *skills.Activator, maxActive int) {
+	if maxActive <= 0 {
+		maxActive = defaultMaxActiveSkills
+	}
+	cb.activeSkills.setMax(maxActive)
+	cb.activator.Store(activator)
+}
+
+// ActivateSkill loads the instructions of the named skill into the system
+// prompt of the session. evicted lists the skills unloaded to make room.
+func (cb *ContextBuilder) ActivateSkill(sessions *session.SessionManager, sessionKey, name string) (evicted []string, err error) {
+	if _, ok := cb.skillsLoader.LoadSkill(name); !ok {
+		return nil, fmt.Errorf("unknown skill %q", name)
+	}
+	return cb.activeSkills.activate(sessions, sessionKey, name), nil
+}
+
+// ActiveSkills returns the skills loaded in the session. They are cleared
+// with the rest of the session by SessionManager.Reset.
+func (cb *ContextBuilder) ActiveSkills(sessions *session.SessionManager, sessionKey string) []string {
+	return cb.activeSkills.list(sessions, sessionKey)
+}
+
+// AutoActivateSkills loads the skills message matches and returns the ones
+// that were not active yet.
+func (cb *ContextBuilder) AutoActivateSkills(ctx context.Context, sessions *session.SessionManager, sessionKey, message string) []string {
+	activator := cb.activator.Load()
+	if activator == nil || sessionKey == "" {
+		return nil
+	}
+	var activated []string
+	for _, name := range activator.Match(ctx, message, cb.activeSkills.limit()) {
+		if !cb.activeSkills.isActive(sessions, sessionKey, name) {
+			activated = append(activated, name)
+		}
+		cb.activeSkills.activate(sessions, sessionKey, name)
+	}
+	return activated
+}
+
+// ApplyActiveSkills replaces the Active Skills section of the system prompt
+// with the instructions of the skills currently loaded in the session, so a
+// skill loaded by a tool call is followed from the next LLM call on.
+func (cb *ContextBuilder) ApplyActiveSkills(messages []providers.Message, sessions *session.SessionManager, sessionKey string) []providers.Message {
+	if len(messages) == 0 || messages[0].Role != "system" {
+		return messages
+	}
+	prompt := messages[0].Content
+	if i := strings.Index(prompt, activeSkillsHeader); i >= 0 {
+		prompt = prompt[:i]
+	}
+	if content := cb.skillsLoader.LoadSkillsForContext(cb.ActiveSkills(sessions, sessionKey)); content != "" {
+		prompt += activeSkillsHeader + content
+	}
+	messages[0].Content = prompt
+	return messages
+}
+
+// newSkillActivator creates the activator configured by skills.auto_activate,
+// using the memory embedding model in embeddings mode.
+func newSkillActivator(cfg *config.Config, settings config.AgentDefaults, loader *skills.SkillsLoader) *skills.Activator {
+	mode := cfg.Skills.AutoActivate
+	if mode == "" || mode == skills.ActivateOff {
+		return nil
+	}
+	activator := skills.NewActivator(loader, mode)
+	if mode != skills.ActivateEmbeddings || cfg.Memory.EmbeddingModel == "" {
+		return activator
+	}
+	providerName := cfg.Memory.EmbeddingProvider
+	if providerName == "" {
+		providerName = settings.Provider
+	}
+	embedder, err := providers.CreateEmbeddingProvider(cfg, providerName, cfg.Memory.EmbeddingModel)
+	if err != nil {
+		logger.WarnCF("agent", "Embeddings unavailable, skills are activated by keywords", map[string]interface{}{
+			"model": cfg.Memory.EmbeddingModel,
+			"error": err.Error(),
+		})
+		return activator
+	}
+	activator.SetEmbedder(embedder, cfg.Memory.EmbeddingModel, cfg.Skills.ActivationThreshold)
+	return activator
+}
+
+type sessionContextKey struct{}
+
+// sessionScope is the conversation a turn belongs to.
+type sessionScope struct {
+	sessions *session.SessionManager
+	key      string
+}
+
+func withSession(ctx context.Context, sessions *session.SessionManager, sessionKey string) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, sessionScope{sessions: sessions, key: sessionKey})
+}
+
+func sessionKeyFrom(ctx context.Context) string {
+	scope, _ := ctx.Value(sessionContextKey{}).(sessionScope)
+	return scope.key
+}
+
+func sessionsFrom(ctx context.Context) *session.SessionManager {
+	scope, _ := ctx.Value(sessionContextKey{}).(sessionScope)
+	return scope.sessions
+}
+
+// loadSkillTool loads the instructions of a skill listed in the system
+// prompt into the current session.
+type loadSkillTool struct {
+	cb *ContextBuilder
+}
+
+func newLoadSkillTool(cb *ContextBuilder) *loadSkillTool {
+	return &loadSkillTool{cb: cb}
+}
+
+func (t *loadSkillTool) Name() string {
+	return "load_skill"
+}
+
+func (t *loadSkillTool) Description() string {
+	return "Load the full instructions of a skill from the Skills list before using it. They stay in the system prompt under Active Skills, so load each skill once."
+}
+
+func (t *loadSkillTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of the skill, as in the Skills list",
+			},
+		},
+		"required": []string{"name"},
+	}
+}
+
+func (t *loadSkillTool) Execute(ctx context.Context, args map[string]interface{}) *tools.ToolResult {
+	name, _ := args["name"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return tools.ErrorResult("name is required")
+	}
+
+	sessionKey, sessions := sessionKeyFrom(ctx), sessionsFrom(ctx)
+	if sessionKey == "" || sessions == nil {
+		// Nowhere to keep it active: hand the instructions over directly
+		if content, ok := t.cb.skillsLoader.LoadSkill(name); ok {
+			return tools.NewToolResult(content)
+		}
+	} else if t.cb.activeSkills.isActive(sessions, sessionKey, name) {
+		return tools.NewToolResult(fmt.Sprintf("Skill %q is already loaded, its instructions are under Active Skills in the system prompt.", name))
+	} else if evicted, err := t.cb.ActivateSkill(sessions, sessionKey, name); err == nil {
+		msg := fmt.Sprintf("Loaded skill %q. Its instructions are now under Active Skills in the system prompt.", name)
+		if len(evicted) > 0 {
+			msg += fmt.Sprintf(" Unloaded %s to make room.", strings.Join(evicted, ", "))
+		}
+		return tools.NewToolResult(msg)
+	}
+
+	var names []string
+	for _, s := range t.cb.skillsLoader.ListSkills() {
+		names = append(names, s.Name)
+	}
+	if len(names) == 0 {
+		return tools.ErrorResult(fmt.Sprintf("Unknown skill %q, no skills are installed", name))
+	}
+	return tools.ErrorResult(fmt.Sprintf("Unknown skill %q. Available skills: %s", name, strings.Join(names, ", ")))
+}
@@ -0,0 +1,189 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	// streamEditInterval throttles message edits; Telegram and Slack both
+	// rate-limit edits to roughly one per second per chat.
+	streamEditInterval = time.Second
+	// streamPreviewMaxRunes keeps in-progress previews under the smallest
+	// channel limit (Discord, 2000). The final edit carries the full text.
+	streamPreviewMaxRunes = 1800
+)
+
+// replyStreamer progressively renders a streamed reply into one editable
+// channel message. The message is posted on the first delta and edited at
+// most once per streamEditInterval after that.
+type replyStreamer struct {
+	ctx    context.Context
+	editor channels.MessageEditor
+	chatID string
+
+	mu        sync.Mutex
+	buf       strings.Builder
+	messageID string
+	shown     string
+	lastEdit  time.Time
+	failed    bool
+}
+
+// turnStreamer receives the text of a turn's LLM calls as it streams.
+type turnStreamer interface {
+	Reset()
+	OnDelta(delta string)
+}
+
+type replyStreamerKey struct{}
+
+func newReplyStreamer(ctx context.Context, editor channels.MessageEditor, chatID string) *replyStreamer {
+	return &replyStreamer{
+		ctx:    ctx,
+		editor: editor,
+		chatID: chatID,
+	}
+}
+
+func withReplyStreamer(ctx context.Context, s turnStreamer) context.Context {
+	return context.WithValue(ctx, replyStreamerKey{}, s)
+}
+
+func replyStreamerFrom(ctx context.Context) turnStreamer {
+	s, _ := ctx.Value(replyStreamerKey{}).(turnStreamer)
+	return s
+}
+
+// Reset clears the buffered text before a new LLM call, so each iteration of
+// the tool loop replaces the preview rather than appending to it.
+func (s *replyStreamer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+}
+
+// OnDelta is the providers.StreamCallback for the current turn.
+func (s *replyStreamer) OnDelta(delta string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf.WriteString(delta)
+	if s.failed || time.Since(s.lastEdit) < streamEditInterval {
+		return
+	}
+	s.render(streamPreview(s.buf.String()))
+}
+
+// Finish replaces the streamed preview with the final reply. It returns false
+// if nothing was streamed, or if the final edit failed and the preview was
+// deleted, in which case the caller should deliver the reply the normal way.
+// A preview that can't be deleted either is left as is rather than followed
+// by a duplicate of the reply.
+func (s *replyStreamer) Finish(content string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.messageID == "" || s.failed {
+		return false
+	}
+	if content == s.shown {
+		return true
+	}
+	err := s.editor.EditMessage(s.ctx, s.chatID, s.messageID, content)
+	if err == nil {
+		return true
+	}
+	logger.WarnCF("agent", "Failed to finalize streamed reply", map[string]interface{}{
+		"chat_id": s.chatID,
+		"error":   err.Error(),
+	})
+	if err := s.editor.DeleteMessage(s.ctx, s.chatID, s.messageID); err != nil {
+		logger.WarnCF("agent", "Failed to delete streamed preview", map[string]interface{}{
+			"chat_id": s.chatID,
+			"error":   err.Error(),
+		})
+		return true
+	}
+	return false
+}
+
+// render posts or edits the preview message. Must be called with s.mu held.
+func (s *replyStreamer) render(text string) {
+	if strings.TrimSpace(text) == "" || text == s.shown {
+		return
+	}
+
+	var err error
+	if s.messageID == "" {
+		s.messageID, err = s.editor.SendEditable(s.ctx, s.chatID, text)
+	} else {
+		err = s.editor.EditMessage(s.ctx, s.chatID, s.messageID, text)
+	}
+	s.lastEdit = time.Now()
+	if err != nil {
+		// A failed edit is retried on the next delta, but if the message
+		// could not be posted at all the reply falls back to a regular send.
+		s.failed = s.messageID == ""
+		logger.DebugCF("agent", "Streaming edit failed", map[string]interface{}{
+			"chat_id": s.chatID,
+			"error":   err.Error(),
+		})
+		return
+	}
+	s.shown = text
+}
+
+func streamPreview(text string) string {
+	runes := []rune(text)
+	if len(runes) <= streamPreviewMaxRunes {
+		return text
+	}
+	return string(runes[:streamPreviewMaxRunes]) + " …"
+}
+
+// callbackStreamer passes streamed text to a callback. Text already handed
+// over can't be replaced, so the replies of successive LLM calls in a turn
+// are appended, separated by a blank line.
+type callbackStreamer struct {
+	onDelta func(string)
+
+	mu      sync.Mutex
+	wrote   bool
+	pending bool // a new LLM call started after text was written
+}
+
+func newCallbackStreamer(onDelta func(string)) *callbackStreamer {
+	return &callbackStreamer{onDelta: onDelta}
+}
+
+func (s *callbackStreamer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = s.wrote
+}
+
+func (s *callbackStreamer) OnDelta(delta string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if delta == "" {
+		return
+	}
+	if s.pending {
+		s.onDelta("\n\n")
+		s.pending = false
+	}
+	s.wrote = true
+	s.onDelta(delta)
+}
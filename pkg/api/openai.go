@@ -0,0 +1,363 @@
+// Package api serves an OpenAI-compatible chat completions API on the
+// gateway, so existing OpenAI clients can talk to PicoClaw agents.
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// SessionHeader names the conversation a request continues. The request's
+// "user" field is used when the header is absent.
+const SessionHeader = "X-PicoClaw-Session"
+
+const maxRequestBytes = 10 << 20
+
+// Agent is the part of agent.AgentLoop the API needs.
+type Agent interface {
+	Name() string
+	ProcessAPIRequest(ctx context.Context, sessionKey, chatID, content string, history []providers.Message, onDelta func(string)) (string, error)
+}
+
+// Handler serves /v1/chat/completions and /v1/models. Each agent is exposed
+// as a model named after it; requests for any other model go to the default
+// agent.
+type Handler struct {
+	agents []Agent // agents[0] is the default
+	tokens []string
+	mux    *http.ServeMux
+
+	sessions sessionLocks // one turn at a time per session
+}
+
+// sessionLocks serializes requests per session key. A key's lock only
+// exists while a request holds or waits for it.
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	sync.Mutex
+	users int // requests holding or waiting for the lock
+}
+
+// lock waits until no other request holds key and returns the function
+// releasing it.
+func (l *sessionLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sessionLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &sessionLock{}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// NewHandler creates a Handler. Requests must carry one of tokens as a
+// bearer token; with no tokens only loopback clients are accepted.
+func NewHandler(tokens []string, defaultAgent Agent, others ...Agent) *Handler {
+	h := &Handler{
+		agents: append([]Agent{defaultAgent}, others...),
+		tokens: tokens,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/v1/chat/completions", h.chatCompletions)
+	h.mux.HandleFunc("/v1/models", h.models)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "authentication_error", "invalid or missing API token")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if len(h.tokens) == 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	for _, want := range h.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func (h *Handler) models(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "use GET")
+		return
+	}
+	data := make([]model, 0, len(h.agents))
+	for _, a := range h.agents {
+		data = append(data, model{ID: a.Name(), Object: "model", OwnedBy: "picoclaw"})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	User     string        `json:"user"`
+}
+
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the message content, which clients send either as a string
+// or as a list of parts of which only text parts are used.
+func (m chatMessage) text() string {
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+type completionMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type completionChoice struct {
+	Index        int                `json:"index"`
+	Message      *completionMessage `json:"message,omitempty"`
+	Delta        *completionMessage `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type completion struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+}
+
+func (h *Handler) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "use POST")
+		return
+	}
+
+	var req chatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON body: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "user" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "messages must end with a user message")
+		return
+	}
+	content := req.Messages[len(req.Messages)-1].text()
+	if strings.TrimSpace(content) == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "the last user message is empty")
+		return
+	}
+
+	agent := h.agentFor(req.Model)
+
+	// A session continues from the agent's own history, so the messages the
+	// client resends are ignored. Stateless requests pass them through.
+	session := r.Header.Get(SessionHeader)
+	if session == "" {
+		session = req.User
+	}
+	var sessionKey, chatID string
+	var history []providers.Message
+	if session != "" {
+		sessionKey = "api:" + session
+		chatID = session
+		defer h.sessions.lock(agent.Name() + "/" + sessionKey)()
+	} else {
+		chatID = "stateless"
+		history = clientHistory(req.Messages[:len(req.Messages)-1])
+	}
+
+	// Agent turns with tool calls easily outlast the server's write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	logger.InfoCF("api", "Chat completion request", map[string]interface{}{
+		"agent":   agent.Name(),
+		"session": sessionKey,
+		"stream":  req.Stream,
+	})
+
+	resp := completion{
+		ID:      "chatcmpl-" + newID(),
+		Created: time.Now().Unix(),
+		Model:   agent.Name(),
+	}
+
+	if !req.Stream {
+		reply, err := agent.ProcessAPIRequest(r.Context(), sessionKey, chatID, content, history, nil)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		stop := "stop"
+		resp.Object = "chat.completion"
+		resp.Choices = []completionChoice{{
+			Message:      &completionMessage{Role: "assistant", Content: reply},
+			FinishReason: &stop,
+		}}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	h.stream(w, r, agent, resp, sessionKey, chatID, content, history)
+}
+
+// stream runs the turn and sends the reply as server-sent events in the
+// chat.completion.chunk format.
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request, agent Agent, resp completion, sessionKey, chatID, content string, history []providers.Message) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	resp.Object = "chat.completion.chunk"
+	var mu sync.Mutex
+	send := func(delta *completionMessage, finish *string) {
+		mu.Lock()
+		defer mu.Unlock()
+		resp.Choices = []completionChoice{{Delta: delta, FinishReason: finish}}
+		data, _ := json.Marshal(resp)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send(&completionMessage{Role: "assistant"}, nil)
+	streamed := false
+	reply, err := agent.ProcessAPIRequest(r.Context(), sessionKey, chatID, content, history, func(delta string) {
+		streamed = true
+		send(&completionMessage{Content: delta}, nil)
+	})
+	if err != nil {
+		data, _ := json.Marshal(errorBody("server_error", err.Error()))
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	} else {
+		// Commands and non-streaming providers produce the reply in one go.
+		if !streamed && reply != "" {
+			send(&completionMessage{Content: reply}, nil)
+		}
+		stop := "stop"
+		send(&completionMessage{}, &stop)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func (h *Handler) agentFor(model string) Agent {
+	for _, a := range h.agents {
+		if a.Name() == model {
+			return a
+		}
+	}
+	return h.agents[0]
+}
+
+// clientHistory converts the earlier messages of a stateless request. The
+// agent brings its own system prompt, so client system messages are dropped.
+func clientHistory(messages []chatMessage) []providers.Message {
+	var history []providers.Message
+	for _, m := range messages {
+		if m.Role != "user" && m.Role != "assistant" {
+			continue
+		}
+		if text := m.text(); text != "" {
+			history = append(history, providers.Message{Role: m.Role, Content: text})
+		}
+	}
+	return history
+}
+
+func errorBody(kind, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]string{"type": kind, "message": message},
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, kind, message string) {
+	writeJSON(w, status, errorBody(kind, message))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
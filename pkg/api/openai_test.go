@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+type fakeAgent struct {
+	name   string
+	deltas []string
+
+	sessionKey string
+	chatID     string
+	content    string
+	history    []providers.Message
+}
+
+func (a *fakeAgent) Name() string { return a.name }
+
+func (a *fakeAgent) ProcessAPIRequest(ctx context.Context, sessionKey, chatID, content string, history []providers.Message, onDelta func(string)) (string, error) {
+	a.sessionKey, a.chatID, a.content, a.history = sessionKey, chatID, content, history
+	if onDelta != nil {
+		for _, d := range a.deltas {
+			onDelta(d)
+		}
+	}
+	return a.name + " says hi", nil
+}
+
+func post(t *testing.T, h http.Handler, body string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler_Auth(t *testing.T) {
+	h := NewHandler([]string{"secret"}, &fakeAgent{name: "default"})
+	body := `{"messages":[{"role":"user","content":"hi"}]}`
+
+	if rec := post(t, h, body, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", rec.Code)
+	}
+	if rec := post(t, h, body, map[string]string{"Authorization": "Bearer wrong"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", rec.Code)
+	}
+	if rec := post(t, h, body, map[string]string{"Authorization": "Bearer secret"}); rec.Code != http.StatusOK {
+		t.Errorf("valid token: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHandler_NoTokensAllowsLoopbackOnly(t *testing.T) {
+	h := NewHandler(nil, &fakeAgent{name: "default"})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.RemoteAddr = "203.0.113.5:4000"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("remote client: status = %d, want 401", rec.Code)
+	}
+
+	req.RemoteAddr = "127.0.0.1:4000"
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("loopback client: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestHandler_Models(t *testing.T) {
+	h := NewHandler(nil, &fakeAgent{name: "default"}, &fakeAgent{name: "coder"})
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.RemoteAddr = "127.0.0.1:4000"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad response %q: %v", rec.Body, err)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].ID != "default" || resp.Data[1].ID != "coder" {
+		t.Errorf("models = %+v", resp.Data)
+	}
+}
+
+func TestHandler_SessionFromHeaderAndModelSelectsAgent(t *testing.T) {
+	def := &fakeAgent{name: "default"}
+	coder := &fakeAgent{name: "coder"}
+	h := NewHandler([]string{"t"}, def, coder)
+
+	rec := post(t, h, `{"model":"coder","messages":[
+		{"role":"system","content":"ignored"},
+		{"role":"user","content":"earlier"},
+		{"role":"user","content":[{"type":"text","text":"fix the bug"}]}]}`,
+		map[string]string{"Authorization": "Bearer t", SessionHeader: "ide-1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	if coder.sessionKey != "api:ide-1" || coder.chatID != "ide-1" {
+		t.Errorf("session = %q/%q, want api:ide-1/ide-1", coder.sessionKey, coder.chatID)
+	}
+	if coder.content != "fix the bug" {
+		t.Errorf("content = %q", coder.content)
+	}
+	if coder.history != nil {
+		t.Errorf("session requests should not pass client history, got %v", coder.history)
+	}
+
+	var resp completion
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "chat.completion" || resp.Model != "coder" || len(resp.Choices) != 1 ||
+		resp.Choices[0].Message.Content != "coder says hi" || *resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected response: %s", rec.Body)
+	}
+}
+
+func TestHandler_StatelessPassesHistory(t *testing.T) {
+	def := &fakeAgent{name: "default"}
+	h := NewHandler([]string{"t"}, def)
+
+	rec := post(t, h, `{"model":"gpt-4o","messages":[
+		{"role":"system","content":"be brief"},
+		{"role":"user","content":"hello"},
+		{"role":"assistant","content":"hi!"},
+		{"role":"user","content":"and now?"}]}`,
+		map[string]string{"Authorization": "Bearer t"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if def.sessionKey != "" {
+		t.Errorf("sessionKey = %q, want stateless", def.sessionKey)
+	}
+	if len(def.history) != 2 || def.history[0].Content != "hello" || def.history[1].Role != "assistant" {
+		t.Errorf("history = %+v", def.history)
+	}
+}
+
+func TestHandler_RejectsBadRequests(t *testing.T) {
+	h := NewHandler([]string{"t"}, &fakeAgent{name: "default"})
+	auth := map[string]string{"Authorization": "Bearer t"}
+
+	for _, body := range []string{
+		`not json`,
+		`{"messages":[]}`,
+		`{"messages":[{"role":"assistant","content":"hi"}]}`,
+		`{"messages":[{"role":"user","content":"  "}]}`,
+	} {
+		if rec := post(t, h, body, auth); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestHandler_Stream(t *testing.T) {
+	h := NewHandler([]string{"t"}, &fakeAgent{name: "default", deltas: []string{"Hel", "lo"}})
+	rec := post(t, h, `{"stream":true,"user":"u1","messages":[{"role":"user","content":"hi"}]}`,
+		map[string]string{"Authorization": "Bearer t"})
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var text strings.Builder
+	var finish string
+	done := false
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk completion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("bad chunk %q: %v", data, err)
+		}
+		if chunk.Object != "chat.completion.chunk" {
+			t.Errorf("object = %q", chunk.Object)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Choices[0].FinishReason != nil {
+			finish = *chunk.Choices[0].FinishReason
+		}
+	}
+
+	if text.String() != "Hello" {
+		t.Errorf("streamed text = %q, want %q", text.String(), "Hello")
+	}
+	if finish != "stop" || !done {
+		t.Errorf("finish = %q, done = %v", finish, done)
+	}
+}
+
+func TestHandler_StreamWithoutDeltasSendsReply(t *testing.T) {
+	h := NewHandler([]string{"t"}, &fakeAgent{name: "default"})
+	rec := post(t, h, `{"stream":true,"messages":[{"role":"user","content":"/usage"}]}`,
+		map[string]string{"Authorization": "Bearer t"})
+	if !strings.Contains(rec.Body.String(), `"content":"default says hi"`) {
+		t.Errorf("reply missing from stream: %s", rec.Body)
+	}
+}
+
+func TestSessionLocks(t *testing.T) {
+	var locks sessionLocks
+	unlock := locks.lock("default/api:ide-1")
+
+	acquired := make(chan func())
+	go func() { acquired <- locks.lock("default/api:ide-1") }()
+	select {
+	case <-acquired:
+		t.Fatal("second request got the session while the first held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	(<-acquired)()
+
+	if len(locks.locks) != 0 {
+		t.Errorf("%d session locks left after the requests ended", len(locks.locks))
+	}
+}
@@ -0,0 +1,306 @@
+// Package approval pauses tool calls that the approval policy matches until
+// someone in the originating chat approves or denies them, and keeps an
+// audit trail of every request and decision.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Audit events.
+const (
+	EventRequested = "requested"
+	EventApproved  = "approved"
+	EventDenied    = "denied"
+	EventTimeout   = "timeout"   // nobody answered in time, denied
+	EventCancelled = "cancelled" // the turn ended while waiting
+)
+
+const auditFile = "audit.jsonl"
+
+// Prompter asks for approval directly, for channels that have a user at hand
+// but no message round trip (the interactive CLI).
+type Prompter func(ctx context.Context, id string, req tools.ApprovalRequest) (bool, error)
+
+// Record is one line of the audit trail.
+type Record struct {
+	Time    time.Time              `json:"time"`
+	ID      string                 `json:"id"`
+	Event   string                 `json:"event"`
+	Tool    string                 `json:"tool"`
+	Args    map[string]interface{} `json:"args,omitempty"`
+	Rule    string                 `json:"rule,omitempty"`
+	Channel string                 `json:"channel,omitempty"`
+	ChatID  string                 `json:"chat_id,omitempty"`
+	By      string                 `json:"by,omitempty"`
+	Reason  string                 `json:"reason,omitempty"`
+}
+
+type pending struct {
+	req      tools.ApprovalRequest
+	decision chan decision
+}
+
+type decision struct {
+	approved bool
+	by       string
+}
+
+// Manager implements tools.Approver on top of the message bus. Prompts go to
+// the chat the turn came from, with Approve/Deny buttons where the channel
+// supports them; replies come back through HandleReply.
+type Manager struct {
+	bus     *bus.MessageBus
+	timeout time.Duration
+	dir     string // audit trail directory, "" to disable
+
+	mu        sync.Mutex
+	pending   map[string]*pending
+	prompters map[string]Prompter
+	auditMu   sync.Mutex
+}
+
+// NewManager creates a manager that writes its audit trail to dir.
+func NewManager(msgBus *bus.MessageBus, cfg config.ApprovalConfig, dir string) *Manager {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	return &Manager{
+		bus:       msgBus,
+		timeout:   timeout,
+		dir:       dir,
+		pending:   make(map[string]*pending),
+		prompters: make(map[string]Prompter),
+	}
+}
+
+// SetPrompter asks p instead of sending a prompt for requests from channel.
+func (m *Manager) SetPrompter(channel string, p Prompter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prompters[channel] = p
+}
+
+// RequestApproval implements tools.Approver.
+func (m *Manager) RequestApproval(ctx context.Context, req tools.ApprovalRequest) tools.ApprovalDecision {
+	id := newID()
+	m.audit(Record{ID: id, Event: EventRequested}, req)
+
+	m.mu.Lock()
+	prompter := m.prompters[req.Channel]
+	m.mu.Unlock()
+
+	if prompter != nil {
+		approved, err := prompter(ctx, id, req)
+		if err != nil {
+			m.audit(Record{ID: id, Event: EventCancelled, Reason: err.Error()}, req)
+			return tools.ApprovalDecision{Reason: err.Error()}
+		}
+		return m.decided(id, req, decision{approved: approved, by: req.Channel})
+	}
+
+	if req.Channel == "" || req.ChatID == "" || constants.IsInternalChannel(req.Channel) {
+		reason := fmt.Sprintf("nobody can approve it from channel %q", req.Channel)
+		m.audit(Record{ID: id, Event: EventDenied, Reason: reason}, req)
+		return tools.ApprovalDecision{Reason: reason}
+	}
+
+	p := &pending{req: req, decision: make(chan decision, 1)}
+	m.mu.Lock()
+	m.pending[id] = p
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, id)
+		m.mu.Unlock()
+	}()
+
+	m.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: req.Channel,
+		ChatID:  req.ChatID,
+		Content: fmt.Sprintf("%s\n\nReply /approve %s or /deny %s (denied automatically in %s).", Describe(id, req), id, id, m.timeout),
+		Buttons: []bus.Button{
+			{Label: "Approve", Command: "/approve " + id},
+			{Label: "Deny", Command: "/deny " + id},
+		},
+	})
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	select {
+	case d := <-p.decision:
+		return m.decided(id, req, d)
+	case <-timer.C:
+		reason := fmt.Sprintf("nobody approved it within %s", m.timeout)
+		m.audit(Record{ID: id, Event: EventTimeout, Reason: reason}, req)
+		m.bus.PublishOutbound(bus.OutboundMessage{
+			Channel: req.Channel,
+			ChatID:  req.ChatID,
+			Content: fmt.Sprintf("Approval %s expired, the %s call was denied.", id, req.Tool),
+		})
+		return tools.ApprovalDecision{Reason: reason}
+	case <-ctx.Done():
+		m.audit(Record{ID: id, Event: EventCancelled, Reason: ctx.Err().Error()}, req)
+		return tools.ApprovalDecision{Reason: "the turn was cancelled"}
+	}
+}
+
+func (m *Manager) decided(id string, req tools.ApprovalRequest, d decision) tools.ApprovalDecision {
+	if d.approved {
+		m.audit(Record{ID: id, Event: EventApproved, By: d.by}, req)
+		return tools.ApprovalDecision{Approved: true}
+	}
+	m.audit(Record{ID: id, Event: EventDenied, By: d.by}, req)
+	return tools.ApprovalDecision{Reason: "denied by the user"}
+}
+
+// HandleReply resolves "/approve <id>" and "/deny <id>" messages. The ID can
+// be left out when the chat has a single pending request. It reports whether
+// msg was such a reply and returns the confirmation to send back.
+//
+// Replies are only accepted from the chat the request was sent to.
+func (m *Manager) HandleReply(msg bus.InboundMessage) (string, bool) {
+	fields := strings.Fields(msg.Content)
+	if len(fields) == 0 {
+		return "", false
+	}
+	// Telegram appends the bot name to commands in groups: /approve@my_bot
+	cmd, _, _ := strings.Cut(fields[0], "@")
+	var approve bool
+	switch cmd {
+	case "/approve":
+		approve = true
+	case "/deny":
+		approve = false
+	default:
+		return "", false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var id string
+	if len(fields) > 1 {
+		id = fields[1]
+	} else {
+		var ids []string
+		for pid, p := range m.pending {
+			if p.req.Channel == msg.Channel && p.req.ChatID == msg.ChatID {
+				ids = append(ids, pid)
+			}
+		}
+		switch len(ids) {
+		case 0:
+			return "There is nothing waiting for approval.", true
+		case 1:
+			id = ids[0]
+		default:
+			sort.Strings(ids)
+			return fmt.Sprintf("Several calls are waiting for approval (%s), please give the ID: %s <id>", strings.Join(ids, ", "), cmd), true
+		}
+	}
+
+	p, ok := m.pending[id]
+	if !ok || p.req.Channel != msg.Channel || p.req.ChatID != msg.ChatID {
+		return fmt.Sprintf("No pending approval %s (it may have expired).", id), true
+	}
+	delete(m.pending, id)
+	p.decision <- decision{approved: approve, by: msg.SenderID}
+
+	if approve {
+		return fmt.Sprintf("Approved %s, running %s.", id, p.req.Tool), true
+	}
+	return fmt.Sprintf("Denied %s, %s will not run.", id, p.req.Tool), true
+}
+
+// Pending returns the number of requests waiting for a reply.
+func (m *Manager) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending)
+}
+
+// AuditPath returns the audit trail file, or "" when auditing is disabled.
+func (m *Manager) AuditPath() string {
+	if m.dir == "" {
+		return ""
+	}
+	return filepath.Join(m.dir, auditFile)
+}
+
+func (m *Manager) audit(rec Record, req tools.ApprovalRequest) {
+	rec.Time = time.Now()
+	rec.Tool = req.Tool
+	rec.Args = req.Args
+	rec.Rule = req.Rule
+	rec.Channel = req.Channel
+	rec.ChatID = req.ChatID
+
+	logger.InfoCF("approval", "Approval "+rec.Event, map[string]interface{}{
+		"id":      rec.ID,
+		"tool":    rec.Tool,
+		"channel": rec.Channel,
+		"by":      rec.By,
+	})
+
+	if m.dir == "" {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err == nil {
+		err = m.appendAudit(append(data, '\n'))
+	}
+	if err != nil {
+		logger.WarnCF("approval", "Failed to write audit trail", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+func (m *Manager) appendAudit(line []byte) error {
+	m.auditMu.Lock()
+	defer m.auditMu.Unlock()
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.AuditPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	return err
+}
+
+// Describe formats a request for a human.
+func Describe(id string, req tools.ApprovalRequest) string {
+	args, _ := json.MarshalIndent(req.Args, "", "  ")
+	return fmt.Sprintf("Approval needed [%s]\nTool: %s\nArguments:\n%s\nRule: %s",
+		id, req.Tool, utils.Truncate(string(args), 800), req.Rule)
+}
+
+func newID() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%06x", time.Now().UnixNano()&0xffffff)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,188 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+func newTestManager(t *testing.T, timeout int) (*Manager, *bus.MessageBus) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	t.Cleanup(mb.Close)
+	return NewManager(mb, config.ApprovalConfig{Enabled: true, Timeout: timeout}, t.TempDir()), mb
+}
+
+var execReq = tools.ApprovalRequest{
+	Tool:    "exec",
+	Args:    map[string]interface{}{"command": "rm -rf build"},
+	Channel: "telegram",
+	ChatID:  "42",
+	Rule:    "exec",
+}
+
+// requestAsync starts a request and returns its prompt and the decision.
+func requestAsync(t *testing.T, m *Manager, mb *bus.MessageBus, req tools.ApprovalRequest) (bus.OutboundMessage, <-chan tools.ApprovalDecision) {
+	t.Helper()
+	result := make(chan tools.ApprovalDecision, 1)
+	go func() { result <- m.RequestApproval(context.Background(), req) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	prompt, ok := mb.SubscribeOutbound(ctx)
+	if !ok {
+		t.Fatal("no approval prompt was sent")
+	}
+	return prompt, result
+}
+
+func promptID(t *testing.T, prompt bus.OutboundMessage) string {
+	t.Helper()
+	if len(prompt.Buttons) != 2 {
+		t.Fatalf("prompt buttons = %+v", prompt.Buttons)
+	}
+	id, ok := strings.CutPrefix(prompt.Buttons[0].Command, "/approve ")
+	if !ok {
+		t.Fatalf("approve button command = %q", prompt.Buttons[0].Command)
+	}
+	return id
+}
+
+func TestManager_ApproveByReply(t *testing.T) {
+	m, mb := newTestManager(t, 60)
+	prompt, result := requestAsync(t, m, mb, execReq)
+	if prompt.Channel != "telegram" || prompt.ChatID != "42" || !strings.Contains(prompt.Content, "rm -rf build") {
+		t.Errorf("prompt = %+v", prompt)
+	}
+	id := promptID(t, prompt)
+
+	reply, handled := m.HandleReply(bus.InboundMessage{Channel: "telegram", ChatID: "42", SenderID: "7", Content: "/approve " + id})
+	if !handled || !strings.Contains(reply, "Approved") {
+		t.Fatalf("HandleReply = %q, %v", reply, handled)
+	}
+	if d := <-result; !d.Approved {
+		t.Errorf("decision = %+v, want approved", d)
+	}
+	if m.Pending() != 0 {
+		t.Errorf("pending = %d after decision", m.Pending())
+	}
+}
+
+func TestManager_DenyWithoutID(t *testing.T) {
+	m, mb := newTestManager(t, 60)
+	_, result := requestAsync(t, m, mb, execReq)
+
+	reply, handled := m.HandleReply(bus.InboundMessage{Channel: "telegram", ChatID: "42", Content: "/deny@picoclaw_bot"})
+	if !handled || !strings.Contains(reply, "Denied") {
+		t.Fatalf("HandleReply = %q, %v", reply, handled)
+	}
+	if d := <-result; d.Approved || d.Reason == "" {
+		t.Errorf("decision = %+v, want denied with a reason", d)
+	}
+}
+
+func TestManager_ReplyFromOtherChatIgnored(t *testing.T) {
+	m, mb := newTestManager(t, 60)
+	prompt, result := requestAsync(t, m, mb, execReq)
+	id := promptID(t, prompt)
+
+	reply, handled := m.HandleReply(bus.InboundMessage{Channel: "telegram", ChatID: "99", Content: "/approve " + id})
+	if !handled || !strings.Contains(reply, "No pending approval") {
+		t.Errorf("HandleReply from another chat = %q, %v", reply, handled)
+	}
+	if m.Pending() != 1 {
+		t.Fatalf("request should still be pending")
+	}
+
+	if _, handled := m.HandleReply(bus.InboundMessage{Channel: "telegram", ChatID: "42", Content: "approve it please"}); handled {
+		t.Error("ordinary messages must not be treated as replies")
+	}
+
+	m.HandleReply(bus.InboundMessage{Channel: "telegram", ChatID: "42", Content: "/deny " + id})
+	<-result
+}
+
+func TestManager_TimeoutDenies(t *testing.T) {
+	m, mb := newTestManager(t, 0)
+	m.timeout = 50 * time.Millisecond
+
+	_, result := requestAsync(t, m, mb, execReq)
+	select {
+	case d := <-result:
+		if d.Approved || !strings.Contains(d.Reason, "within") {
+			t.Errorf("decision = %+v, want timeout denial", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request did not time out")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if expired, ok := mb.SubscribeOutbound(ctx); !ok || !strings.Contains(expired.Content, "expired") {
+		t.Errorf("expiry notice = %+v, %v", expired, ok)
+	}
+
+	events := readAudit(t, m)
+	if len(events) != 2 || events[0].Event != EventRequested || events[1].Event != EventTimeout {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func TestManager_InternalChannelDenied(t *testing.T) {
+	m, _ := newTestManager(t, 60)
+	req := execReq
+	req.Channel, req.ChatID = "system", "cron"
+	if d := m.RequestApproval(context.Background(), req); d.Approved {
+		t.Error("requests from internal channels must be denied")
+	}
+}
+
+func TestManager_Prompter(t *testing.T) {
+	m, _ := newTestManager(t, 60)
+	var asked string
+	m.SetPrompter("cli", func(ctx context.Context, id string, req tools.ApprovalRequest) (bool, error) {
+		asked = id
+		return true, nil
+	})
+
+	req := execReq
+	req.Channel, req.ChatID = "cli", "direct"
+	if d := m.RequestApproval(context.Background(), req); !d.Approved {
+		t.Errorf("decision = %+v, want approved", d)
+	}
+	if asked == "" {
+		t.Error("prompter was not asked")
+	}
+
+	events := readAudit(t, m)
+	if len(events) != 2 || events[1].Event != EventApproved || events[1].By != "cli" || events[1].Args["command"] != "rm -rf build" {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func readAudit(t *testing.T, m *Manager) []Record {
+	t.Helper()
+	f, err := os.Open(m.AuditPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("bad audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
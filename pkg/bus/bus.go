@@ -2,61 +2,425 @@ package bus
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
+// Options configures delivery guarantees of a MessageBus.
+type Options struct {
+	// Dir holds the journal and the dead-letter file. Empty keeps messages
+	// in memory only, so anything not yet handled is lost on exit.
+	Dir string
+	// MaxAttempts is how many times a message is delivered before it is
+	// moved to the dead-letter file.
+	MaxAttempts    int
+	InitialBackoff time.Duration // Delay before redelivering a nacked message, doubled every attempt
+	MaxBackoff     time.Duration
+	// MaxPending caps the unacknowledged messages. Publishing beyond it
+	// moves the oldest queued message to the dead letters.
+	MaxPending int
+	// AckTimeout is how long a consumer may hold a message without acking
+	// or nacking it before it is moved to the dead letters.
+	AckTimeout time.Duration
+	// OnDeadLetter, if set, is called with every inbound message moved to
+	// the dead letters, e.g. to remove the media it refers to. It runs with
+	// the bus locked and must not call back into it.
+	OnDeadLetter func(InboundMessage)
+}
+
+// DefaultOptions returns the in-memory defaults used by NewMessageBus.
+func DefaultOptions() Options {
+	return Options{
+		MaxAttempts:    5,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     time.Minute,
+		MaxPending:     10000,
+		AckTimeout:     time.Hour,
+	}
+}
+
+// MessageBus carries inbound messages from channels to agents and outbound
+// replies back. Publishing never blocks: once Options.MaxPending messages are
+// waiting, the oldest queued one is dropped to make room.
+//
+// Every published message gets an ID and stays unacknowledged until its
+// consumer calls Ack. Nack redelivers it after a backoff and Reject gives up
+// on it; a message that runs out of attempts, or that is not acknowledged
+// within Options.AckTimeout, lands in the dead-letter file.
+// With Options.Dir set, all of this is journaled so unacknowledged messages
+// are redelivered after a crash or restart.
 type MessageBus struct {
-	inbound  chan InboundMessage
-	outbound chan OutboundMessage
+	opts     Options
+	inbound  []*entry
+	outbound []*entry
+	inReady  chan struct{}
+	outReady chan struct{}
+	unacked  map[string]*entry
+	journal  *journal // nil when in-memory
+	seq      uint64
 	handlers map[string]MessageHandler
 	closed   bool
+	done     chan struct{}
 	mu       sync.RWMutex
 }
 
+// NewMessageBus creates an in-memory bus.
 func NewMessageBus() *MessageBus {
+	return newMessageBus(DefaultOptions())
+}
+
+// OpenMessageBus creates a bus with opts. If opts.Dir is set, messages left
+// unacknowledged by the previous run are queued for redelivery.
+func OpenMessageBus(opts Options) (*MessageBus, error) {
+	defaults := DefaultOptions()
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaults.MaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaults.InitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaults.MaxBackoff
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = defaults.MaxPending
+	}
+	if opts.AckTimeout <= 0 {
+		opts.AckTimeout = defaults.AckTimeout
+	}
+
+	mb := newMessageBus(opts)
+	if opts.Dir == "" {
+		return mb, nil
+	}
+
+	j, pending, err := openJournal(opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("open bus journal: %w", err)
+	}
+	mb.journal = j
+
+	redelivered := 0
+	for _, e := range pending {
+		if e.Seq > mb.seq {
+			mb.seq = e.Seq
+		}
+		// A message that was handed to a consumer but never acknowledged
+		// may be what brought the process down, so it costs an attempt.
+		if e.delivered {
+			e.delivered = false
+			e.Attempts++
+			e.Error = "not acknowledged before shutdown"
+			if e.Attempts >= opts.MaxAttempts {
+				mb.deadLetterLocked(e)
+				continue
+			}
+		}
+		mb.unacked[e.ID] = e
+		mb.enqueueLocked(e)
+		redelivered++
+	}
+	if err := j.compact(mb.pendingLocked()); err != nil {
+		j.close()
+		return nil, fmt.Errorf("compact bus journal: %w", err)
+	}
+
+	if redelivered > 0 {
+		logger.InfoCF("bus", "Redelivering unacknowledged messages", map[string]interface{}{
+			"count": redelivered,
+		})
+	}
+	return mb, nil
+}
+
+func newMessageBus(opts Options) *MessageBus {
 	return &MessageBus{
-		inbound:  make(chan InboundMessage, 100),
-		outbound: make(chan OutboundMessage, 100),
+		opts:     opts,
+		inReady:  make(chan struct{}, 1),
+		outReady: make(chan struct{}, 1),
+		unacked:  make(map[string]*entry),
 		handlers: make(map[string]MessageHandler),
+		done:     make(chan struct{}),
 	}
 }
 
 func (mb *MessageBus) PublishInbound(msg InboundMessage) {
-	mb.mu.RLock()
-	defer mb.mu.RUnlock()
-	if mb.closed {
-		return
+	if msg.ID == "" {
+		msg.ID = newID()
 	}
-	mb.inbound <- msg
+	mb.publish(&entry{Kind: kindInbound, ID: msg.ID, Inbound: &msg})
 }
 
 func (mb *MessageBus) ConsumeInbound(ctx context.Context) (InboundMessage, bool) {
-	select {
-	case msg := <-mb.inbound:
-		return msg, true
-	case <-ctx.Done():
+	e, ok := mb.next(ctx, &mb.inbound, mb.inReady)
+	if !ok {
 		return InboundMessage{}, false
 	}
+	return *e.Inbound, true
 }
 
 func (mb *MessageBus) PublishOutbound(msg OutboundMessage) {
+	if msg.ID == "" {
+		msg.ID = newID()
+	}
+	mb.publish(&entry{Kind: kindOutbound, ID: msg.ID, Outbound: &msg})
+}
+
+func (mb *MessageBus) SubscribeOutbound(ctx context.Context) (OutboundMessage, bool) {
+	e, ok := mb.next(ctx, &mb.outbound, mb.outReady)
+	if !ok {
+		return OutboundMessage{}, false
+	}
+	return *e.Outbound, true
+}
+
+func (mb *MessageBus) publish(e *entry) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return
+	}
+
+	mb.seq++
+	e.Seq = mb.seq
+	mb.unacked[e.ID] = e
+	if mb.journal != nil {
+		mb.journalLocked(e.record(opPublish), true)
+	}
+	mb.enqueueLocked(e)
+	if len(mb.unacked) > mb.opts.MaxPending {
+		mb.dropOldestLocked()
+	}
+}
+
+// dropOldestLocked dead-letters the oldest queued message. Messages being
+// handled are left alone, AckTimeout bounds those.
+func (mb *MessageBus) dropOldestLocked() {
+	queue := &mb.inbound
+	if len(mb.inbound) == 0 || (len(mb.outbound) > 0 && mb.outbound[0].Seq < mb.inbound[0].Seq) {
+		queue = &mb.outbound
+	}
+	if len(*queue) == 0 {
+		return
+	}
+	e := (*queue)[0]
+	(*queue)[0] = nil
+	*queue = (*queue)[1:]
+	e.Error = fmt.Sprintf("dropped, more than %d messages pending", mb.opts.MaxPending)
+	mb.deadLetterLocked(e)
+}
+
+// next pops the head of queue, waiting until one is available.
+func (mb *MessageBus) next(ctx context.Context, queue *[]*entry, ready chan struct{}) (*entry, bool) {
+	for {
+		mb.mu.Lock()
+		if mb.closed {
+			mb.mu.Unlock()
+			return nil, false
+		}
+		if len(*queue) > 0 {
+			e := (*queue)[0]
+			(*queue)[0] = nil
+			*queue = (*queue)[1:]
+			if len(*queue) > 0 {
+				signal(ready)
+			}
+			e.delivered = true
+			if mb.journal != nil {
+				mb.journalLocked(&entry{Op: opDeliver, ID: e.ID}, false)
+			}
+			mb.watchAckLocked(e)
+			mb.mu.Unlock()
+			return e, true
+		}
+		mb.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, false
+		case <-mb.done:
+			return nil, false
+		}
+	}
+}
+
+// watchAckLocked dead-letters e if its consumer neither acks nor nacks it
+// within AckTimeout.
+func (mb *MessageBus) watchAckLocked(e *entry) {
+	e.ackTimer = time.AfterFunc(mb.opts.AckTimeout, func() {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		if mb.closed || mb.unacked[e.ID] != e || !e.delivered {
+			return
+		}
+		e.Attempts++
+		e.Error = fmt.Sprintf("not acknowledged within %s", mb.opts.AckTimeout)
+		mb.deadLetterLocked(e)
+	})
+}
+
+// Ack marks a consumed message as handled. Unknown IDs (e.g. messages built
+// by hand and never published) are ignored.
+func (mb *MessageBus) Ack(id string) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	e, ok := mb.unacked[id]
+	if !ok {
+		return
+	}
+	e.stopAckTimer()
+	delete(mb.unacked, id)
+	if mb.journal != nil {
+		mb.journalLocked(&entry{Op: opAck, ID: id}, false)
+		if mb.journal.needsCompaction() {
+			if err := mb.journal.compact(mb.pendingLocked()); err != nil {
+				logger.WarnCF("bus", "Failed to compact journal", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+		}
+	}
+}
+
+// Nack reports that handling a consumed message failed. It is redelivered
+// after a backoff, or moved to the dead-letter file once it has used up its
+// attempts.
+func (mb *MessageBus) Nack(id string, reason error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	e, ok := mb.unacked[id]
+	if !ok {
+		return
+	}
+
+	e.stopAckTimer()
+	e.Attempts++
+	e.Error = reason.Error()
+	if e.Attempts >= mb.opts.MaxAttempts {
+		mb.deadLetterLocked(e)
+		return
+	}
+	e.delivered = false
+	if mb.journal != nil {
+		mb.journalLocked(&entry{Op: opNack, ID: id, Attempts: e.Attempts, Error: e.Error}, false)
+	}
+
+	delay := mb.backoff(e.Attempts)
+	logger.WarnCF("bus", "Message delivery failed, retrying", map[string]interface{}{
+		"id":       id,
+		"kind":     e.Kind,
+		"attempts": e.Attempts,
+		"retry_in": delay.String(),
+		"error":    e.Error,
+	})
+	time.AfterFunc(delay, func() {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		if mb.closed || mb.unacked[id] != e {
+			return
+		}
+		mb.enqueueLocked(e)
+	})
+}
+
+// Reject moves a consumed message straight to the dead-letter file, for
+// failures that retrying cannot fix.
+func (mb *MessageBus) Reject(id string, reason error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	e, ok := mb.unacked[id]
+	if !ok {
+		return
+	}
+	e.Attempts++
+	e.Error = reason.Error()
+	mb.deadLetterLocked(e)
+}
+
+// Unacked returns the number of published messages not yet acknowledged.
+func (mb *MessageBus) Unacked() int {
 	mb.mu.RLock()
 	defer mb.mu.RUnlock()
-	if mb.closed {
+	return len(mb.unacked)
+}
+
+// DeadLetterPath returns the dead-letter file, or "" for an in-memory bus.
+func (mb *MessageBus) DeadLetterPath() string {
+	if mb.journal == nil {
+		return ""
+	}
+	return mb.journal.deadLetterPath()
+}
+
+func (mb *MessageBus) deadLetterLocked(e *entry) {
+	e.stopAckTimer()
+	delete(mb.unacked, e.ID)
+	logger.ErrorCF("bus", "Message moved to dead letters", map[string]interface{}{
+		"id":       e.ID,
+		"kind":     e.Kind,
+		"attempts": e.Attempts,
+		"error":    e.Error,
+	})
+	if e.Inbound != nil && mb.opts.OnDeadLetter != nil {
+		mb.opts.OnDeadLetter(*e.Inbound)
+	}
+	if mb.journal == nil {
 		return
 	}
-	mb.outbound <- msg
+	if err := mb.journal.deadLetter(e); err != nil {
+		logger.ErrorCF("bus", "Failed to write dead letter", map[string]interface{}{
+			"id":    e.ID,
+			"error": err.Error(),
+		})
+	}
+	mb.journalLocked(&entry{Op: opDead, ID: e.ID}, false)
 }
 
-func (mb *MessageBus) SubscribeOutbound(ctx context.Context) (OutboundMessage, bool) {
-	select {
-	case msg := <-mb.outbound:
-		return msg, true
-	case <-ctx.Done():
-		return OutboundMessage{}, false
+func (mb *MessageBus) journalLocked(rec *entry, sync bool) {
+	if err := mb.journal.append(rec, sync); err != nil {
+		logger.WarnCF("bus", "Failed to write journal", map[string]interface{}{
+			"op":    rec.Op,
+			"id":    rec.ID,
+			"error": err.Error(),
+		})
 	}
 }
 
+func (mb *MessageBus) enqueueLocked(e *entry) {
+	if e.Kind == kindInbound {
+		mb.inbound = append(mb.inbound, e)
+		signal(mb.inReady)
+	} else {
+		mb.outbound = append(mb.outbound, e)
+		signal(mb.outReady)
+	}
+}
+
+// pendingLocked returns the unacknowledged messages in publish order.
+func (mb *MessageBus) pendingLocked() []*entry {
+	pending := make([]*entry, 0, len(mb.unacked))
+	for _, e := range mb.unacked {
+		pending = append(pending, e)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Seq < pending[j].Seq })
+	return pending
+}
+
+func (mb *MessageBus) backoff(attempts int) time.Duration {
+	wait := mb.opts.InitialBackoff << (attempts - 1)
+	if wait <= 0 || wait > mb.opts.MaxBackoff {
+		wait = mb.opts.MaxBackoff
+	}
+	return wait
+}
+
 func (mb *MessageBus) RegisterHandler(channel string, handler MessageHandler) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
@@ -70,6 +434,8 @@ func (mb *MessageBus) GetHandler(channel string) (MessageHandler, bool) {
 	return handler, ok
 }
 
+// Close stops delivery. Unacknowledged messages stay in the journal and are
+// redelivered by the next OpenMessageBus on the same directory.
 func (mb *MessageBus) Close() {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
@@ -77,6 +443,23 @@ func (mb *MessageBus) Close() {
 		return
 	}
 	mb.closed = true
-	close(mb.inbound)
-	close(mb.outbound)
+	close(mb.done)
+	if mb.journal != nil {
+		mb.journal.close()
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,277 @@
+package bus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func consumeInbound(t *testing.T, mb *MessageBus) InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("timed out waiting for inbound message")
+	}
+	return msg
+}
+
+func readDeadLetters(t *testing.T, dir string) []entry {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, deadLetterFile))
+	if err != nil {
+		t.Fatalf("open dead letters: %v", err)
+	}
+	defer f.Close()
+
+	var letters []entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("bad dead letter %q: %v", scanner.Text(), err)
+		}
+		letters = append(letters, e)
+	}
+	return letters
+}
+
+func TestMessageBus_PublishNeverBlocks(t *testing.T) {
+	mb := NewMessageBus()
+	defer mb.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 500; i++ {
+			mb.PublishInbound(InboundMessage{Channel: "test", Content: "hi"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("PublishInbound blocked with no consumer")
+	}
+	if got := mb.Unacked(); got != 500 {
+		t.Errorf("Unacked = %d, want 500", got)
+	}
+}
+
+func TestMessageBus_OrderAndAck(t *testing.T) {
+	mb := NewMessageBus()
+	defer mb.Close()
+
+	mb.PublishInbound(InboundMessage{Content: "first"})
+	mb.PublishInbound(InboundMessage{Content: "second"})
+
+	first := consumeInbound(t, mb)
+	second := consumeInbound(t, mb)
+	if first.Content != "first" || second.Content != "second" {
+		t.Fatalf("got %q, %q; want first, second", first.Content, second.Content)
+	}
+	if first.ID == "" || first.ID == second.ID {
+		t.Fatalf("messages need distinct IDs, got %q and %q", first.ID, second.ID)
+	}
+
+	mb.Ack(first.ID)
+	mb.Ack(second.ID)
+	mb.Ack("unknown")
+	if got := mb.Unacked(); got != 0 {
+		t.Errorf("Unacked = %d, want 0", got)
+	}
+}
+
+func TestMessageBus_MaxPendingDropsOldest(t *testing.T) {
+	var dropped []string
+	mb, err := OpenMessageBus(Options{MaxPending: 2, OnDeadLetter: func(msg InboundMessage) {
+		dropped = append(dropped, msg.Content)
+	}})
+	if err != nil {
+		t.Fatalf("OpenMessageBus: %v", err)
+	}
+	defer mb.Close()
+
+	mb.PublishInbound(InboundMessage{Content: "first"})
+	mb.PublishOutbound(OutboundMessage{Content: "second"})
+	mb.PublishInbound(InboundMessage{Content: "third"})
+
+	if got := mb.Unacked(); got != 2 {
+		t.Errorf("Unacked = %d, want 2", got)
+	}
+	if len(dropped) != 1 || dropped[0] != "first" {
+		t.Errorf("OnDeadLetter got %v, want [first]", dropped)
+	}
+	if msg := consumeInbound(t, mb); msg.Content != "third" {
+		t.Errorf("got %q, want the oldest message dropped", msg.Content)
+	}
+}
+
+func TestMessageBus_AckTimeout(t *testing.T) {
+	mb, err := OpenMessageBus(Options{AckTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenMessageBus: %v", err)
+	}
+	defer mb.Close()
+
+	mb.PublishInbound(InboundMessage{Content: "forgotten"})
+	consumeInbound(t, mb)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for mb.Unacked() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message never acked was kept")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMessageBus_NackRetriesThenDeadLetters(t *testing.T) {
+	dir := t.TempDir()
+	mb, err := OpenMessageBus(Options{
+		Dir:            dir,
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("OpenMessageBus: %v", err)
+	}
+	defer mb.Close()
+
+	mb.PublishOutbound(OutboundMessage{Channel: "telegram", ChatID: "1", Content: "reply"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	msg, ok := mb.SubscribeOutbound(ctx)
+	if !ok {
+		t.Fatal("no outbound message")
+	}
+	mb.Nack(msg.ID, errors.New("network down"))
+
+	retry, ok := mb.SubscribeOutbound(ctx)
+	if !ok {
+		t.Fatal("nacked message was not redelivered")
+	}
+	if retry.ID != msg.ID {
+		t.Fatalf("redelivered ID = %q, want %q", retry.ID, msg.ID)
+	}
+	mb.Nack(retry.ID, errors.New("still down"))
+
+	if got := mb.Unacked(); got != 0 {
+		t.Errorf("Unacked = %d, want 0 after last attempt", got)
+	}
+	letters := readDeadLetters(t, dir)
+	if len(letters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(letters))
+	}
+	dl := letters[0]
+	if dl.Kind != kindOutbound || dl.Attempts != 2 || dl.Error != "still down" || dl.Outbound == nil || dl.Outbound.Content != "reply" {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
+	if dl.Time == nil {
+		t.Error("dead letter has no time")
+	}
+}
+
+func TestMessageBus_Reject(t *testing.T) {
+	dir := t.TempDir()
+	mb, err := OpenMessageBus(Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenMessageBus: %v", err)
+	}
+	defer mb.Close()
+
+	mb.PublishInbound(InboundMessage{Content: "bad"})
+	msg := consumeInbound(t, mb)
+	mb.Reject(msg.ID, errors.New("unknown channel"))
+
+	if letters := readDeadLetters(t, dir); len(letters) != 1 || letters[0].Error != "unknown channel" {
+		t.Errorf("unexpected dead letters: %+v", letters)
+	}
+}
+
+func TestMessageBus_RedeliversAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Dir: dir, MaxAttempts: 2}
+
+	mb, err := OpenMessageBus(opts)
+	if err != nil {
+		t.Fatalf("OpenMessageBus: %v", err)
+	}
+	mb.PublishInbound(InboundMessage{Content: "handled"})
+	mb.PublishInbound(InboundMessage{Content: "in flight"})
+	mb.PublishInbound(InboundMessage{Content: "queued"})
+	mb.Ack(consumeInbound(t, mb).ID)
+	consumeInbound(t, mb) // never acknowledged, as if the process died mid-turn
+	mb.Close()
+
+	mb, err = OpenMessageBus(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := mb.Unacked(); got != 2 {
+		t.Fatalf("Unacked after restart = %d, want 2", got)
+	}
+	if msg := consumeInbound(t, mb); msg.Content != "in flight" {
+		t.Errorf("first redelivery = %q, want %q", msg.Content, "in flight")
+	}
+	if msg := consumeInbound(t, mb); msg.Content != "queued" {
+		t.Errorf("second redelivery = %q, want %q", msg.Content, "queued")
+	}
+	mb.Close()
+
+	// Both were delivered again without an ack. "in flight" has now used up
+	// its two attempts; "queued" gets one more try.
+	mb, err = OpenMessageBus(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer mb.Close()
+
+	if msg := consumeInbound(t, mb); msg.Content != "queued" {
+		t.Errorf("redelivery = %q, want %q", msg.Content, "queued")
+	}
+	letters := readDeadLetters(t, dir)
+	if len(letters) != 1 || letters[0].Inbound == nil || letters[0].Inbound.Content != "in flight" {
+		t.Errorf("unexpected dead letters: %+v", letters)
+	}
+}
+
+func TestMessageBus_JournalCompaction(t *testing.T) {
+	dir := t.TempDir()
+	mb, err := OpenMessageBus(Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenMessageBus: %v", err)
+	}
+	defer mb.Close()
+
+	for i := 0; i < compactAfter; i++ {
+		mb.PublishInbound(InboundMessage{Content: "x"})
+		mb.Ack(consumeInbound(t, mb).ID)
+	}
+	mb.PublishInbound(InboundMessage{Content: "pending"})
+
+	pending, err := replay(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Inbound.Content != "pending" {
+		t.Errorf("replayed %d messages, want only the pending one", len(pending))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines >= compactAfter {
+		t.Errorf("journal has %d records, expected it to have been compacted", lines)
+	}
+}
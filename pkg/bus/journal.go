@@ -0,0 +1,237 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	journalFile    = "journal.jsonl"
+	deadLetterFile = "dead-letter.jsonl"
+
+	// compactAfter is how many records may pile up in the journal before it
+	// is rewritten to hold only unacknowledged messages.
+	compactAfter = 1000
+)
+
+const (
+	kindInbound  = "inbound"
+	kindOutbound = "outbound"
+)
+
+// Journal operations.
+const (
+	opPublish = "publish"
+	opDeliver = "deliver"
+	opAck     = "ack"
+	opNack    = "nack"
+	opDead    = "dead"
+)
+
+// entry is a queued message. The same shape is used for journal records and
+// dead letters, with only the fields relevant to Op set.
+type entry struct {
+	Op       string           `json:"op,omitempty"`
+	ID       string           `json:"id"`
+	Seq      uint64           `json:"seq,omitempty"`
+	Kind     string           `json:"kind,omitempty"`
+	Attempts int              `json:"attempts,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Time     *time.Time       `json:"time,omitempty"`
+	Inbound  *InboundMessage  `json:"inbound,omitempty"`
+	Outbound *OutboundMessage `json:"outbound,omitempty"`
+
+	delivered bool        // handed to a consumer since it was last queued
+	ackTimer  *time.Timer // dead-letters the message if it is not acked in time
+}
+
+func (e *entry) stopAckTimer() {
+	if e.ackTimer != nil {
+		e.ackTimer.Stop()
+		e.ackTimer = nil
+	}
+}
+
+func (e *entry) record(op string) *entry {
+	rec := *e
+	rec.Op = op
+	return &rec
+}
+
+// journal is an append-only log of bus operations. Replaying it yields the
+// messages that were published but never acknowledged or dead-lettered.
+type journal struct {
+	dir string
+	f   *os.File
+	ops int // records appended since the last compaction
+}
+
+// openJournal replays the journal in dir and returns it with the pending
+// messages in publish order.
+func openJournal(dir string) (*journal, []*entry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	pending, err := replay(filepath.Join(dir, journalFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &journal{dir: dir, f: f}, pending, nil
+}
+
+func replay(path string) ([]*entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	byID := map[string]*entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec entry
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // skip a line torn by a crash mid-write
+		}
+		switch rec.Op {
+		case opPublish:
+			rec.Op = ""
+			byID[rec.ID] = &rec
+		case opDeliver:
+			if e, ok := byID[rec.ID]; ok {
+				e.delivered = true
+			}
+		case opNack:
+			if e, ok := byID[rec.ID]; ok {
+				e.delivered = false
+				e.Attempts = rec.Attempts
+				e.Error = rec.Error
+			}
+		case opAck, opDead:
+			delete(byID, rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := make([]*entry, 0, len(byID))
+	for _, e := range byID {
+		pending = append(pending, e)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Seq < pending[j].Seq })
+	return pending, nil
+}
+
+// append writes rec to the journal. Publishes are synced to disk so an
+// accepted message survives a crash; losing an ack only means a redelivery.
+func (j *journal) append(rec *entry, sync bool) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	j.ops++
+	if sync {
+		return j.f.Sync()
+	}
+	return nil
+}
+
+func (j *journal) needsCompaction() bool {
+	return j.ops >= compactAfter
+}
+
+// compact atomically replaces the journal with the records needed to
+// recover pending.
+func (j *journal) compact(pending []*entry) error {
+	path := filepath.Join(j.dir, journalFile)
+	tmp, err := os.CreateTemp(j.dir, journalFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, e := range pending {
+		data, err := json.Marshal(e.record(opPublish))
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+		if e.delivered {
+			data, _ = json.Marshal(&entry{Op: opDeliver, ID: e.ID})
+			w.Write(append(data, '\n'))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.f.Close()
+	j.f = f
+	j.ops = 0
+	return nil
+}
+
+func (j *journal) deadLetterPath() string {
+	return filepath.Join(j.dir, deadLetterFile)
+}
+
+// deadLetter appends e to the dead-letter file.
+func (j *journal) deadLetter(e *entry) error {
+	now := time.Now()
+	rec := *e
+	rec.Op = ""
+	rec.Time = &now
+	data, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.deadLetterPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (j *journal) close() {
+	j.f.Close()
+}
@@ -1,20 +1,37 @@
 package bus
 
 type InboundMessage struct {
+	ID         string            `json:"id,omitempty"` // Assigned by the bus on publish, used for Ack/Nack
 	Channel    string            `json:"channel"`
 	SenderID   string            `json:"sender_id"`
 	ChatID     string            `json:"chat_id"`
 	Content    string            `json:"content"`
-	Media      []string          `json:"media,omitempty"`
+	Media      []string          `json:"media,omitempty"` // Local temp files (owned by the consumer) or remote URLs
 	SessionKey string            `json:"session_key"`
 	Metadata   map[string]string `json:"metadata,omitempty"`
 }
 
 type OutboundMessage struct {
+	ID      string   `json:"id,omitempty"` // Assigned by the bus on publish, used for Ack/Nack
 	Channel string   `json:"channel"`
 	ChatID  string   `json:"chat_id"`
 	Content string   `json:"content"`
 	Media   []string `json:"media,omitempty"`
+	Buttons []Button `json:"buttons,omitempty"`
+	ReplyTo string   `json:"reply_to,omitempty"` // ID of the inbound message this is the agent's final reply to
+
+	// Metadata of the inbound message in ReplyTo, for channels that thread
+	// their replies.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Button is an inline action offered with an outbound message. Channels that
+// support buttons render them, and pressing one arrives as an inbound message
+// whose content is Command. Other channels ignore buttons, so the message
+// text should also say how to reply.
+type Button struct {
+	Label   string `json:"label"`
+	Command string `json:"command"`
 }
 
 type MessageHandler func(InboundMessage) error
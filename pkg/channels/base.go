@@ -17,6 +17,17 @@ type Channel interface {
 	IsAllowed(senderID string) bool
 }
 
+// MessageEditor is implemented by channels that can update a message after
+// sending it. The agent uses it to stream long replies into a single message.
+type MessageEditor interface {
+	// SendEditable sends content and returns an ID that can be passed to EditMessage.
+	SendEditable(ctx context.Context, chatID, content string) (string, error)
+	// EditMessage replaces the content of a message sent with SendEditable.
+	EditMessage(ctx context.Context, chatID, messageID, content string) error
+	// DeleteMessage removes a message sent with SendEditable.
+	DeleteMessage(ctx context.Context, chatID, messageID string) error
+}
+
 type BaseChannel struct {
 	config    interface{}
 	bus       *bus.MessageBus
@@ -83,6 +94,12 @@ func (c *BaseChannel) IsAllowed(senderID string) bool {
 }
 
 func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []string, metadata map[string]string) {
+	c.handleMessage("", senderID, chatID, content, media, metadata)
+}
+
+// handleMessage publishes the message with the given ID, or one assigned by
+// the bus if id is empty. The agent's final reply carries it in ReplyTo.
+func (c *BaseChannel) handleMessage(id, senderID, chatID, content string, media []string, metadata map[string]string) {
 	if !c.IsAllowed(senderID) {
 		return
 	}
@@ -91,6 +108,7 @@ func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []st
 	sessionKey := fmt.Sprintf("%s:%s", c.name, chatID)
 
 	msg := bus.InboundMessage{
+		ID:         id,
 		Channel:    c.name,
 		SenderID:   senderID,
 		ChatID:     chatID,
@@ -61,6 +61,7 @@ func (c *DiscordChannel) Start(ctx context.Context) error {
 
 	c.ctx = ctx
 	c.session.AddHandler(c.handleMessage)
+	c.session.AddHandler(c.handleInteraction)
 
 	if err := c.session.Open(); err != nil {
 		return fmt.Errorf("failed to open discord session: %w", err)
@@ -108,6 +109,16 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 
 	chunks := splitMessage(msg.Content, 1500) // Discord has a limit of 2000 characters per message, leave 500 for natural split e.g. code blocks
 
+	if len(msg.Buttons) > 0 {
+		last := len(chunks) - 1
+		for _, chunk := range chunks[:last] {
+			if err := c.sendChunk(ctx, channelID, chunk); err != nil {
+				return err
+			}
+		}
+		return c.sendWithButtons(ctx, channelID, chunks[last], msg.Buttons)
+	}
+
 	for _, chunk := range chunks {
 		if err := c.sendChunk(ctx, channelID, chunk); err != nil {
 			return err
@@ -117,6 +128,128 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 	return nil
 }
 
+// sendWithButtons posts content with a row of buttons whose custom IDs carry
+// the commands they send back.
+func (c *DiscordChannel) sendWithButtons(ctx context.Context, channelID, content string, buttons []bus.Button) error {
+	row := discordgo.ActionsRow{}
+	for i, b := range buttons {
+		style := discordgo.SecondaryButton
+		if i == 0 {
+			style = discordgo.PrimaryButton
+		}
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    b.Label,
+			Style:    style,
+			CustomID: b.Command,
+		})
+	}
+
+	_, err := c.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:    content,
+		Components: []discordgo.MessageComponent{row},
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to send discord message: %w", err)
+	}
+	return nil
+}
+
+// handleInteraction turns a button press into a message carrying the
+// button's command, and removes the buttons so they can't be pressed twice.
+func (c *DiscordChannel) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i == nil || i.Type != discordgo.InteractionMessageComponent {
+		return
+	}
+
+	user := i.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	}
+	if user == nil {
+		return
+	}
+
+	if !c.IsAllowed(user.ID) {
+		logger.DebugCF("discord", "Button press rejected by allowlist", map[string]any{
+			"user_id": user.ID,
+		})
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredMessageUpdate,
+		})
+		return
+	}
+
+	command := i.MessageComponentData().CustomID
+	response := &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate}
+	if i.Message != nil {
+		response = &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    appendContent(i.Message.Content, fmt.Sprintf("\n<@%s>: %s", user.ID, command)),
+				Components: []discordgo.MessageComponent{},
+			},
+		}
+	}
+	if err := s.InteractionRespond(i.Interaction, response); err != nil {
+		logger.ErrorCF("discord", "Failed to respond to interaction", map[string]any{
+			"error": err.Error(),
+		})
+	}
+
+	metadata := map[string]string{
+		"user_id":    user.ID,
+		"username":   user.Username,
+		"guild_id":   i.GuildID,
+		"channel_id": i.ChannelID,
+		"is_dm":      fmt.Sprintf("%t", i.GuildID == ""),
+		"is_button":  "true",
+	}
+
+	c.HandleMessage(user.ID, i.ChannelID, command, nil, metadata)
+}
+
+// SendEditable posts content as a message that can later be updated with EditMessage.
+func (c *DiscordChannel) SendEditable(ctx context.Context, chatID, content string) (string, error) {
+	if !c.IsRunning() {
+		return "", fmt.Errorf("discord bot not running")
+	}
+
+	sent, err := c.session.ChannelMessageSend(chatID, content, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to send discord message: %w", err)
+	}
+	return sent.ID, nil
+}
+
+// EditMessage replaces the content of a previously sent message. Content over
+// Discord's length limit is split: the first chunk replaces the message and
+// the rest is sent as follow-up messages.
+func (c *DiscordChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
+	chunks := splitMessage(content, 1500)
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	if _, err := c.session.ChannelMessageEdit(chatID, messageID, chunks[0], discordgo.WithContext(ctx)); err != nil {
+		return fmt.Errorf("failed to edit discord message: %w", err)
+	}
+
+	for _, chunk := range chunks[1:] {
+		if err := c.sendChunk(ctx, chatID, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMessage removes a previously sent message.
+func (c *DiscordChannel) DeleteMessage(ctx context.Context, chatID, messageID string) error {
+	if err := c.session.ChannelMessageDelete(chatID, messageID, discordgo.WithContext(ctx)); err != nil {
+		return fmt.Errorf("failed to delete discord message: %w", err)
+	}
+	return nil
+}
+
 // splitMessage splits long messages into chunks, preserving code block integrity
 // Uses natural boundaries (newlines, spaces) and extends messages slightly to avoid breaking code blocks
 func splitMessage(content string, limit int) []string {
@@ -1,25 +1,43 @@
 package channels
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
+var (
+	textSection       = &imap.FetchItemBodySection{Specifier: imap.PartSpecifierText}
+	referencesSection = &imap.FetchItemBodySection{Specifier: imap.PartSpecifierHeader, HeaderFields: []string{"References"}}
+)
+
 // EmailChannel polls an IMAP inbox and delivers new messages into the agent bus.
-// Outbound replies are a no-op — agents respond via their primary channel (e.g. Matrix).
+// The chat ID of a conversation is the sender's address. Replies go out over
+// SMTP when smtp_host is configured, threaded under the message they answer
+// using the headers carried in its metadata; without it Send is a no-op and
+// agents respond via their primary channel (e.g. Matrix).
 type EmailChannel struct {
 	*BaseChannel
 	emailConfig config.EmailConfig
@@ -71,14 +89,262 @@ func (c *EmailChannel) Stop(_ context.Context) error {
 	return nil
 }
 
-// Send is a no-op — agents receive emails and reply via their primary channel.
-func (c *EmailChannel) Send(_ context.Context, msg bus.OutboundMessage) error {
-	logger.WarnCF("email", "Outbound email not implemented — agent replies via primary channel", map[string]interface{}{
-		"chat_id": msg.ChatID,
+// Send mails msg to the address in its chat ID, if it may receive mail, see
+// canSendTo. It is a no-op when no SMTP server is configured.
+func (c *EmailChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if c.emailConfig.SMTPHost == "" {
+		logger.WarnCF("email", "Outbound email disabled (no smtp_host) — agent replies via primary channel", map[string]interface{}{
+			"chat_id": msg.ChatID,
+		})
+		return nil
+	}
+
+	to, err := mail.ParseAddress(msg.ChatID)
+	if err != nil {
+		return fmt.Errorf("email channel: invalid recipient %q: %w", msg.ChatID, err)
+	}
+	if !c.canSendTo(msg, to.Address) {
+		// Retrying would not help: drop it
+		logger.WarnCF("email", "Recipient not allowed, dropping outbound email", map[string]interface{}{
+			"to": to.Address,
+		})
+		return nil
+	}
+	from, err := mail.ParseAddress(c.fromAddress())
+	if err != nil {
+		return fmt.Errorf("email channel: invalid from address: %w", err)
+	}
+
+	data, err := c.buildMessage(msg, from, to)
+	if err != nil {
+		return fmt.Errorf("email channel: build message: %w", err)
+	}
+	if err := c.sendSMTP(ctx, from.Address, to.Address, data); err != nil {
+		return fmt.Errorf("email channel: %w", err)
+	}
+
+	logger.InfoCF("email", "Sent email", map[string]interface{}{
+		"to":          to.Address,
+		"attachments": len(msg.Media),
 	})
 	return nil
 }
 
+// canSendTo reports whether msg may be mailed to address: the sender of the
+// message it replies to, an address in allow_to, or one in allow_from.
+func (c *EmailChannel) canSendTo(msg bus.OutboundMessage, address string) bool {
+	if msg.ReplyTo != "" && strings.EqualFold(msg.Metadata["from"], address) {
+		return true
+	}
+	for _, allowed := range c.emailConfig.AllowTo {
+		if strings.EqualFold(strings.TrimSpace(allowed), address) {
+			return true
+		}
+	}
+	return len(c.emailConfig.AllowFrom) > 0 && c.IsAllowed(address)
+}
+
+func (c *EmailChannel) fromAddress() string {
+	if c.emailConfig.From != "" {
+		return c.emailConfig.From
+	}
+	return c.emailConfig.Username
+}
+
+// buildMessage renders msg as a multipart email: the Markdown content as
+// text/plain and text/html alternatives, followed by the media files as
+// attachments. A reply to an inbound email is threaded under it.
+func (c *EmailChannel) buildMessage(msg bus.OutboundMessage, from, to *mail.Address) ([]byte, error) {
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetAddressList("From", []*mail.Address{from})
+	h.SetAddressList("To", []*mail.Address{to})
+	if err := h.GenerateMessageIDWithHostname(domainOf(from.Address)); err != nil {
+		return nil, err
+	}
+
+	subject := "Message from PicoClaw"
+	if msg.ReplyTo != "" && msg.Metadata != nil {
+		subject = replySubject(msg.Metadata["subject"])
+		if messageID := msg.Metadata["message_id"]; messageID != "" {
+			h.SetMsgIDList("In-Reply-To", []string{messageID})
+			h.SetMsgIDList("References", append(strings.Fields(msg.Metadata["references"]), messageID))
+		}
+	}
+	h.SetSubject(subject)
+
+	var buf bytes.Buffer
+	mw, err := mail.CreateWriter(&buf, h)
+	if err != nil {
+		return nil, err
+	}
+
+	tw, err := mw.CreateInline()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeInlinePart(tw, "text/plain", msg.Content); err != nil {
+		return nil, err
+	}
+	html := "<html><body>" + markdownToMatrixHTML(msg.Content) + "</body></html>"
+	if err := writeInlinePart(tw, "text/html", html); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, path := range msg.Media {
+		if err := writeAttachment(mw, path); err != nil {
+			logger.WarnCF("email", "Skipping attachment", map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			})
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeInlinePart(tw *mail.InlineWriter, contentType, body string) error {
+	var h mail.InlineHeader
+	h.SetContentType(contentType, map[string]string{"charset": "utf-8"})
+	w, err := tw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writeAttachment attaches a local file. Remote URLs are not fetched.
+func writeAttachment(mw *mail.Writer, path string) error {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return fmt.Errorf("remote media is not supported")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	var h mail.AttachmentHeader
+	h.SetContentType(contentType, nil)
+	h.SetFilename(filepath.Base(path))
+
+	w, err := mw.CreateAttachment(h)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// replySubject prefixes subject with "Re: " unless it already has it.
+func replySubject(subject string) string {
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		return "Re: your message"
+	}
+	if strings.HasPrefix(strings.ToLower(subject), "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+func domainOf(address string) string {
+	if idx := strings.LastIndex(address, "@"); idx >= 0 {
+		return address[idx+1:]
+	}
+	return "localhost"
+}
+
+// sendSMTP delivers data over SMTP, using implicit TLS when smtp_tls is set
+// and upgrading with STARTTLS otherwise if the server offers it.
+func (c *EmailChannel) sendSMTP(ctx context.Context, from, to string, data []byte) error {
+	host := c.emailConfig.SMTPHost
+	addr := net.JoinHostPort(host, strconv.Itoa(c.emailConfig.SMTPPort))
+	tlsCfg := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.emailConfig.SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(2 * time.Minute)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if !c.emailConfig.SMTPTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsCfg); err != nil {
+				return fmt.Errorf("smtp starttls: %w", err)
+			}
+		}
+	}
+
+	username, password := c.emailConfig.SMTPUsername, c.emailConfig.SMTPPassword
+	if username == "" {
+		username, password = c.emailConfig.Username, c.emailConfig.Password
+	}
+	if ok, _ := client.Extension("AUTH"); ok && username != "" {
+		if err := client.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	return client.Quit()
+}
+
 // pollLoop runs at the configured interval and fetches UNSEEN messages.
 func (c *EmailChannel) pollLoop(ctx context.Context) {
 	interval := time.Duration(c.emailConfig.PollInterval) * time.Second
@@ -164,10 +430,8 @@ func (c *EmailChannel) fetchUnseen() {
 	seqSet := imap.SeqSetNum(seqNums...)
 
 	fetchOptions := &imap.FetchOptions{
-		Envelope: true,
-		BodySection: []*imap.FetchItemBodySection{
-			{Specifier: imap.PartSpecifierText},
-		},
+		Envelope:    true,
+		BodySection: []*imap.FetchItemBodySection{textSection, referencesSection},
 	}
 
 	messages, err := client.Fetch(seqSet, fetchOptions).Collect()
@@ -224,23 +488,47 @@ func (c *EmailChannel) processMessage(msg *imapclient.FetchMessageBuffer) {
 		chatID = "unknown-sender"
 	}
 
-	c.HandleMessage(senderEmail, chatID, content, nil, map[string]string{
-		"subject": subject,
-		"from":    senderEmail,
-	})
+	// The reply is threaded with these, see buildMessage
+	references := parseReferences(msg.FindBodySection(referencesSection))
+	metadata := map[string]string{
+		"subject":    subject,
+		"from":       senderEmail,
+		"message_id": env.MessageID,
+	}
+	if len(env.InReplyTo) > 0 {
+		metadata["in_reply_to"] = strings.Join(env.InReplyTo, " ")
+	}
+	if len(references) > 0 {
+		metadata["references"] = strings.Join(references, " ")
+	}
+
+	c.HandleMessage(senderEmail, chatID, content, nil, metadata)
+}
+
+// parseReferences returns the message IDs in a raw References header.
+func parseReferences(raw []byte) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	th, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil
+	}
+	h := mail.Header{Header: message.Header{Header: th}}
+	ids, err := h.MsgIDList("References")
+	if err != nil {
+		return nil
+	}
+	return ids
 }
 
 // extractBody returns the plaintext body from a buffered IMAP message.
 func (c *EmailChannel) extractBody(msg *imapclient.FetchMessageBuffer) string {
-	for _, section := range msg.BodySection {
-		if len(section.Bytes) == 0 {
-			continue
-		}
-
-		mr, err := mail.CreateReader(strings.NewReader(string(section.Bytes)))
+	if body := msg.FindBodySection(textSection); len(body) > 0 {
+		mr, err := mail.CreateReader(bytes.NewReader(body))
 		if err != nil {
 			// Not a MIME message — return raw bytes
-			return strings.TrimSpace(string(section.Bytes))
+			return strings.TrimSpace(string(body))
 		}
 
 		for {
@@ -0,0 +1,200 @@
+package channels
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newTestEmailChannel(t *testing.T) *EmailChannel {
+	t.Helper()
+	ch, err := NewEmailChannel(config.EmailConfig{
+		IMAPHost: "imap.example.com",
+		Username: "bot@example.com",
+		Password: "secret",
+		SMTPHost: "smtp.example.com",
+		SMTPPort: 587,
+	}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatalf("NewEmailChannel: %v", err)
+	}
+	return ch
+}
+
+func TestEmailBuildMessage_Reply(t *testing.T) {
+	ch := newTestEmailChannel(t)
+
+	dir := t.TempDir()
+	attachment := filepath.Join(dir, "report.txt")
+	if err := os.WriteFile(attachment, []byte("numbers"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	from, _ := mail.ParseAddress("bot@example.com")
+	to, _ := mail.ParseAddress("alice@example.com")
+	data, err := ch.buildMessage(bus.OutboundMessage{
+		Channel: "email",
+		ChatID:  "alice@example.com",
+		Content: "Here is the **summary**.",
+		Media:   []string{attachment},
+		ReplyTo: "in-1",
+		Metadata: map[string]string{
+			"from":       "alice@example.com",
+			"subject":    "Weekly report",
+			"message_id": "msg2@example.com",
+			"references": "msg1@example.com",
+		},
+	}, from, to)
+	if err != nil {
+		t.Fatalf("buildMessage: %v", err)
+	}
+
+	mr, err := mail.CreateReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("CreateReader: %v", err)
+	}
+
+	if subject, _ := mr.Header.Subject(); subject != "Re: Weekly report" {
+		t.Errorf("Subject = %q, want %q", subject, "Re: Weekly report")
+	}
+	if ids, _ := mr.Header.MsgIDList("In-Reply-To"); !reflect.DeepEqual(ids, []string{"msg2@example.com"}) {
+		t.Errorf("In-Reply-To = %v", ids)
+	}
+	if ids, _ := mr.Header.MsgIDList("References"); !reflect.DeepEqual(ids, []string{"msg1@example.com", "msg2@example.com"}) {
+		t.Errorf("References = %v", ids)
+	}
+	if id, _ := mr.Header.MessageID(); !strings.HasSuffix(id, "@example.com") {
+		t.Errorf("Message-ID = %q, want an example.com ID", id)
+	}
+
+	var plain, html, filename string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		body, _ := io.ReadAll(part.Body)
+		switch h := part.Header.(type) {
+		case *mail.InlineHeader:
+			ct, _, _ := h.ContentType()
+			if ct == "text/plain" {
+				plain = string(body)
+			} else if ct == "text/html" {
+				html = string(body)
+			}
+		case *mail.AttachmentHeader:
+			filename, _ = h.Filename()
+			if string(body) != "numbers" {
+				t.Errorf("attachment body = %q", body)
+			}
+		}
+	}
+
+	if plain != "Here is the **summary**." {
+		t.Errorf("text/plain = %q", plain)
+	}
+	if !strings.Contains(html, "<strong>summary</strong>") {
+		t.Errorf("text/html = %q, want rendered Markdown", html)
+	}
+	if filename != "report.txt" {
+		t.Errorf("attachment filename = %q, want report.txt", filename)
+	}
+}
+
+func TestEmailBuildMessage_NewConversation(t *testing.T) {
+	ch := newTestEmailChannel(t)
+
+	from, _ := mail.ParseAddress("PicoClaw <bot@example.com>")
+	to, _ := mail.ParseAddress("bob@example.com")
+	data, err := ch.buildMessage(bus.OutboundMessage{ChatID: "bob@example.com", Content: "Reminder"}, from, to)
+	if err != nil {
+		t.Fatalf("buildMessage: %v", err)
+	}
+
+	mr, err := mail.CreateReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("CreateReader: %v", err)
+	}
+	if v := mr.Header.Get("In-Reply-To"); v != "" {
+		t.Errorf("In-Reply-To = %q, want none", v)
+	}
+	if subject, _ := mr.Header.Subject(); subject != "Message from PicoClaw" {
+		t.Errorf("Subject = %q", subject)
+	}
+}
+
+func TestEmailCanSendTo(t *testing.T) {
+	ch, err := NewEmailChannel(config.EmailConfig{
+		IMAPHost:  "imap.example.com",
+		Username:  "bot@example.com",
+		Password:  "secret",
+		AllowFrom: config.FlexibleStringSlice{"you@example.com"},
+		AllowTo:   config.FlexibleStringSlice{"team@example.com"},
+	}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatalf("NewEmailChannel: %v", err)
+	}
+	reply := bus.OutboundMessage{ReplyTo: "in-1", Metadata: map[string]string{"from": "alice@example.com"}}
+
+	tests := []struct {
+		msg     bus.OutboundMessage
+		address string
+		want    bool
+	}{
+		{reply, "alice@example.com", true},
+		{reply, "mallory@example.com", false},
+		{bus.OutboundMessage{}, "alice@example.com", false},
+		{bus.OutboundMessage{}, "you@example.com", true},
+		{bus.OutboundMessage{}, "Team@Example.com", true},
+	}
+	for _, tt := range tests {
+		if got := ch.canSendTo(tt.msg, tt.address); got != tt.want {
+			t.Errorf("canSendTo(%+v, %q) = %v, want %v", tt.msg, tt.address, got, tt.want)
+		}
+	}
+
+	// Without allow_from anyone may write in, but mail only goes to them in reply
+	open := newTestEmailChannel(t)
+	if open.canSendTo(bus.OutboundMessage{}, "alice@example.com") {
+		t.Error("an open inbox should not mail arbitrary addresses")
+	}
+}
+
+func TestParseReferences(t *testing.T) {
+	raw := []byte("References: <a@example.com>\r\n <b@example.com>\r\n\r\n")
+	got := parseReferences(raw)
+	want := []string{"a@example.com", "b@example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseReferences = %v, want %v", got, want)
+	}
+	if got := parseReferences(nil); got != nil {
+		t.Errorf("parseReferences(nil) = %v, want nil", got)
+	}
+}
+
+func TestReplySubject(t *testing.T) {
+	tests := map[string]string{
+		"Hello":        "Re: Hello",
+		"Re: Hello":    "Re: Hello",
+		"RE: Hello":    "RE: Hello",
+		"  ":           "Re: your message",
+		"Invoice #123": "Re: Invoice #123",
+	}
+	for in, want := range tests {
+		if got := replySubject(in); got != want {
+			t.Errorf("replySubject(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
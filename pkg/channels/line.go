@@ -276,6 +276,14 @@ func (c *LINEChannel) processEvent(event lineEvent) {
 	chatID := c.resolveChatID(event.Source)
 	isGroup := event.Source.Type == "group" || event.Source.Type == "room"
 
+	// Check the allowlist before downloading any media for the sender
+	if !c.IsAllowed(senderID) {
+		logger.DebugCF("line", "Message rejected by allowlist", map[string]interface{}{
+			"user_id": senderID,
+		})
+		return
+	}
+
 	var msg lineMessage
 	if err := json.Unmarshal(event.Message, &msg); err != nil {
 		logger.ErrorCF("line", "Failed to parse message", map[string]interface{}{
@@ -378,6 +386,9 @@ func (c *LINEChannel) processEvent(event lineEvent) {
 	c.sendLoading(senderID)
 
 	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+
+	// Forwarded media now belongs to the agent, which removes it after the turn
+	localFiles = nil
 }
 
 // isBotMentioned checks if the bot is mentioned in the message.
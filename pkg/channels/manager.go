@@ -9,6 +9,7 @@ package channels
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
@@ -22,6 +23,8 @@ type Manager struct {
 	bus          *bus.MessageBus
 	config       *config.Config
 	dispatchTask *asyncTask
+	started      bool                  // Between StartAll and StopAll
+	setup        func(channel Channel) // Called for channels created by Reload
 	mu           sync.RWMutex
 }
 
@@ -43,162 +46,155 @@ func NewManager(cfg *config.Config, messageBus *bus.MessageBus) (*Manager, error
 	return m, nil
 }
 
-func (m *Manager) initChannels() error {
-	logger.InfoC("channels", "Initializing channel manager")
-
-	if m.config.Channels.Telegram.Enabled && m.config.Channels.Telegram.Token != "" {
-		logger.DebugC("channels", "Attempting to initialize Telegram channel")
-		telegram, err := NewTelegramChannel(m.config, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize Telegram channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["telegram"] = telegram
-			logger.InfoC("channels", "Telegram channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.WhatsApp.Enabled && m.config.Channels.WhatsApp.BridgeURL != "" {
-		logger.DebugC("channels", "Attempting to initialize WhatsApp channel")
-		whatsapp, err := NewWhatsAppChannel(m.config.Channels.WhatsApp, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize WhatsApp channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["whatsapp"] = whatsapp
-			logger.InfoC("channels", "WhatsApp channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.Feishu.Enabled {
-		logger.DebugC("channels", "Attempting to initialize Feishu channel")
-		feishu, err := NewFeishuChannel(m.config.Channels.Feishu, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize Feishu channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["feishu"] = feishu
-			logger.InfoC("channels", "Feishu channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.Discord.Enabled && m.config.Channels.Discord.Token != "" {
-		logger.DebugC("channels", "Attempting to initialize Discord channel")
-		discord, err := NewDiscordChannel(m.config.Channels.Discord, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize Discord channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["discord"] = discord
-			logger.InfoC("channels", "Discord channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.MaixCam.Enabled {
-		logger.DebugC("channels", "Attempting to initialize MaixCam channel")
-		maixcam, err := NewMaixCamChannel(m.config.Channels.MaixCam, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize MaixCam channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["maixcam"] = maixcam
-			logger.InfoC("channels", "MaixCam channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.QQ.Enabled {
-		logger.DebugC("channels", "Attempting to initialize QQ channel")
-		qq, err := NewQQChannel(m.config.Channels.QQ, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize QQ channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["qq"] = qq
-			logger.InfoC("channels", "QQ channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.DingTalk.Enabled && m.config.Channels.DingTalk.ClientID != "" {
-		logger.DebugC("channels", "Attempting to initialize DingTalk channel")
-		dingtalk, err := NewDingTalkChannel(m.config.Channels.DingTalk, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize DingTalk channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["dingtalk"] = dingtalk
-			logger.InfoC("channels", "DingTalk channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.Slack.Enabled && m.config.Channels.Slack.BotToken != "" {
-		logger.DebugC("channels", "Attempting to initialize Slack channel")
-		slackCh, err := NewSlackChannel(m.config.Channels.Slack, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize Slack channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["slack"] = slackCh
-			logger.InfoC("channels", "Slack channel enabled successfully")
-		}
-	}
-
-	if m.config.Channels.LINE.Enabled && m.config.Channels.LINE.ChannelAccessToken != "" {
-		logger.DebugC("channels", "Attempting to initialize LINE channel")
-		line, err := NewLINEChannel(m.config.Channels.LINE, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize LINE channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["line"] = line
-			logger.InfoC("channels", "LINE channel enabled successfully")
-		}
-	}
+// channelFactory creates one kind of channel from the config.
+type channelFactory struct {
+	name    string // Key in Manager.channels and in the channels config
+	label   string
+	enabled func(cfg *config.Config) bool
+	create  func(cfg *config.Config, bus *bus.MessageBus) (Channel, error)
+}
 
-	if m.config.Channels.OneBot.Enabled && m.config.Channels.OneBot.WSUrl != "" {
-		logger.DebugC("channels", "Attempting to initialize OneBot channel")
-		onebot, err := NewOneBotChannel(m.config.Channels.OneBot, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize OneBot channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["onebot"] = onebot
-			logger.InfoC("channels", "OneBot channel enabled successfully")
-		}
-	}
+// channelFactories lists every channel kind, in the order they are
+// initialized.
+var channelFactories = []channelFactory{
+	{
+		name:  "telegram",
+		label: "Telegram",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.Telegram.Enabled && cfg.Channels.Telegram.Token != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewTelegramChannel(cfg, bus)
+		},
+	},
+	{
+		name:  "whatsapp",
+		label: "WhatsApp",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.WhatsApp.Enabled && cfg.Channels.WhatsApp.BridgeURL != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewWhatsAppChannel(cfg.Channels.WhatsApp, bus)
+		},
+	},
+	{
+		name:  "feishu",
+		label: "Feishu",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.Feishu.Enabled
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewFeishuChannel(cfg.Channels.Feishu, bus)
+		},
+	},
+	{
+		name:  "discord",
+		label: "Discord",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.Discord.Enabled && cfg.Channels.Discord.Token != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewDiscordChannel(cfg.Channels.Discord, bus)
+		},
+	},
+	{
+		name:  "maixcam",
+		label: "MaixCam",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.MaixCam.Enabled
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewMaixCamChannel(cfg.Channels.MaixCam, bus)
+		},
+	},
+	{
+		name:  "qq",
+		label: "QQ",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.QQ.Enabled
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewQQChannel(cfg.Channels.QQ, bus)
+		},
+	},
+	{
+		name:  "dingtalk",
+		label: "DingTalk",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.DingTalk.Enabled && cfg.Channels.DingTalk.ClientID != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewDingTalkChannel(cfg.Channels.DingTalk, bus)
+		},
+	},
+	{
+		name:  "slack",
+		label: "Slack",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.Slack.Enabled && cfg.Channels.Slack.BotToken != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewSlackChannel(cfg.Channels.Slack, bus)
+		},
+	},
+	{
+		name:  "line",
+		label: "LINE",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.LINE.Enabled && cfg.Channels.LINE.ChannelAccessToken != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewLINEChannel(cfg.Channels.LINE, bus)
+		},
+	},
+	{
+		name:  "onebot",
+		label: "OneBot",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.OneBot.Enabled && cfg.Channels.OneBot.WSUrl != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewOneBotChannel(cfg.Channels.OneBot, bus)
+		},
+	},
+	{
+		name:  "matrix",
+		label: "Matrix",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.Matrix.Enabled && cfg.Channels.Matrix.AccessToken != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewMatrixChannel(cfg.Channels.Matrix, bus)
+		},
+	},
+	{
+		name:  "webhook",
+		label: "Webhook",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.Webhook.Enabled && cfg.Channels.Webhook.Secret != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewWebhookChannel(cfg.Channels.Webhook, bus)
+		},
+	},
+	{
+		name:  "email",
+		label: "Email",
+		enabled: func(cfg *config.Config) bool {
+			return cfg.Channels.Email.Enabled && cfg.Channels.Email.Username != ""
+		},
+		create: func(cfg *config.Config, bus *bus.MessageBus) (Channel, error) {
+			return NewEmailChannel(cfg.Channels.Email, bus)
+		},
+	},
+}
 
-	if m.config.Channels.Matrix.Enabled && m.config.Channels.Matrix.AccessToken != "" {
-		logger.DebugC("channels", "Attempting to initialize Matrix channel")
-		matrix, err := NewMatrixChannel(m.config.Channels.Matrix, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize Matrix channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["matrix"] = matrix
-			logger.InfoC("channels", "Matrix channel enabled successfully")
-		}
-	}
+func (m *Manager) initChannels() error {
+	logger.InfoC("channels", "Initializing channel manager")
 
-	if m.config.Channels.Email.Enabled && m.config.Channels.Email.Username != "" {
-		logger.DebugC("channels", "Attempting to initialize Email channel")
-		email, err := NewEmailChannel(m.config.Channels.Email, m.bus)
-		if err != nil {
-			logger.ErrorCF("channels", "Failed to initialize Email channel", map[string]interface{}{
-				"error": err.Error(),
-			})
-		} else {
-			m.channels["email"] = email
-			logger.InfoC("channels", "Email channel enabled successfully")
+	for _, factory := range channelFactories {
+		if channel := m.createChannel(factory, m.config); channel != nil {
+			m.channels[factory.name] = channel
 		}
 	}
 
@@ -209,10 +205,28 @@ func (m *Manager) initChannels() error {
 	return nil
 }
 
+// createChannel creates a channel if it is enabled in cfg, or returns nil.
+func (m *Manager) createChannel(factory channelFactory, cfg *config.Config) Channel {
+	if !factory.enabled(cfg) {
+		return nil
+	}
+	logger.DebugC("channels", "Attempting to initialize "+factory.label+" channel")
+	channel, err := factory.create(cfg, m.bus)
+	if err != nil {
+		logger.ErrorCF("channels", "Failed to initialize "+factory.label+" channel", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil
+	}
+	logger.InfoC("channels", factory.label+" channel enabled successfully")
+	return channel
+}
+
 func (m *Manager) StartAll(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.started = true
 	if len(m.channels) == 0 {
 		logger.WarnC("channels", "No channels enabled")
 		return nil
@@ -220,10 +234,7 @@ func (m *Manager) StartAll(ctx context.Context) error {
 
 	logger.InfoC("channels", "Starting all channels")
 
-	dispatchCtx, cancel := context.WithCancel(ctx)
-	m.dispatchTask = &asyncTask{cancel: cancel}
-
-	go m.dispatchOutbound(dispatchCtx)
+	m.startDispatch(ctx)
 
 	for name, channel := range m.channels {
 		logger.InfoCF("channels", "Starting channel", map[string]interface{}{
@@ -247,6 +258,7 @@ func (m *Manager) StopAll(ctx context.Context) error {
 
 	logger.InfoC("channels", "Stopping all channels")
 
+	m.started = false
 	if m.dispatchTask != nil {
 		m.dispatchTask.cancel()
 		m.dispatchTask = nil
@@ -268,6 +280,95 @@ func (m *Manager) StopAll(ctx context.Context) error {
 	return nil
 }
 
+// startDispatch starts delivering outbound messages. m.mu must be held.
+func (m *Manager) startDispatch(ctx context.Context) {
+	dispatchCtx, cancel := context.WithCancel(ctx)
+	m.dispatchTask = &asyncTask{cancel: cancel}
+
+	go m.dispatchOutbound(dispatchCtx)
+}
+
+// SetChannelSetup sets a function Reload calls for every channel it creates,
+// before the channel is started.
+func (m *Manager) SetChannelSetup(setup func(channel Channel)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setup = setup
+}
+
+// Reload applies a new config. Channels whose section changed are stopped
+// and, if they are still enabled, recreated and started again; all other
+// channels keep running. It returns the names of the channels it touched.
+//
+// The channels are swapped under the lock but stopped and started outside
+// it, so outbound messages to other channels keep flowing meanwhile.
+func (m *Manager) Reload(ctx context.Context, cfg *config.Config) []string {
+	type swap struct {
+		name     string
+		old, new Channel
+	}
+
+	m.mu.Lock()
+	changed := make(map[string]bool)
+	for _, section := range config.ChangedSections(m.config, cfg) {
+		if name, ok := strings.CutPrefix(section, "channels."); ok {
+			changed[name] = true
+		}
+	}
+	m.config = cfg
+
+	var reloaded []string
+	var swaps []swap
+	for _, factory := range channelFactories {
+		if !changed[factory.name] {
+			continue
+		}
+		reloaded = append(reloaded, factory.name)
+
+		sw := swap{name: factory.name, old: m.channels[factory.name]}
+		delete(m.channels, factory.name)
+		if sw.new = m.createChannel(factory, cfg); sw.new != nil {
+			if m.setup != nil {
+				m.setup(sw.new)
+			}
+			m.channels[factory.name] = sw.new
+		}
+		swaps = append(swaps, sw)
+	}
+	started := m.started
+	if started && m.dispatchTask == nil && len(m.channels) > 0 {
+		m.startDispatch(ctx)
+	}
+	m.mu.Unlock()
+
+	for _, sw := range swaps {
+		if sw.old != nil {
+			logger.InfoCF("channels", "Stopping channel", map[string]interface{}{
+				"channel": sw.name,
+			})
+			if err := sw.old.Stop(ctx); err != nil {
+				logger.ErrorCF("channels", "Error stopping channel", map[string]interface{}{
+					"channel": sw.name,
+					"error":   err.Error(),
+				})
+			}
+		}
+		if sw.new == nil || !started {
+			continue
+		}
+		logger.InfoCF("channels", "Starting channel", map[string]interface{}{
+			"channel": sw.name,
+		})
+		if err := sw.new.Start(ctx); err != nil {
+			logger.ErrorCF("channels", "Failed to start channel", map[string]interface{}{
+				"channel": sw.name,
+				"error":   err.Error(),
+			})
+		}
+	}
+	return reloaded
+}
+
 func (m *Manager) dispatchOutbound(ctx context.Context) {
 	logger.InfoC("channels", "Outbound dispatcher started")
 
@@ -284,6 +385,7 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 
 			// Silently skip internal channels
 			if constants.IsInternalChannel(msg.Channel) {
+				m.bus.Ack(msg.ID)
 				continue
 			}
 
@@ -295,15 +397,24 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 				logger.WarnCF("channels", "Unknown channel for outbound message", map[string]interface{}{
 					"channel": msg.Channel,
 				})
+				m.bus.Reject(msg.ID, fmt.Errorf("unknown channel %q", msg.Channel))
 				continue
 			}
 
+			// A failed send goes back to the bus, which redelivers it after a
+			// backoff without holding up this loop, and dead-letters it after
+			// the last attempt.
 			if err := channel.Send(ctx, msg); err != nil {
 				logger.ErrorCF("channels", "Error sending message to channel", map[string]interface{}{
 					"channel": msg.Channel,
 					"error":   err.Error(),
 				})
+				if ctx.Err() == nil {
+					m.bus.Nack(msg.ID, err)
+				}
+				continue
 			}
+			m.bus.Ack(msg.ID)
 		}
 	}
 }
@@ -315,6 +426,14 @@ func (m *Manager) GetChannel(name string) (Channel, bool) {
 	return channel, ok
 }
 
+// GetEditor returns the named channel if it supports editing sent messages.
+func (m *Manager) GetEditor(name string) (MessageEditor, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	editor, ok := m.channels[name].(MessageEditor)
+	return editor, ok
+}
+
 func (m *Manager) GetStatus() map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -0,0 +1,58 @@
+package channels
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestManagerReload(t *testing.T) {
+	mb := bus.NewMessageBus()
+	t.Cleanup(mb.Close)
+	ctx := context.Background()
+
+	cfg := config.DefaultConfig()
+	m, err := NewManager(cfg, mb)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	m.StartAll(ctx)
+	defer m.StopAll(ctx)
+
+	withWebhook := func(mode string) *config.Config {
+		next := config.DefaultConfig()
+		next.Channels.Webhook = config.WebhookConfig{
+			Enabled:     true,
+			Secret:      "s3cret",
+			WebhookHost: "127.0.0.1",
+			WebhookPath: "/hook",
+			Mode:        mode,
+		}
+		return next
+	}
+
+	if got := m.Reload(ctx, withWebhook("async")); len(got) != 1 || got[0] != "webhook" {
+		t.Fatalf("Reload enabling webhook = %v", got)
+	}
+	first, ok := m.GetChannel("webhook")
+	if !ok || !first.IsRunning() {
+		t.Fatal("webhook channel should be running after reload")
+	}
+
+	if got := m.Reload(ctx, withWebhook("async")); len(got) != 0 {
+		t.Errorf("Reload without changes = %v", got)
+	}
+
+	m.Reload(ctx, withWebhook("sync"))
+	second, _ := m.GetChannel("webhook")
+	if second == first || first.IsRunning() || !second.IsRunning() {
+		t.Error("a changed channel should be replaced by a new, running one")
+	}
+
+	m.Reload(ctx, config.DefaultConfig())
+	if _, ok := m.GetChannel("webhook"); ok || second.IsRunning() {
+		t.Error("a disabled channel should be stopped and removed")
+	}
+}
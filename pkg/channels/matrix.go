@@ -19,6 +19,7 @@ import (
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
@@ -329,6 +330,9 @@ func (c *MatrixChannel) handleMessage(ctx context.Context, evt *event.Event) {
 
 	// Handle the message through base channel
 	c.HandleMessage(senderID, roomID, messageText, mediaPaths, metadata)
+
+	// Forwarded media now belongs to the agent, which removes it after the turn
+	localFiles = nil
 }
 
 // ─── Send (outbound) ──────────────────────────────────────────────────────────
@@ -357,17 +361,7 @@ func (c *MatrixChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 
 	// 2. Send text content
 	if msg.Content != "" {
-		content := &event.MessageEventContent{
-			MsgType: event.MsgText,
-			Body:    msg.Content,
-		}
-
-		if hasMarkdown(msg.Content) {
-			content.Format = event.FormatHTML
-			content.FormattedBody = markdownToMatrixHTML(msg.Content)
-		}
-
-		_, err := c.client.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+		_, err := c.client.SendMessageEvent(ctx, roomID, event.EventMessage, matrixTextContent(msg.Content))
 		if err != nil {
 			return fmt.Errorf("failed to send matrix message: %w", err)
 		}
@@ -379,6 +373,57 @@ func (c *MatrixChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	return nil
 }
 
+// SendEditable posts content as a message that can later be updated with
+// EditMessage. The returned ID is the Matrix event ID.
+func (c *MatrixChannel) SendEditable(ctx context.Context, chatID, content string) (string, error) {
+	roomID := id.RoomID(chatID)
+
+	if _, active := c.typing.LoadAndDelete(chatID); active {
+		if _, err := c.client.UserTyping(ctx, roomID, false, 0); err != nil {
+			logger.WarnCF("matrix", "Failed to clear typing indicator", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	resp, err := c.client.SendMessageEvent(ctx, roomID, event.EventMessage, matrixTextContent(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to send matrix message: %w", err)
+	}
+	return resp.EventID.String(), nil
+}
+
+// EditMessage replaces a previously sent message using an m.replace relation.
+func (c *MatrixChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
+	edit := matrixTextContent(content)
+	edit.SetEdit(id.EventID(messageID))
+
+	if _, err := c.client.SendMessageEvent(ctx, id.RoomID(chatID), event.EventMessage, edit); err != nil {
+		return fmt.Errorf("failed to edit matrix message: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessage redacts a previously sent message.
+func (c *MatrixChannel) DeleteMessage(ctx context.Context, chatID, messageID string) error {
+	if _, err := c.client.RedactEvent(ctx, id.RoomID(chatID), id.EventID(messageID)); err != nil {
+		return fmt.Errorf("failed to redact matrix message: %w", err)
+	}
+	return nil
+}
+
+func matrixTextContent(text string) *event.MessageEventContent {
+	content := &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	}
+	if hasMarkdown(text) {
+		content.Format = event.FormatHTML
+		content.FormattedBody = markdownToMatrixHTML(text)
+	}
+	return content
+}
+
 // ─── Media upload helpers ─────────────────────────────────────────────────────
 
 // sendMediaFile uploads a local file to the Matrix content repository and sends
@@ -604,7 +649,13 @@ func (c *MatrixChannel) downloadMedia(ctx context.Context, mxcURL id.ContentURIS
 	}
 
 	// Write to temp file
-	tempFile, err := os.CreateTemp("", "matrix-media-*"+ext)
+	if err := os.MkdirAll(utils.MediaDir(), 0700); err != nil {
+		logger.ErrorCF("matrix", "Failed to create media directory", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return ""
+	}
+	tempFile, err := os.CreateTemp(utils.MediaDir(), "matrix-media-*"+ext)
 	if err != nil {
 		logger.ErrorCF("matrix", "Failed to create temp file", map[string]interface{}{
 			"error": err.Error(),
@@ -125,6 +125,10 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		opts = append(opts, slack.MsgOptionTS(threadTS))
 	}
 
+	if len(msg.Buttons) > 0 {
+		opts = append(opts, slack.MsgOptionBlocks(slackButtonBlocks(msg)...))
+	}
+
 	_, _, err := c.api.PostMessageContext(ctx, channelID, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to send slack message: %w", err)
@@ -146,6 +150,58 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	return nil
 }
 
+// SendEditable posts content as a message that can later be updated with
+// EditMessage. The returned ID is the Slack message timestamp.
+func (c *SlackChannel) SendEditable(ctx context.Context, chatID, content string) (string, error) {
+	if !c.IsRunning() {
+		return "", fmt.Errorf("slack channel not running")
+	}
+
+	channelID, threadTS := parseSlackChatID(chatID)
+	if channelID == "" {
+		return "", fmt.Errorf("invalid slack chat ID: %s", chatID)
+	}
+
+	opts := []slack.MsgOption{
+		slack.MsgOptionText(content, false),
+	}
+	if threadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTS))
+	}
+
+	_, ts, err := c.api.PostMessageContext(ctx, channelID, opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to send slack message: %w", err)
+	}
+	return ts, nil
+}
+
+// EditMessage replaces the text of a previously sent message.
+func (c *SlackChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
+	channelID, _ := parseSlackChatID(chatID)
+	if channelID == "" {
+		return fmt.Errorf("invalid slack chat ID: %s", chatID)
+	}
+
+	if _, _, _, err := c.api.UpdateMessageContext(ctx, channelID, messageID, slack.MsgOptionText(content, false)); err != nil {
+		return fmt.Errorf("failed to update slack message: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessage removes a previously sent message.
+func (c *SlackChannel) DeleteMessage(ctx context.Context, chatID, messageID string) error {
+	channelID, _ := parseSlackChatID(chatID)
+	if channelID == "" {
+		return fmt.Errorf("invalid slack chat ID: %s", chatID)
+	}
+
+	if _, _, err := c.api.DeleteMessageContext(ctx, channelID, messageID); err != nil {
+		return fmt.Errorf("failed to delete slack message: %w", err)
+	}
+	return nil
+}
+
 func (c *SlackChannel) eventLoop() {
 	for {
 		select {
@@ -161,9 +217,7 @@ func (c *SlackChannel) eventLoop() {
 			case socketmode.EventTypeSlashCommand:
 				c.handleSlashCommand(event)
 			case socketmode.EventTypeInteractive:
-				if event.Request != nil {
-					c.socketClient.Ack(*event.Request)
-				}
+				c.handleInteractive(event)
 			}
 		}
 	}
@@ -289,6 +343,9 @@ func (c *SlackChannel) handleMessageEvent(ev *slackevents.MessageEvent) {
 	})
 
 	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+
+	// Forwarded media now belongs to the agent, which removes it after the turn
+	localFiles = nil
 }
 
 func (c *SlackChannel) handleAppMention(ev *slackevents.AppMentionEvent) {
@@ -384,6 +441,77 @@ func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
 	c.HandleMessage(senderID, chatID, content, nil, metadata)
 }
 
+// handleInteractive turns a press of one of our message buttons into a
+// message carrying the button's command.
+func (c *SlackChannel) handleInteractive(event socketmode.Event) {
+	if event.Request != nil {
+		c.socketClient.Ack(*event.Request)
+	}
+
+	callback, ok := event.Data.(slack.InteractionCallback)
+	if !ok || callback.Type != slack.InteractionTypeBlockActions {
+		return
+	}
+	var content string
+	for _, action := range callback.ActionCallback.BlockActions {
+		if action.BlockID == slackButtonsBlockID && action.Value != "" {
+			content = action.Value
+			break
+		}
+	}
+	if content == "" {
+		return
+	}
+
+	if !c.IsAllowed(callback.User.ID) {
+		logger.DebugCF("slack", "Button press rejected by allowlist", map[string]interface{}{
+			"user_id": callback.User.ID,
+		})
+		return
+	}
+
+	channelID := callback.Channel.ID
+	if channelID == "" {
+		channelID = callback.Container.ChannelID
+	}
+	chatID := channelID
+	if threadTS := callback.Container.ThreadTs; threadTS != "" {
+		chatID = channelID + "/" + threadTS
+	} else if threadTS := callback.Message.ThreadTimestamp; threadTS != "" {
+		chatID = channelID + "/" + threadTS
+	}
+
+	// Replace the buttons with the choice so they can't be pressed twice.
+	if messageTS := callback.Container.MessageTs; messageTS != "" {
+		c.api.UpdateMessage(channelID, messageTS,
+			slack.MsgOptionText(fmt.Sprintf("%s\n\n<@%s>: %s", callback.Message.Text, callback.User.ID, content), false),
+			slack.MsgOptionBlocks())
+	}
+
+	metadata := map[string]string{
+		"channel_id": channelID,
+		"platform":   "slack",
+		"is_button":  "true",
+	}
+
+	c.HandleMessage(callback.User.ID, chatID, content, nil, metadata)
+}
+
+const slackButtonsBlockID = "picoclaw_buttons"
+
+func slackButtonBlocks(msg bus.OutboundMessage) []slack.Block {
+	elements := make([]slack.BlockElement, 0, len(msg.Buttons))
+	for i, b := range msg.Buttons {
+		elements = append(elements, slack.NewButtonBlockElement(
+			fmt.Sprintf("button_%d", i), b.Command,
+			slack.NewTextBlockObject(slack.PlainTextType, b.Label, false, false)))
+	}
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, msg.Content, false, false), nil, nil),
+		slack.NewActionBlock(slackButtonsBlockID, elements...),
+	}
+}
+
 func (c *SlackChannel) downloadSlackFile(file slack.File) string {
 	downloadURL := file.URLPrivateDownload
 	if downloadURL == "" {
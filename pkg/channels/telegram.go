@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -119,6 +120,10 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 		return c.handleMessage(ctx, &message)
 	}, th.AnyMessage())
 
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		return c.handleCallbackQuery(ctx, query)
+	}, th.AnyCallbackQueryWithMessage())
+
 	c.setRunning(true)
 	logger.InfoCF("telegram", "Telegram bot connected", map[string]interface{}{
 		"username": c.bot.Username(),
@@ -149,14 +154,12 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		return fmt.Errorf("invalid chat ID: %w", err)
 	}
 
-	// Stop thinking animation
-	if stop, ok := c.stopThinking.Load(msg.ChatID); ok {
-		if cf, ok := stop.(*thinkingCancel); ok && cf != nil {
-			cf.Cancel()
-		}
-		c.stopThinking.Delete(msg.ChatID)
+	if len(msg.Buttons) > 0 {
+		return c.sendWithButtons(ctx, chatID, msg)
 	}
 
+	c.stopThinkingAnimation(msg.ChatID)
+
 	htmlContent := markdownToTelegramHTML(msg.Content)
 
 	// Try to edit placeholder
@@ -186,6 +189,144 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	return nil
 }
 
+// sendWithButtons posts a new message with an inline keyboard. The
+// "Thinking..." placeholder is left alone: the turn is still running and its
+// reply will replace it.
+func (c *TelegramChannel) sendWithButtons(ctx context.Context, chatID int64, msg bus.OutboundMessage) error {
+	row := make([]telego.InlineKeyboardButton, 0, len(msg.Buttons))
+	for _, b := range msg.Buttons {
+		row = append(row, tu.InlineKeyboardButton(b.Label).WithCallbackData(b.Command))
+	}
+
+	tgMsg := tu.Message(tu.ID(chatID), markdownToTelegramHTML(msg.Content)).
+		WithReplyMarkup(tu.InlineKeyboard(row))
+	tgMsg.ParseMode = telego.ModeHTML
+
+	if _, err := c.bot.SendMessage(ctx, tgMsg); err != nil {
+		tgMsg.Text = msg.Content
+		tgMsg.ParseMode = ""
+		_, err = c.bot.SendMessage(ctx, tgMsg)
+		return err
+	}
+	return nil
+}
+
+// handleCallbackQuery turns a button press into a message carrying the
+// button's command, then removes the keyboard so it can't be pressed twice.
+func (c *TelegramChannel) handleCallbackQuery(ctx context.Context, query telego.CallbackQuery) error {
+	if err := c.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID)); err != nil {
+		logger.DebugCF("telegram", "Failed to answer callback query", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	senderID := fmt.Sprintf("%d", query.From.ID)
+	if query.From.Username != "" {
+		senderID = fmt.Sprintf("%d|%s", query.From.ID, query.From.Username)
+	}
+	if !c.IsAllowed(senderID) {
+		logger.DebugCF("telegram", "Callback query rejected by allowlist", map[string]interface{}{
+			"user_id": senderID,
+		})
+		return nil
+	}
+
+	chat := query.Message.GetChat()
+	if _, err := c.bot.EditMessageReplyMarkup(ctx, &telego.EditMessageReplyMarkupParams{
+		ChatID:    tu.ID(chat.ID),
+		MessageID: query.Message.GetMessageID(),
+	}); err != nil {
+		logger.DebugCF("telegram", "Failed to remove inline keyboard", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	metadata := map[string]string{
+		"user_id":     fmt.Sprintf("%d", query.From.ID),
+		"username":    query.From.Username,
+		"first_name":  query.From.FirstName,
+		"is_group":    fmt.Sprintf("%t", chat.Type != "private"),
+		"callback_id": query.ID,
+	}
+	c.HandleMessage(fmt.Sprintf("%d", query.From.ID), fmt.Sprintf("%d", chat.ID), query.Data, nil, metadata)
+	return nil
+}
+
+// SendEditable posts content as a message that can later be updated with
+// EditMessage, reusing the "Thinking..." placeholder if one is pending.
+func (c *TelegramChannel) SendEditable(ctx context.Context, chatID, content string) (string, error) {
+	if !c.IsRunning() {
+		return "", fmt.Errorf("telegram bot not running")
+	}
+
+	cid, err := parseChatID(chatID)
+	if err != nil {
+		return "", fmt.Errorf("invalid chat ID: %w", err)
+	}
+
+	c.stopThinkingAnimation(chatID)
+
+	if pID, ok := c.placeholders.LoadAndDelete(chatID); ok {
+		messageID := strconv.Itoa(pID.(int))
+		if err := c.EditMessage(ctx, chatID, messageID, content); err == nil {
+			return messageID, nil
+		}
+	}
+
+	sent, err := c.bot.SendMessage(ctx, tu.Message(tu.ID(cid), content))
+	if err != nil {
+		return "", fmt.Errorf("failed to send telegram message: %w", err)
+	}
+	return strconv.Itoa(sent.MessageID), nil
+}
+
+// EditMessage replaces the text of a previously sent message.
+func (c *TelegramChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
+	cid, err := parseChatID(chatID)
+	if err != nil {
+		return fmt.Errorf("invalid chat ID: %w", err)
+	}
+	mid, err := strconv.Atoi(messageID)
+	if err != nil {
+		return fmt.Errorf("invalid message ID: %w", err)
+	}
+
+	editMsg := tu.EditMessageText(tu.ID(cid), mid, markdownToTelegramHTML(content))
+	editMsg.ParseMode = telego.ModeHTML
+	if _, err := c.bot.EditMessageText(ctx, editMsg); err != nil {
+		// Half-streamed markdown can produce HTML Telegram rejects; retry as plain text
+		if _, err := c.bot.EditMessageText(ctx, tu.EditMessageText(tu.ID(cid), mid, content)); err != nil {
+			return fmt.Errorf("failed to edit telegram message: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeleteMessage removes a previously sent message.
+func (c *TelegramChannel) DeleteMessage(ctx context.Context, chatID, messageID string) error {
+	cid, err := parseChatID(chatID)
+	if err != nil {
+		return fmt.Errorf("invalid chat ID: %w", err)
+	}
+	mid, err := strconv.Atoi(messageID)
+	if err != nil {
+		return fmt.Errorf("invalid message ID: %w", err)
+	}
+
+	if err := c.bot.DeleteMessage(ctx, &telego.DeleteMessageParams{ChatID: tu.ID(cid), MessageID: mid}); err != nil {
+		return fmt.Errorf("failed to delete telegram message: %w", err)
+	}
+	return nil
+}
+
+func (c *TelegramChannel) stopThinkingAnimation(chatID string) {
+	if stop, ok := c.stopThinking.LoadAndDelete(chatID); ok {
+		if cf, ok := stop.(*thinkingCancel); ok && cf != nil {
+			cf.Cancel()
+		}
+	}
+}
+
 func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Message) error {
 	if message == nil {
 		return fmt.Errorf("message is nil")
@@ -356,6 +497,9 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Mes
 	}
 
 	c.HandleMessage(fmt.Sprintf("%d", user.ID), fmt.Sprintf("%d", chatID), content, mediaPaths, metadata)
+
+	// Forwarded media now belongs to the agent, which removes it after the turn
+	localFiles = nil
 	return nil
 }
 
@@ -40,6 +40,10 @@ func (c *cmd) Help(ctx context.Context, message telego.Message) error {
 /help - Show this help message
 /show [model|channel] - Show current configuration
 /list [models|channels] - List available options
+/usage - Show token usage and cost for today
+/history [count] - Show the last messages of this chat
+/summary - Show the summary of earlier messages
+/reset - Clear this chat's history
 	`
 	_, err := c.bot.SendMessage(ctx, &telego.SendMessageParams{
 		ChatID: telego.ChatID{ID: message.Chat.ID},
@@ -0,0 +1,367 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const webhookMaxBodyBytes = 1 << 20
+
+// WebhookChannel accepts signed JSON POSTs from arbitrary systems (home
+// automation, CI, internal tools). Sender, chat and content are read from
+// configurable fields of the body.
+//
+// In sync mode the HTTP request is held open until the agent's final reply to
+// it, which is the response body. In async mode the request is accepted at
+// once and replies are POSTed, signed the same way, to the request's callback
+// URL or the configured one. Other messages to a chat, such as approval
+// prompts or tool output, always go to the callback URL.
+type WebhookChannel struct {
+	*BaseChannel
+	config     config.WebhookConfig
+	httpServer *http.Server
+	client     *http.Client
+
+	mu        sync.Mutex
+	waiters   map[string]chan string // inbound message ID -> pending sync request
+	callbacks map[string]string      // chat ID -> callback URL from its last request
+}
+
+// NewWebhookChannel creates a new webhook channel instance.
+func NewWebhookChannel(cfg config.WebhookConfig, messageBus *bus.MessageBus) (*WebhookChannel, error) {
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("webhook secret is required")
+	}
+	if cfg.Mode != "" && cfg.Mode != "sync" && cfg.Mode != "async" {
+		return nil, fmt.Errorf("webhook mode must be \"sync\" or \"async\", got %q", cfg.Mode)
+	}
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = "X-PicoClaw-Signature"
+	}
+	if cfg.WebhookPath == "" {
+		cfg.WebhookPath = "/webhook"
+	}
+	if cfg.SenderField == "" {
+		cfg.SenderField = "sender"
+	}
+	if cfg.ChatField == "" {
+		cfg.ChatField = "chat_id"
+	}
+	if cfg.ContentField == "" {
+		cfg.ContentField = "content"
+	}
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = 120
+	}
+
+	base := NewBaseChannel("webhook", cfg, messageBus, cfg.AllowFrom)
+
+	return &WebhookChannel{
+		BaseChannel: base,
+		config:      cfg,
+		client:      &http.Client{Timeout: 15 * time.Second},
+		waiters:     make(map[string]chan string),
+		callbacks:   make(map[string]string),
+	}, nil
+}
+
+// Start launches the HTTP server.
+func (c *WebhookChannel) Start(ctx context.Context) error {
+	logger.InfoC("webhook", "Starting webhook channel")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(c.config.WebhookPath, c.webhookHandler)
+
+	addr := fmt.Sprintf("%s:%d", c.config.WebhookHost, c.config.WebhookPort)
+	c.httpServer = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		logger.InfoCF("webhook", "Webhook server listening", map[string]interface{}{
+			"addr": addr,
+			"path": c.config.WebhookPath,
+			"mode": c.defaultMode(),
+		})
+		if err := c.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.ErrorCF("webhook", "Webhook server error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	c.setRunning(true)
+	return nil
+}
+
+// Stop gracefully shuts down the HTTP server.
+func (c *WebhookChannel) Stop(ctx context.Context) error {
+	logger.InfoC("webhook", "Stopping webhook channel")
+
+	if c.httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := c.httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.ErrorCF("webhook", "Webhook server shutdown error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	c.setRunning(false)
+	return nil
+}
+
+// Send answers the sync request msg is the final reply to, or POSTs msg to
+// the chat's callback URL.
+func (c *WebhookChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	c.mu.Lock()
+	waiter, waiting := c.waiters[msg.ReplyTo]
+	if waiting {
+		delete(c.waiters, msg.ReplyTo)
+	}
+	callbackURL := c.callbacks[msg.ChatID]
+	c.mu.Unlock()
+
+	if waiting {
+		waiter <- msg.Content
+		return nil
+	}
+
+	if callbackURL == "" {
+		callbackURL = c.config.CallbackURL
+	}
+	if callbackURL == "" {
+		logger.WarnCF("webhook", "No callback URL for reply, dropping it", map[string]interface{}{
+			"chat_id": msg.ChatID,
+		})
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"chat_id": msg.ChatID,
+		"content": msg.Content,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook callback: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(c.config.SignatureHeader, "sha256="+c.sign(body))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook callback: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook callback returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	logger.DebugCF("webhook", "Reply delivered to callback", map[string]interface{}{
+		"chat_id": msg.ChatID,
+	})
+	return nil
+}
+
+// webhookHandler handles incoming webhook requests. The mode can be chosen
+// per request with ?mode=sync or ?mode=async.
+func (c *WebhookChannel) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBodyBytes))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if !c.verifySignature(body, r.Header.Get(c.config.SignatureHeader)) {
+		logger.WarnC("webhook", "Invalid webhook signature")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	payload, err := parseWebhookPayload(body)
+	if err != nil {
+		http.Error(w, "Bad request: body must be a JSON object", http.StatusBadRequest)
+		return
+	}
+
+	content, _ := lookupField(payload, c.config.ContentField)
+	if strings.TrimSpace(content) == "" {
+		http.Error(w, fmt.Sprintf("Bad request: missing %q", c.config.ContentField), http.StatusBadRequest)
+		return
+	}
+	senderID, ok := lookupField(payload, c.config.SenderField)
+	if !ok || senderID == "" {
+		senderID = "webhook"
+	}
+	chatID, ok := lookupField(payload, c.config.ChatField)
+	if !ok || chatID == "" {
+		chatID = senderID
+	}
+
+	if !c.IsAllowed(senderID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = c.defaultMode()
+	}
+
+	if c.config.CallbackField != "" {
+		if url, ok := lookupField(payload, c.config.CallbackField); ok && url != "" {
+			c.mu.Lock()
+			c.callbacks[chatID] = url
+			c.mu.Unlock()
+		}
+	}
+
+	metadata := map[string]string{"mode": mode}
+
+	if mode != "sync" {
+		c.HandleMessage(senderID, chatID, content, nil, metadata)
+		writeWebhookJSON(w, http.StatusAccepted, map[string]string{"status": "accepted", "chat_id": chatID})
+		return
+	}
+
+	id := newWebhookRequestID()
+	reply := make(chan string, 1)
+	c.mu.Lock()
+	c.waiters[id] = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.waiters, id)
+		c.mu.Unlock()
+	}()
+
+	c.handleMessage(id, senderID, chatID, content, nil, metadata)
+
+	timer := time.NewTimer(time.Duration(c.config.SyncTimeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case content := <-reply:
+		writeWebhookJSON(w, http.StatusOK, map[string]string{"chat_id": chatID, "content": content})
+	case <-timer.C:
+		// The reply will go to the callback URL, if there is one.
+		http.Error(w, "Timed out waiting for the agent", http.StatusGatewayTimeout)
+	case <-r.Context().Done():
+	}
+}
+
+// newWebhookRequestID returns the ID of the inbound message of a sync
+// request, which the agent's reply refers to.
+func newWebhookRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "webhook-" + hex.EncodeToString(b)
+}
+
+func (c *WebhookChannel) defaultMode() string {
+	if c.config.Mode == "" {
+		return "async"
+	}
+	return c.config.Mode
+}
+
+// verifySignature validates the hex HMAC-SHA256 of the body, with or
+// without a "sha256=" prefix.
+func (c *WebhookChannel) verifySignature(body []byte, signature string) bool {
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	if signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(c.sign(body)), []byte(strings.ToLower(signature)))
+}
+
+func (c *WebhookChannel) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(c.config.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseWebhookPayload(body []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var payload map[string]interface{}
+	if err := dec.Decode(&payload); err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	return payload, nil
+}
+
+// lookupField returns the value at a dotted path such as "event.user.id" as
+// a string. Numbers and booleans are formatted; objects and arrays are
+// returned as JSON.
+func lookupField(payload map[string]interface{}, path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	var value interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = obj[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+}
+
+func writeWebhookJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// compile-time interface check
+var _ Channel = (*WebhookChannel)(nil)
@@ -0,0 +1,215 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newTestWebhookChannel(t *testing.T, cfg config.WebhookConfig) (*WebhookChannel, *bus.MessageBus) {
+	t.Helper()
+	cfg.Secret = "s3cret"
+	mb := bus.NewMessageBus()
+	t.Cleanup(mb.Close)
+	ch, err := NewWebhookChannel(cfg, mb)
+	if err != nil {
+		t.Fatalf("NewWebhookChannel: %v", err)
+	}
+	return ch, mb
+}
+
+func postWebhook(ch *WebhookChannel, target, body, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if signature != "" {
+		req.Header.Set(ch.config.SignatureHeader, signature)
+	}
+	rec := httptest.NewRecorder()
+	ch.webhookHandler(rec, req)
+	return rec
+}
+
+func TestWebhookVerifySignature(t *testing.T) {
+	ch, _ := newTestWebhookChannel(t, config.WebhookConfig{})
+	body := []byte(`{"content":"hi"}`)
+	sig := ch.sign(body)
+
+	if !ch.verifySignature(body, sig) {
+		t.Error("bare hex signature rejected")
+	}
+	if !ch.verifySignature(body, "sha256="+strings.ToUpper(sig)) {
+		t.Error("prefixed upper-case signature rejected")
+	}
+	if ch.verifySignature([]byte(`{"content":"tampered"}`), sig) {
+		t.Error("signature accepted for a different body")
+	}
+	if ch.verifySignature(body, "") {
+		t.Error("empty signature accepted")
+	}
+}
+
+func TestWebhookHandler_RejectsBadSignature(t *testing.T) {
+	ch, mb := newTestWebhookChannel(t, config.WebhookConfig{})
+	if rec := postWebhook(ch, "/webhook", `{"content":"hi"}`, "sha256=00"); rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+	if got := mb.Unacked(); got != 0 {
+		t.Errorf("%d messages published for a forged request", got)
+	}
+}
+
+func TestWebhookHandler_FieldMapping(t *testing.T) {
+	ch, mb := newTestWebhookChannel(t, config.WebhookConfig{
+		SenderField:  "event.user.id",
+		ChatField:    "event.room",
+		ContentField: "event.text",
+	})
+	body := `{"event":{"user":{"id":42},"room":"kitchen","text":"lights off"}}`
+	rec := postWebhook(ch, "/webhook", body, "sha256="+ch.sign([]byte(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.Channel != "webhook" || msg.SenderID != "42" || msg.ChatID != "kitchen" || msg.Content != "lights off" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+	if msg.Metadata["mode"] != "async" {
+		t.Errorf("mode = %q, want async", msg.Metadata["mode"])
+	}
+}
+
+func TestWebhookHandler_MissingContent(t *testing.T) {
+	ch, _ := newTestWebhookChannel(t, config.WebhookConfig{})
+	body := `{"sender":"ci"}`
+	if rec := postWebhook(ch, "/webhook", body, ch.sign([]byte(body))); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestWebhookHandler_SyncRoundTrip(t *testing.T) {
+	ch, mb := newTestWebhookChannel(t, config.WebhookConfig{})
+
+	// Play the agent: answer the first inbound message.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		msg, ok := mb.ConsumeInbound(ctx)
+		if !ok {
+			return
+		}
+		// An approval prompt or tool output is not the reply
+		ch.Send(ctx, bus.OutboundMessage{Channel: "webhook", ChatID: msg.ChatID, Content: "Approve?"})
+		ch.Send(ctx, bus.OutboundMessage{Channel: "webhook", ChatID: msg.ChatID, Content: "echo: " + msg.Content, ReplyTo: msg.ID})
+	}()
+
+	body := `{"sender":"ci","chat_id":"build-7","content":"status?"}`
+	rec := postWebhook(ch, "/webhook?mode=sync", body, ch.sign([]byte(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["chat_id"] != "build-7" || resp["content"] != "echo: status?" {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestWebhookHandler_ConcurrentSyncRequests(t *testing.T) {
+	ch, mb := newTestWebhookChannel(t, config.WebhookConfig{})
+
+	// Play the agent: answer both requests, the second one first.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		var msgs []bus.InboundMessage
+		for len(msgs) < 2 {
+			msg, ok := mb.ConsumeInbound(ctx)
+			if !ok {
+				return
+			}
+			msgs = append(msgs, msg)
+		}
+		for i := len(msgs) - 1; i >= 0; i-- {
+			ch.Send(ctx, bus.OutboundMessage{Channel: "webhook", ChatID: msgs[i].ChatID, Content: "echo: " + msgs[i].Content, ReplyTo: msgs[i].ID})
+		}
+	}()
+
+	results := make(chan string, 2)
+	for _, content := range []string{"one", "two"} {
+		go func(content string) {
+			body := `{"sender":"ci","chat_id":"build-7","content":"` + content + `"}`
+			rec := postWebhook(ch, "/webhook?mode=sync", body, ch.sign([]byte(body)))
+			var resp map[string]string
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			results <- content + "=" + resp["content"]
+		}(content)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-results:
+			if got != "one=echo: one" && got != "two=echo: two" {
+				t.Errorf("request got another request's reply: %s", got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("sync request not answered")
+		}
+	}
+}
+
+func TestWebhookSend_Callback(t *testing.T) {
+	var gotBody []byte
+	var gotSig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSig = r.Header.Get("X-PicoClaw-Signature")
+	}))
+	defer srv.Close()
+
+	ch, _ := newTestWebhookChannel(t, config.WebhookConfig{CallbackField: "callback_url"})
+	body := `{"chat_id":"c1","content":"hi","callback_url":"` + srv.URL + `"}`
+	if rec := postWebhook(ch, "/webhook", body, ch.sign([]byte(body))); rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	if err := ch.Send(context.Background(), bus.OutboundMessage{Channel: "webhook", ChatID: "c1", Content: "hello back"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !ch.verifySignature(gotBody, gotSig) {
+		t.Errorf("callback signature %q does not match body %s", gotSig, gotBody)
+	}
+	var reply map[string]string
+	if err := json.Unmarshal(gotBody, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply["chat_id"] != "c1" || reply["content"] != "hello back" {
+		t.Errorf("unexpected callback body: %s", gotBody)
+	}
+}
+
+func TestWebhookSend_CallbackErrorIsReturned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ch, _ := newTestWebhookChannel(t, config.WebhookConfig{CallbackURL: srv.URL})
+	err := ch.Send(context.Background(), bus.OutboundMessage{Channel: "webhook", ChatID: "c1", Content: "x"})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Send error = %v, want a 503 error so the bus retries", err)
+	}
+}
@@ -44,28 +44,109 @@ func (f *FlexibleStringSlice) UnmarshalJSON(data []byte) error {
 }
 
 type Config struct {
-	Agents    AgentsConfig    `json:"agents"`
-	Channels  ChannelsConfig  `json:"channels"`
-	Providers ProvidersConfig `json:"providers"`
-	Gateway   GatewayConfig   `json:"gateway"`
-	Tools     ToolsConfig     `json:"tools"`
-	Heartbeat HeartbeatConfig `json:"heartbeat"`
-	Devices   DevicesConfig   `json:"devices"`
-	mu        sync.RWMutex
+	Agents     AgentsConfig     `json:"agents"`
+	Channels   ChannelsConfig   `json:"channels"`
+	Providers  ProvidersConfig  `json:"providers"`
+	Gateway    GatewayConfig    `json:"gateway"`
+	Tools      ToolsConfig      `json:"tools"`
+	Heartbeat  HeartbeatConfig  `json:"heartbeat"`
+	Devices    DevicesConfig    `json:"devices"`
+	Generation GenerationConfig `json:"generation"`
+	Fallback   FallbackConfig   `json:"fallback"`
+	Usage      UsageConfig      `json:"usage"`
+	Trace      TraceConfig      `json:"trace"`
+	Memory     MemoryConfig     `json:"memory"`
+	Storage    StorageConfig    `json:"storage"`
+	Bus        BusConfig        `json:"bus"`
+	MCP        MCPConfig        `json:"mcp"`
+	Skills     SkillsConfig     `json:"skills"`
+	mu         sync.RWMutex
 }
 
 type AgentsConfig struct {
 	Defaults AgentDefaults `json:"defaults"`
+	List     []AgentConfig `json:"list"`   // Additional named agents, see AgentSettings
+	Routes   []AgentRoute  `json:"routes"` // First matching route wins; unmatched messages go to the default agent
+}
+
+// DefaultAgentName is the name of the agent built from agents.defaults.
+const DefaultAgentName = "default"
+
+// AgentConfig describes a named agent. Empty fields inherit from
+// agents.defaults, except the workspace, which defaults to agents/<name> in
+// the default workspace so that agents don't share memory and sessions.
+type AgentConfig struct {
+	Name                string   `json:"name"`
+	Workspace           string   `json:"workspace,omitempty"`
+	Provider            string   `json:"provider,omitempty"`
+	Model               string   `json:"model,omitempty"`
+	RestrictToWorkspace *bool    `json:"restrict_to_workspace,omitempty"`
+	Tools               []string `json:"tools,omitempty"`
+}
+
+// AgentRoute sends inbound messages to an agent. Empty fields match anything.
+type AgentRoute struct {
+	Agent    string `json:"agent"`
+	Channel  string `json:"channel,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+	SenderID string `json:"sender_id,omitempty"`
+}
+
+// Matches reports whether a message from senderID in channel/chatID is
+// covered by the route.
+func (r AgentRoute) Matches(channel, chatID, senderID string) bool {
+	return (r.Channel == "" || r.Channel == channel) &&
+		(r.ChatID == "" || r.ChatID == chatID) &&
+		(r.SenderID == "" || r.SenderID == senderID)
 }
 
 type AgentDefaults struct {
-	Workspace           string  `json:"workspace" env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
-	RestrictToWorkspace bool    `json:"restrict_to_workspace" env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
-	Provider            string  `json:"provider" env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
-	Model               string  `json:"model" env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"`
-	MaxTokens           int     `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
-	Temperature         float64 `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
-	MaxToolIterations   int     `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	Workspace           string   `json:"workspace" env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
+	RestrictToWorkspace bool     `json:"restrict_to_workspace" env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
+	Provider            string   `json:"provider" env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
+	Model               string   `json:"model" env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"`
+	MaxTokens           int      `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
+	Temperature         float64  `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
+	MaxToolIterations   int      `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	MaxConcurrentTurns  int      `json:"max_concurrent_turns" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_CONCURRENT_TURNS"` // sessions processed in parallel
+	MaxParallelTools    int      `json:"max_parallel_tools" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_PARALLEL_TOOLS"`     // tool calls from one LLM response run concurrently
+	Vision              bool     `json:"vision" env:"PICOCLAW_AGENTS_DEFAULTS_VISION"`                             // send inbound images/PDFs to the model
+	MediaMaxBytes       int      `json:"media_max_bytes" env:"PICOCLAW_AGENTS_DEFAULTS_MEDIA_MAX_BYTES"`           // per-attachment limit
+	Streaming           bool     `json:"streaming" env:"PICOCLAW_AGENTS_DEFAULTS_STREAMING"`                       // progressively edit replies on channels that support it
+	Tools               []string `json:"tools,omitempty"`                                                          // tool allowlist; empty allows all tools
+}
+
+// WorkspacePath returns the workspace with ~ expanded.
+func (d AgentDefaults) WorkspacePath() string {
+	return expandHome(d.Workspace)
+}
+
+// Generation purposes, used to pick a profile for each kind of LLM call.
+const (
+	PurposeChat      = "chat"
+	PurposeSummarize = "summarize"
+	PurposeSubagent  = "subagent"
+	PurposeHeartbeat = "heartbeat"
+)
+
+// GenerationProfile holds sampling settings for an LLM call. Unset fields are
+// left out of the request (or inherited, when profiles are layered).
+type GenerationProfile struct {
+	MaxTokens       int      `json:"max_tokens,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"` // low, medium or high
+}
+
+// GenerationConfig defines named profiles and selects them per purpose,
+// channel ("telegram") and chat ("telegram:123456"). Channel and chat
+// selections apply to conversational replies only.
+type GenerationConfig struct {
+	Profiles map[string]GenerationProfile `json:"profiles"`
+	Purposes map[string]string            `json:"purposes"`
+	Channels map[string]string            `json:"channels"`
+	Chats    map[string]string            `json:"chats"`
 }
 
 type ChannelsConfig struct {
@@ -81,6 +162,7 @@ type ChannelsConfig struct {
 	OneBot   OneBotConfig   `json:"onebot"`
 	Matrix   MatrixConfig   `json:"matrix"`
 	Email    EmailConfig    `json:"email"`
+	Webhook  WebhookConfig  `json:"webhook"`
 }
 
 type MatrixConfig struct {
@@ -178,6 +260,34 @@ type EmailConfig struct {
 	TLS          bool                `json:"tls" env:"PICOCLAW_CHANNELS_EMAIL_TLS"`
 	PollInterval int                 `json:"poll_interval" env:"PICOCLAW_CHANNELS_EMAIL_POLL_INTERVAL"` // seconds
 	AllowFrom    FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_EMAIL_ALLOW_FROM"`
+	AllowTo      FlexibleStringSlice `json:"allow_to" env:"PICOCLAW_CHANNELS_EMAIL_ALLOW_TO"` // more recipients, besides replies and allow_from
+
+	// Outbound mail. Replies are disabled while SMTPHost is empty.
+	SMTPHost     string `json:"smtp_host" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_HOST"`
+	SMTPPort     int    `json:"smtp_port" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_PORT"`
+	SMTPUsername string `json:"smtp_username" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_USERNAME"` // defaults to username
+	SMTPPassword string `json:"smtp_password" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_PASSWORD"` // defaults to password
+	SMTPTLS      bool   `json:"smtp_tls" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_TLS"`           // implicit TLS (port 465); otherwise STARTTLS when offered
+	From         string `json:"from" env:"PICOCLAW_CHANNELS_EMAIL_FROM"`                   // defaults to username
+}
+
+// WebhookConfig configures the generic webhook channel. Inbound POSTs must be
+// signed with Secret; the *Field settings are dotted paths into the JSON body.
+type WebhookConfig struct {
+	Enabled         bool                `json:"enabled" env:"PICOCLAW_CHANNELS_WEBHOOK_ENABLED"`
+	Secret          string              `json:"secret" env:"PICOCLAW_CHANNELS_WEBHOOK_SECRET"`
+	SignatureHeader string              `json:"signature_header" env:"PICOCLAW_CHANNELS_WEBHOOK_SIGNATURE_HEADER"`
+	WebhookHost     string              `json:"webhook_host" env:"PICOCLAW_CHANNELS_WEBHOOK_WEBHOOK_HOST"`
+	WebhookPort     int                 `json:"webhook_port" env:"PICOCLAW_CHANNELS_WEBHOOK_WEBHOOK_PORT"`
+	WebhookPath     string              `json:"webhook_path" env:"PICOCLAW_CHANNELS_WEBHOOK_WEBHOOK_PATH"`
+	Mode            string              `json:"mode" env:"PICOCLAW_CHANNELS_WEBHOOK_MODE"`                 // "async" (reply via callback) or "sync" (reply in the HTTP response)
+	SyncTimeout     int                 `json:"sync_timeout" env:"PICOCLAW_CHANNELS_WEBHOOK_SYNC_TIMEOUT"` // seconds
+	CallbackURL     string              `json:"callback_url" env:"PICOCLAW_CHANNELS_WEBHOOK_CALLBACK_URL"`
+	SenderField     string              `json:"sender_field" env:"PICOCLAW_CHANNELS_WEBHOOK_SENDER_FIELD"`
+	ChatField       string              `json:"chat_field" env:"PICOCLAW_CHANNELS_WEBHOOK_CHAT_FIELD"`
+	ContentField    string              `json:"content_field" env:"PICOCLAW_CHANNELS_WEBHOOK_CONTENT_FIELD"`
+	CallbackField   string              `json:"callback_field" env:"PICOCLAW_CHANNELS_WEBHOOK_CALLBACK_FIELD"` // per-request callback URL
+	AllowFrom       FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WEBHOOK_ALLOW_FROM"`
 }
 
 type HeartbeatConfig struct {
@@ -185,6 +295,18 @@ type HeartbeatConfig struct {
 	Interval int  `json:"interval" env:"PICOCLAW_HEARTBEAT_INTERVAL"` // minutes, min 5
 }
 
+// BusConfig controls delivery guarantees of the gateway's message bus.
+type BusConfig struct {
+	// Durable journals messages under <workspace>/bus so unhandled ones are
+	// redelivered after a restart and failed ones land in dead-letter.jsonl.
+	Durable          bool `json:"durable" env:"PICOCLAW_BUS_DURABLE"`
+	MaxAttempts      int  `json:"max_attempts" env:"PICOCLAW_BUS_MAX_ATTEMPTS"` // deliveries before dead-lettering
+	InitialBackoffMs int  `json:"initial_backoff_ms" env:"PICOCLAW_BUS_INITIAL_BACKOFF_MS"`
+	MaxBackoffMs     int  `json:"max_backoff_ms" env:"PICOCLAW_BUS_MAX_BACKOFF_MS"`
+	MaxPending       int  `json:"max_pending" env:"PICOCLAW_BUS_MAX_PENDING"`       // queued messages before the oldest is dropped
+	AckTimeoutMs     int  `json:"ack_timeout_ms" env:"PICOCLAW_BUS_ACK_TIMEOUT_MS"` // handling time before a message is dead-lettered
+}
+
 type DevicesConfig struct {
 	Enabled    bool `json:"enabled" env:"PICOCLAW_DEVICES_ENABLED"`
 	MonitorUSB bool `json:"monitor_usb" env:"PICOCLAW_DEVICES_MONITOR_USB"`
@@ -214,9 +336,138 @@ type ProviderConfig struct {
 	ConnectMode string `json:"connect_mode,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
 }
 
+// FallbackConfig controls retries and failover for LLM calls. The agent's own
+// provider/model is always tried first, followed by Chain in order.
+type FallbackConfig struct {
+	Chain                  []FallbackTarget `json:"chain"`
+	MaxRetries             int              `json:"max_retries" env:"PICOCLAW_FALLBACK_MAX_RETRIES"`                   // retries per backend on 429
+	InitialBackoffMs       int              `json:"initial_backoff_ms" env:"PICOCLAW_FALLBACK_INITIAL_BACKOFF_MS"`     // doubled on every retry
+	MaxBackoffMs           int              `json:"max_backoff_ms" env:"PICOCLAW_FALLBACK_MAX_BACKOFF_MS"`             // longer Retry-After values fail over instead
+	BreakerThreshold       int              `json:"breaker_threshold" env:"PICOCLAW_FALLBACK_BREAKER_THRESHOLD"`       // consecutive failures that open a backend's breaker
+	BreakerCooldownSeconds int              `json:"breaker_cooldown_seconds" env:"PICOCLAW_FALLBACK_BREAKER_COOLDOWN"` // how long an open breaker skips the backend
+}
+
+// FallbackTarget is one backend in the fallback chain.
+type FallbackTarget struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// UsageConfig controls the token usage ledger and daily budgets.
+type UsageConfig struct {
+	Enabled          bool                  `json:"enabled" env:"PICOCLAW_USAGE_ENABLED"`
+	Prices           map[string]ModelPrice `json:"prices"`                                                     // keyed by model name
+	DailyBudgetUSD   float64               `json:"daily_budget_usd" env:"PICOCLAW_USAGE_DAILY_BUDGET_USD"`     // 0 disables the cost cap
+	DailyTokenBudget int                   `json:"daily_token_budget" env:"PICOCLAW_USAGE_DAILY_TOKEN_BUDGET"` // 0 disables the token cap
+	BudgetAction     string                `json:"budget_action" env:"PICOCLAW_USAGE_BUDGET_ACTION"`           // "refuse" or "downgrade"
+	DowngradeModel   string                `json:"downgrade_model" env:"PICOCLAW_USAGE_DOWNGRADE_MODEL"`       // used by "downgrade", same provider
+}
+
+// TraceConfig controls the per-turn traces written to workspace/traces.
+type TraceConfig struct {
+	Enabled       bool `json:"enabled" env:"PICOCLAW_TRACE_ENABLED"`
+	RetentionDays int  `json:"retention_days" env:"PICOCLAW_TRACE_RETENTION_DAYS"` // 0 keeps traces forever
+}
+
+// MemoryConfig controls retrieval from the memory/ directory and session
+// summaries. With Search enabled only the snippets relevant to the current
+// message are put in the system prompt, instead of all of MEMORY.md and the
+// recent daily notes. Setting an embedding model adds semantic search on top
+// of BM25.
+type MemoryConfig struct {
+	Search            bool   `json:"search" env:"PICOCLAW_MEMORY_SEARCH"`
+	TopK              int    `json:"top_k" env:"PICOCLAW_MEMORY_TOP_K"`
+	EmbeddingProvider string `json:"embedding_provider" env:"PICOCLAW_MEMORY_EMBEDDING_PROVIDER"` // defaults to the agent's provider
+	EmbeddingModel    string `json:"embedding_model" env:"PICOCLAW_MEMORY_EMBEDDING_MODEL"`       // empty uses BM25 only
+}
+
+// StorageConfig selects where each agent workspace keeps its sessions, state
+// and cron jobs: "json" files, or a "sqlite" database at
+// <workspace>/picoclaw.db that is filled from the JSON files when first used.
+type StorageConfig struct {
+	Backend string `json:"backend" env:"PICOCLAW_STORAGE_BACKEND"`
+}
+
+// SkillsConfig sets where picoclaw skills install looks up skills given by
+// name: the local Registries directories, in order, then the index at
+// RegistryURL. Local registries make installs work offline.
+//
+// The system prompt only lists the skills; the agent loads the instructions
+// of at most MaxActive of them per session with the load_skill tool.
+// AutoActivate also loads the skills a message matches, by "keywords" or by
+// "embeddings" of their descriptions using the memory embedding model, at
+// ActivationThreshold cosine similarity.
+type SkillsConfig struct {
+	Registries          FlexibleStringSlice `json:"registries" env:"PICOCLAW_SKILLS_REGISTRIES"`
+	RegistryURL         string              `json:"registry_url" env:"PICOCLAW_SKILLS_REGISTRY_URL"`
+	AutoActivate        string              `json:"auto_activate" env:"PICOCLAW_SKILLS_AUTO_ACTIVATE"`
+	MaxActive           int                 `json:"max_active" env:"PICOCLAW_SKILLS_MAX_ACTIVE"`
+	ActivationThreshold float64             `json:"activation_threshold" env:"PICOCLAW_SKILLS_ACTIVATION_THRESHOLD"`
+}
+
+// MCPConfig lists the Model Context Protocol servers whose tools, resources
+// and prompts are offered to the agents, keyed by a short server name that
+// prefixes their tool names. Serve offers PicoClaw's own tools to MCP clients.
+type MCPConfig struct {
+	Servers map[string]MCPServerConfig `json:"servers"`
+	Serve   MCPServeConfig             `json:"serve"`
+}
+
+// MCPServeConfig exposes the default agent's tools over MCP, with
+// picoclaw mcp serve or, when Enabled, at /mcp on the gateway port.
+// Requests to /mcp must carry one of APITokens as a bearer token; with no
+// tokens only loopback clients are accepted, and only MCPOpenTools are
+// offered. Requests must be addressed to localhost or one of AllowedHosts,
+// and come from no web page or one on those hosts.
+type MCPServeConfig struct {
+	Enabled      bool                `json:"enabled" env:"PICOCLAW_MCP_SERVE_ENABLED"`
+	Tools        []string            `json:"tools"` // tools offered, by name; empty offers none
+	APITokens    FlexibleStringSlice `json:"api_tokens" env:"PICOCLAW_MCP_SERVE_API_TOKENS"`
+	AllowedHosts FlexibleStringSlice `json:"allowed_hosts" env:"PICOCLAW_MCP_SERVE_ALLOWED_HOSTS"` // names clients reach the server by, besides localhost
+	// Channel and ChatID are where message and cron deliver when a call
+	// names no chat, e.g. "telegram" and the owner's chat ID.
+	Channel string `json:"channel" env:"PICOCLAW_MCP_SERVE_CHANNEL"`
+	ChatID  string `json:"chat_id" env:"PICOCLAW_MCP_SERVE_CHAT_ID"`
+}
+
+// MCPOpenTools are the only tools offered over HTTP to clients without one
+// of mcp.serve.api_tokens. None of them can run commands or write files,
+// also not through a subagent; exec, cron, spawn, subagent, the file tools
+// and skill tools need a token.
+var MCPOpenTools = []string{"message", "memory_search", "memory_save", "web_search", "web_fetch", "i2c", "spi"}
+
+// MCPServerConfig describes one MCP server: a command speaking MCP on its
+// stdin/stdout, or the URL of a remote server.
+type MCPServerConfig struct {
+	Disabled  bool              `json:"disabled,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Args      []string          `json:"args,omitempty"`
+	Env       map[string]string `json:"env,omitempty"` // added to PicoClaw's environment
+	Dir       string            `json:"dir,omitempty"` // working directory, defaults to the workspace
+	URL       string            `json:"url,omitempty"`
+	Transport string            `json:"transport,omitempty"` // "stdio", "http" (streamable HTTP) or "sse"; inferred when empty
+	Headers   map[string]string `json:"headers,omitempty"`   // sent with every HTTP request, e.g. Authorization
+	Tools     []string          `json:"tools,omitempty"`     // tools to offer, by their name on the server; empty offers all
+	Timeout   int               `json:"timeout,omitempty"`   // seconds per request, default 60
+}
+
+// ModelPrice is the price of a model in USD per million tokens.
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
 type GatewayConfig struct {
 	Host string `json:"host" env:"PICOCLAW_GATEWAY_HOST"`
 	Port int    `json:"port" env:"PICOCLAW_GATEWAY_PORT"`
+	// APIEnabled serves an OpenAI-compatible API (/v1/chat/completions,
+	// /v1/models) on the gateway port. Requests must carry one of APITokens
+	// as a bearer token; with no tokens only loopback clients are accepted.
+	APIEnabled bool                `json:"api_enabled" env:"PICOCLAW_GATEWAY_API_ENABLED"`
+	APITokens  FlexibleStringSlice `json:"api_tokens" env:"PICOCLAW_GATEWAY_API_TOKENS"`
+	// WatchConfig reloads config.json when it changes. SIGHUP reloads it
+	// either way.
+	WatchConfig bool `json:"watch_config" env:"PICOCLAW_GATEWAY_WATCH_CONFIG"`
 }
 
 type BraveConfig struct {
@@ -251,10 +502,44 @@ type TTSConfig struct {
 	CFGWeight    float64 `json:"cfg_weight" env:"PICOCLAW_TOOLS_TTS_CFG_WEIGHT"`     // Chatterbox: voice guidance weight 0.0–1.0
 }
 
+// ExecSandboxConfig isolates commands run by the exec tool. Linux only.
+type ExecSandboxConfig struct {
+	Mode          string              `json:"mode" env:"PICOCLAW_TOOLS_EXEC_SANDBOX_MODE"` // "off", "auto", "namespaces" or "bwrap"
+	AllowNetwork  bool                `json:"allow_network" env:"PICOCLAW_TOOLS_EXEC_SANDBOX_ALLOW_NETWORK"`
+	WritablePaths FlexibleStringSlice `json:"writable_paths" env:"PICOCLAW_TOOLS_EXEC_SANDBOX_WRITABLE_PATHS"` // besides the workspace
+	MemoryMB      int                 `json:"memory_mb" env:"PICOCLAW_TOOLS_EXEC_SANDBOX_MEMORY_MB"`           // 0 = unlimited
+	CPUs          float64             `json:"cpus" env:"PICOCLAW_TOOLS_EXEC_SANDBOX_CPUS"`                     // 0 = unlimited
+	MaxPids       int                 `json:"max_pids" env:"PICOCLAW_TOOLS_EXEC_SANDBOX_MAX_PIDS"`             // 0 = unlimited
+	CgroupParent  string              `json:"cgroup_parent" env:"PICOCLAW_TOOLS_EXEC_SANDBOX_CGROUP_PARENT"`   // cgroup v2 directory for per-command groups
+}
+
+// ApprovalConfig makes matching tool calls wait for a human to approve
+// them from the chat the turn came from.
+type ApprovalConfig struct {
+	Enabled bool           `json:"enabled" env:"PICOCLAW_TOOLS_APPROVAL_ENABLED"`
+	Timeout int            `json:"timeout" env:"PICOCLAW_TOOLS_APPROVAL_TIMEOUT"` // seconds before a request is denied
+	Rules   []ApprovalRule `json:"rules"`
+}
+
+// ApprovalRule marks calls of Tool ("*" for any tool) as requiring approval.
+// With Pattern set, only calls whose argument Arg (or, without Arg, the JSON
+// of all arguments) matches the regular expression do.
+type ApprovalRule struct {
+	Tool    string `json:"tool"`
+	Arg     string `json:"arg,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+type ExecToolsConfig struct {
+	Sandbox ExecSandboxConfig `json:"sandbox"`
+}
+
 type ToolsConfig struct {
-	Web     WebToolsConfig `json:"web"`
-	Whisper WhisperConfig  `json:"whisper"`
-	TTS     TTSConfig      `json:"tts"`
+	Web      WebToolsConfig  `json:"web"`
+	Whisper  WhisperConfig   `json:"whisper"`
+	TTS      TTSConfig       `json:"tts"`
+	Exec     ExecToolsConfig `json:"exec"`
+	Approval ApprovalConfig  `json:"approval"`
 }
 
 func DefaultConfig() *Config {
@@ -268,7 +553,48 @@ func DefaultConfig() *Config {
 				MaxTokens:           8192,
 				Temperature:         0.7,
 				MaxToolIterations:   20,
+				MaxConcurrentTurns:  4,
+				MaxParallelTools:    4,
+				Vision:              true,
+				MediaMaxBytes:       5 * 1024 * 1024,
+				Streaming:           true,
+			},
+		},
+		Usage: UsageConfig{
+			Enabled:      true,
+			Prices:       map[string]ModelPrice{},
+			BudgetAction: "refuse",
+		},
+		Trace: TraceConfig{
+			Enabled:       true,
+			RetentionDays: 14,
+		},
+		Memory: MemoryConfig{
+			Search: true,
+			TopK:   5,
+		},
+		Storage: StorageConfig{
+			Backend: "json",
+		},
+		Fallback: FallbackConfig{
+			Chain:                  []FallbackTarget{},
+			MaxRetries:             2,
+			InitialBackoffMs:       1000,
+			MaxBackoffMs:           30000,
+			BreakerThreshold:       3,
+			BreakerCooldownSeconds: 60,
+		},
+		Generation: GenerationConfig{
+			Profiles: map[string]GenerationProfile{
+				"summarize": {MaxTokens: 1024, Temperature: Float64(0.3)},
+				"subagent":  {MaxTokens: 4096, Temperature: Float64(0.7)},
 			},
+			Purposes: map[string]string{
+				PurposeSummarize: "summarize",
+				PurposeSubagent:  "subagent",
+			},
+			Channels: map[string]string{},
+			Chats:    map[string]string{},
 		},
 		Channels: ChannelsConfig{
 			WhatsApp: WhatsAppConfig{
@@ -345,6 +671,21 @@ func DefaultConfig() *Config {
 				JoinOnInvite:          true,
 				RequireMentionInGroup: true,
 			},
+			Webhook: WebhookConfig{
+				Enabled:         false,
+				Secret:          "",
+				SignatureHeader: "X-PicoClaw-Signature",
+				WebhookHost:     "0.0.0.0",
+				WebhookPort:     18792,
+				WebhookPath:     "/webhook",
+				Mode:            "async",
+				SyncTimeout:     120,
+				SenderField:     "sender",
+				ChatField:       "chat_id",
+				ContentField:    "content",
+				CallbackField:   "callback_url",
+				AllowFrom:       FlexibleStringSlice{},
+			},
 			Email: EmailConfig{
 				Enabled:      false,
 				IMAPHost:     "localhost",
@@ -354,6 +695,8 @@ func DefaultConfig() *Config {
 				TLS:          false,
 				PollInterval: 60,
 				AllowFrom:    FlexibleStringSlice{},
+				AllowTo:      FlexibleStringSlice{},
+				SMTPPort:     587,
 			},
 		},
 		Providers: ProvidersConfig{
@@ -369,8 +712,11 @@ func DefaultConfig() *Config {
 			ShengSuanYun: ProviderConfig{},
 		},
 		Gateway: GatewayConfig{
-			Host: "0.0.0.0",
-			Port: 18790,
+			Host:        "0.0.0.0",
+			Port:        18790,
+			APIEnabled:  false,
+			APITokens:   FlexibleStringSlice{},
+			WatchConfig: true,
 		},
 		Tools: ToolsConfig{
 			Web: WebToolsConfig{
@@ -398,6 +744,17 @@ func DefaultConfig() *Config {
 				Exaggeration: 0.5,
 				CFGWeight:    0.5,
 			},
+			Exec: ExecToolsConfig{
+				Sandbox: ExecSandboxConfig{
+					Mode:         "off",
+					CgroupParent: "/sys/fs/cgroup/picoclaw",
+				},
+			},
+			Approval: ApprovalConfig{
+				Enabled: false,
+				Timeout: 300,
+				Rules:   []ApprovalRule{{Tool: "exec"}},
+			},
 		},
 		Heartbeat: HeartbeatConfig{
 			Enabled:  true,
@@ -407,6 +764,29 @@ func DefaultConfig() *Config {
 			Enabled:    false,
 			MonitorUSB: true,
 		},
+		Bus: BusConfig{
+			Durable:          false,
+			MaxAttempts:      5,
+			InitialBackoffMs: 2000,
+			MaxBackoffMs:     60000,
+			MaxPending:       10000,
+			AckTimeoutMs:     3600000,
+		},
+		MCP: MCPConfig{
+			Servers: map[string]MCPServerConfig{},
+			Serve: MCPServeConfig{
+				Tools:        []string{"i2c", "spi", "message", "memory_search", "memory_save"},
+				APITokens:    FlexibleStringSlice{},
+				AllowedHosts: FlexibleStringSlice{},
+			},
+		},
+		Skills: SkillsConfig{
+			Registries:          FlexibleStringSlice{},
+			RegistryURL:         "https://raw.githubusercontent.com/sipeed/picoclaw-skills/main/skills.json",
+			AutoActivate:        "off",
+			MaxActive:           3,
+			ActivationThreshold: 0.5,
+		},
 	}
 }
 
@@ -455,6 +835,43 @@ func (c *Config) WorkspacePath() string {
 	return expandHome(c.Agents.Defaults.Workspace)
 }
 
+// AgentSettings returns the effective settings of the named agent:
+// agents.defaults overlaid with its agents.list entry, see AgentConfig.
+// DefaultAgentName returns agents.defaults as is.
+func (c *Config) AgentSettings(name string) (AgentDefaults, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	settings := c.Agents.Defaults
+	if name == DefaultAgentName {
+		return settings, true
+	}
+	for _, agent := range c.Agents.List {
+		if agent.Name != name {
+			continue
+		}
+		if agent.Workspace != "" {
+			settings.Workspace = agent.Workspace
+		} else {
+			settings.Workspace = filepath.Join(settings.Workspace, "agents", agent.Name)
+		}
+		if agent.Provider != "" {
+			settings.Provider = agent.Provider
+		}
+		if agent.Model != "" {
+			settings.Model = agent.Model
+		}
+		if agent.RestrictToWorkspace != nil {
+			settings.RestrictToWorkspace = *agent.RestrictToWorkspace
+		}
+		if len(agent.Tools) > 0 {
+			settings.Tools = agent.Tools
+		}
+		return settings, true
+	}
+	return AgentDefaults{}, false
+}
+
 func (c *Config) GetAPIKey() string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -503,6 +920,76 @@ func (c *Config) GetAPIBase() string {
 	return ""
 }
 
+// GenerationProfile resolves the sampling settings for an LLM call. It starts
+// from agents.defaults (max_tokens, temperature) and layers the purpose
+// profile and, for chat replies, the channel and chat profiles on top.
+func (c *Config) GenerationProfile(purpose, channel, chatID string) GenerationProfile {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	profile := GenerationProfile{
+		MaxTokens:   c.Agents.Defaults.MaxTokens,
+		Temperature: Float64(c.Agents.Defaults.Temperature),
+	}
+
+	names := []string{c.Generation.Purposes[purpose]}
+	if purpose == PurposeChat {
+		names = append(names, c.Generation.Channels[channel], c.Generation.Chats[channel+":"+chatID])
+	}
+	for _, name := range names {
+		if overlay, ok := c.Generation.Profiles[name]; ok && name != "" {
+			profile = profile.Merge(overlay)
+		}
+	}
+	return profile
+}
+
+// Merge returns p with every field that is set in overlay replaced.
+func (p GenerationProfile) Merge(overlay GenerationProfile) GenerationProfile {
+	if overlay.MaxTokens > 0 {
+		p.MaxTokens = overlay.MaxTokens
+	}
+	if overlay.Temperature != nil {
+		p.Temperature = overlay.Temperature
+	}
+	if overlay.TopP != nil {
+		p.TopP = overlay.TopP
+	}
+	if len(overlay.Stop) > 0 {
+		p.Stop = overlay.Stop
+	}
+	if overlay.ReasoningEffort != "" {
+		p.ReasoningEffort = overlay.ReasoningEffort
+	}
+	return p
+}
+
+// Options converts the profile to the options map passed to LLMProvider.Chat.
+func (p GenerationProfile) Options() map[string]interface{} {
+	opts := map[string]interface{}{}
+	if p.MaxTokens > 0 {
+		opts["max_tokens"] = p.MaxTokens
+	}
+	if p.Temperature != nil {
+		opts["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		opts["top_p"] = *p.TopP
+	}
+	if len(p.Stop) > 0 {
+		opts["stop"] = p.Stop
+	}
+	if p.ReasoningEffort != "" {
+		opts["reasoning_effort"] = p.ReasoningEffort
+	}
+	return opts
+}
+
+// Float64 returns a pointer to v, for optional profile fields.
+func Float64(v float64) *float64 {
+	return &v
+}
+
 func expandHome(path string) string {
 	if path == "" {
 		return path
@@ -204,3 +204,86 @@ func TestConfig_Complete(t *testing.T) {
 		t.Error("Heartbeat should be enabled by default")
 	}
 }
+
+// TestGenerationProfile_Layering verifies defaults, purpose, channel and chat
+// profiles are applied in order
+func TestGenerationProfile_Layering(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Agents.Defaults.MaxTokens = 2000
+	cfg.Agents.Defaults.Temperature = 0.5
+	cfg.Generation.Profiles["precise"] = GenerationProfile{Temperature: Float64(0.1), Stop: []string{"END"}}
+	cfg.Generation.Profiles["vip"] = GenerationProfile{MaxTokens: 16000, ReasoningEffort: "high"}
+	cfg.Generation.Channels["telegram"] = "precise"
+	cfg.Generation.Chats["telegram:42"] = "vip"
+
+	base := cfg.GenerationProfile(PurposeChat, "discord", "1")
+	if base.MaxTokens != 2000 || base.Temperature == nil || *base.Temperature != 0.5 {
+		t.Errorf("Expected agent defaults, got %+v", base)
+	}
+
+	chat := cfg.GenerationProfile(PurposeChat, "telegram", "42")
+	if chat.MaxTokens != 16000 {
+		t.Errorf("MaxTokens = %d, want 16000 from the chat profile", chat.MaxTokens)
+	}
+	if *chat.Temperature != 0.1 || len(chat.Stop) != 1 {
+		t.Errorf("Expected channel profile to be kept under the chat profile, got %+v", chat)
+	}
+	if chat.ReasoningEffort != "high" {
+		t.Errorf("ReasoningEffort = %q, want high", chat.ReasoningEffort)
+	}
+
+	// Channel and chat selections don't apply to background purposes
+	summary := cfg.GenerationProfile(PurposeSummarize, "telegram", "42")
+	if summary.MaxTokens != 1024 || *summary.Temperature != 0.3 || len(summary.Stop) != 0 {
+		t.Errorf("Expected the default summarize profile, got %+v", summary)
+	}
+}
+
+func TestGenerationProfile_Options(t *testing.T) {
+	opts := GenerationProfile{MaxTokens: 100, TopP: Float64(0.9)}.Options()
+	if opts["max_tokens"] != 100 || opts["top_p"] != 0.9 {
+		t.Errorf("Unexpected options: %v", opts)
+	}
+	if _, ok := opts["temperature"]; ok {
+		t.Error("Unset temperature should be left out of the options")
+	}
+}
+
+func TestAgentSettings_InheritsDefaults(t *testing.T) {
+	cfg := DefaultConfig()
+	restrict := false
+	cfg.Agents.List = []AgentConfig{
+		{Name: "ops", Model: "ops-model", RestrictToWorkspace: &restrict, Tools: []string{"exec"}},
+	}
+
+	ops, ok := cfg.AgentSettings("ops")
+	if !ok {
+		t.Fatal("Expected ops agent to be found")
+	}
+	if ops.Model != "ops-model" || ops.RestrictToWorkspace || len(ops.Tools) != 1 {
+		t.Errorf("Expected agent overrides to apply, got %+v", ops)
+	}
+	if ops.MaxTokens != cfg.Agents.Defaults.MaxTokens {
+		t.Error("Expected unset fields to inherit agents.defaults")
+	}
+	if want := filepath.Join(cfg.Agents.Defaults.Workspace, "agents", "ops"); ops.Workspace != want {
+		t.Errorf("Expected the workspace to default to %s, got %s", want, ops.Workspace)
+	}
+
+	if _, ok := cfg.AgentSettings("missing"); ok {
+		t.Error("Expected unknown agent to be reported")
+	}
+	if def, _ := cfg.AgentSettings(DefaultAgentName); def.Model != cfg.Agents.Defaults.Model {
+		t.Error("Expected the default agent to use agents.defaults")
+	}
+}
+
+func TestAgentRoute_Matches(t *testing.T) {
+	route := AgentRoute{Agent: "ops", Channel: "telegram", ChatID: "42"}
+	if !route.Matches("telegram", "42", "anyone") {
+		t.Error("Expected route to match channel and chat")
+	}
+	if route.Matches("telegram", "7", "anyone") || route.Matches("slack", "42", "anyone") {
+		t.Error("Expected route not to match other chats or channels")
+	}
+}
@@ -0,0 +1,352 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Document is a config.json decoded with the order of its keys kept, so
+// editing one value leaves the rest of the file as the user wrote it,
+// apart from whitespace. Objects are *object values, arrays
+// []interface{}, numbers json.Number.
+type Document struct {
+	root interface{}
+}
+
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newObject() *object {
+	return &object{values: make(map[string]interface{})}
+}
+
+func (o *object) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ParseDocument decodes a config.json.
+func ParseDocument(data []byte) (*Document, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	root, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, syntaxError(data, err)
+	}
+	if _, ok := root.(*object); !ok {
+		return nil, fmt.Errorf("config must be a JSON object")
+	}
+	return &Document{root: root}, nil
+}
+
+// NewDocument returns cfg as a document, keys in struct order.
+func NewDocument(cfg *Config) (*Document, error) {
+	cfg.mu.RLock()
+	data, err := json.Marshal(cfg)
+	cfg.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return ParseDocument(data)
+}
+
+// ParseValue parses a value given on the command line for a setting with
+// schema s: JSON if it parses as such, otherwise a plain string. Settings
+// that only take strings always get the text as is, so a token like
+// "12345" is not turned into a number.
+func ParseValue(text string, s *Schema) interface{} {
+	if s != nil && len(s.Type) == 1 && s.Type[0] == "string" {
+		return text
+	}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	value, err := decodeOrdered(dec)
+	if err != nil {
+		return text
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return text
+	}
+	return value
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := newObject()
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(keyTok.(string), value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return arr, nil
+	}
+	return tok, nil
+}
+
+// SplitPath splits a dotted config path into its keys. Array indices and
+// keys containing dots go in brackets: "agents.list[0].model",
+// "usage.prices[gpt-4.1].input_per_million".
+func SplitPath(path string) ([]string, error) {
+	var keys []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			keys = append(keys, cur.String())
+			cur.Reset()
+		}
+	}
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: missing ]", path)
+			}
+			keys = append(keys, path[i+1:i+end])
+			i += end
+		default:
+			cur.WriteByte(path[i])
+		}
+	}
+	flush()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return keys, nil
+}
+
+// Get returns the value at path.
+func (d *Document) Get(path string) (interface{}, error) {
+	keys, err := SplitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	cur := d.root
+	for i, key := range keys {
+		next, ok := child(cur, key)
+		if !ok {
+			return nil, fmt.Errorf("%s is not set", strings.Join(keys[:i+1], "."))
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// Set sets the value at path, creating missing objects and arrays on the
+// way. An index one past the end of an array appends to it.
+func (d *Document) Set(path string, value interface{}) error {
+	keys, err := SplitPath(path)
+	if err != nil {
+		return err
+	}
+	cur := d.root
+	// replace swaps cur in its parent, for appending to an array
+	var replace func(interface{})
+	for i, key := range keys {
+		last := i == len(keys)-1
+		switch node := cur.(type) {
+		case *object:
+			if last {
+				node.set(key, value)
+				return nil
+			}
+			next, ok := node.values[key]
+			if !ok || next == nil {
+				next = newObject()
+				if _, err := strconv.Atoi(keys[i+1]); err == nil {
+					next = []interface{}{}
+				}
+				node.set(key, next)
+			}
+			cur, replace = next, func(v interface{}) { node.values[key] = v }
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index > len(node) || (index == len(node) && !last) {
+				return fmt.Errorf("%s: no element %s", strings.Join(keys[:i], "."), key)
+			}
+			if index == len(node) {
+				replace(append(node, value))
+				return nil
+			}
+			if last {
+				node[index] = value
+				return nil
+			}
+			cur, replace = node[index], func(v interface{}) { node[index] = v }
+		default:
+			return fmt.Errorf("%s is not an object or array", strings.Join(keys[:i], "."))
+		}
+	}
+	return nil
+}
+
+func child(node interface{}, key string) (interface{}, bool) {
+	switch n := node.(type) {
+	case *object:
+		value, ok := n.values[key]
+		return value, ok
+	case []interface{}:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= len(n) {
+			return nil, false
+		}
+		return n[index], true
+	}
+	return nil, false
+}
+
+// MaskSecrets replaces the values of secret keys, see IsSecret, with a mask
+// that only keeps the last few characters of long values.
+func (d *Document) MaskSecrets() {
+	maskSecrets(d.root)
+}
+
+func maskSecrets(node interface{}) {
+	switch n := node.(type) {
+	case *object:
+		for _, key := range n.keys {
+			if IsSecret(key) {
+				n.values[key] = maskValue(n.values[key])
+			} else {
+				maskSecrets(n.values[key])
+			}
+		}
+	case []interface{}:
+		for _, item := range n {
+			maskSecrets(item)
+		}
+	}
+}
+
+func maskValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return MaskSecret(v)
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = maskValue(item)
+		}
+		return masked
+	case *object:
+		masked := newObject()
+		for _, key := range v.keys {
+			masked.set(key, maskValue(v.values[key]))
+		}
+		return masked
+	}
+	return value
+}
+
+// MaskSecret masks a credential for display.
+func MaskSecret(secret string) string {
+	switch {
+	case secret == "":
+		return ""
+	case len(secret) < 16:
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// Marshal encodes the document as indented JSON.
+func (d *Document) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(d.root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Save writes the document to path through a temporary file, so readers
+// such as the gateway's config watcher never see a partial file.
+func (d *Document) Save(path string) error {
+	data, err := d.Marshal()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
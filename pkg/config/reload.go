@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// ChangedSections returns the config sections that differ between old and
+// new, named by their JSON path: "channels.telegram", "agents.defaults",
+// "providers" and so on.
+func ChangedSections(old, new *Config) []string {
+	old.mu.RLock()
+	defer old.mu.RUnlock()
+	new.mu.RLock()
+	defer new.mu.RUnlock()
+
+	var changed []string
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		field := ov.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		// Channels and agents are applied one by one, so report them in
+		// more detail
+		if field.Name == "Channels" || field.Name == "Agents" {
+			for j := 0; j < field.Type.NumField(); j++ {
+				if !reflect.DeepEqual(ov.Field(i).Field(j).Interface(), nv.Field(i).Field(j).Interface()) {
+					changed = append(changed, name+"."+jsonName(field.Type.Field(j)))
+				}
+			}
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Watcher reloads a config file when its content changes or Reload is
+// called, e.g. on SIGHUP. A file that fails to load or validate is logged
+// and skipped, so the running config stays in effect.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	onChange func(*Config)
+	reload   chan struct{}
+	last     []byte
+}
+
+// NewWatcher creates a watcher that checks path every interval and passes
+// each new config to onChange. With an interval of 0 the file is only
+// read when Reload is called.
+func NewWatcher(path string, interval time.Duration, onChange func(*Config)) *Watcher {
+	last, _ := os.ReadFile(path)
+	return &Watcher{
+		path:     path,
+		interval: interval,
+		onChange: onChange,
+		reload:   make(chan struct{}, 1),
+		last:     last,
+	}
+}
+
+// Reload asks the watcher to load the file now, even if it did not change.
+func (w *Watcher) Reload() {
+	select {
+	case w.reload <- struct{}{}:
+	default:
+	}
+}
+
+// Run watches the file until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			w.check(false)
+		case <-w.reload:
+			w.check(true)
+		}
+	}
+}
+
+func (w *Watcher) check(force bool) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		if force {
+			logger.ErrorCF("config", "Config reload failed, keeping the running config", map[string]interface{}{
+				"path":  w.path,
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+	// Editors often touch or rewrite a file without changing it
+	if !force && bytes.Equal(data, w.last) {
+		return
+	}
+	w.last = data
+
+	cfg, issues, err := ValidateJSON(data)
+	if err == nil && HasErrors(issues) {
+		err = fmt.Errorf("%d invalid settings, see picoclaw config validate", countErrors(issues))
+	}
+	for _, issue := range issues {
+		logIssue(issue)
+	}
+	if err != nil {
+		logger.ErrorCF("config", "Config reload failed, keeping the running config", map[string]interface{}{
+			"path":  w.path,
+			"error": err.Error(),
+		})
+		return
+	}
+	w.onChange(cfg)
+}
+
+func logIssue(issue Issue) {
+	fields := map[string]interface{}{
+		"path":    issue.Path,
+		"problem": issue.Message,
+	}
+	if issue.Severity == SeverityError {
+		logger.ErrorCF("config", "Invalid config setting", fields)
+	} else {
+		logger.WarnCF("config", "Suspicious config setting", fields)
+	}
+}
+
+func countErrors(issues []Issue) int {
+	n := 0
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangedSections(t *testing.T) {
+	old := DefaultConfig()
+	if got := ChangedSections(old, DefaultConfig()); len(got) != 0 {
+		t.Errorf("identical configs changed %v", got)
+	}
+
+	next := DefaultConfig()
+	next.Channels.Telegram.AllowFrom = FlexibleStringSlice{"123"}
+	next.Agents.Defaults.Model = "other-model"
+	next.Heartbeat.Interval = 60
+	want := []string{"agents.defaults", "channels.telegram", "heartbeat"}
+	if got := ChangedSections(old, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedSections = %v, want %v", got, want)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"heartbeat": {"interval": 10}}`), 0600)
+
+	loaded := make(chan *Config, 4)
+	w := NewWatcher(path, 10*time.Millisecond, func(cfg *Config) { loaded <- cfg })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	wait := func() *Config {
+		t.Helper()
+		select {
+		case cfg := <-loaded:
+			return cfg
+		case <-time.After(2 * time.Second):
+			t.Fatal("no reload")
+			return nil
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"heartbeat": {"interval": 20}}`), 0600)
+	if cfg := wait(); cfg.Heartbeat.Interval != 20 {
+		t.Errorf("interval = %d, want 20", cfg.Heartbeat.Interval)
+	}
+
+	// Invalid JSON is skipped, the next valid version is picked up
+	os.WriteFile(path, []byte(`{"heartbeat": `), 0600)
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(path, []byte(`{"heartbeat": {"interval": 30}}`), 0600)
+	if cfg := wait(); cfg.Heartbeat.Interval != 30 {
+		t.Errorf("interval = %d, want 30", cfg.Heartbeat.Interval)
+	}
+
+	// Reload loads the file even if it did not change
+	w.Reload()
+	if cfg := wait(); cfg.Heartbeat.Interval != 30 {
+		t.Errorf("interval = %d, want 30", cfg.Heartbeat.Interval)
+	}
+}
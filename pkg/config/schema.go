@@ -0,0 +1,218 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Schema is the subset of JSON Schema used to describe config.json. It is
+// generated from the Config struct, so it never lags behind the fields the
+// gateway actually reads.
+type Schema struct {
+	SchemaURI            string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 SchemaType         `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"` // false, or the *Schema of map values
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Secret               bool               `json:"x-secret,omitempty"` // masked by picoclaw config show
+}
+
+// SchemaType is a JSON Schema type, or a list of them.
+type SchemaType []string
+
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// Has reports whether t allows values of JSON type name.
+func (t SchemaType) Has(name string) bool {
+	for _, s := range t {
+		if s == name || (s == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// MapValues returns the schema of the values of a map, or nil if s is not one.
+func (s *Schema) MapValues() *Schema {
+	values, _ := s.AdditionalProperties.(*Schema)
+	return values
+}
+
+// Lookup returns the schema of the setting at path, or nil if config.json
+// has no such setting.
+func (s *Schema) Lookup(path string) *Schema {
+	keys, err := SplitPath(path)
+	if err != nil {
+		return nil
+	}
+	cur := s
+	for _, key := range keys {
+		switch {
+		case cur.Items != nil:
+			if _, err := strconv.Atoi(key); err != nil {
+				return nil
+			}
+			cur = cur.Items
+		case cur.Properties != nil:
+			cur = cur.Properties[key]
+		default:
+			cur = cur.MapValues()
+		}
+		if cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+// secretKeys are the JSON keys whose values picoclaw config show masks.
+var secretKeys = map[string]bool{
+	"api_key":              true,
+	"api_tokens":           true,
+	"token":                true,
+	"access_token":         true,
+	"bot_token":            true,
+	"app_token":            true,
+	"app_secret":           true,
+	"client_secret":        true,
+	"channel_secret":       true,
+	"channel_access_token": true,
+	"encrypt_key":          true,
+	"verification_token":   true,
+	"password":             true,
+	"smtp_password":        true,
+	"secret":               true,
+	"headers":              true, // MCP servers, e.g. Authorization
+	"env":                  true, // MCP servers, e.g. GITHUB_TOKEN
+}
+
+// IsSecret reports whether a config key holds a credential.
+func IsSecret(key string) bool {
+	return secretKeys[key]
+}
+
+// enumValues restricts string settings to the values the code understands.
+// Paths use "*" for map values and "[]" for array items.
+var enumValues = map[string][]string{
+	"storage.backend":                        {"json", "sqlite"},
+	"usage.budget_action":                    {"refuse", "downgrade"},
+	"channels.webhook.mode":                  {"async", "sync"},
+	"tools.exec.sandbox.mode":                {"off", "auto", "namespaces", "bwrap"},
+	"generation.profiles.*.reasoning_effort": {"low", "medium", "high"},
+	"providers.github_copilot.connect_mode":  {"stdio", "grpc"},
+	"mcp.servers.*.transport":                {"stdio", "http", "sse"},
+	"skills.auto_activate":                   {"off", "keywords", "embeddings"},
+}
+
+var flexibleStringSliceType = reflect.TypeOf(FlexibleStringSlice{})
+
+var (
+	schemaOnce sync.Once
+	schema     *Schema
+)
+
+// ConfigSchema returns the JSON Schema of config.json. Defaults are taken
+// from DefaultConfig.
+func ConfigSchema() *Schema {
+	schemaOnce.Do(func() {
+		schema = buildSchema("", reflect.TypeOf(Config{}), reflect.ValueOf(DefaultConfig()).Elem())
+		schema.SchemaURI = "https://json-schema.org/draft/2020-12/schema"
+		schema.Title = "PicoClaw config.json"
+	})
+	return schema
+}
+
+// buildSchema describes t. def holds its default value, if there is one.
+func buildSchema(path string, t reflect.Type, def reflect.Value) *Schema {
+	if t == flexibleStringSliceType {
+		return &Schema{
+			Type:  SchemaType{"array"},
+			Items: &Schema{Type: SchemaType{"string", "number"}},
+		}
+	}
+
+	s := &Schema{}
+	switch t.Kind() {
+	case reflect.Ptr:
+		var elem reflect.Value
+		if def.IsValid() && !def.IsNil() {
+			elem = def.Elem()
+		}
+		s = buildSchema(path, t.Elem(), elem)
+		s.Type = append(s.Type, "null")
+		return s
+	case reflect.Struct:
+		s.Type = SchemaType{"object"}
+		s.Properties = make(map[string]*Schema)
+		s.AdditionalProperties = false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonName(field)
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.Field(i)
+			}
+			prop := buildSchema(joinPath(path, name), field.Type, fieldDef)
+			if envName := field.Tag.Get("env"); envName != "" && !strings.Contains(envName, "{{") {
+				prop.Description = "Environment variable: " + envName
+			}
+			prop.Secret = IsSecret(name)
+			s.Properties[name] = prop
+		}
+		return s
+	case reflect.Map:
+		s.Type = SchemaType{"object"}
+		s.AdditionalProperties = buildSchema(joinPath(path, "*"), t.Elem(), reflect.Value{})
+		return s
+	case reflect.Slice, reflect.Array:
+		s.Type = SchemaType{"array"}
+		s.Items = buildSchema(path+"[]", t.Elem(), reflect.Value{})
+		return s
+	case reflect.String:
+		s.Type = SchemaType{"string"}
+		s.Enum = enumValues[path]
+	case reflect.Bool:
+		s.Type = SchemaType{"boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.Type = SchemaType{"integer"}
+		if isPortKey(path) {
+			s.Minimum, s.Maximum = Float64(0), Float64(65535)
+		}
+	case reflect.Float32, reflect.Float64:
+		s.Type = SchemaType{"number"}
+	}
+	if def.IsValid() && !def.IsZero() {
+		s.Default = def.Interface()
+	}
+	return s
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func isPortKey(path string) bool {
+	key := path[strings.LastIndex(path, ".")+1:]
+	return key == "port" || strings.HasSuffix(key, "_port")
+}
@@ -0,0 +1,745 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/caarlos0/env/v11"
+)
+
+// Severity tells whether an Issue stops the gateway from working as
+// configured.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // a setting the gateway cannot use
+	SeverityWarning Severity = "warning" // probably a mistake, e.g. an unknown key
+)
+
+// Issue is a problem found by ValidateJSON or Config.Validate.
+type Issue struct {
+	Path     string // JSON path, e.g. "channels.telegram.token" or "agents.list[0].name"
+	Message  string
+	Severity Severity
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Path, i.Message)
+}
+
+// HasErrors reports whether issues contains an error.
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFile loads the config at path like LoadConfig and checks it, see
+// ValidateJSON. A missing file yields the default config.
+func ValidateFile(path string) (*Config, []Issue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		cfg := DefaultConfig()
+		if err := env.Parse(cfg); err != nil {
+			return nil, nil, err
+		}
+		return cfg, cfg.Validate(), nil
+	}
+	return ValidateJSON(data)
+}
+
+// ValidateJSON decodes a config.json like LoadConfig and checks it against
+// ConfigSchema and Config.Validate. Unknown keys are warnings; values of
+// the wrong type are errors and leave the default in place. err is only set
+// if data is not JSON at all or an environment override cannot be parsed.
+func ValidateJSON(data []byte) (*Config, []Issue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, nil, syntaxError(data, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, fmt.Errorf("unexpected data after the top-level object at offset %d", dec.InputOffset())
+	}
+
+	issues := checkRaw("", raw, ConfigSchema())
+
+	cfg := DefaultConfig()
+	if err := json.Unmarshal(data, cfg); err != nil && !HasErrors(issues) {
+		var typeErr *json.UnmarshalTypeError
+		path := ""
+		if errors.As(err, &typeErr) {
+			path = typeErr.Field
+		}
+		issues = append(issues, Issue{Path: path, Message: err.Error(), Severity: SeverityError})
+	}
+	if err := env.Parse(cfg); err != nil {
+		return nil, nil, err
+	}
+	return cfg, append(issues, cfg.Validate()...), nil
+}
+
+// syntaxError adds the line and column to a JSON syntax error.
+func syntaxError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return fmt.Errorf("unexpected end of JSON input")
+		}
+		return err
+	}
+	before := data[:syntaxErr.Offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// checkRaw checks a value decoded with UseNumber against s. Nulls are
+// accepted anywhere, json.Unmarshal leaves the default in place for them.
+func checkRaw(path string, value interface{}, s *Schema) []Issue {
+	if value == nil || s == nil {
+		return nil
+	}
+	got := rawType(value)
+	if !s.Type.Has(got) {
+		return []Issue{{
+			Path:     path,
+			Message:  fmt.Sprintf("expected %s, got %s", strings.Join(s.Type, " or "), got),
+			Severity: SeverityError,
+		}}
+	}
+
+	var issues []Issue
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			prop, ok := s.Properties[key]
+			if !ok {
+				prop = s.MapValues()
+			}
+			if prop == nil {
+				issues = append(issues, Issue{
+					Path:     joinPath(path, key),
+					Message:  unknownKeyMessage(key, s.Properties),
+					Severity: SeverityWarning,
+				})
+				continue
+			}
+			issues = append(issues, checkRaw(joinPath(path, key), v[key], prop)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			issues = append(issues, checkRaw(fmt.Sprintf("%s[%d]", path, i), item, s.Items)...)
+		}
+	}
+	return issues
+}
+
+func rawType(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	}
+	return "null"
+}
+
+// unknownKeyMessage suggests the known key closest to a misspelled one.
+func unknownKeyMessage(key string, known map[string]*Schema) string {
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDist := "", 3
+	for _, name := range names {
+		if d := editDistance(key, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" && bestDist <= len(key)/2 {
+		return fmt.Sprintf("unknown key, did you mean %q?", best)
+	}
+	return "unknown key"
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// channelRequirements lists the settings each channel cannot start without.
+var channelRequirements = map[string][]string{
+	"telegram": {"token"},
+	"whatsapp": {"bridge_url"},
+	"feishu":   {"app_id", "app_secret"},
+	"discord":  {"token"},
+	"qq":       {"app_id", "app_secret"},
+	"dingtalk": {"client_id", "client_secret"},
+	"slack":    {"bot_token", "app_token"},
+	"line":     {"channel_secret", "channel_access_token"},
+	"onebot":   {"ws_url"},
+	"matrix":   {"homeserver", "access_token"},
+	"email":    {"imap_host", "username", "password"},
+	"webhook":  {"secret"},
+}
+
+// providerRequirement names the providers section a provider reads and the
+// settings of which at least one must be set for it to be used.
+type providerRequirement struct {
+	section string
+	anyOf   []string
+}
+
+// providerRequirements covers the provider names createProvider knows.
+// CLI-backed providers need no settings.
+var providerRequirements = map[string]providerRequirement{
+	"groq":           {"groq", []string{"api_key"}},
+	"openai":         {"openai", []string{"api_key", "auth_method"}},
+	"gpt":            {"openai", []string{"api_key", "auth_method"}},
+	"anthropic":      {"anthropic", []string{"api_key", "auth_method"}},
+	"claude":         {"anthropic", []string{"api_key", "auth_method"}},
+	"openrouter":     {"openrouter", []string{"api_key"}},
+	"zhipu":          {"zhipu", []string{"api_key"}},
+	"glm":            {"zhipu", []string{"api_key"}},
+	"gemini":         {"gemini", []string{"api_key"}},
+	"google":         {"gemini", []string{"api_key"}},
+	"vllm":           {"vllm", []string{"api_base"}},
+	"shengsuanyun":   {"shengsuanyun", []string{"api_key"}},
+	"deepseek":       {"deepseek", []string{"api_key"}},
+	"github_copilot": {},
+	"copilot":        {},
+	"claude-cli":     {},
+	"claudecode":     {},
+	"claude-code":    {},
+	"codex-cli":      {},
+	"codex-code":     {},
+}
+
+// Validate checks what the schema cannot express: enum values and port
+// ranges after environment overrides, credentials of enabled channels and
+// of the providers in use, port conflicts, workspace and sandbox paths, and
+// references between sections.
+func (c *Config) Validate() []Issue {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	v := &validator{cfg: c}
+	v.checkValues("", reflect.ValueOf(c).Elem(), ConfigSchema())
+	v.checkChannels()
+	v.checkProviders()
+	v.checkAgents()
+	v.checkGeneration()
+	v.checkReasoningEffort()
+	v.checkPorts()
+	v.checkPaths()
+	v.checkTools()
+	v.checkMCP()
+	return v.issues
+}
+
+type validator struct {
+	cfg    *Config
+	issues []Issue
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{Path: path, Message: fmt.Sprintf(format, args...), Severity: SeverityError})
+}
+
+func (v *validator) warnf(path, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{Path: path, Message: fmt.Sprintf(format, args...), Severity: SeverityWarning})
+}
+
+// checkValues enforces the enums and ranges of the schema on a decoded value.
+func (v *validator) checkValues(path string, value reflect.Value, s *Schema) {
+	if s == nil {
+		return
+	}
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			v.checkValues(path, value.Elem(), s)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := jsonName(field)
+			v.checkValues(joinPath(path, name), value.Field(i), s.Properties[name])
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			v.checkValues(joinPath(path, key.String()), value.MapIndex(key), s.MapValues())
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			v.checkValues(fmt.Sprintf("%s[%d]", path, i), value.Index(i), s.Items)
+		}
+	case reflect.String:
+		if str := value.String(); str != "" && len(s.Enum) > 0 && !contains(s.Enum, str) {
+			v.errorf(path, "%q is not one of %s", str, strings.Join(s.Enum, ", "))
+		}
+	case reflect.Int, reflect.Int64:
+		n := float64(value.Int())
+		if (s.Minimum != nil && n < *s.Minimum) || (s.Maximum != nil && n > *s.Maximum) {
+			v.errorf(path, "%d is out of range %g..%g", value.Int(), *s.Minimum, *s.Maximum)
+		}
+	}
+}
+
+func (v *validator) checkChannels() {
+	channels := reflect.ValueOf(v.cfg.Channels)
+	for i := 0; i < channels.NumField(); i++ {
+		name := jsonName(channels.Type().Field(i))
+		channel := channels.Field(i)
+		if enabled, _ := fieldByJSONName(channel, "enabled").Interface().(bool); !enabled {
+			continue
+		}
+		for _, key := range channelRequirements[name] {
+			if fieldByJSONName(channel, key).IsZero() {
+				v.errorf("channels."+name+"."+key, "required when channels.%s is enabled", name)
+			}
+		}
+	}
+}
+
+// checkProviders makes sure every provider an agent, the fallback chain or
+// memory search uses has its credentials.
+func (v *validator) checkProviders() {
+	cfg := v.cfg
+	if cfg.Agents.Defaults.Model == "" {
+		v.errorf("agents.defaults.model", "required")
+	}
+	seen := make(map[string]bool)
+	v.checkProvider("agents.defaults.provider", cfg.Agents.Defaults.Provider, seen)
+	for i, agent := range cfg.Agents.List {
+		v.checkProvider(fmt.Sprintf("agents.list[%d].provider", i), agent.Provider, seen)
+	}
+	for i, target := range cfg.Fallback.Chain {
+		if target.Model == "" {
+			v.errorf(fmt.Sprintf("fallback.chain[%d].model", i), "required")
+		}
+		v.checkProvider(fmt.Sprintf("fallback.chain[%d].provider", i), target.Provider, seen)
+	}
+	if cfg.Memory.EmbeddingModel != "" {
+		v.checkProvider("memory.embedding_provider", cfg.Memory.EmbeddingProvider, seen)
+	}
+}
+
+func (v *validator) checkProvider(path, name string, seen map[string]bool) {
+	if name == "" {
+		// Picked from the model name
+		return
+	}
+	req, ok := providerRequirements[strings.ToLower(name)]
+	if !ok {
+		v.warnf(path, "unknown provider %q, the provider is picked from the model name instead", name)
+		return
+	}
+	if req.section == "" || seen[req.section] {
+		return
+	}
+	seen[req.section] = true
+	settings := fieldByJSONName(reflect.ValueOf(v.cfg.Providers), req.section)
+	for _, key := range req.anyOf {
+		if !fieldByJSONName(settings, key).IsZero() {
+			return
+		}
+	}
+	v.errorf("providers."+req.section+"."+req.anyOf[0], "%s is required by %s %q",
+		strings.Join(req.anyOf, " or "), path, name)
+}
+
+func (v *validator) checkAgents() {
+	agents := v.cfg.Agents
+	names := map[string]bool{DefaultAgentName: true}
+	for i, agent := range agents.List {
+		path := fmt.Sprintf("agents.list[%d].name", i)
+		switch {
+		case agent.Name == "":
+			v.errorf(path, "required")
+		case agent.Name == DefaultAgentName:
+			v.errorf(path, "%q is reserved for agents.defaults", agent.Name)
+		case agent.Name == "." || agent.Name == ".." || strings.ContainsAny(agent.Name, `/\`):
+			v.errorf(path, "%q can't be used as a directory name", agent.Name)
+		case names[agent.Name]:
+			v.errorf(path, "duplicate agent name %q", agent.Name)
+		}
+		names[agent.Name] = true
+	}
+	for i, route := range agents.Routes {
+		path := fmt.Sprintf("agents.routes[%d].agent", i)
+		if route.Agent == "" {
+			v.errorf(path, "required")
+		} else if !names[route.Agent] {
+			v.errorf(path, "unknown agent %q", route.Agent)
+		}
+	}
+}
+
+func (v *validator) checkGeneration() {
+	gen := v.cfg.Generation
+	for _, section := range []struct {
+		name      string
+		selection map[string]string
+	}{
+		{"purposes", gen.Purposes},
+		{"channels", gen.Channels},
+		{"chats", gen.Chats},
+	} {
+		for _, key := range sortedKeys(section.selection) {
+			profile := section.selection[key]
+			if _, ok := gen.Profiles[profile]; !ok && profile != "" {
+				v.errorf("generation."+section.name+"."+key, "unknown profile %q", profile)
+			}
+		}
+	}
+}
+
+// checkReasoningEffort warns about profiles with reasoning_effort selected
+// for calls the Anthropic provider may serve, which drops it. Purpose
+// profiles apply to every agent; channel and chat profiles to the default
+// agent and the agents the channel or chat is routed to.
+func (v *validator) checkReasoningEffort() {
+	gen := v.cfg.Generation
+	reached := make(map[string]string) // profile -> Anthropic agent or fallback it reaches
+	reach := func(profile, user string) {
+		if _, ok := reached[profile]; !ok && user != "" {
+			reached[profile] = user
+		}
+	}
+	for _, purpose := range sortedKeys(gen.Purposes) {
+		reach(gen.Purposes[purpose], v.anthropicUser("", ""))
+	}
+	for _, channel := range sortedKeys(gen.Channels) {
+		reach(gen.Channels[channel], v.anthropicUser(channel, ""))
+	}
+	for _, key := range sortedKeys(gen.Chats) {
+		channel, chatID, _ := strings.Cut(key, ":")
+		reach(gen.Chats[key], v.anthropicUser(channel, chatID))
+	}
+	for _, name := range sortedKeys(reached) {
+		if gen.Profiles[name].ReasoningEffort != "" {
+			v.warnf("generation.profiles."+name+".reasoning_effort", "dropped by the anthropic provider of %s", reached[name])
+		}
+	}
+}
+
+// anthropicUser returns the path of the first agent or fallback target
+// served by the Anthropic provider that may handle a call for channel and
+// chatID, or "" if there is none. An empty channel stands for any call.
+func (v *validator) anthropicUser(channel, chatID string) string {
+	cfg := v.cfg
+	defaults := cfg.Agents.Defaults
+	if isAnthropic(defaults.Provider, defaults.Model) {
+		return "agents.defaults"
+	}
+	for i, agent := range cfg.Agents.List {
+		if channel != "" && !routedTo(cfg.Agents.Routes, agent.Name, channel, chatID) {
+			continue
+		}
+		provider, model := agent.Provider, agent.Model
+		if provider == "" {
+			provider = defaults.Provider
+		}
+		if model == "" {
+			model = defaults.Model
+		}
+		if isAnthropic(provider, model) {
+			return fmt.Sprintf("agents.list[%d]", i)
+		}
+	}
+	for i, target := range cfg.Fallback.Chain {
+		if isAnthropic(target.Provider, target.Model) {
+			return fmt.Sprintf("fallback.chain[%d]", i)
+		}
+	}
+	return ""
+}
+
+// routedTo reports whether a route sends messages of channel, or of chatID
+// in it when set, to agent.
+func routedTo(routes []AgentRoute, agent, channel, chatID string) bool {
+	for _, r := range routes {
+		if r.Agent == agent && (r.Channel == "" || r.Channel == channel) && (r.ChatID == "" || chatID == "" || r.ChatID == chatID) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAnthropic mirrors how createProvider picks the Anthropic provider: by
+// name, or from a claude model name not routed through OpenRouter.
+func isAnthropic(provider, model string) bool {
+	provider = strings.ToLower(provider)
+	if provider == "anthropic" || provider == "claude" {
+		return true
+	}
+	if _, known := providerRequirements[provider]; known {
+		return false
+	}
+	return strings.Contains(strings.ToLower(model), "claude") && !strings.Contains(model, "/")
+}
+
+// checkPorts reports the listeners that would fight over a port. The
+// gateway's health server always listens on gateway.port.
+func (v *validator) checkPorts() {
+	cfg := v.cfg
+	if cfg.Gateway.Port == 0 {
+		v.errorf("gateway.port", "required")
+	}
+
+	listeners := []struct {
+		path    string
+		enabled bool
+		port    int
+	}{
+		{"gateway.port", true, cfg.Gateway.Port},
+		{"channels.maixcam.port", cfg.Channels.MaixCam.Enabled, cfg.Channels.MaixCam.Port},
+		{"channels.line.webhook_port", cfg.Channels.LINE.Enabled, cfg.Channels.LINE.WebhookPort},
+		{"channels.webhook.webhook_port", cfg.Channels.Webhook.Enabled, cfg.Channels.Webhook.WebhookPort},
+	}
+	used := make(map[int]string)
+	for _, l := range listeners {
+		if !l.enabled || l.port == 0 {
+			continue
+		}
+		if other, ok := used[l.port]; ok {
+			v.errorf(l.path, "port %d is already used by %s", l.port, other)
+			continue
+		}
+		used[l.port] = l.path
+	}
+}
+
+func (v *validator) checkPaths() {
+	cfg := v.cfg
+	if cfg.Agents.Defaults.Workspace == "" {
+		v.errorf("agents.defaults.workspace", "required")
+	} else if problem := dirProblem(expandHome(cfg.Agents.Defaults.Workspace), true); problem != "" {
+		v.errorf("agents.defaults.workspace", "%s", problem)
+	}
+	for i, agent := range cfg.Agents.List {
+		if agent.Workspace == "" {
+			continue
+		}
+		if problem := dirProblem(expandHome(agent.Workspace), true); problem != "" {
+			v.errorf(fmt.Sprintf("agents.list[%d].workspace", i), "%s", problem)
+		}
+	}
+
+	for i, path := range cfg.Skills.Registries {
+		if problem := dirProblem(expandHome(path), false); problem != "" {
+			v.warnf(fmt.Sprintf("skills.registries[%d]", i), "%s, the registry is skipped", problem)
+		}
+	}
+
+	sandbox := cfg.Tools.Exec.Sandbox
+	if sandbox.Mode == "" || sandbox.Mode == "off" {
+		return
+	}
+	for i, path := range sandbox.WritablePaths {
+		if problem := dirProblem(expandHome(path), false); problem != "" {
+			v.errorf(fmt.Sprintf("tools.exec.sandbox.writable_paths[%d]", i), "%s", problem)
+		}
+	}
+	if sandbox.CgroupParent != "" {
+		if problem := dirProblem(sandbox.CgroupParent, false); problem != "" {
+			v.errorf("tools.exec.sandbox.cgroup_parent", "%s", problem)
+		}
+	}
+}
+
+func (v *validator) checkTools() {
+	cfg := v.cfg
+	for i, rule := range cfg.Tools.Approval.Rules {
+		if rule.Tool == "" {
+			v.warnf(fmt.Sprintf("tools.approval.rules[%d].tool", i), "missing, the rule is ignored")
+		}
+		if rule.Pattern != "" {
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				v.errorf(fmt.Sprintf("tools.approval.rules[%d].pattern", i), "%v", err)
+			}
+		}
+	}
+	if cfg.Usage.BudgetAction == "downgrade" && cfg.Usage.DowngradeModel == "" {
+		v.warnf("usage.downgrade_model", "missing, budget_action \"downgrade\" refuses instead")
+	}
+	if cfg.Skills.AutoActivate == "embeddings" && cfg.Memory.EmbeddingModel == "" {
+		v.warnf("skills.auto_activate", "\"embeddings\" needs memory.embedding_model, keywords are matched instead")
+	}
+	if cfg.Skills.MaxActive < 0 {
+		v.errorf("skills.max_active", "must not be negative")
+	}
+}
+
+func (v *validator) checkMCP() {
+	names := make([]string, 0, len(v.cfg.MCP.Servers))
+	for name := range v.cfg.MCP.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		server := v.cfg.MCP.Servers[name]
+		path := "mcp.servers." + name
+		if !mcpServerName.MatchString(name) {
+			v.errorf(path, "server names may only contain letters, digits, _ and -")
+		}
+		if server.Disabled {
+			continue
+		}
+		switch {
+		case server.Command == "" && server.URL == "":
+			v.errorf(path, "command or url is required")
+		case server.Command != "" && server.URL != "":
+			v.errorf(path, "set either command or url, not both")
+		case server.Transport == "stdio" && server.Command == "":
+			v.errorf(path+".command", "required by transport \"stdio\"")
+		case (server.Transport == "http" || server.Transport == "sse") && server.URL == "":
+			v.errorf(path+".url", "required by transport %q", server.Transport)
+		}
+	}
+
+	serve := v.cfg.MCP.Serve
+	if serve.Enabled && len(serve.Tools) == 0 {
+		v.warnf("mcp.serve.tools", "empty, no tools are offered")
+	}
+	if serve.Enabled && len(serve.APITokens) == 0 {
+		for i, tool := range serve.Tools {
+			if !contains(MCPOpenTools, tool) {
+				v.errorf(fmt.Sprintf("mcp.serve.tools[%d]", i), "%q is only offered with mcp.serve.api_tokens, without them only %s are",
+					tool, strings.Join(MCPOpenTools, ", "))
+			}
+		}
+	}
+	if serve.Channel != "" {
+		var channel reflect.Value
+		channels := reflect.ValueOf(v.cfg.Channels)
+		for i := 0; i < channels.NumField(); i++ {
+			if jsonName(channels.Type().Field(i)) == serve.Channel {
+				channel = channels.Field(i)
+			}
+		}
+		if !channel.IsValid() {
+			v.errorf("mcp.serve.channel", "unknown channel %q", serve.Channel)
+		} else if enabled, _ := fieldByJSONName(channel, "enabled").Interface().(bool); !enabled {
+			v.warnf("mcp.serve.channel", "channels.%s is not enabled, messages to it will fail", serve.Channel)
+		}
+		if serve.ChatID == "" {
+			v.errorf("mcp.serve.chat_id", "required with mcp.serve.channel")
+		}
+	}
+}
+
+var mcpServerName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// dirProblem explains why dir cannot be used as a directory, or returns ""
+// if it can. With create, a missing dir is fine if it can be created.
+func dirProblem(dir string, create bool) string {
+	info, err := os.Stat(dir)
+	switch {
+	case err == nil && !info.IsDir():
+		return fmt.Sprintf("%s is not a directory", dir)
+	case err == nil:
+		return ""
+	case !os.IsNotExist(err):
+		return err.Error()
+	case !create:
+		return fmt.Sprintf("%s does not exist", dir)
+	}
+
+	for parent := filepath.Dir(dir); ; parent = filepath.Dir(parent) {
+		info, err := os.Stat(parent)
+		switch {
+		case err == nil && !info.IsDir():
+			return fmt.Sprintf("%s cannot be created, %s is not a directory", dir, parent)
+		case err == nil:
+			return ""
+		case !os.IsNotExist(err):
+			return fmt.Sprintf("%s cannot be created: %v", dir, err)
+		}
+		if parent == filepath.Dir(parent) {
+			return ""
+		}
+	}
+}
+
+// fieldByJSONName returns the field of struct value s with the JSON name name.
+func fieldByJSONName(s reflect.Value, name string) reflect.Value {
+	for i := 0; i < s.NumField(); i++ {
+		if jsonName(s.Type().Field(i)) == name {
+			return s.Field(i)
+		}
+	}
+	panic(fmt.Sprintf("config: %s has no field %q", s.Type(), name))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func issuesByPath(issues []Issue) map[string]Issue {
+	byPath := make(map[string]Issue)
+	for _, issue := range issues {
+		byPath[issue.Path] = issue
+	}
+	return byPath
+}
+
+func TestValidateJSON(t *testing.T) {
+	workspace := t.TempDir()
+	notDir := filepath.Join(workspace, "file")
+	os.WriteFile(notDir, nil, 0600)
+
+	data := `{
+		"agents": {
+			"defaults": {"workspace": "` + workspace + `", "provider": "openai", "max_tokens": "lots"},
+			"list": [{"name": "coder", "workspace": "` + notDir + `/sub"}, {"name": "../ops"}],
+			"routes": [{"agent": "writer"}]
+		},
+		"channels": {
+			"telegramm": {"enabled": true},
+			"slack": {"enabled": true, "bot_token": "xoxb"},
+			"line": {"enabled": true, "channel_secret": "s", "channel_access_token": "t", "webhook_port": 18790}
+		},
+		"storage": {"backend": "postgres"},
+		"generation": {"purposes": {"chat": "fast"}},
+		"skills": {"auto_activate": "embeddings", "max_active": -1},
+		"mcp": {"serve": {"enabled": true, "tools": ["memory_search", "exec", "spawn"]}}
+	}`
+	cfg, issues, err := ValidateJSON([]byte(data))
+	if err != nil {
+		t.Fatalf("ValidateJSON: %v", err)
+	}
+	if cfg.Agents.Defaults.MaxTokens != 8192 {
+		t.Errorf("max_tokens = %d, want the default", cfg.Agents.Defaults.MaxTokens)
+	}
+
+	want := map[string]Severity{
+		"agents.defaults.max_tokens":   SeverityError,
+		"agents.list[0].workspace":     SeverityError,
+		"agents.list[1].name":          SeverityError,
+		"agents.list[0].name":          "",
+		"agents.routes[0].agent":       SeverityError,
+		"channels.telegramm":           SeverityWarning,
+		"channels.slack.app_token":     SeverityError,
+		"channels.line.webhook_port":   SeverityError,
+		"providers.openai.api_key":     SeverityError,
+		"storage.backend":              SeverityError,
+		"generation.purposes.chat":     SeverityError,
+		"skills.auto_activate":         SeverityWarning,
+		"skills.max_active":            SeverityError,
+		"mcp.serve.tools[1]":           SeverityError,
+		"mcp.serve.tools[2]":           SeverityError,
+		"mcp.serve.tools[0]":           "",
+		"channels.telegram.token":      "", // not enabled
+		"channels.line.channel_secret": "",
+	}
+	got := issuesByPath(issues)
+	for path, severity := range want {
+		issue, ok := got[path]
+		if severity == "" {
+			if ok {
+				t.Errorf("unexpected issue %s", issue)
+			}
+			continue
+		}
+		if !ok || issue.Severity != severity {
+			t.Errorf("%s: got %+v, want a %s", path, issue, severity)
+		}
+	}
+	if msg := got["channels.telegramm"].Message; !strings.Contains(msg, `"telegram"`) {
+		t.Errorf("unknown key message %q does not suggest telegram", msg)
+	}
+}
+
+func TestValidateJSON_Syntax(t *testing.T) {
+	_, _, err := ValidateJSON([]byte("{\n  \"agents\": {,\n}"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("err = %v, want a line number", err)
+	}
+}
+
+func TestValidate_Default(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+	if issues := cfg.Validate(); len(issues) != 0 {
+		t.Errorf("default config has issues: %v", issues)
+	}
+
+	// MaixCam listens on the gateway port unless moved
+	cfg.Channels.MaixCam.Enabled = true
+	if issues := cfg.Validate(); !HasErrors(issues) {
+		t.Error("port conflict not reported")
+	}
+}
+
+func TestValidate_ReasoningEffort(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+	cfg.Agents.Defaults.Provider = "openai"
+	cfg.Agents.Defaults.Model = "gpt-5.2"
+	cfg.Providers.OpenAI.APIKey = "key"
+	cfg.Providers.Anthropic.APIKey = "key"
+	cfg.Agents.List = []AgentConfig{{Name: "writer", Provider: "anthropic", Model: "claude-sonnet-4-5"}}
+	cfg.Agents.Routes = []AgentRoute{{Agent: "writer", Channel: "slack"}}
+	cfg.Generation.Profiles["deep"] = GenerationProfile{ReasoningEffort: "high"}
+	cfg.Generation.Profiles["unused"] = GenerationProfile{ReasoningEffort: "low"}
+
+	// Telegram never reaches the Anthropic agent
+	cfg.Generation.Channels["telegram"] = "deep"
+	if issues := cfg.Validate(); len(issues) != 0 {
+		t.Errorf("mixed providers config has issues: %v", issues)
+	}
+
+	cfg.Generation.Channels["slack"] = "deep"
+	issue, ok := issuesByPath(cfg.Validate())["generation.profiles.deep.reasoning_effort"]
+	if !ok || issue.Severity != SeverityWarning || !strings.Contains(issue.Message, "agents.list[0]") {
+		t.Errorf("got %+v, want a warning naming agents.list[0]", issue)
+	}
+
+	// A Claude fallback can serve any call
+	delete(cfg.Generation.Channels, "slack")
+	cfg.Fallback.Chain = []FallbackTarget{{Model: "claude-sonnet-4-5"}}
+	issue, ok = issuesByPath(cfg.Validate())["generation.profiles.deep.reasoning_effort"]
+	if !ok || !strings.Contains(issue.Message, "fallback.chain[0]") {
+		t.Errorf("got %+v, want a warning naming fallback.chain[0]", issue)
+	}
+
+	// Routed through OpenRouter, which supports it
+	cfg.Providers.OpenRouter.APIKey = "key"
+	cfg.Fallback.Chain[0].Model = "anthropic/claude-sonnet-4-5"
+	if issues := cfg.Validate(); len(issues) != 0 {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+}
+
+func TestSchemaLookup(t *testing.T) {
+	s := ConfigSchema()
+	for path, wantType := range map[string]string{
+		"channels.telegram.token":                         "string",
+		"agents.list[0].model":                            "string",
+		"usage.prices[gpt-4.1].input_per_million":         "number",
+		"generation.profiles.summarize.temperature":       "number",
+		"channels.telegram.allow_from":                    "array",
+		"tools.exec.sandbox.writable_paths[2]":            "string",
+		"generation.profiles.summarize.reasoning_effort":  "string",
+		"providers.github_copilot.connect_mode":           "string",
+		"agents.defaults.max_tokens":                      "integer",
+		"channels.telegram.tokenn":                        "",
+		"agents.list.model":                               "",
+		"generation.profiles.summarize.reasoning_effortt": "",
+	} {
+		sub := s.Lookup(path)
+		if wantType == "" {
+			if sub != nil {
+				t.Errorf("Lookup(%s) found a setting", path)
+			}
+			continue
+		}
+		if sub == nil || !sub.Type.Has(wantType) {
+			t.Errorf("Lookup(%s) = %+v, want type %s", path, sub, wantType)
+		}
+	}
+	if !s.Lookup("channels.telegram.token").Secret {
+		t.Error("telegram token not marked secret")
+	}
+}
+
+func TestDocument_SetKeepsOrder(t *testing.T) {
+	doc, err := ParseDocument([]byte(`{"zeta": 1, "agents": {"defaults": {"model": "a"}}, "alpha": [1, 2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, value := range map[string]interface{}{
+		"agents.defaults.model":        "b",
+		"agents.list[0]":               ParseValue(`{"name": "x"}`, nil),
+		"alpha[2]":                     ParseValue("3", nil),
+		"usage.prices[gpt-4.1].in":     ParseValue("2.5", nil),
+		"channels.telegram.allow_from": ParseValue(`["1", 2]`, nil),
+	} {
+		if err := doc.Set(path, value); err != nil {
+			t.Fatalf("Set(%s): %v", path, err)
+		}
+	}
+	if err := doc.Set("alpha[7]", "x"); err == nil {
+		t.Error("Set past the end of an array succeeded")
+	}
+
+	data, err := doc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	if strings.Index(text, "zeta") > strings.Index(text, "agents") || strings.Index(text, "agents") > strings.Index(text, "alpha") {
+		t.Errorf("key order lost:\n%s", text)
+	}
+
+	reparsed, _ := ParseDocument(data)
+	for path, want := range map[string]string{
+		"agents.defaults.model":    "b",
+		"agents.list[0].name":      "x",
+		"alpha[2]":                 "3",
+		"usage.prices[gpt-4.1].in": "2.5",
+	} {
+		got, err := reparsed.Get(path)
+		if err != nil {
+			t.Errorf("Get(%s): %v", path, err)
+			continue
+		}
+		if fmt.Sprint(got) != want {
+			t.Errorf("Get(%s) = %v, want %s", path, got, want)
+		}
+	}
+}
+
+func TestDocument_MaskSecrets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Providers.OpenRouter.APIKey = "sk-or-v1-0123456789abcdef"
+	cfg.Channels.Telegram.Token = "short"
+	cfg.Gateway.APITokens = FlexibleStringSlice{"token-one-0123456789"}
+	doc, err := NewDocument(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.MaskSecrets()
+
+	for path, want := range map[string]string{
+		"providers.openrouter.api_key": "****cdef",
+		"channels.telegram.token":      "****",
+		"gateway.api_tokens[0]":        "****6789",
+		"agents.defaults.model":        cfg.Agents.Defaults.Model,
+	} {
+		if got, _ := doc.Get(path); got != want {
+			t.Errorf("%s = %v, want %s", path, got, want)
+		}
+	}
+	if got, _ := doc.Get("agents.defaults.max_tokens"); got == "****" {
+		t.Error("max_tokens masked")
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	str := &Schema{Type: SchemaType{"string"}}
+	if got := ParseValue("12345", str); got != "12345" {
+		t.Errorf("string setting got %#v", got)
+	}
+	if got := ParseValue("true", &Schema{Type: SchemaType{"boolean"}}); got != true {
+		t.Errorf("boolean setting got %#v", got)
+	}
+	if got := ParseValue("not json", nil); got != "not json" {
+		t.Errorf("plain text got %#v", got)
+	}
+}
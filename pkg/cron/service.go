@@ -6,12 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/adhocore/gronx"
+
+	"github.com/sipeed/picoclaw/pkg/storage"
 )
 
 type CronSchedule struct {
@@ -29,6 +29,7 @@ type CronPayload struct {
 	Deliver bool   `json:"deliver"`
 	Channel string `json:"channel,omitempty"`
 	To      string `json:"to,omitempty"`
+	Agent   string `json:"agent,omitempty"` // Agent that created the job, empty for the default agent
 }
 
 type CronJobState struct {
@@ -58,20 +59,26 @@ type CronStore struct {
 type JobHandler func(job *CronJob) (string, error)
 
 type CronService struct {
-	storePath string
-	store     *CronStore
-	onJob     JobHandler
-	mu        sync.RWMutex
-	running   bool
-	stopChan  chan struct{}
-	gronx     *gronx.Gronx
+	docs     storage.DocumentStore
+	store    *CronStore
+	onJob    JobHandler
+	mu       sync.RWMutex
+	running  bool
+	stopChan chan struct{}
+	gronx    *gronx.Gronx
 }
 
 func NewCronService(storePath string, onJob JobHandler) *CronService {
+	return NewCronServiceWithStore(storage.NewFileDocuments(map[string]string{storage.DocCron: storePath}), onJob)
+}
+
+// NewCronServiceWithStore creates a cron service that keeps its jobs in the
+// storage.DocCron document of docs.
+func NewCronServiceWithStore(docs storage.DocumentStore, onJob JobHandler) *CronService {
 	cs := &CronService{
-		storePath: storePath,
-		onJob:     onJob,
-		gronx:     gronx.New(),
+		docs:  docs,
+		onJob: onJob,
+		gronx: gronx.New(),
 	}
 	// Initialize and load store on creation
 	cs.loadStore()
@@ -318,11 +325,8 @@ func (cs *CronService) loadStore() error {
 		Jobs:    []CronJob{},
 	}
 
-	data, err := os.ReadFile(cs.storePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
+	data, err := cs.docs.LoadDocument(storage.DocCron)
+	if err != nil || data == nil {
 		return err
 	}
 
@@ -330,17 +334,12 @@ func (cs *CronService) loadStore() error {
 }
 
 func (cs *CronService) saveStoreUnsafe() error {
-	dir := filepath.Dir(cs.storePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
 	data, err := json.MarshalIndent(cs.store, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(cs.storePath, data, 0600)
+	return cs.docs.SaveDocument(storage.DocCron, data)
 }
 
 func (cs *CronService) AddJob(name string, schedule CronSchedule, message string, deliver bool, channel, to string) (*CronJob, error) {
@@ -11,6 +11,7 @@ import (
 
 type Server struct {
 	server    *http.Server
+	mux       *http.ServeMux
 	mu        sync.RWMutex
 	ready     bool
 	checks    map[string]Check
@@ -33,6 +34,7 @@ type StatusResponse struct {
 func NewServer(host string, port int) *Server {
 	mux := http.NewServeMux()
 	s := &Server{
+		mux:       mux,
 		ready:     false,
 		checks:    make(map[string]Check),
 		startTime: time.Now(),
@@ -52,6 +54,13 @@ func NewServer(host string, port int) *Server {
 	return s
 }
 
+// Handle mounts an additional handler on the server, e.g. the gateway API.
+// Handlers that run longer than the server's 5s write timeout must extend
+// their own deadline.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
 func (s *Server) Start() error {
 	s.mu.Lock()
 	s.ready = true
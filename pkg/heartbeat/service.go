@@ -39,13 +39,23 @@ type HeartbeatService struct {
 	handler   HeartbeatHandler
 	interval  time.Duration
 	enabled   bool
+	started   bool // Between Start and Stop, even while disabled
 	mu        sync.RWMutex
 	stopChan  chan struct{}
 }
 
 // NewHeartbeatService creates a new heartbeat service
 func NewHeartbeatService(workspace string, intervalMinutes int, enabled bool) *HeartbeatService {
-	// Apply minimum interval
+	return &HeartbeatService{
+		workspace: workspace,
+		interval:  normalizeInterval(intervalMinutes),
+		enabled:   enabled,
+		state:     state.NewManager(workspace),
+	}
+}
+
+// normalizeInterval applies the minimum and default interval.
+func normalizeInterval(intervalMinutes int) time.Duration {
 	if intervalMinutes < minIntervalMinutes && intervalMinutes != 0 {
 		intervalMinutes = minIntervalMinutes
 	}
@@ -54,12 +64,43 @@ func NewHeartbeatService(workspace string, intervalMinutes int, enabled bool) *H
 		intervalMinutes = defaultIntervalMinutes
 	}
 
-	return &HeartbeatService{
-		workspace: workspace,
-		interval:  time.Duration(intervalMinutes) * time.Minute,
-		enabled:   enabled,
-		state:     state.NewManager(workspace),
+	return time.Duration(intervalMinutes) * time.Minute
+}
+
+// SetInterval changes how often the heartbeat runs and whether it runs at
+// all. A started service picks up the change right away.
+func (hs *HeartbeatService) SetInterval(intervalMinutes int, enabled bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	interval := normalizeInterval(intervalMinutes)
+	if interval == hs.interval && enabled == hs.enabled {
+		return
+	}
+	hs.interval = interval
+	hs.enabled = enabled
+
+	if hs.stopChan != nil {
+		close(hs.stopChan)
+		hs.stopChan = nil
+	}
+	if hs.started && enabled {
+		hs.stopChan = make(chan struct{})
+		go hs.runLoop(hs.stopChan, interval, false)
 	}
+
+	logger.InfoCF("heartbeat", "Heartbeat settings updated", map[string]any{
+		"enabled":          enabled,
+		"interval_minutes": interval.Minutes(),
+	})
+}
+
+// SetStateManager replaces the state manager the last active channel is
+// read from, so that it matches the agent's storage backend.
+func (hs *HeartbeatService) SetStateManager(sm *state.Manager) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.state = sm
 }
 
 // SetBus sets the message bus for delivering heartbeat results.
@@ -86,13 +127,14 @@ func (hs *HeartbeatService) Start() error {
 		return nil
 	}
 
+	hs.started = true
 	if !hs.enabled {
 		logger.InfoC("heartbeat", "Heartbeat service disabled")
 		return nil
 	}
 
 	hs.stopChan = make(chan struct{})
-	go hs.runLoop(hs.stopChan)
+	go hs.runLoop(hs.stopChan, hs.interval, true)
 
 	logger.InfoCF("heartbeat", "Heartbeat service started", map[string]any{
 		"interval_minutes": hs.interval.Minutes(),
@@ -106,6 +148,7 @@ func (hs *HeartbeatService) Stop() {
 	hs.mu.Lock()
 	defer hs.mu.Unlock()
 
+	hs.started = false
 	if hs.stopChan == nil {
 		return
 	}
@@ -123,14 +166,16 @@ func (hs *HeartbeatService) IsRunning() bool {
 }
 
 // runLoop runs the heartbeat ticker
-func (hs *HeartbeatService) runLoop(stopChan chan struct{}) {
-	ticker := time.NewTicker(hs.interval)
+func (hs *HeartbeatService) runLoop(stopChan chan struct{}, interval time.Duration, runNow bool) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	// Run first heartbeat after initial delay
-	time.AfterFunc(time.Second, func() {
-		hs.executeHeartbeat()
-	})
+	if runNow {
+		time.AfterFunc(time.Second, func() {
+			hs.executeHeartbeat()
+		})
+	}
 
 	for {
 		select {
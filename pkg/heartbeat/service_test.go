@@ -219,3 +219,29 @@ func TestHeartbeatFilePath(t *testing.T) {
 		t.Errorf("Expected HEARTBEAT.md at %s, but it doesn't exist", expectedPath)
 	}
 }
+
+func TestSetInterval(t *testing.T) {
+	hs := NewHeartbeatService(t.TempDir(), 30, false)
+
+	// Not started yet: only the settings change
+	hs.SetInterval(1, true)
+	if hs.IsRunning() || hs.interval != minIntervalMinutes*time.Minute {
+		t.Fatalf("running=%v interval=%v", hs.IsRunning(), hs.interval)
+	}
+
+	hs.SetInterval(10, false)
+	hs.Start()
+	defer hs.Stop()
+	if hs.IsRunning() {
+		t.Fatal("disabled service should not run")
+	}
+
+	hs.SetInterval(10, true)
+	if !hs.IsRunning() || hs.interval != 10*time.Minute {
+		t.Errorf("enabling a started service should run it, interval=%v", hs.interval)
+	}
+	hs.SetInterval(10, false)
+	if hs.IsRunning() {
+		t.Error("disabling should stop the loop")
+	}
+}
@@ -0,0 +1,356 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// DefaultTimeout bounds each request when the server config sets none.
+const DefaultTimeout = 60 * time.Second
+
+// ErrClosed is returned for requests on a connection that has ended.
+var ErrClosed = errors.New("connection closed")
+
+// Options configures Connect.
+type Options struct {
+	// Workspace is the working directory of stdio servers without a dir.
+	Workspace string
+	// ClientInfo is sent to the server in initialize.
+	ClientInfo Implementation
+	// OnNotify receives the server's notifications, e.g.
+	// notifications/tools/list_changed. It must not block.
+	OnNotify func(method string, params json.RawMessage)
+}
+
+// Client is a connection to one MCP server. It is safe for concurrent use.
+type Client struct {
+	name      string
+	transport transport
+	timeout   time.Duration
+	onNotify  func(method string, params json.RawMessage)
+
+	nextID  atomic.Int64
+	mu      sync.Mutex
+	pending map[string]chan *message
+
+	initResult InitializeResult
+}
+
+// Transport returns the transport a server config uses: the configured one,
+// otherwise stdio for a command and streamable HTTP for a URL.
+func Transport(cfg config.MCPServerConfig) string {
+	switch {
+	case cfg.Transport != "":
+		return cfg.Transport
+	case cfg.Command != "":
+		return "stdio"
+	default:
+		return "http"
+	}
+}
+
+// Connect starts or dials the server described by cfg and performs the
+// initialize handshake.
+func Connect(ctx context.Context, name string, cfg config.MCPServerConfig, opts Options) (*Client, error) {
+	c := &Client{
+		name:     name,
+		timeout:  DefaultTimeout,
+		onNotify: opts.OnNotify,
+		pending:  make(map[string]chan *message),
+	}
+	if cfg.Timeout > 0 {
+		c.timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	var err error
+	switch Transport(cfg) {
+	case "stdio":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = opts.Workspace
+		}
+		c.transport, err = startStdio(name, cfg.Command, cfg.Args, cfg.Env, dir, c.receive)
+	case "http":
+		c.transport = newHTTPTransport(cfg.URL, cfg.Headers, c.receive)
+	case "sse":
+		c.transport, err = startSSE(ctx, cfg.URL, cfg.Headers, c.receive)
+	default:
+		err = fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-c.transport.Done()
+		c.failPending()
+	}()
+
+	if err := c.initialize(ctx, opts.ClientInfo); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	return c, nil
+}
+
+func (c *Client) initialize(ctx context.Context, info Implementation) error {
+	params := InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]interface{}{},
+		ClientInfo:      info,
+	}
+	if err := c.call(ctx, "initialize", params, &c.initResult); err != nil {
+		return err
+	}
+	if ht, ok := c.transport.(*httpTransport); ok {
+		ht.initialized(c.initResult.ProtocolVersion)
+	}
+	return c.notify(ctx, "notifications/initialized", nil)
+}
+
+// Server returns what the server said about itself in initialize.
+func (c *Client) Server() InitializeResult {
+	return c.initResult
+}
+
+// Done is closed when the connection ends, e.g. because the server exited.
+func (c *Client) Done() <-chan struct{} {
+	return c.transport.Done()
+}
+
+// Err explains why the connection ended, nil while it is up.
+func (c *Client) Err() error {
+	return c.transport.Err()
+}
+
+func (c *Client) Close() error {
+	return c.transport.Close()
+}
+
+// call sends a request and decodes its result into out, which may be nil.
+func (c *Client) call(ctx context.Context, method string, params, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	id := strconv.FormatInt(c.nextID.Add(1), 10)
+	msg := message{JSONRPC: "2.0", ID: json.RawMessage(id), Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	reply := make(chan *message, 1)
+	c.mu.Lock()
+	c.pending[id] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.transport.Send(ctx, data); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-reply:
+		if resp == nil {
+			return c.closedError()
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, out)
+	case <-ctx.Done():
+		// Tell the server to stop working on it; the reply is dropped
+		c.notify(context.Background(), "notifications/cancelled", map[string]interface{}{
+			"requestId": json.RawMessage(id),
+			"reason":    ctx.Err().Error(),
+		})
+		return fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+}
+
+func (c *Client) notify(ctx context.Context, method string, params interface{}) error {
+	msg := message{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.transport.Send(ctx, data)
+}
+
+func (c *Client) closedError() error {
+	if err := c.transport.Err(); err != nil {
+		return err
+	}
+	return ErrClosed
+}
+
+// failPending ends the requests still waiting when the connection is lost.
+func (c *Client) failPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, reply := range c.pending {
+		reply <- nil
+		delete(c.pending, id)
+	}
+}
+
+// receive handles one message from the server: a response to one of our
+// requests, a notification or a request of the server.
+func (c *Client) receive(data []byte) {
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		logger.WarnCF("mcp", "Invalid message from server", map[string]interface{}{
+			"server": c.name,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	switch {
+	case msg.Method == "" && len(msg.ID) > 0:
+		c.mu.Lock()
+		reply, ok := c.pending[string(msg.ID)]
+		if !ok {
+			// String IDs come back from servers that convert them
+			var s string
+			if json.Unmarshal(msg.ID, &s) == nil {
+				reply, ok = c.pending[s]
+			}
+		}
+		if ok {
+			reply <- &msg
+			delete(c.pending, string(msg.ID))
+		}
+		c.mu.Unlock()
+	case msg.Method != "" && len(msg.ID) == 0:
+		if c.onNotify != nil {
+			c.onNotify(msg.Method, msg.Params)
+		}
+	case msg.Method != "":
+		go c.answer(msg)
+	}
+}
+
+// answer responds to a request of the server. PicoClaw offers no client
+// features such as sampling or roots, so only ping is supported.
+func (c *Client) answer(req message) {
+	resp := message{JSONRPC: "2.0", ID: req.ID}
+	if req.Method == "ping" {
+		resp.Result = json.RawMessage("{}")
+	} else {
+		resp.Error = &RPCError{Code: CodeMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	c.transport.Send(ctx, data)
+}
+
+// ListTools returns all tools of the server, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var all []Tool
+	params := ListParams{}
+	for {
+		var result ListToolsResult
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+		all = append(all, result.Tools...)
+		if result.NextCursor == "" {
+			return all, nil
+		}
+		params.Cursor = result.NextCursor
+	}
+}
+
+// ListResources returns all resources of the server, following pagination.
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	var all []Resource
+	params := ListParams{}
+	for {
+		var result ListResourcesResult
+		if err := c.call(ctx, "resources/list", params, &result); err != nil {
+			return nil, err
+		}
+		all = append(all, result.Resources...)
+		if result.NextCursor == "" {
+			return all, nil
+		}
+		params.Cursor = result.NextCursor
+	}
+}
+
+// ListPrompts returns all prompts of the server, following pagination.
+func (c *Client) ListPrompts(ctx context.Context) ([]Prompt, error) {
+	var all []Prompt
+	params := ListParams{}
+	for {
+		var result ListPromptsResult
+		if err := c.call(ctx, "prompts/list", params, &result); err != nil {
+			return nil, err
+		}
+		all = append(all, result.Prompts...)
+		if result.NextCursor == "" {
+			return all, nil
+		}
+		params.Cursor = result.NextCursor
+	}
+}
+
+// CallTool calls a tool of the server. A tool that fails reports it with
+// IsError in the result rather than an error.
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]interface{}) (*CallToolResult, error) {
+	var result CallToolResult
+	if err := c.call(ctx, "tools/call", CallToolParams{Name: name, Arguments: args}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) ReadResource(ctx context.Context, uri string) (*ReadResourceResult, error) {
+	var result ReadResourceResult
+	if err := c.call(ctx, "resources/read", ReadResourceParams{URI: uri}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) GetPrompt(ctx context.Context, name string, args map[string]string) (*GetPromptResult, error) {
+	var result GetPromptResult
+	if err := c.call(ctx, "prompts/get", GetPromptParams{Name: name, Arguments: args}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,204 @@
+package mcp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+const (
+	maxRequestBytes = 10 << 20
+	// sessionIdle is how long an unused HTTP session is kept.
+	sessionIdle = time.Hour
+)
+
+// HTTPHandler serves a Server over the streamable HTTP transport. Every
+// request is answered with a single JSON response; server-initiated
+// messages are not used.
+type HTTPHandler struct {
+	server *Server
+	tokens []string
+	hosts  []string // accepted besides localhost, see AllowHosts
+
+	mu       sync.Mutex
+	sessions map[string]time.Time // session ID -> last use
+}
+
+// NewHTTPHandler creates the handler. Requests must carry one of tokens as a
+// bearer token; with no tokens only loopback clients are accepted, and the
+// server is limited to config.MCPOpenTools.
+func NewHTTPHandler(server *Server, tokens []string) *HTTPHandler {
+	if len(tokens) == 0 {
+		server.Limit(config.MCPOpenTools...)
+	}
+	return &HTTPHandler{
+		server:   server,
+		tokens:   tokens,
+		sessions: make(map[string]time.Time),
+	}
+}
+
+// AllowHosts accepts requests addressed to hosts, and from web pages on
+// them, besides localhost and loopback addresses. Remote clients need the
+// name or address they reach the server by listed here.
+func (h *HTTPHandler) AllowHosts(hosts []string) {
+	h.hosts = hosts
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		http.Error(w, "invalid or missing API token", http.StatusUnauthorized)
+		return
+	}
+	// A web page must not reach a local server through the browser, also by
+	// rebinding its own domain name to a loopback address
+	if !h.allowedHost(r.Host) {
+		http.Error(w, "host not allowed", http.StatusForbidden)
+		return
+	}
+	if !h.allowedOrigin(r.Header.Get("Origin")) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		h.post(w, r)
+	case http.MethodDelete:
+		h.mu.Lock()
+		delete(h.sessions, r.Header.Get(headerSessionID))
+		h.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		// No stream for server-initiated messages is offered
+		w.Header().Set("Allow", "POST, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *HTTPHandler) post(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var probe struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &probe) == nil && probe.Method == "initialize" {
+		w.Header().Set(headerSessionID, h.newSession())
+	} else if status := h.checkSession(r.Header.Get(headerSessionID)); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	resp := h.server.handle(r.Context(), body)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func (h *HTTPHandler) newSession() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for old, used := range h.sessions {
+		if now.Sub(used) > sessionIdle {
+			delete(h.sessions, old)
+		}
+	}
+	h.sessions[id] = now
+	return id
+}
+
+// checkSession returns the status to refuse a request with, or 0.
+func (h *HTTPHandler) checkSession(id string) int {
+	if id == "" {
+		return http.StatusBadRequest
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.sessions[id]; !ok {
+		// Tells the client to initialize again
+		return http.StatusNotFound
+	}
+	h.sessions[id] = time.Now()
+	return 0
+}
+
+func (h *HTTPHandler) authorized(r *http.Request) bool {
+	if len(h.tokens) == 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	for _, want := range h.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin accepts requests without an Origin header, as sent by
+// non-browser clients, and those from pages on an allowed host.
+func (h *HTTPHandler) allowedOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return h.allowedHost(u.Host)
+}
+
+// allowedHost reports whether hostport names localhost, a loopback address
+// or one of the allowed hosts.
+func (h *HTTPHandler) allowedHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	if host == "" {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, allowed := range h.hosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
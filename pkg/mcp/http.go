@@ -0,0 +1,356 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerSessionID       = "Mcp-Session-Id"
+	headerProtocolVersion = "MCP-Protocol-Version"
+)
+
+// httpTransport implements the streamable HTTP transport: every message is
+// POSTed to the server URL, which answers with JSON or an event stream.
+// Messages the server sends on its own arrive on an optional GET stream.
+type httpTransport struct {
+	doneState
+	url     string
+	headers map[string]string
+	client  *http.Client
+	deliver func([]byte)
+
+	ctx    context.Context // ends the event streams on Close
+	cancel context.CancelFunc
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+	listening       bool
+}
+
+func newHTTPTransport(serverURL string, headers map[string]string, deliver func([]byte)) *httpTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &httpTransport{
+		doneState: newDoneState(),
+		url:       serverURL,
+		headers:   headers,
+		client:    &http.Client{},
+		deliver:   deliver,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (t *httpTransport) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set(headerSessionID, t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set(headerProtocolVersion, t.protocolVersion)
+	}
+	t.mu.Unlock()
+	return req, nil
+}
+
+func (t *httpTransport) Send(ctx context.Context, msg []byte) error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+	}
+
+	req, err := t.newRequest(ctx, http.MethodPost, msg)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if id := resp.Header.Get(headerSessionID); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && t.hasSession():
+		resp.Body.Close()
+		// The server forgot the session, e.g. after a restart; connecting
+		// again starts a new one
+		err := errors.New("session expired")
+		t.finish(err)
+		return err
+	case resp.StatusCode >= 300:
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	case resp.StatusCode == http.StatusAccepted:
+		resp.Body.Close()
+		return nil
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		// Responses, and requests of the server, arrive as events until the
+		// server ends the stream
+		go func() {
+			defer resp.Body.Close()
+			readEvents(resp.Body, func(event, data string) {
+				if event == "" || event == "message" {
+					t.deliver([]byte(data))
+				}
+			})
+		}()
+		return nil
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	body = bytes.TrimSpace(body)
+	switch {
+	case len(body) == 0:
+	case body[0] == '[':
+		deliverBatch(body, t.deliver)
+	default:
+		t.deliver(body)
+	}
+	return nil
+}
+
+func (t *httpTransport) hasSession() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessionID != ""
+}
+
+// initialized records the negotiated protocol version, sent with every
+// later request, and opens the GET stream for server notifications.
+func (t *httpTransport) initialized(protocolVersion string) {
+	t.mu.Lock()
+	t.protocolVersion = protocolVersion
+	start := !t.listening
+	t.listening = true
+	t.mu.Unlock()
+	if start {
+		go t.listen()
+	}
+}
+
+// listen reads the optional GET stream. Servers without one answer 405,
+// then notifications only arrive along with responses.
+func (t *httpTransport) listen() {
+	req, err := t.newRequest(t.ctx, http.MethodGet, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	readEvents(resp.Body, func(event, data string) {
+		if event == "" || event == "message" {
+			t.deliver([]byte(data))
+		}
+	})
+}
+
+// Close ends the session on the server, as far as it supports that.
+func (t *httpTransport) Close() error {
+	if t.hasSession() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if req, err := t.newRequest(ctx, http.MethodDelete, nil); err == nil {
+			if resp, err := t.client.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}
+		cancel()
+	}
+	t.cancel()
+	t.finish(errors.New("connection closed"))
+	return nil
+}
+
+// sseTransport implements the HTTP+SSE transport of protocol revision
+// 2024-11-05, still used by many servers: messages from the server arrive
+// on one long GET event stream, whose first event names the URL to POST
+// messages to.
+type sseTransport struct {
+	doneState
+	headers  map[string]string
+	client   *http.Client
+	endpoint string
+	cancel   context.CancelFunc
+}
+
+func startSSE(ctx context.Context, serverURL string, headers map[string]string, deliver func([]byte)) (*sseTransport, error) {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, serverURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("HTTP %d opening the event stream", resp.StatusCode)
+	}
+
+	t := &sseTransport{doneState: newDoneState(), headers: headers, client: client, cancel: cancel}
+	endpoint := make(chan string, 1)
+	go func() {
+		defer resp.Body.Close()
+		err := readEvents(resp.Body, func(event, data string) {
+			switch event {
+			case "endpoint":
+				select {
+				case endpoint <- data:
+				default:
+				}
+			case "", "message":
+				deliver([]byte(data))
+			}
+		})
+		if err == nil {
+			err = errors.New("event stream ended")
+		}
+		t.finish(err)
+	}()
+
+	select {
+	case path := <-endpoint:
+		base, _ := url.Parse(serverURL)
+		ref, err := url.Parse(path)
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("invalid endpoint %q: %w", path, err)
+		}
+		t.endpoint = base.ResolveReference(ref).String()
+		return t, nil
+	case <-t.done:
+		return nil, t.err
+	case <-ctx.Done():
+		t.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (t *sseTransport) Send(ctx context.Context, msg []byte) error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (t *sseTransport) Close() error {
+	t.cancel()
+	t.finish(errors.New("connection closed"))
+	return nil
+}
+
+// readEvents parses a server-sent event stream, calling fn for each event.
+// It returns when the stream ends.
+func readEvents(r io.Reader, fn func(event, data string)) error {
+	reader := bufio.NewReader(r)
+	var event string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				fn(event, strings.Join(data, "\n"))
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// comment, e.g. a keep-alive
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+	}
+}
+
+// deliverBatch splits a JSON-RPC batch into its messages.
+func deliverBatch(data []byte, deliver func([]byte)) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return
+	}
+	for _, msg := range batch {
+		deliver(msg)
+	}
+}
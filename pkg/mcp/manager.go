@@ -0,0 +1,500 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	// healthyRun is how long a connection must last for the restart
+	// backoff to start over.
+	healthyRun = time.Minute
+	// maxPromptResources caps how many resources a server lists in the
+	// system prompt; the rest are still readable.
+	maxPromptResources = 20
+)
+
+// Manager keeps the configured MCP servers connected, restarting them when
+// they fail, and offers their tools in the tool registries it is attached
+// to. Tools of a server that is down stay registered and report that the
+// server is not connected, so the model sees a stable tool list.
+type Manager struct {
+	workspace string
+	info      Implementation
+
+	mu         sync.Mutex
+	ctx        context.Context
+	servers    map[string]*server
+	registries []*tools.ToolRegistry
+	registered map[string]bool // tool names currently in the registries
+	syncMu     sync.Mutex      // keeps concurrent syncTools calls in order
+}
+
+// NewManager creates a manager whose stdio servers run in workspace unless
+// configured otherwise. version is sent to servers as the client version.
+func NewManager(workspace, version string) *Manager {
+	return &Manager{
+		workspace:  workspace,
+		info:       Implementation{Name: "picoclaw", Version: version},
+		ctx:        context.Background(),
+		servers:    make(map[string]*server),
+		registered: make(map[string]bool),
+	}
+}
+
+// Start connects the enabled servers of cfg. It waits until each server has
+// connected or failed its first attempt, so their tools are available to the
+// first message; failed servers keep retrying in the background until ctx
+// ends or Close is called.
+func (m *Manager) Start(ctx context.Context, cfg config.MCPConfig) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+	m.Update(cfg)
+
+	m.mu.Lock()
+	servers := make([]*server, 0, len(m.servers))
+	for _, s := range m.servers {
+		servers = append(servers, s)
+	}
+	m.mu.Unlock()
+	for _, s := range servers {
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Update applies a changed mcp config: servers that were removed, disabled
+// or changed are stopped, new and changed ones started.
+func (m *Manager) Update(cfg config.MCPConfig) {
+	m.mu.Lock()
+	var stopped []*server
+	for name, s := range m.servers {
+		next, ok := cfg.Servers[name]
+		if !ok || next.Disabled || !reflect.DeepEqual(next, s.cfg) {
+			stopped = append(stopped, s)
+			delete(m.servers, name)
+		}
+	}
+	for name, serverCfg := range cfg.Servers {
+		if _, running := m.servers[name]; running || serverCfg.Disabled {
+			continue
+		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		s := newServer(m, name, serverCfg, cancel)
+		m.servers[name] = s
+		go s.run(ctx)
+	}
+	m.mu.Unlock()
+
+	for _, s := range stopped {
+		s.stop()
+	}
+	if len(stopped) > 0 {
+		m.syncTools()
+	}
+}
+
+// Attach registers the servers' tools in registry and keeps them in sync as
+// servers come, go and change their tool lists.
+func (m *Manager) Attach(registry *tools.ToolRegistry) {
+	m.mu.Lock()
+	m.registries = append(m.registries, registry)
+	m.mu.Unlock()
+	m.syncTools()
+}
+
+// Close stops all servers.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	servers := m.servers
+	m.servers = make(map[string]*server)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *server) {
+			defer wg.Done()
+			s.stop()
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (m *Manager) server(name string) (*server, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.servers[name]
+	return s, ok
+}
+
+// sortedServers returns the servers ordered by name.
+func (m *Manager) sortedServers() []*server {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	servers := make([]*server, 0, len(m.servers))
+	for _, s := range m.servers {
+		servers = append(servers, s)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].name < servers[j].name })
+	return servers
+}
+
+// syncTools brings the attached registries up to date with the servers'
+// current tools.
+func (m *Manager) syncTools() {
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+
+	want := make(map[string]tools.Tool)
+	hasResources, hasPrompts := false, false
+	for _, s := range m.sortedServers() {
+		s.mu.RLock()
+		for _, t := range s.tools {
+			want[t.Name()] = t
+		}
+		hasResources = hasResources || len(s.resources) > 0
+		hasPrompts = hasPrompts || len(s.prompts) > 0
+		s.mu.RUnlock()
+	}
+	if hasResources {
+		want[readResourceToolName] = &readResourceTool{manager: m}
+	}
+	if hasPrompts {
+		want[getPromptToolName] = &getPromptTool{manager: m}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.registered {
+		if _, ok := want[name]; !ok {
+			for _, r := range m.registries {
+				r.Unregister(name)
+			}
+			delete(m.registered, name)
+		}
+	}
+	for name, t := range want {
+		for _, r := range m.registries {
+			r.Register(t)
+		}
+		m.registered[name] = true
+	}
+}
+
+// PromptSection describes the servers for the system prompt: their
+// instructions, resources and prompts. It is empty without servers.
+func (m *Manager) PromptSection() string {
+	servers := m.sortedServers()
+	if len(servers) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# MCP Servers\n\n")
+	sb.WriteString("Tools named mcp_<server>_<tool> are provided by these Model Context Protocol servers.\n")
+	for _, s := range servers {
+		s.mu.RLock()
+		fmt.Fprintf(&sb, "\n## %s\n", s.name)
+		if s.client == nil {
+			sb.WriteString("\nNot connected, its tools will fail until it is back.\n")
+		}
+		if s.instructions != "" {
+			fmt.Fprintf(&sb, "\n%s\n", strings.TrimSpace(s.instructions))
+		}
+		if len(s.resources) > 0 {
+			fmt.Fprintf(&sb, "\nResources, read them with %s:\n", readResourceToolName)
+			for i, r := range s.resources {
+				if i == maxPromptResources {
+					fmt.Fprintf(&sb, "- ... and %d more\n", len(s.resources)-i)
+					break
+				}
+				fmt.Fprintf(&sb, "- %s", r.URI)
+				if label := firstNonEmpty(r.Title, r.Name); label != "" && label != r.URI {
+					fmt.Fprintf(&sb, " (%s)", label)
+				}
+				if r.Description != "" {
+					fmt.Fprintf(&sb, ": %s", r.Description)
+				}
+				sb.WriteString("\n")
+			}
+		}
+		if len(s.prompts) > 0 {
+			fmt.Fprintf(&sb, "\nPrompts, fetch them with %s:\n", getPromptToolName)
+			for _, p := range s.prompts {
+				args := make([]string, 0, len(p.Arguments))
+				for _, a := range p.Arguments {
+					if a.Required {
+						args = append(args, a.Name)
+					} else {
+						args = append(args, a.Name+"?")
+					}
+				}
+				fmt.Fprintf(&sb, "- %s(%s)", p.Name, strings.Join(args, ", "))
+				if p.Description != "" {
+					fmt.Fprintf(&sb, ": %s", p.Description)
+				}
+				sb.WriteString("\n")
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// server supervises the connection to one configured server.
+type server struct {
+	manager *Manager
+	name    string
+	cfg     config.MCPServerConfig
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	ready   chan struct{} // closed after the first connection attempt
+	refresh chan struct{} // a list changed on the server
+
+	mu           sync.RWMutex
+	client       *Client // nil while disconnected
+	tools        map[string]*proxyTool
+	resources    []Resource
+	prompts      []Prompt
+	instructions string
+}
+
+func newServer(m *Manager, name string, cfg config.MCPServerConfig, cancel context.CancelFunc) *server {
+	return &server{
+		manager: m,
+		name:    name,
+		cfg:     cfg,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}),
+		refresh: make(chan struct{}, 1),
+	}
+}
+
+func (s *server) stop() {
+	s.cancel()
+	<-s.done
+}
+
+func (s *server) timeout() time.Duration {
+	if s.cfg.Timeout > 0 {
+		return time.Duration(s.cfg.Timeout) * time.Second
+	}
+	return DefaultTimeout
+}
+
+// run connects to the server and reconnects with exponential backoff
+// whenever the connection fails, until ctx ends or stop is called.
+func (s *server) run(ctx context.Context) {
+	defer close(s.done)
+
+	backoff := minBackoff
+	first := true
+	for {
+		started := time.Now()
+		err := s.connect(ctx)
+		if first {
+			close(s.ready)
+			first = false
+		}
+		if err == nil {
+			err = s.serve(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > healthyRun {
+			backoff = minBackoff
+		}
+		logger.WarnCF("mcp", "Server disconnected, restarting", map[string]interface{}{
+			"server": s.name,
+			"error":  err.Error(),
+			"retry":  backoff.String(),
+		})
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (s *server) connect(ctx context.Context) error {
+	connectCtx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	client, err := Connect(connectCtx, s.name, s.cfg, Options{
+		Workspace:  s.manager.workspace,
+		ClientInfo: s.manager.info,
+		OnNotify:   s.notified,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.client = client
+	s.instructions = client.Server().Instructions
+	s.mu.Unlock()
+	s.load(ctx)
+
+	info := client.Server().ServerInfo
+	s.mu.RLock()
+	logger.InfoCF("mcp", "Server connected", map[string]interface{}{
+		"server":    s.name,
+		"name":      info.Name,
+		"version":   info.Version,
+		"tools":     len(s.tools),
+		"resources": len(s.resources),
+		"prompts":   len(s.prompts),
+	})
+	s.mu.RUnlock()
+	return nil
+}
+
+// serve waits for the connection to end, reloading the server's lists when
+// it announces changes.
+func (s *server) serve(ctx context.Context) error {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+	defer func() {
+		s.mu.Lock()
+		s.client = nil
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-client.Done():
+			return client.Err()
+		case <-s.refresh:
+			s.load(ctx)
+		case <-ctx.Done():
+			client.Close()
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *server) notified(method string, params json.RawMessage) {
+	switch method {
+	case "notifications/tools/list_changed", "notifications/resources/list_changed", "notifications/prompts/list_changed":
+		select {
+		case s.refresh <- struct{}{}:
+		default:
+		}
+	case "notifications/message":
+		logger.DebugCF("mcp", "Server log", map[string]interface{}{
+			"server": s.name,
+			"params": string(params),
+		})
+	}
+}
+
+// load fetches the server's tools, resources and prompts and updates the
+// registries. Lists the server does not offer stay empty.
+func (s *server) load(ctx context.Context) {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+	if client == nil {
+		return
+	}
+	caps := client.Server().Capabilities
+
+	var serverTools []Tool
+	var resources []Resource
+	var prompts []Prompt
+	var err error
+	if caps.Tools != nil {
+		if serverTools, err = client.ListTools(ctx); err != nil {
+			s.listFailed("tools", err)
+		}
+	}
+	if caps.Resources != nil {
+		if resources, err = client.ListResources(ctx); err != nil {
+			s.listFailed("resources", err)
+		}
+	}
+	if caps.Prompts != nil {
+		if prompts, err = client.ListPrompts(ctx); err != nil {
+			s.listFailed("prompts", err)
+		}
+	}
+
+	allowed := make(map[string]bool, len(s.cfg.Tools))
+	for _, name := range s.cfg.Tools {
+		allowed[name] = true
+	}
+	proxies := make(map[string]*proxyTool, len(serverTools))
+	for _, t := range serverTools {
+		if len(allowed) > 0 && !allowed[t.Name] {
+			continue
+		}
+		proxy := newProxyTool(s, t)
+		if _, dup := proxies[proxy.Name()]; dup {
+			logger.WarnCF("mcp", "Tool name collides with another tool of the server, skipped", map[string]interface{}{
+				"server": s.name,
+				"tool":   t.Name,
+			})
+			continue
+		}
+		proxies[proxy.Name()] = proxy
+	}
+
+	s.mu.Lock()
+	s.tools = proxies
+	s.resources = resources
+	s.prompts = prompts
+	s.mu.Unlock()
+	s.manager.syncTools()
+}
+
+func (s *server) listFailed(list string, err error) {
+	logger.WarnCF("mcp", "Failed to list "+list, map[string]interface{}{
+		"server": s.name,
+		"error":  err.Error(),
+	})
+}
+
+// connected returns the client, or an error while the server is down.
+func (s *server) connected() (*Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.client == nil {
+		return nil, fmt.Errorf("MCP server %q is not connected, it is being restarted", s.name)
+	}
+	return s.client, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,320 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// TestMain runs the test binary as a fake stdio server when re-executed
+// with MCP_TEST_SERVER set.
+func TestMain(m *testing.M) {
+	if os.Getenv("MCP_TEST_SERVER") != "" {
+		runFakeServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runFakeServer() {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		if reply := fakeReply(line); reply != nil {
+			os.Stdout.Write(append(reply, '\n'))
+		}
+	}
+}
+
+// fakeReply answers one request of the fake server, nil for notifications.
+func fakeReply(data []byte) []byte {
+	var req message
+	if err := json.Unmarshal(data, &req); err != nil || len(req.ID) == 0 {
+		return nil
+	}
+
+	var result interface{}
+	switch req.Method {
+	case "initialize":
+		result = InitializeResult{
+			ProtocolVersion: ProtocolVersion,
+			Capabilities: ServerCapabilities{
+				Tools:     &Capability{ListChanged: true},
+				Resources: &Capability{},
+				Prompts:   &Capability{},
+			},
+			ServerInfo:   Implementation{Name: "fake", Version: "1.0"},
+			Instructions: "Echo repeats its input.",
+		}
+	case "tools/list":
+		readOnly := true
+		result = ListToolsResult{Tools: []Tool{
+			{Name: "echo", Description: "Repeat text", Annotations: &ToolAnnotations{ReadOnlyHint: &readOnly},
+				InputSchema: map[string]interface{}{"type": "object", "properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}}}},
+			{Name: "crash", Description: "Exit the server"},
+			{Name: "fail", Description: "Always fail"},
+		}}
+	case "tools/call":
+		var params CallToolParams
+		json.Unmarshal(req.Params, &params)
+		switch params.Name {
+		case "echo":
+			result = CallToolResult{Content: []Content{TextContent(fmt.Sprint(params.Arguments["text"]))}}
+		case "crash":
+			os.Exit(3)
+		default:
+			result = CallToolResult{Content: []Content{TextContent("it broke")}, IsError: true}
+		}
+	case "resources/list":
+		result = ListResourcesResult{Resources: []Resource{{URI: "file:///notes.txt", Name: "notes"}}}
+	case "resources/read":
+		result = ReadResourceResult{Contents: []ResourceContents{{URI: "file:///notes.txt", Text: "buy milk"}}}
+	case "prompts/list":
+		result = ListPromptsResult{Prompts: []Prompt{{Name: "review", Arguments: []PromptArgument{{Name: "file", Required: true}}}}}
+	case "prompts/get":
+		var params GetPromptParams
+		json.Unmarshal(req.Params, &params)
+		result = GetPromptResult{Messages: []PromptMessage{{Role: "user", Content: TextContent("Review " + params.Arguments["file"])}}}
+	default:
+		reply, _ := json.Marshal(message{JSONRPC: "2.0", ID: req.ID, Error: &RPCError{Code: CodeMethodNotFound, Message: "no " + req.Method}})
+		return reply
+	}
+	encoded, _ := json.Marshal(result)
+	reply, _ := json.Marshal(message{JSONRPC: "2.0", ID: req.ID, Result: encoded})
+	return reply
+}
+
+func stdioServerConfig(t *testing.T) config.MCPServerConfig {
+	return config.MCPServerConfig{
+		Command: os.Args[0],
+		Env:     map[string]string{"MCP_TEST_SERVER": "1"},
+		Dir:     t.TempDir(),
+		Timeout: 10,
+	}
+}
+
+func execTool(t *testing.T, registry *tools.ToolRegistry, name string, args map[string]interface{}) *tools.ToolResult {
+	t.Helper()
+	tool, ok := registry.Get(name)
+	if !ok {
+		t.Fatalf("tool %s not registered, have %v", name, registry.List())
+	}
+	return tool.Execute(context.Background(), args)
+}
+
+func TestManager_Stdio(t *testing.T) {
+	registry := tools.NewToolRegistry()
+	m := NewManager(t.TempDir(), "test")
+	m.Attach(registry)
+	m.Start(context.Background(), config.MCPConfig{Servers: map[string]config.MCPServerConfig{
+		"fake": stdioServerConfig(t),
+	}})
+	defer m.Close()
+
+	if got := execTool(t, registry, "mcp_fake_echo", map[string]interface{}{"text": "hello"}); got.IsError || got.ForLLM != "hello" {
+		t.Errorf("echo = %+v", got)
+	}
+	long := strings.Repeat("x", tools.MaxOutputLength+100)
+	if got := execTool(t, registry, "mcp_fake_echo", map[string]interface{}{"text": long}); !strings.HasSuffix(got.ForLLM, "(truncated, 100 more chars)") {
+		t.Errorf("long echo not truncated, got %d chars", len(got.ForLLM))
+	}
+	if got := execTool(t, registry, "mcp_fake_fail", nil); !got.IsError || got.ForLLM != "it broke" {
+		t.Errorf("fail = %+v", got)
+	}
+	if got := execTool(t, registry, readResourceToolName, map[string]interface{}{"server": "fake", "uri": "file:///notes.txt"}); got.ForLLM != "buy milk" {
+		t.Errorf("read resource = %+v", got)
+	}
+	got := execTool(t, registry, getPromptToolName, map[string]interface{}{"server": "fake", "name": "review", "arguments": map[string]interface{}{"file": "main.go"}})
+	if !strings.Contains(got.ForLLM, "Review main.go") {
+		t.Errorf("get prompt = %+v", got)
+	}
+
+	section := m.PromptSection()
+	for _, want := range []string{"## fake", "Echo repeats its input.", "file:///notes.txt (notes)", "review(file)"} {
+		if !strings.Contains(section, want) {
+			t.Errorf("prompt section lacks %q:\n%s", want, section)
+		}
+	}
+
+	// The tools stay registered while the server restarts, then work again
+	if got := execTool(t, registry, "mcp_fake_crash", nil); !got.IsError {
+		t.Errorf("crash = %+v", got)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := execTool(t, registry, "mcp_fake_echo", map[string]interface{}{"text": "again"})
+		if got.ForLLM == "again" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server not restarted, echo = %+v", got)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Removing the server removes its tools
+	m.Update(config.MCPConfig{})
+	if _, ok := registry.Get("mcp_fake_echo"); ok {
+		t.Error("tools of a removed server still registered")
+	}
+	if _, ok := registry.Get(readResourceToolName); ok {
+		t.Error("mcp_read_resource registered without servers")
+	}
+}
+
+// TestStdioTransport_SendHonorsContext verifies that a server not reading
+// its input can't block senders past their context
+func TestStdioTransport_SendHonorsContext(t *testing.T) {
+	transport, err := startStdio("stuck", "sleep", []string{"30"}, nil, t.TempDir(), func([]byte) {})
+	if err != nil {
+		t.Skipf("sleep unavailable: %v", err)
+	}
+	defer transport.cmd.Process.Kill()
+
+	// More than a pipe buffer, so the write blocks
+	msg := []byte(strings.Repeat("x", 1<<20))
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		err := transport.Send(ctx, msg)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 2*time.Second {
+			t.Fatalf("send %d = %v after %v", i, err, time.Since(start))
+		}
+	}
+}
+
+func TestManager_ToolAllowlist(t *testing.T) {
+	cfg := stdioServerConfig(t)
+	cfg.Tools = []string{"echo"}
+	registry := tools.NewToolRegistry()
+	m := NewManager(t.TempDir(), "test")
+	m.Attach(registry)
+	m.Start(context.Background(), config.MCPConfig{Servers: map[string]config.MCPServerConfig{"fake": cfg}})
+	defer m.Close()
+
+	if _, ok := registry.Get("mcp_fake_echo"); !ok {
+		t.Error("allowed tool not registered")
+	}
+	if _, ok := registry.Get("mcp_fake_crash"); ok {
+		t.Error("tool outside the allowlist registered")
+	}
+	tool, _ := registry.Get("mcp_fake_echo")
+	if pt, ok := tool.(tools.ParallelSafeTool); !ok || !pt.ParallelSafe() {
+		t.Error("read-only tool not parallel safe")
+	}
+}
+
+// newFakeHTTPServer serves the fake server over streamable HTTP. Calls of
+// echo are answered with an event stream, everything else with JSON.
+func newFakeHTTPServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req message
+		json.Unmarshal(body, &req)
+		if req.Method == "initialize" {
+			w.Header().Set(headerSessionID, "session-1")
+		} else if r.Header.Get(headerSessionID) != "session-1" {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+
+		reply := fakeReply(body)
+		switch {
+		case reply == nil:
+			w.WriteHeader(http.StatusAccepted)
+		case strings.Contains(string(body), `"echo"`):
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, ": keep-alive\n\nevent: message\ndata: %s\n\n", reply)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(reply)
+		}
+	}))
+}
+
+func TestClient_HTTP(t *testing.T) {
+	srv := newFakeHTTPServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := Connect(ctx, "remote", config.MCPServerConfig{
+		URL:     srv.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	}, Options{ClientInfo: Implementation{Name: "test", Version: "1"}})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+
+	if got := client.Server().ServerInfo.Name; got != "fake" {
+		t.Errorf("server name = %q", got)
+	}
+	list, err := client.ListTools(ctx)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("ListTools = %v, %v", list, err)
+	}
+	result, err := client.CallTool(ctx, "echo", map[string]interface{}{"text": "over sse"})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	if got := resultText(result.Content, result.StructuredContent); got != "over sse" {
+		t.Errorf("echo = %q", got)
+	}
+	if _, err := client.GetPrompt(ctx, "review", nil); err != nil {
+		t.Errorf("GetPrompt: %v", err)
+	}
+	var rpcErr *RPCError
+	if err := client.call(ctx, "unknown/method", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != CodeMethodNotFound {
+		t.Errorf("unknown method err = %v", err)
+	}
+}
+
+func TestToolName(t *testing.T) {
+	if got := ToolName("git hub", "search.issues"); got != "mcp_git_hub_search_issues" {
+		t.Errorf("ToolName = %q", got)
+	}
+	long := ToolName("server", strings.Repeat("x", 80))
+	other := ToolName("server", strings.Repeat("x", 79)+"y")
+	if len(long) != maxToolNameLength || long == other {
+		t.Errorf("long names %q and %q not shortened uniquely", long, other)
+	}
+}
+
+func TestResultText(t *testing.T) {
+	content := []Content{
+		TextContent("done"),
+		{Type: "image", MimeType: "image/png", Data: "aGVsbG8="},
+		{Type: "resource", Resource: &ResourceContents{URI: "file:///a", Text: "inline"}},
+	}
+	want := "done\n[image: image/png, 8 bytes base64]\ninline"
+	if got := resultText(content, nil); got != want {
+		t.Errorf("resultText = %q, want %q", got, want)
+	}
+	if got := resultText(nil, map[string]int{"n": 1}); got != `{"n":1}` {
+		t.Errorf("structured only = %q", got)
+	}
+}
@@ -0,0 +1,286 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// supportedVersions are the protocol revisions the server accepts from
+// clients; others get ProtocolVersion.
+var supportedVersions = map[string]bool{
+	"2024-11-05": true,
+	"2025-03-26": true,
+	"2025-06-18": true,
+}
+
+// Server offers the tools of a tools.ToolRegistry to MCP clients. It serves
+// stdio with ServeStdio and streamable HTTP with NewHTTPHandler.
+type Server struct {
+	registry *tools.ToolRegistry
+	allowed  map[string]bool
+	limit    map[string]bool // nil leaves the allowlist as is, see Limit
+	info     Implementation
+
+	// Tool calls without their own execution context deliver messages and
+	// reminders here.
+	channel string
+	chatID  string
+}
+
+// NewServer creates a server for the named tools of registry. An empty
+// allowlist offers no tools.
+func NewServer(registry *tools.ToolRegistry, allow []string, version string) *Server {
+	return &Server{
+		registry: registry,
+		allowed:  toSet(allow),
+		info:     Implementation{Name: "picoclaw", Version: version},
+	}
+}
+
+func toSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// SetDefaultChat sets where message and cron deliver when a call does not
+// name a chat.
+func (s *Server) SetDefaultChat(channel, chatID string) {
+	s.channel = channel
+	s.chatID = chatID
+}
+
+// Limit stops offering the tools not named, even if the allowlist has them.
+func (s *Server) Limit(names ...string) {
+	s.limit = toSet(names)
+}
+
+// offers reports whether the named tool is offered to clients.
+func (s *Server) offers(name string) bool {
+	return s.allowed[name] && (s.limit == nil || s.limit[name])
+}
+
+// tools returns the offered tools, sorted by name.
+func (s *Server) tools() []Tool {
+	names := s.registry.List()
+	sort.Strings(names)
+	list := make([]Tool, 0, len(names))
+	for _, name := range names {
+		if !s.offers(name) {
+			continue
+		}
+		tool, ok := s.registry.Get(name)
+		if !ok {
+			continue
+		}
+		list = append(list, Tool{
+			Name:        name,
+			Description: tool.Description(),
+			InputSchema: tool.Parameters(),
+		})
+	}
+	return list
+}
+
+// handle processes one message and returns the response, or nil for
+// notifications and responses.
+func (s *Server) handle(ctx context.Context, data []byte) []byte {
+	var req message
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errorResponse(json.RawMessage("null"), CodeParseError, "parse error: "+err.Error())
+	}
+	if len(req.ID) == 0 || req.Method == "" {
+		return nil
+	}
+
+	result, rpcErr := s.dispatch(ctx, req)
+	if rpcErr != nil {
+		return errorResponse(req.ID, rpcErr.Code, rpcErr.Message)
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, CodeInternalError, err.Error())
+	}
+	resp, _ := json.Marshal(message{JSONRPC: "2.0", ID: req.ID, Result: encoded})
+	return resp
+}
+
+func (s *Server) dispatch(ctx context.Context, req message) (interface{}, *RPCError) {
+	switch req.Method {
+	case "initialize":
+		var params InitializeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: err.Error()}
+		}
+		version := ProtocolVersion
+		if supportedVersions[params.ProtocolVersion] {
+			version = params.ProtocolVersion
+		}
+		logger.InfoCF("mcp", "Client connected", map[string]interface{}{
+			"client":  params.ClientInfo.Name,
+			"version": params.ClientInfo.Version,
+		})
+		return InitializeResult{
+			ProtocolVersion: version,
+			Capabilities:    ServerCapabilities{Tools: &Capability{}},
+			ServerInfo:      s.info,
+		}, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return ListToolsResult{Tools: s.tools()}, nil
+	case "tools/call":
+		var params CallToolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: err.Error()}
+		}
+		return s.callTool(ctx, params)
+	default:
+		return nil, &RPCError{Code: CodeMethodNotFound, Message: "method not found: " + req.Method}
+	}
+}
+
+func (s *Server) callTool(ctx context.Context, params CallToolParams) (interface{}, *RPCError) {
+	if _, ok := s.registry.Get(params.Name); !ok || !s.offers(params.Name) {
+		return nil, &RPCError{Code: CodeInvalidParams, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+	}
+	args := params.Arguments
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	result := s.registry.ExecuteWithContext(ctx, params.Name, args, s.channel, s.chatID, nil)
+	text := result.ForLLM
+	if text == "" {
+		text = result.ForUser
+	}
+	return CallToolResult{Content: []Content{TextContent(text)}, IsError: result.IsError}, nil
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) []byte {
+	resp, _ := json.Marshal(message{JSONRPC: "2.0", ID: id, Error: &RPCError{Code: code, Message: msg}})
+	return resp
+}
+
+// ServeStdio serves newline delimited messages from in, writing responses
+// to out, until in ends or ctx is cancelled. Requests run concurrently so a
+// slow tool does not hold up the others.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var pendingMu sync.Mutex
+	pending := make(map[string]context.CancelFunc)
+
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var msg message
+			json.Unmarshal(line, &msg)
+			if msg.Method == "notifications/cancelled" {
+				var params struct {
+					RequestID json.RawMessage `json:"requestId"`
+				}
+				json.Unmarshal(msg.Params, &params)
+				pendingMu.Lock()
+				if cancelCall, ok := pending[string(params.RequestID)]; ok {
+					cancelCall()
+				}
+				pendingMu.Unlock()
+				continue
+			}
+
+			callCtx, cancelCall := context.WithCancel(ctx)
+			id := string(msg.ID)
+			if id != "" {
+				pendingMu.Lock()
+				pending[id] = cancelCall
+				pendingMu.Unlock()
+			}
+			wg.Add(1)
+			go func(data []byte) {
+				defer wg.Done()
+				defer cancelCall()
+				resp := s.handle(callCtx, data)
+				if id != "" {
+					pendingMu.Lock()
+					delete(pending, id)
+					pendingMu.Unlock()
+				}
+				if resp == nil {
+					return
+				}
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				out.Write(append(resp, '\n'))
+			}(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Relay serves stdio by forwarding every message to the streamable HTTP
+// server at serverURL, e.g. the gateway's /mcp endpoint, and writing its
+// replies to out. It returns when in ends or ctx is cancelled.
+func Relay(ctx context.Context, in io.Reader, out io.Writer, serverURL string, headers map[string]string) error {
+	var writeMu sync.Mutex
+	write := func(data []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		out.Write(append(data, '\n'))
+	}
+	t := newHTTPTransport(serverURL, headers, write)
+	defer t.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			wg.Add(1)
+			go func(data []byte) {
+				defer wg.Done()
+				if err := t.Send(ctx, data); err != nil {
+					// Fail the request rather than leave the client waiting
+					var msg message
+					if json.Unmarshal(data, &msg) == nil && len(msg.ID) > 0 && msg.Method != "" {
+						write(errorResponse(msg.ID, CodeInternalError, err.Error()))
+					}
+				}
+			}(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
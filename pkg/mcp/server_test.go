@@ -0,0 +1,296 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// whereTool reports the chat a call runs for.
+type whereTool struct{ name string }
+
+func (t *whereTool) Name() string        { return t.name }
+func (t *whereTool) Description() string { return "Report the chat" }
+func (t *whereTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+}
+
+func (t *whereTool) Execute(ctx context.Context, args map[string]interface{}) *tools.ToolResult {
+	if ec := tools.ExecutionContextFrom(ctx); ec != nil {
+		return tools.NewToolResult(fmt.Sprintf("%s:%s", ec.Channel, ec.ChatID))
+	}
+	return tools.ErrorResult("no chat")
+}
+
+func newTestServer() *Server {
+	registry := tools.NewToolRegistry()
+	registry.Register(&whereTool{name: "message"})
+	registry.Register(&whereTool{name: "hidden"})
+	server := NewServer(registry, []string{"message"}, "test")
+	server.SetDefaultChat("telegram", "42")
+	return server
+}
+
+func TestServer_HTTP(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(newTestServer(), nil))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := Connect(ctx, "picoclaw", config.MCPServerConfig{URL: srv.URL}, Options{})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+
+	list, err := client.ListTools(ctx)
+	if err != nil || len(list) != 1 || list[0].Name != "message" {
+		t.Fatalf("ListTools = %+v, %v", list, err)
+	}
+	result, err := client.CallTool(ctx, "message", nil)
+	if err != nil || result.IsError || result.Content[0].Text != "telegram:42" {
+		t.Errorf("CallTool = %+v, %v", result, err)
+	}
+	if _, err := client.CallTool(ctx, "hidden", nil); err == nil {
+		t.Error("tool outside the allowlist was called")
+	}
+
+	// Requests need the session from initialize
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("request without session: status %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign origin: status %d", resp.StatusCode)
+	}
+
+	// A page that rebinds its domain to 127.0.0.1 sends its own Host and Origin
+	req, _ = http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	req.Host = "evil.example"
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("rebound host: status %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPHandler_AllowedHosts(t *testing.T) {
+	h := NewHTTPHandler(newTestServer(), nil)
+	h.AllowHosts([]string{"picoclaw.lan"})
+	for host, want := range map[string]bool{
+		"localhost:18790":   true,
+		"127.0.0.1:18790":   true,
+		"[::1]:18790":       true,
+		"picoclaw.lan":      true,
+		"PicoClaw.lan:8931": true,
+		"evil.example":      false,
+		"10.0.0.1:18790":    false,
+		"":                  false,
+	} {
+		if got := h.allowedHost(host); got != want {
+			t.Errorf("allowedHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+	for origin, want := range map[string]bool{
+		"":                          true,
+		"http://localhost:3000":     true,
+		"https://picoclaw.lan":      true,
+		"http://evil.example":       false,
+		"http://localhost.evil.com": false,
+		"null":                      false,
+	} {
+		if got := h.allowedOrigin(origin); got != want {
+			t.Errorf("allowedOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestServer_Limit(t *testing.T) {
+	registry := tools.NewToolRegistry()
+	registry.Register(&whereTool{name: "message"})
+	registry.Register(&whereTool{name: "exec"})
+	if list := NewServer(registry, nil, "test").tools(); len(list) != 0 {
+		t.Errorf("empty allowlist offers %+v", list)
+	}
+
+	server := NewServer(registry, []string{"message", "exec"}, "test")
+	server.Limit("message")
+	if list := server.tools(); len(list) != 1 || list[0].Name != "message" {
+		t.Errorf("tools = %+v", list)
+	}
+	if _, rpcErr := server.callTool(context.Background(), CallToolParams{Name: "exec"}); rpcErr == nil {
+		t.Error("tool outside the limit was called")
+	}
+}
+
+func TestHTTPHandler_NoTokensOffersOpenTools(t *testing.T) {
+	workspace := t.TempDir()
+	subagents := tools.NewSubagentManager(nil, "test-model", workspace, nil)
+	skillTool, err := tools.NewCustomTool(tools.CustomToolSpec{Name: "deploy", Description: "Deploy the site", Command: tools.CommandTemplate{"echo", "deployed"}}, workspace, workspace, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := tools.NewToolRegistry()
+	for _, tool := range []tools.Tool{
+		tools.NewExecTool(workspace, false),
+		tools.NewSpawnTool(subagents),
+		tools.NewSubagentTool(subagents),
+		tools.NewWriteFileTool(workspace, false),
+		tools.NewReadFileTool(workspace, false),
+		skillTool,
+		&whereTool{name: "message"},
+	} {
+		registry.Register(tool)
+	}
+	server := NewServer(registry, registry.List(), "test")
+	srv := httptest.NewServer(NewHTTPHandler(server, nil))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := Connect(ctx, "picoclaw", config.MCPServerConfig{URL: srv.URL}, Options{})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+	list, err := client.ListTools(ctx)
+	if err != nil || len(list) != 1 || list[0].Name != "message" {
+		t.Errorf("ListTools = %+v, %v; want only message", list, err)
+	}
+	if _, err := client.CallTool(ctx, "deploy", nil); err == nil {
+		t.Error("skill command tool was called without a token")
+	}
+}
+
+func TestServer_HTTPTokens(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(newTestServer(), []string{"secret"}))
+	defer srv.Close()
+
+	if _, err := Connect(context.Background(), "picoclaw", config.MCPServerConfig{URL: srv.URL}, Options{}); err == nil {
+		t.Error("connected without a token")
+	}
+	client, err := Connect(context.Background(), "picoclaw", config.MCPServerConfig{
+		URL:     srv.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	}, Options{})
+	if err != nil {
+		t.Fatalf("Connect with token: %v", err)
+	}
+	client.Close()
+}
+
+func TestServer_Stdio(t *testing.T) {
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"ide","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"message"}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/list"}`,
+		`not json`,
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	if err := newTestServer().ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatalf("ServeStdio: %v", err)
+	}
+
+	replies := make(map[string]message)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid reply %q", line)
+		}
+		replies[string(msg.ID)] = msg
+	}
+	if len(replies) != 4 {
+		t.Fatalf("got %d replies, want 4:\n%s", len(replies), out.String())
+	}
+
+	var init InitializeResult
+	json.Unmarshal(replies["1"].Result, &init)
+	if init.ProtocolVersion != "2024-11-05" || init.ServerInfo.Name != "picoclaw" {
+		t.Errorf("initialize = %+v", init)
+	}
+	var call CallToolResult
+	json.Unmarshal(replies["2"].Result, &call)
+	if len(call.Content) != 1 || call.Content[0].Text != "telegram:42" {
+		t.Errorf("tools/call = %+v", call)
+	}
+	if e := replies["3"].Error; e == nil || e.Code != CodeMethodNotFound {
+		t.Errorf("resources/list error = %+v", e)
+	}
+	if e := replies["null"].Error; e == nil || e.Code != CodeParseError {
+		t.Errorf("parse error = %+v", e)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(newTestServer(), nil))
+	defer srv.Close()
+
+	// The relay sends messages concurrently, so the client waits for the
+	// initialize reply before going on, as real clients do
+	inReader, inWriter := io.Pipe()
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() { done <- Relay(context.Background(), inReader, &out, srv.URL, nil) }()
+
+	inWriter.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"ide","version":"1"}}}` + "\n"))
+	out.waitFor(t, `"id":1`)
+	inWriter.Write([]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"message"}}` + "\n"))
+	out.waitFor(t, "telegram:42")
+	inWriter.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Relay: %v", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for a writer and a polling reader.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) waitFor(t *testing.T, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		found := strings.Contains(b.buf.String(), text)
+		b.mu.Unlock()
+		if found {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("output lacks %q", text)
+}
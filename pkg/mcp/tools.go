@@ -0,0 +1,277 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+const (
+	readResourceToolName = "mcp_read_resource"
+	getPromptToolName    = "mcp_get_prompt"
+	// maxToolNameLength is the longest function name LLM APIs accept.
+	maxToolNameLength = 64
+)
+
+var unsafeToolChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// ToolName returns the name a server's tool is registered under:
+// mcp_<server>_<tool>, with characters LLM APIs reject replaced and long
+// names shortened with a hash that keeps them unique.
+func ToolName(server, tool string) string {
+	name := unsafeToolChars.ReplaceAllString("mcp_"+server+"_"+tool, "_")
+	if len(name) <= maxToolNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+	return name[:maxToolNameLength-len(suffix)] + suffix
+}
+
+// proxyTool offers one tool of a server in a tools.ToolRegistry.
+type proxyTool struct {
+	server *server
+	tool   Tool
+	name   string
+}
+
+func newProxyTool(s *server, t Tool) *proxyTool {
+	return &proxyTool{server: s, tool: t, name: ToolName(s.name, t.Name)}
+}
+
+func (t *proxyTool) Name() string {
+	return t.name
+}
+
+func (t *proxyTool) Description() string {
+	desc := firstNonEmpty(t.tool.Description, t.tool.Title, t.tool.Name)
+	return fmt.Sprintf("%s (tool %s of MCP server %s)", strings.TrimSpace(desc), t.tool.Name, t.server.name)
+}
+
+func (t *proxyTool) Parameters() map[string]interface{} {
+	schema := make(map[string]interface{}, len(t.tool.InputSchema)+2)
+	for k, v := range t.tool.InputSchema {
+		schema[k] = v
+	}
+	// Some providers refuse object schemas without properties
+	schema["type"] = "object"
+	if _, ok := schema["properties"]; !ok {
+		schema["properties"] = map[string]interface{}{}
+	}
+	return schema
+}
+
+// ParallelSafe trusts the server's read-only hint; other tools may have
+// side effects and run one at a time.
+func (t *proxyTool) ParallelSafe() bool {
+	a := t.tool.Annotations
+	return a != nil && a.ReadOnlyHint != nil && *a.ReadOnlyHint
+}
+
+func (t *proxyTool) Execute(ctx context.Context, args map[string]interface{}) *tools.ToolResult {
+	client, err := t.server.connected()
+	if err != nil {
+		return tools.ErrorResult(err.Error()).WithError(err)
+	}
+	result, err := client.CallTool(ctx, t.tool.Name, args)
+	if err != nil {
+		return tools.ErrorResult(fmt.Sprintf("%s failed: %v", t.name, err)).WithError(err)
+	}
+	text := tools.TruncateOutput(resultText(result.Content, result.StructuredContent))
+	if result.IsError {
+		return tools.ErrorResult(text).WithError(fmt.Errorf("%s: %s", t.name, text))
+	}
+	return tools.NewToolResult(text)
+}
+
+// resultText renders tool result content for the model. Binary content is
+// described rather than included.
+func resultText(content []Content, structured interface{}) string {
+	var parts []string
+	for _, c := range content {
+		switch c.Type {
+		case "text":
+			parts = append(parts, c.Text)
+		case "image", "audio":
+			parts = append(parts, fmt.Sprintf("[%s: %s, %d bytes base64]", c.Type, c.MimeType, len(c.Data)))
+		case "resource":
+			if c.Resource != nil {
+				parts = append(parts, resourceText(*c.Resource))
+			}
+		case "resource_link":
+			parts = append(parts, fmt.Sprintf("[resource %s: %s]", firstNonEmpty(c.Name, c.URI), c.URI))
+		default:
+			parts = append(parts, fmt.Sprintf("[unsupported %s content]", c.Type))
+		}
+	}
+	if len(parts) == 0 && structured != nil {
+		if data, err := json.Marshal(structured); err == nil {
+			parts = append(parts, string(data))
+		}
+	}
+	if len(parts) == 0 {
+		return "(no output)"
+	}
+	return strings.Join(parts, "\n")
+}
+
+func resourceText(r ResourceContents) string {
+	if r.Blob != "" && r.Text == "" {
+		return fmt.Sprintf("[binary resource %s: %s, %d bytes base64]", r.URI, r.MimeType, len(r.Blob))
+	}
+	return r.Text
+}
+
+// readResourceTool reads a resource of any server.
+type readResourceTool struct {
+	manager *Manager
+}
+
+func (t *readResourceTool) Name() string {
+	return readResourceToolName
+}
+
+func (t *readResourceTool) Description() string {
+	return "Read a resource offered by an MCP server, e.g. a file or a database record listed in the MCP Servers section"
+}
+
+func (t *readResourceTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"server": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of the MCP server",
+			},
+			"uri": map[string]interface{}{
+				"type":        "string",
+				"description": "URI of the resource",
+			},
+		},
+		"required": []string{"server", "uri"},
+	}
+}
+
+func (t *readResourceTool) ParallelSafe() bool {
+	return true
+}
+
+func (t *readResourceTool) Execute(ctx context.Context, args map[string]interface{}) *tools.ToolResult {
+	uri, _ := args["uri"].(string)
+	if uri == "" {
+		return tools.ErrorResult("uri is required")
+	}
+	client, errResult := t.manager.client(args)
+	if errResult != nil {
+		return errResult
+	}
+	result, err := client.ReadResource(ctx, uri)
+	if err != nil {
+		return tools.ErrorResult(fmt.Sprintf("reading %s failed: %v", uri, err)).WithError(err)
+	}
+	parts := make([]string, 0, len(result.Contents))
+	for _, c := range result.Contents {
+		parts = append(parts, resourceText(c))
+	}
+	if len(parts) == 0 {
+		return tools.NewToolResult("(empty resource)")
+	}
+	return tools.NewToolResult(tools.TruncateOutput(strings.Join(parts, "\n")))
+}
+
+// getPromptTool fetches a prompt template of any server.
+type getPromptTool struct {
+	manager *Manager
+}
+
+func (t *getPromptTool) Name() string {
+	return getPromptToolName
+}
+
+func (t *getPromptTool) Description() string {
+	return "Get a prompt offered by an MCP server, listed in the MCP Servers section, filled in with its arguments"
+}
+
+func (t *getPromptTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"server": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of the MCP server",
+			},
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of the prompt",
+			},
+			"arguments": map[string]interface{}{
+				"type":                 "object",
+				"description":          "Prompt arguments",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+		},
+		"required": []string{"server", "name"},
+	}
+}
+
+func (t *getPromptTool) ParallelSafe() bool {
+	return true
+}
+
+func (t *getPromptTool) Execute(ctx context.Context, args map[string]interface{}) *tools.ToolResult {
+	name, _ := args["name"].(string)
+	if name == "" {
+		return tools.ErrorResult("name is required")
+	}
+	client, errResult := t.manager.client(args)
+	if errResult != nil {
+		return errResult
+	}
+	promptArgs := make(map[string]string)
+	if raw, ok := args["arguments"].(map[string]interface{}); ok {
+		for k, v := range raw {
+			if s, ok := v.(string); ok {
+				promptArgs[k] = s
+			} else {
+				promptArgs[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	result, err := client.GetPrompt(ctx, name, promptArgs)
+	if err != nil {
+		return tools.ErrorResult(fmt.Sprintf("getting prompt %s failed: %v", name, err)).WithError(err)
+	}
+
+	var sb strings.Builder
+	if result.Description != "" {
+		fmt.Fprintf(&sb, "%s\n\n", result.Description)
+	}
+	for _, msg := range result.Messages {
+		fmt.Fprintf(&sb, "[%s]\n%s\n\n", msg.Role, resultText([]Content{msg.Content}, nil))
+	}
+	return tools.NewToolResult(strings.TrimRight(sb.String(), "\n"))
+}
+
+// client returns the connected client of the server named in args.
+func (m *Manager) client(args map[string]interface{}) (*Client, *tools.ToolResult) {
+	name, _ := args["server"].(string)
+	s, ok := m.server(name)
+	if !ok {
+		names := make([]string, 0)
+		for _, s := range m.sortedServers() {
+			names = append(names, s.name)
+		}
+		return nil, tools.ErrorResult(fmt.Sprintf("unknown MCP server %q, configured servers: %s", name, strings.Join(names, ", ")))
+	}
+	client, err := s.connected()
+	if err != nil {
+		return nil, tools.ErrorResult(err.Error()).WithError(err)
+	}
+	return client, nil
+}
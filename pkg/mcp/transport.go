@@ -0,0 +1,208 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// transport carries JSON-RPC messages between a Client and a server.
+// Incoming messages are passed to the deliver function given to the
+// constructor.
+type transport interface {
+	// Send delivers one message to the server.
+	Send(ctx context.Context, msg []byte) error
+	// Done is closed when the connection is lost or closed.
+	Done() <-chan struct{}
+	// Err explains why Done was closed.
+	Err() error
+	Close() error
+}
+
+// doneState implements Done and Err for the transports.
+type doneState struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newDoneState() doneState {
+	return doneState{done: make(chan struct{})}
+}
+
+func (d *doneState) finish(err error) {
+	d.once.Do(func() {
+		d.err = err
+		close(d.done)
+	})
+}
+
+func (d *doneState) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *doneState) Err() error {
+	select {
+	case <-d.done:
+		return d.err
+	default:
+		return nil
+	}
+}
+
+// stderrLines is how much of a server's stderr is kept to explain a crash.
+const stderrLines = 5
+
+// stdioTransport runs a server as a child process speaking newline
+// delimited JSON-RPC on stdin and stdout.
+type stdioTransport struct {
+	doneState
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// writing holds a token while a message is written to stdin, so
+	// messages don't interleave
+	writing chan struct{}
+
+	stderrMu   sync.Mutex
+	stderrTail []string
+}
+
+func startStdio(name, command string, args []string, env map[string]string, dir string, deliver func([]byte)) (*stdioTransport, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	t := &stdioTransport{doneState: newDoneState(), name: name, cmd: cmd, stdin: stdin, writing: make(chan struct{}, 1)}
+	stderrDone := make(chan struct{})
+	go func() {
+		t.readStderr(stderr)
+		close(stderrDone)
+	}()
+	go func() {
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				deliver(line)
+			}
+			if err != nil {
+				break
+			}
+		}
+		// Wait closes the pipes, let the last words on stderr arrive first
+		select {
+		case <-stderrDone:
+		case <-time.After(time.Second):
+		}
+		t.finish(t.exitError(cmd.Wait()))
+	}()
+	return t, nil
+}
+
+func (t *stdioTransport) readStderr(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		logger.DebugCF("mcp", "Server stderr", map[string]interface{}{
+			"server": t.name,
+			"line":   line,
+		})
+		t.stderrMu.Lock()
+		t.stderrTail = append(t.stderrTail, line)
+		if len(t.stderrTail) > stderrLines {
+			t.stderrTail = t.stderrTail[1:]
+		}
+		t.stderrMu.Unlock()
+	}
+}
+
+// exitError describes how the process ended, with the end of its stderr.
+func (t *stdioTransport) exitError(err error) error {
+	if err == nil {
+		err = errors.New("server exited")
+	} else {
+		err = fmt.Errorf("server exited: %w", err)
+	}
+	t.stderrMu.Lock()
+	defer t.stderrMu.Unlock()
+	if len(t.stderrTail) > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.Join(t.stderrTail, " | "))
+	}
+	return err
+}
+
+// Send writes msg to the server's stdin. It gives up when ctx is done, also
+// while a server that doesn't read its input blocks the write; the write
+// then finishes in the background.
+func (t *stdioTransport) Send(ctx context.Context, msg []byte) error {
+	select {
+	case t.writing <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return t.Err()
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := t.stdin.Write(append(msg, '\n'))
+		<-t.writing
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return t.Err()
+	}
+}
+
+// Close closes stdin, which tells the server to exit and fails a pending
+// write, and kills the server if it does not exit within a few seconds.
+func (t *stdioTransport) Close() error {
+	t.stdin.Close()
+	select {
+	case <-t.done:
+		return nil
+	case <-time.After(3 * time.Second):
+	}
+	t.cmd.Process.Kill()
+	select {
+	case <-t.done:
+	case <-time.After(time.Second):
+		// A child of the server may still hold stdout open
+		t.finish(errors.New("server killed"))
+	}
+	return nil
+}
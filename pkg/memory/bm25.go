@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopwords are common English words that carry no meaning for retrieval.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "do": true, "for": true, "from": true, "has": true,
+	"have": true, "i": true, "in": true, "is": true, "it": true, "me": true,
+	"my": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true,
+	"where": true, "which": true, "who": true, "with": true, "you": true,
+}
+
+// tokenize lowercases text and splits it into words. Han, Hiragana,
+// Katakana and Hangul characters are not separated by spaces, so each one is
+// a token of its own.
+func tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			if w := word.String(); !stopwords[w] {
+				tokens = append(tokens, w)
+			}
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// bm25Index scores documents against a query with Okapi BM25.
+type bm25Index struct {
+	docs  []map[string]int // term frequencies per document
+	lens  []int
+	df    map[string]int
+	avgdl float64
+}
+
+func newBM25Index(texts []string) *bm25Index {
+	idx := &bm25Index{
+		docs: make([]map[string]int, len(texts)),
+		lens: make([]int, len(texts)),
+		df:   make(map[string]int),
+	}
+	total := 0
+	for i, text := range texts {
+		tf := make(map[string]int)
+		tokens := tokenize(text)
+		for _, tok := range tokens {
+			tf[tok]++
+		}
+		for tok := range tf {
+			idx.df[tok]++
+		}
+		idx.docs[i] = tf
+		idx.lens[i] = len(tokens)
+		total += len(tokens)
+	}
+	if len(texts) > 0 {
+		idx.avgdl = float64(total) / float64(len(texts))
+	}
+	return idx
+}
+
+// scores returns the BM25 score of every document for query.
+func (idx *bm25Index) scores(query string) []float64 {
+	scores := make([]float64, len(idx.docs))
+	if idx.avgdl == 0 {
+		return scores
+	}
+	n := float64(len(idx.docs))
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		df := float64(idx.df[term])
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for i, tf := range idx.docs {
+			f := float64(tf[term])
+			if f == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(idx.lens[i])/idx.avgdl
+			scores[i] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+	return scores
+}
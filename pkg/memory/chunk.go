@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"strings"
+)
+
+// maxChunkChars is the size above which a section is split into several
+// snippets, at paragraph boundaries.
+const maxChunkChars = 800
+
+// chunk is a snippet of a memory file or session summary.
+type chunk struct {
+	source  string
+	heading string
+	text    string
+}
+
+// indexText is the text BM25 and embeddings see: the headings give context
+// to short snippets.
+func (c chunk) indexText() string {
+	if c.heading == "" {
+		return c.text
+	}
+	return c.heading + "\n" + c.text
+}
+
+// chunkMarkdown splits a markdown document into snippets: one per section,
+// with long sections split at paragraph boundaries.
+func chunkMarkdown(source, content string) []chunk {
+	var chunks []chunk
+	var headings []string // by level, headings[0] is the "#" heading
+	var paragraphs []string
+	var para []string
+	inFence := false
+
+	heading := func() string {
+		var parts []string
+		for _, h := range headings {
+			if h != "" {
+				parts = append(parts, h)
+			}
+		}
+		return strings.Join(parts, " > ")
+	}
+	endParagraph := func() {
+		if text := strings.TrimSpace(strings.Join(para, "\n")); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+		para = nil
+	}
+	endSection := func() {
+		endParagraph()
+		var current strings.Builder
+		for _, p := range paragraphs {
+			if current.Len() > 0 && current.Len()+len(p) > maxChunkChars {
+				chunks = append(chunks, chunk{source: source, heading: heading(), text: current.String()})
+				current.Reset()
+			}
+			if current.Len() > 0 {
+				current.WriteString("\n\n")
+			}
+			current.WriteString(p)
+		}
+		if current.Len() > 0 {
+			chunks = append(chunks, chunk{source: source, heading: heading(), text: current.String()})
+		}
+		paragraphs = nil
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+		}
+		if !inFence {
+			if level, title := parseHeading(trimmed); level > 0 {
+				endSection()
+				for len(headings) < level {
+					headings = append(headings, "")
+				}
+				headings = append(headings[:level-1], title)
+				continue
+			}
+			if trimmed == "" {
+				endParagraph()
+				continue
+			}
+		}
+		para = append(para, line)
+	}
+	endSection()
+	return chunks
+}
+
+// parseHeading returns the level and title of an ATX heading line, or 0.
+func parseHeading(line string) (int, string) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ') {
+		return 0, ""
+	}
+	return level, strings.TrimSpace(line[level:])
+}
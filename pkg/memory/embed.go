@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// embedBatchSize is the number of snippets sent per embedding request.
+const embedBatchSize = 64
+
+// vectorCache keeps the embeddings of snippets on disk, keyed by the hash of
+// their text, so that only new or edited snippets are embedded again.
+type vectorCache struct {
+	path    string
+	model   string
+	loaded  bool
+	vectors map[string][]float32
+}
+
+type vectorCacheFile struct {
+	Model   string               `json:"model"`
+	Vectors map[string][]float32 `json:"vectors"`
+}
+
+func newVectorCache(path, model string) *vectorCache {
+	return &vectorCache{path: path, model: model, vectors: make(map[string][]float32)}
+}
+
+func (vc *vectorCache) load() {
+	vc.loaded = true
+	data, err := os.ReadFile(vc.path)
+	if err != nil {
+		return
+	}
+	var f vectorCacheFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Model != vc.model {
+		// Unreadable, or embeddings of another model: start over
+		return
+	}
+	if f.Vectors != nil {
+		vc.vectors = f.Vectors
+	}
+}
+
+func (vc *vectorCache) save() error {
+	data, err := json.Marshal(vectorCacheFile{Model: vc.model, Vectors: vc.vectors})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(vc.path), 0755); err != nil {
+		return err
+	}
+	tmp := vc.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, vc.path)
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// semanticScores returns the cosine similarity of every chunk to query,
+// embedding the chunks that are not cached yet. idx.mu must not be held, it
+// is only taken to read and update the cache.
+func (idx *Index) semanticScores(ctx context.Context, chunks []chunk, query string) ([]float64, error) {
+	vc := idx.vectors
+
+	hashes := make([]string, len(chunks))
+	var missing []int
+	idx.mu.Lock()
+	if !vc.loaded {
+		vc.load()
+	}
+	for i, c := range chunks {
+		hashes[i] = textHash(c.indexText())
+		if _, ok := vc.vectors[hashes[i]]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	idx.mu.Unlock()
+
+	embedded := make(map[string][]float32, len(missing))
+	for start := 0; start < len(missing); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		texts := make([]string, 0, end-start)
+		for _, i := range missing[start:end] {
+			texts = append(texts, chunks[i].indexText())
+		}
+		vectors, err := idx.opts.Embedder.Embed(ctx, texts, vc.model)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(texts) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+		}
+		for j, i := range missing[start:end] {
+			embedded[hashes[i]] = vectors[j]
+		}
+	}
+
+	queryVectors, err := idx.opts.Embedder.Embed(ctx, []string{query}, vc.model)
+	if err != nil {
+		return nil, err
+	}
+	if len(queryVectors) != 1 {
+		return nil, fmt.Errorf("expected 1 embedding, got %d", len(queryVectors))
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for h, v := range embedded {
+		vc.vectors[h] = v
+	}
+	scores := make([]float64, len(chunks))
+	for i, h := range hashes {
+		scores[i] = cosine(queryVectors[0], vc.vectors[h])
+	}
+
+	if len(embedded) > 0 || len(vc.vectors) > len(idx.chunks) {
+		// Drop the embeddings of snippets that no longer exist
+		current := make(map[string]bool, len(idx.chunks))
+		for _, c := range idx.chunks {
+			current[textHash(c.indexText())] = true
+		}
+		for h := range vc.vectors {
+			if !current[h] {
+				delete(vc.vectors, h)
+			}
+		}
+		if err := vc.save(); err != nil {
+			logger.WarnCF("memory", "Failed to save embedding cache",
+				map[string]interface{}{"error": err.Error()})
+		}
+	}
+	return scores, nil
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,352 @@
+// Package memory indexes the agent's memory files (memory/**/*.md) and session
+// summaries so that only the snippets relevant to a query are retrieved.
+// Ranking uses BM25, combined with embeddings when an embedding provider is
+// configured.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Targets of Save.
+const (
+	KindLongTerm = "long_term" // memory/MEMORY.md
+	KindDaily    = "daily"     // today's note, memory/YYYYMM/YYYYMMDD.md
+)
+
+// SummarySourcePrefix prefixes the source of snippets taken from session
+// summaries, followed by the session key.
+const SummarySourcePrefix = "session:"
+
+// Options configures an Index.
+type Options struct {
+	// Summaries returns the session summaries to index, keyed by session key.
+	Summaries func() map[string]string
+
+	// Embedder and EmbeddingModel enable semantic search. Without them, or
+	// when an embedding request fails, results are ranked by BM25 alone.
+	Embedder       providers.EmbeddingProvider
+	EmbeddingModel string
+}
+
+// Result is a snippet returned by Search.
+type Result struct {
+	Source  string // path relative to the workspace, or "session:<key>"
+	Heading string // enclosing markdown headings, outermost first
+	Text    string
+	Score   float64 // BM25 score, or the fused rank score with embeddings
+}
+
+// Title returns the source and heading of the snippet.
+func (r Result) Title() string {
+	if r.Heading == "" {
+		return r.Source
+	}
+	return r.Source + " › " + r.Heading
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	chunks  []chunk
+}
+
+// Index is a search index over a workspace's memory. It picks up changes to
+// the files on the next search.
+type Index struct {
+	workspace string
+	dir       string
+	opts      Options
+
+	mu        sync.Mutex
+	files     map[string]*fileState
+	summaries map[string]string
+	chunks    []chunk
+	bm25      *bm25Index
+	vectors   *vectorCache
+}
+
+// NewIndex creates the index for the memory directory of workspace.
+func NewIndex(workspace string, opts Options) *Index {
+	dir := filepath.Join(workspace, "memory")
+	os.MkdirAll(dir, 0755)
+
+	idx := &Index{
+		workspace: workspace,
+		dir:       dir,
+		opts:      opts,
+		files:     make(map[string]*fileState),
+	}
+	if opts.Embedder != nil && opts.EmbeddingModel != "" {
+		idx.vectors = newVectorCache(filepath.Join(dir, ".index", "embeddings.json"), opts.EmbeddingModel)
+	}
+	return idx
+}
+
+// Dir returns the memory directory.
+func (idx *Index) Dir() string {
+	return idx.dir
+}
+
+// DailyNotePath returns the path of the daily note for day in memoryDir.
+func DailyNotePath(memoryDir string, day time.Time) string {
+	date := day.Format("20060102") // YYYYMMDD
+	return filepath.Join(memoryDir, date[:6], date+".md")
+}
+
+// Search returns the k snippets most relevant to query, best first. The
+// memory files are shared by all sessions, but of the session summaries only
+// the one of sessionKey is searched.
+func (idx *Index) Search(ctx context.Context, sessionKey, query string, k int) ([]Result, error) {
+	if k <= 0 {
+		k = 5
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	// Take what is needed under the lock, embedding requests are made without it
+	idx.mu.Lock()
+	idx.refresh()
+	var chunks []chunk
+	var scores []float64
+	if len(idx.chunks) > 0 {
+		all := idx.bm25.scores(query)
+		for i, c := range idx.chunks {
+			if visibleTo(c.source, sessionKey) {
+				chunks = append(chunks, c)
+				scores = append(scores, all[i])
+			}
+		}
+	}
+	idx.mu.Unlock()
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	ranking := rank(scores, 0)
+	if idx.vectors != nil {
+		semantic, err := idx.semanticScores(ctx, chunks, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.WarnCF("memory", "Embedding search failed, using BM25 only",
+				map[string]interface{}{"error": err.Error()})
+		} else {
+			ranking, scores = fuse(ranking, rank(semantic, 2*k), len(chunks))
+		}
+	}
+
+	if len(ranking) > k {
+		ranking = ranking[:k]
+	}
+	results := make([]Result, 0, len(ranking))
+	for _, i := range ranking {
+		c := chunks[i]
+		results = append(results, Result{Source: c.source, Heading: c.heading, Text: c.text, Score: scores[i]})
+	}
+	return results, nil
+}
+
+// visibleTo reports whether a snippet from source may be shown in the
+// session sessionKey: summaries only are shown in their own session.
+func visibleTo(source, sessionKey string) bool {
+	if !strings.HasPrefix(source, SummarySourcePrefix) {
+		return true
+	}
+	return sessionKey != "" && source == SummarySourcePrefix+sessionKey
+}
+
+// Save appends content to long-term memory or to today's daily note and
+// returns the path written, relative to the workspace.
+func (idx *Index) Save(kind, content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", fmt.Errorf("content is empty")
+	}
+
+	var path, header string
+	switch kind {
+	case KindLongTerm:
+		path = filepath.Join(idx.dir, "MEMORY.md")
+	case KindDaily:
+		now := time.Now()
+		path = DailyNotePath(idx.dir, now)
+		header = fmt.Sprintf("# %s\n\n", now.Format("2006-01-02"))
+	default:
+		return "", fmt.Errorf("unknown memory kind %q (want %s or %s)", kind, KindLongTerm, KindDaily)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	var data string
+	if prev := strings.TrimRight(string(existing), "\n"); prev != "" {
+		data = prev + "\n\n" + content + "\n"
+	} else {
+		data = header + content + "\n"
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		return "", err
+	}
+	// The modification time may not change within the same second
+	delete(idx.files, path)
+
+	rel, err := filepath.Rel(idx.workspace, path)
+	if err != nil {
+		return path, nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// refresh re-reads the memory files and summaries that changed since the
+// last call and rebuilds the BM25 index if anything did. idx.mu must be held.
+func (idx *Index) refresh() {
+	changed := idx.bm25 == nil
+	seen := make(map[string]bool)
+
+	filepath.WalkDir(idx.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != idx.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(path), ".md") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		seen[path] = true
+		if st, ok := idx.files[path]; ok && st.modTime.Equal(info.ModTime()) && st.size == info.Size() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		source := path
+		if rel, err := filepath.Rel(idx.workspace, path); err == nil {
+			source = filepath.ToSlash(rel)
+		}
+		idx.files[path] = &fileState{modTime: info.ModTime(), size: info.Size(), chunks: chunkMarkdown(source, string(data))}
+		changed = true
+		return nil
+	})
+	for path := range idx.files {
+		if !seen[path] {
+			delete(idx.files, path)
+			changed = true
+		}
+	}
+
+	if idx.opts.Summaries != nil {
+		summaries := idx.opts.Summaries()
+		if !sameSummaries(summaries, idx.summaries) {
+			idx.summaries = summaries
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	paths := make([]string, 0, len(idx.files))
+	for path := range idx.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var chunks []chunk
+	for _, path := range paths {
+		chunks = append(chunks, idx.files[path].chunks...)
+	}
+
+	keys := make([]string, 0, len(idx.summaries))
+	for key, summary := range idx.summaries {
+		if strings.TrimSpace(summary) != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		chunks = append(chunks, chunkMarkdown(SummarySourcePrefix+key, idx.summaries[key])...)
+	}
+
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.indexText()
+	}
+	idx.chunks = chunks
+	idx.bm25 = newBM25Index(texts)
+}
+
+func sameSummaries(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// rank returns the indices of the positive scores, highest first, at most
+// limit of them (0 for all).
+func rank(scores []float64, limit int) []int {
+	var ranking []int
+	for i, s := range scores {
+		if s > 0 {
+			ranking = append(ranking, i)
+		}
+	}
+	sort.SliceStable(ranking, func(a, b int) bool {
+		return scores[ranking[a]] > scores[ranking[b]]
+	})
+	if limit > 0 && len(ranking) > limit {
+		ranking = ranking[:limit]
+	}
+	return ranking
+}
+
+// rrfK dampens the weight of the top ranks in reciprocal rank fusion.
+const rrfK = 60
+
+// fuse merges two rankings with reciprocal rank fusion and returns the
+// merged ranking and the fused score of every document.
+func fuse(a, b []int, n int) ([]int, []float64) {
+	scores := make([]float64, n)
+	for _, ranking := range [][]int{a, b} {
+		for pos, i := range ranking {
+			scores[i] += 1 / float64(rrfK+pos+1)
+		}
+	}
+	return rank(scores, 0), scores
+}
@@ -0,0 +1,225 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeMemory(t *testing.T, workspace, rel, content string) {
+	t.Helper()
+	path := filepath.Join(workspace, "memory", rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const longTerm = `# Long-term Memory
+
+## Preferences
+
+The user prefers dark roast coffee, no sugar.
+
+## Family
+
+Their sister Alice lives in Lisbon and works as an architect.
+
+## Work
+
+They maintain the billing service written in Go.
+`
+
+func TestTokenize(t *testing.T) {
+	got := strings.Join(tokenize("What's the Wi-Fi password? 我喜欢咖啡"), ",")
+	if got != "s,wi,fi,password,我,喜,欢,咖,啡" {
+		t.Errorf("tokenize = %s", got)
+	}
+}
+
+func TestChunkMarkdown(t *testing.T) {
+	chunks := chunkMarkdown("memory/MEMORY.md", longTerm+"\n```sh\n# not a heading\n```\n")
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks: %+v", len(chunks), chunks)
+	}
+	if chunks[1].heading != "Long-term Memory > Family" || !strings.HasPrefix(chunks[1].text, "Their sister") {
+		t.Errorf("chunk = %+v", chunks[1])
+	}
+	if !strings.Contains(chunks[2].text, "# not a heading") {
+		t.Errorf("fenced code should stay in its section, got %+v", chunks[2])
+	}
+
+	long := "# Notes\n\n" + strings.Repeat(strings.Repeat("word ", 100)+"\n\n", 4)
+	if chunks := chunkMarkdown("x.md", long); len(chunks) != 4 {
+		t.Errorf("long section: got %d chunks, want 4", len(chunks))
+	}
+}
+
+func TestIndex_SearchRanksRelevantSnippets(t *testing.T) {
+	workspace := t.TempDir()
+	writeMemory(t, workspace, "MEMORY.md", longTerm)
+	writeMemory(t, workspace, "202610/20261015.md", "# 2026-10-15\n\nDeployed the billing service fix for invoice rounding.\n")
+	writeMemory(t, workspace, ".index/notes.md", "coffee coffee coffee")
+
+	idx := NewIndex(workspace, Options{Summaries: func() map[string]string {
+		return map[string]string{"telegram:42": "Discussed a trip to Lisbon to visit Alice in spring."}
+	}})
+
+	results, err := idx.Search(context.Background(), "", "how does the user take coffee?", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Source != "memory/MEMORY.md" || results[0].Heading != "Long-term Memory > Preferences" {
+		t.Errorf("coffee results = %+v", results)
+	}
+
+	results, _ = idx.Search(context.Background(), "telegram:42", "Alice Lisbon", 5)
+	if len(results) != 2 {
+		t.Fatalf("Lisbon results = %+v", results)
+	}
+	sources := results[0].Source + "," + results[1].Source
+	if !strings.Contains(sources, "session:telegram:42") || !strings.Contains(sources, "memory/MEMORY.md") {
+		t.Errorf("sources = %s", sources)
+	}
+
+	// Summaries of other sessions are not searched
+	for _, session := range []string{"", "telegram:7"} {
+		results, _ = idx.Search(context.Background(), session, "Alice Lisbon", 5)
+		if len(results) != 1 || results[0].Source != "memory/MEMORY.md" {
+			t.Errorf("Lisbon results in session %q = %+v", session, results)
+		}
+	}
+
+	results, _ = idx.Search(context.Background(), "", "billing invoice", 1)
+	if len(results) != 1 || results[0].Source != "memory/202610/20261015.md" {
+		t.Errorf("billing results = %+v", results)
+	}
+}
+
+func TestIndex_PicksUpChanges(t *testing.T) {
+	workspace := t.TempDir()
+	idx := NewIndex(workspace, Options{})
+	if results, _ := idx.Search(context.Background(), "", "kayak", 5); len(results) != 0 {
+		t.Fatalf("empty memory returned %+v", results)
+	}
+
+	writeMemory(t, workspace, "MEMORY.md", "The user owns a red kayak.")
+	if results, _ := idx.Search(context.Background(), "", "kayak", 5); len(results) != 1 {
+		t.Fatalf("new file not indexed: %+v", results)
+	}
+
+	os.Remove(filepath.Join(workspace, "memory", "MEMORY.md"))
+	if results, _ := idx.Search(context.Background(), "", "kayak", 5); len(results) != 0 {
+		t.Errorf("removed file still indexed: %+v", results)
+	}
+}
+
+func TestIndex_Save(t *testing.T) {
+	workspace := t.TempDir()
+	idx := NewIndex(workspace, Options{})
+
+	path, err := idx.Save(KindLongTerm, "The user's cat is called Miso.")
+	if err != nil || path != "memory/MEMORY.md" {
+		t.Fatalf("Save = %q, %v", path, err)
+	}
+	idx.Save(KindLongTerm, "The user is allergic to peanuts.")
+	data, _ := os.ReadFile(filepath.Join(workspace, "memory", "MEMORY.md"))
+	if string(data) != "The user's cat is called Miso.\n\nThe user is allergic to peanuts.\n" {
+		t.Errorf("MEMORY.md = %q", data)
+	}
+
+	path, err = idx.Save(KindDaily, "Booked the dentist for Friday.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := DailyNotePath(filepath.Join(workspace, "memory"), time.Now())
+	if want, _ := filepath.Rel(workspace, today); path != filepath.ToSlash(want) {
+		t.Errorf("daily path = %q, want %q", path, want)
+	}
+	data, _ = os.ReadFile(today)
+	if !strings.HasPrefix(string(data), "# "+time.Now().Format("2006-01-02")+"\n\nBooked") {
+		t.Errorf("daily note = %q", data)
+	}
+
+	if results, _ := idx.Search(context.Background(), "", "peanuts", 5); len(results) != 1 {
+		t.Errorf("saved memory not found: %+v", results)
+	}
+	if _, err := idx.Save("weekly", "x"); err == nil {
+		t.Error("unknown kind should fail")
+	}
+	if _, err := idx.Save(KindDaily, "  "); err == nil {
+		t.Error("empty content should fail")
+	}
+}
+
+// fakeEmbedder embeds text as counts of a few topic words, so "espresso"
+// is close to "coffee" without sharing a word with it.
+type fakeEmbedder struct {
+	calls int
+	texts int
+	err   error
+}
+
+var topics = [][]string{{"coffee", "espresso", "roast"}, {"sister", "alice", "family"}, {"billing", "go", "service"}}
+
+func (e *fakeEmbedder) Embed(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.texts += len(texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, len(topics))
+		for _, tok := range tokenize(text) {
+			for j, words := range topics {
+				for _, w := range words {
+					if tok == w {
+						v[j]++
+					}
+				}
+			}
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func TestIndex_SemanticSearch(t *testing.T) {
+	workspace := t.TempDir()
+	writeMemory(t, workspace, "MEMORY.md", longTerm)
+	embedder := &fakeEmbedder{}
+
+	idx := NewIndex(workspace, Options{Embedder: embedder, EmbeddingModel: "test-embed"})
+	results, err := idx.Search(context.Background(), "", "espresso", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Heading != "Long-term Memory > Preferences" {
+		t.Errorf("semantic results = %+v", results)
+	}
+	if embedder.texts != 4 { // three snippets and the query
+		t.Errorf("embedded %d texts, want 4", embedder.texts)
+	}
+
+	// A new index reuses the cached embeddings of unchanged snippets
+	embedder.texts = 0
+	idx = NewIndex(workspace, Options{Embedder: embedder, EmbeddingModel: "test-embed"})
+	idx.Search(context.Background(), "", "espresso", 1)
+	if embedder.texts != 1 {
+		t.Errorf("embedded %d texts with a warm cache, want 1", embedder.texts)
+	}
+
+	// Embedding failures fall back to BM25
+	embedder.err = errors.New("rate limited")
+	results, err = idx.Search(context.Background(), "", "sister", 1)
+	if err != nil || len(results) != 1 || results[0].Heading != "Long-term Memory > Family" {
+		t.Errorf("fallback results = %+v, %v", results, err)
+	}
+}
@@ -52,10 +52,49 @@ func (p *ClaudeProvider) Chat(ctx context.Context, messages []Message, tools []T
 	return parseClaudeResponse(resp), nil
 }
 
+// ChatStream is like Chat but streams text deltas to onDelta as they arrive.
+func (p *ClaudeProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta StreamCallback) (*LLMResponse, error) {
+	var opts []option.RequestOption
+	if p.tokenSource != nil {
+		tok, err := p.tokenSource()
+		if err != nil {
+			return nil, fmt.Errorf("refreshing token: %w", err)
+		}
+		opts = append(opts, option.WithAuthToken(tok))
+	}
+
+	params, err := buildClaudeParams(messages, tools, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := p.client.Messages.NewStreaming(ctx, params, opts...)
+	defer stream.Close()
+
+	var msg anthropic.Message
+	for stream.Next() {
+		event := stream.Current()
+		if err := msg.Accumulate(event); err != nil {
+			return nil, fmt.Errorf("claude stream: %w", err)
+		}
+		if onDelta != nil && event.Type == "content_block_delta" && event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+			onDelta(event.Delta.Text)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("claude API call: %w", err)
+	}
+
+	return parseClaudeResponse(&msg), nil
+}
+
 func (p *ClaudeProvider) GetDefaultModel() string {
 	return "claude-sonnet-4-5-20250929"
 }
 
+// buildClaudeParams converts a chat request to Messages API parameters. The
+// reasoning_effort option is dropped: extended thinking would need its signed
+// thinking blocks sent back with every tool result.
 func buildClaudeParams(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (anthropic.MessageNewParams, error) {
 	var system []anthropic.TextBlockParam
 	var anthropicMessages []anthropic.MessageParam
@@ -69,6 +108,10 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewToolResultBlock(msg.ToolCallID, msg.Content, false)),
 				)
+			} else if msg.HasMedia() {
+				anthropicMessages = append(anthropicMessages,
+					anthropic.NewUserMessage(claudeContentBlocks(msg.Parts)...),
+				)
 			} else {
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content)),
@@ -115,6 +158,14 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		params.Temperature = anthropic.Float(temp)
 	}
 
+	if topP, ok := options["top_p"].(float64); ok {
+		params.TopP = anthropic.Float(topP)
+	}
+
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		params.StopSequences = stop
+	}
+
 	if len(tools) > 0 {
 		params.Tools = translateToolsForClaude(tools)
 	}
@@ -122,6 +173,27 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 	return params, nil
 }
 
+// claudeContentBlocks converts content parts to text, image and document
+// blocks. Anthropic only accepts PDF documents, so other files are skipped.
+func claudeContentBlocks(parts []ContentPart) []anthropic.ContentBlockParamUnion {
+	blocks := make([]anthropic.ContentBlockParamUnion, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case PartText:
+			if part.Text != "" {
+				blocks = append(blocks, anthropic.NewTextBlock(part.Text))
+			}
+		case PartImage:
+			blocks = append(blocks, anthropic.NewImageBlockBase64(part.MIMEType, part.Data))
+		case PartFile:
+			if part.MIMEType == "application/pdf" {
+				blocks = append(blocks, anthropic.NewDocumentBlock(anthropic.Base64PDFSourceParam{Data: part.Data}))
+			}
+		}
+	}
+	return blocks
+}
+
 func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam {
 	result := make([]anthropic.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -31,6 +31,31 @@ func TestBuildClaudeParams_BasicMessage(t *testing.T) {
 	}
 }
 
+func TestBuildClaudeParams_GenerationOptions(t *testing.T) {
+	messages := []Message{{Role: "user", Content: "Hello"}}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"temperature":      0.2,
+		"top_p":            0.9,
+		"stop":             []string{"END"},
+		"reasoning_effort": "high",
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.Temperature.Value != 0.2 {
+		t.Errorf("Temperature = %v, want 0.2", params.Temperature.Value)
+	}
+	if params.TopP.Value != 0.9 {
+		t.Errorf("TopP = %v, want 0.9", params.TopP.Value)
+	}
+	if len(params.StopSequences) != 1 || params.StopSequences[0] != "END" {
+		t.Errorf("StopSequences = %v, want [END]", params.StopSequences)
+	}
+	if params.Thinking.OfEnabled != nil {
+		t.Error("Thinking enabled for reasoning_effort")
+	}
+}
+
 func TestBuildClaudeParams_SystemMessage(t *testing.T) {
 	messages := []Message{
 		{Role: "system", Content: "You are helpful"},
@@ -208,3 +233,38 @@ func createAnthropicTestClient(baseURL, token string) *anthropic.Client {
 	)
 	return &c
 }
+
+func TestBuildClaudeParams_ImageAndPDFParts(t *testing.T) {
+	messages := []Message{
+		{
+			Role:    "user",
+			Content: "What is this?",
+			Parts: []ContentPart{
+				{Type: PartText, Text: "What is this?"},
+				{Type: PartImage, MIMEType: "image/png", Data: "aW1n"},
+				{Type: PartFile, MIMEType: "application/pdf", Data: "cGRm", Filename: "doc.pdf"},
+				{Type: PartFile, MIMEType: "application/zip", Data: "emlw", Filename: "a.zip"},
+			},
+		},
+	}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if len(params.Messages) != 1 {
+		t.Fatalf("len(Messages) = %d, want 1", len(params.Messages))
+	}
+	blocks := params.Messages[0].Content
+	if len(blocks) != 3 {
+		t.Fatalf("len(Content) = %d, want 3 (zip should be skipped)", len(blocks))
+	}
+	if blocks[1].OfImage == nil || blocks[1].OfImage.Source.OfBase64 == nil {
+		t.Fatal("Content[1] should be a base64 image block")
+	}
+	if blocks[1].OfImage.Source.OfBase64.Data != "aW1n" {
+		t.Errorf("image data = %q, want %q", blocks[1].OfImage.Source.OfBase64.Data, "aW1n")
+	}
+	if blocks[2].OfDocument == nil || blocks[2].OfDocument.Source.OfBase64 == nil {
+		t.Fatal("Content[2] should be a base64 PDF document block")
+	}
+}
@@ -49,6 +49,11 @@ func NewCodexProviderWithTokenSource(token, accountID string, tokenSource func()
 }
 
 func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.ChatStream(ctx, messages, tools, model, options, nil)
+}
+
+// ChatStream is like Chat but forwards output text deltas to onDelta.
+func (p *CodexProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta StreamCallback) (*LLMResponse, error) {
 	var opts []option.RequestOption
 	accountID := p.accountID
 	resolvedModel, fallbackReason := resolveCodexModel(model)
@@ -86,6 +91,9 @@ func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []To
 	var resp *responses.Response
 	for stream.Next() {
 		evt := stream.Current()
+		if onDelta != nil && evt.Type == "response.output_text.delta" && evt.Delta != "" {
+			onDelta(evt.Delta)
+		}
 		if evt.Type == "response.completed" || evt.Type == "response.failed" || evt.Type == "response.incomplete" {
 			evtResp := evt.Response
 			if evtResp.ID != "" {
@@ -182,6 +190,34 @@ func resolveCodexModel(model string) (string, string) {
 	return codexDefaultModel, "unsupported model family"
 }
 
+// codexContentList converts content parts to Responses API input content.
+func codexContentList(parts []ContentPart) responses.ResponseInputMessageContentListParam {
+	list := make(responses.ResponseInputMessageContentListParam, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case PartText:
+			list = append(list, responses.ResponseInputContentUnionParam{
+				OfInputText: &responses.ResponseInputTextParam{Text: part.Text},
+			})
+		case PartImage:
+			list = append(list, responses.ResponseInputContentUnionParam{
+				OfInputImage: &responses.ResponseInputImageParam{
+					Detail:   responses.ResponseInputImageDetailAuto,
+					ImageURL: openai.Opt(part.DataURL()),
+				},
+			})
+		case PartFile:
+			list = append(list, responses.ResponseInputContentUnionParam{
+				OfInputFile: &responses.ResponseInputFileParam{
+					Filename: openai.Opt(part.Filename),
+					FileData: openai.Opt(part.DataURL()),
+				},
+			})
+		}
+	}
+	return list
+}
+
 func buildCodexParams(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) responses.ResponseNewParams {
 	var inputItems responses.ResponseInputParam
 	var instructions string
@@ -198,6 +234,13 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 						Output: responses.ResponseInputItemFunctionCallOutputOutputUnionParam{OfString: openai.Opt(msg.Content)},
 					},
 				})
+			} else if msg.HasMedia() {
+				inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
+					OfMessage: &responses.EasyInputMessageParam{
+						Role:    responses.EasyInputMessageRoleUser,
+						Content: responses.EasyInputMessageContentUnionParam{OfInputItemContentList: codexContentList(msg.Parts)},
+					},
+				})
 			} else {
 				inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
 					OfMessage: &responses.EasyInputMessageParam{
@@ -264,6 +307,12 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 		params.MaxOutputTokens = openai.Opt(int64(maxTokens))
 	}
 
+	// The Codex backend rejects sampling parameters; only reasoning effort
+	// is forwarded from the generation profile.
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		params.Reasoning = openai.ReasoningParam{Effort: openai.ReasoningEffort(effort)}
+	}
+
 	if len(tools) > 0 {
 		params.Tools = translateToolsForCodex(tools)
 	}
@@ -93,6 +93,20 @@ func TestBuildCodexParams_WithTools(t *testing.T) {
 	}
 }
 
+func TestBuildCodexParams_ReasoningEffort(t *testing.T) {
+	messages := []Message{{Role: "user", Content: "Hi"}}
+	params := buildCodexParams(messages, nil, "gpt-5.2", map[string]interface{}{
+		"temperature":      0.7,
+		"reasoning_effort": "high",
+	})
+	if params.Reasoning.Effort != "high" {
+		t.Errorf("Reasoning.Effort = %q, want high", params.Reasoning.Effort)
+	}
+	if params.Temperature.Valid() {
+		t.Error("Temperature should not be sent to the Codex backend")
+	}
+}
+
 func TestBuildCodexParams_StoreIsFalse(t *testing.T) {
 	params := buildCodexParams([]Message{{Role: "user", Content: "Hi"}}, nil, "gpt-4o", map[string]interface{}{})
 	if !params.Store.Valid() || params.Store.Or(true) != false {
@@ -467,3 +481,34 @@ func writeCompletedSSE(w http.ResponseWriter, response map[string]interface{}) {
 	fmt.Fprintf(w, "data: %s\n\n", string(b))
 	fmt.Fprintf(w, "data: [DONE]\n\n")
 }
+
+func TestBuildCodexParams_ImagePart(t *testing.T) {
+	messages := []Message{
+		{
+			Role:    "user",
+			Content: "Describe",
+			Parts: []ContentPart{
+				{Type: PartText, Text: "Describe"},
+				{Type: PartImage, MIMEType: "image/jpeg", Data: "aW1n"},
+			},
+		},
+	}
+	params := buildCodexParams(messages, nil, "gpt-4o", map[string]interface{}{})
+	items := params.Input.OfInputItemList
+	if len(items) != 1 || items[0].OfMessage == nil {
+		t.Fatalf("expected a single message input item, got %d", len(items))
+	}
+	content := items[0].OfMessage.Content.OfInputItemContentList
+	if len(content) != 2 {
+		t.Fatalf("len(content) = %d, want 2", len(content))
+	}
+	if content[0].OfInputText == nil || content[0].OfInputText.Text != "Describe" {
+		t.Error("content[0] should be the text part")
+	}
+	if content[1].OfInputImage == nil {
+		t.Fatal("content[1] should be an input image")
+	}
+	if got := content[1].OfInputImage.ImageURL.Or(""); got != "data:image/jpeg;base64,aW1n" {
+		t.Errorf("image_url = %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v3"
+)
+
+// HTTPError is returned when a provider API answers with a non-200 status.
+type HTTPError struct {
+	StatusCode int
+	RetryAfter time.Duration // Parsed Retry-After header, zero if absent
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("API request failed:\n  Status: %d\n  Body:   %s", e.StatusCode, e.Body)
+}
+
+// failureKind tells the fallback provider how to react to an error.
+type failureKind int
+
+const (
+	// failFatal errors are returned to the caller as is (bad request,
+	// context length, auth on the only backend, cancelled context).
+	failFatal failureKind = iota
+	// failRateLimited errors are retried on the same backend with backoff.
+	failRateLimited
+	// failUnavailable errors (5xx, timeouts, connection errors) fail over to
+	// the next backend.
+	failUnavailable
+)
+
+// classifyError maps a provider error to a failureKind and the server's
+// requested retry delay, if any.
+func classifyError(err error) (failureKind, time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		return failFatal, 0
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return classifyStatus(httpErr.StatusCode), httpErr.RetryAfter
+	}
+
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return classifyStatus(anthropicErr.StatusCode), retryAfterFromResponse(anthropicErr.Response)
+	}
+
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return classifyStatus(openaiErr.StatusCode), retryAfterFromResponse(openaiErr.Response)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return failUnavailable, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return failUnavailable, 0
+	}
+	return failFatal, 0
+}
+
+func classifyStatus(status int) failureKind {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return failRateLimited
+	case status == http.StatusRequestTimeout || status >= 500:
+		return failUnavailable
+	default:
+		return failFatal
+	}
+}
+
+// IsTransientError reports whether err is a rate limit or outage rather than
+// a problem with the request itself, so callers shouldn't try to fix the
+// request (e.g. by compressing history) in response.
+func IsTransientError(err error) bool {
+	kind, _ := classifyError(err)
+	return kind != failFatal
+}
+
+func retryAfterFromResponse(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	return parseRetryAfter(resp.Header.Get("Retry-After"))
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header: a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,206 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// FallbackOptions configures retries and circuit breaking for FallbackProvider.
+type FallbackOptions struct {
+	MaxRetries       int           // Retries per backend on 429
+	InitialBackoff   time.Duration // Doubled on every retry
+	MaxBackoff       time.Duration // Longer Retry-After values fail over instead of waiting
+	BreakerThreshold int           // Consecutive failures that open a backend's breaker
+	BreakerCooldown  time.Duration // How long an open breaker skips the backend
+}
+
+// fallbackBackend is one provider/model pair in the chain, with its own
+// circuit breaker.
+type fallbackBackend struct {
+	name     string
+	provider LLMProvider
+	model    string // Empty means the model requested by the caller
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *fallbackBackend) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.openUntil)
+}
+
+func (b *fallbackBackend) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+// recordFailure counts a failed call and reports whether the breaker opened.
+// A backend that fails its first call after a cooldown opens again at once.
+func (b *fallbackBackend) recordFailure(threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if threshold <= 0 || b.failures < threshold {
+		return false
+	}
+	b.openUntil = time.Now().Add(cooldown)
+	return true
+}
+
+// FallbackProvider wraps an ordered list of backends. Rate-limited calls are
+// retried with exponential backoff (honoring Retry-After), outages fail over
+// to the next backend, and a backend that keeps failing is skipped until its
+// circuit breaker cools down. Other errors are returned unchanged.
+type FallbackProvider struct {
+	backends []*fallbackBackend
+	opts     FallbackOptions
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+// NewFallbackProvider creates a FallbackProvider whose first backend is
+// primary, called with whatever model the caller requests.
+func NewFallbackProvider(name string, primary LLMProvider, opts FallbackOptions) *FallbackProvider {
+	return &FallbackProvider{
+		backends: []*fallbackBackend{{name: name, provider: primary}},
+		opts:     opts,
+		sleep:    sleepContext,
+	}
+}
+
+// AddBackend appends a fallback backend that is always called with model.
+func (p *FallbackProvider) AddBackend(name string, provider LLMProvider, model string) {
+	p.backends = append(p.backends, &fallbackBackend{name: name, provider: provider, model: model})
+}
+
+func (p *FallbackProvider) GetDefaultModel() string {
+	return p.backends[0].provider.GetDefaultModel()
+}
+
+func (p *FallbackProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.call(ctx, model, func(b *fallbackBackend, model string) (*LLMResponse, error) {
+		return b.provider.Chat(ctx, messages, tools, model, options)
+	}, nil)
+}
+
+// ChatStream streams from the first backend that supports it. Once text has
+// been streamed to the caller the call is never retried elsewhere, since the
+// caller has already shown part of the reply.
+func (p *FallbackProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta StreamCallback) (*LLMResponse, error) {
+	streamed := false
+	return p.call(ctx, model, func(b *fallbackBackend, model string) (*LLMResponse, error) {
+		sp, ok := b.provider.(StreamingProvider)
+		if !ok || onDelta == nil {
+			return b.provider.Chat(ctx, messages, tools, model, options)
+		}
+		return sp.ChatStream(ctx, messages, tools, model, options, func(delta string) {
+			streamed = true
+			onDelta(delta)
+		})
+	}, func() bool { return streamed })
+}
+
+func (p *FallbackProvider) call(ctx context.Context, requested string, do func(b *fallbackBackend, model string) (*LLMResponse, error), committed func() bool) (*LLMResponse, error) {
+	var lastErr error
+	for i, b := range p.backends {
+		if !b.available(time.Now()) {
+			logger.DebugCF("provider", "Skipping backend with open circuit breaker", map[string]interface{}{
+				"backend": b.name,
+			})
+			continue
+		}
+
+		model := b.model
+		if model == "" {
+			model = requested
+		}
+
+		for attempt := 0; ; attempt++ {
+			resp, err := do(b, model)
+			if err == nil {
+				b.recordSuccess()
+				resp.Backend = b.name
+				resp.Model = model
+				fields := map[string]interface{}{
+					"backend":  b.name,
+					"model":    model,
+					"attempts": attempt + 1,
+				}
+				if i > 0 || attempt > 0 {
+					logger.InfoCF("provider", "LLM call served by fallback", fields)
+				} else {
+					logger.DebugCF("provider", "LLM call served", fields)
+				}
+				return resp, nil
+			}
+
+			if ctx.Err() != nil || (committed != nil && committed()) {
+				return nil, err
+			}
+			kind, retryAfter := classifyError(err)
+			if kind == failFatal {
+				return nil, err
+			}
+			lastErr = err
+
+			if kind == failRateLimited && attempt < p.opts.MaxRetries {
+				if wait := p.backoff(attempt, retryAfter); wait <= p.opts.MaxBackoff {
+					logger.WarnCF("provider", "Rate limited, backing off", map[string]interface{}{
+						"backend": b.name,
+						"attempt": attempt + 1,
+						"wait":    wait.String(),
+					})
+					if err := p.sleep(ctx, wait); err != nil {
+						return nil, err
+					}
+					continue
+				}
+			}
+
+			opened := b.recordFailure(p.opts.BreakerThreshold, p.opts.BreakerCooldown)
+			logger.WarnCF("provider", "Backend failed, trying next", map[string]interface{}{
+				"backend":        b.name,
+				"error":          err.Error(),
+				"breaker_opened": opened,
+			})
+			break
+		}
+	}
+
+	if lastErr == nil {
+		return nil, errors.New("all LLM backends are unavailable (circuit breakers open)")
+	}
+	return nil, fmt.Errorf("all LLM backends failed: %w", lastErr)
+}
+
+// backoff returns how long to wait before retry number attempt+1.
+func (p *FallbackProvider) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	wait := p.opts.InitialBackoff << attempt
+	if wait <= 0 || wait > p.opts.MaxBackoff {
+		wait = p.opts.MaxBackoff
+	}
+	return wait
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,184 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// scriptedProvider returns the queued errors in order, then succeeds
+type scriptedProvider struct {
+	errs   []error
+	calls  int
+	models []string
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	p.calls++
+	p.models = append(p.models, model)
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return nil, err
+	}
+	return &LLMResponse{Content: "ok from " + model}, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string {
+	return "primary-model"
+}
+
+func newTestFallback(primary LLMProvider, opts FallbackOptions) (*FallbackProvider, *[]time.Duration) {
+	waits := &[]time.Duration{}
+	p := NewFallbackProvider("primary", primary, opts)
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return p, waits
+}
+
+var testFallbackOptions = FallbackOptions{
+	MaxRetries:       2,
+	InitialBackoff:   time.Second,
+	MaxBackoff:       10 * time.Second,
+	BreakerThreshold: 2,
+	BreakerCooldown:  time.Minute,
+}
+
+func TestFallbackProvider_RetriesRateLimitWithBackoff(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{
+		&HTTPError{StatusCode: 429, RetryAfter: 3 * time.Second},
+		&HTTPError{StatusCode: 429},
+	}}
+	p, waits := newTestFallback(primary, testFallbackOptions)
+
+	resp, err := p.Chat(context.Background(), nil, nil, "gpt-4o", nil)
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if primary.calls != 3 {
+		t.Errorf("Expected 3 calls to the primary, got %d", primary.calls)
+	}
+	// First wait honors Retry-After, second doubles the initial backoff
+	if len(*waits) != 2 || (*waits)[0] != 3*time.Second || (*waits)[1] != 2*time.Second {
+		t.Errorf("Unexpected backoff waits: %v", *waits)
+	}
+	if resp.Backend != "primary" {
+		t.Errorf("Backend = %q, want primary", resp.Backend)
+	}
+}
+
+func TestFallbackProvider_FailsOverOn5xx(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&HTTPError{StatusCode: 503}}}
+	secondary := &scriptedProvider{}
+	p, waits := newTestFallback(primary, testFallbackOptions)
+	p.AddBackend("openrouter/backup", secondary, "backup-model")
+
+	resp, err := p.Chat(context.Background(), nil, nil, "gpt-4o", nil)
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if len(*waits) != 0 {
+		t.Errorf("Expected immediate failover, waited %v", *waits)
+	}
+	if resp.Content != "ok from backup-model" || resp.Backend != "openrouter/backup" {
+		t.Errorf("Expected reply from the backup backend, got %q via %q", resp.Content, resp.Backend)
+	}
+}
+
+func TestFallbackProvider_LongRetryAfterFailsOver(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&HTTPError{StatusCode: 429, RetryAfter: time.Hour}}}
+	secondary := &scriptedProvider{}
+	p, waits := newTestFallback(primary, testFallbackOptions)
+	p.AddBackend("backup", secondary, "backup-model")
+
+	if _, err := p.Chat(context.Background(), nil, nil, "gpt-4o", nil); err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if len(*waits) != 0 || secondary.calls != 1 {
+		t.Errorf("Expected failover instead of waiting an hour, waits=%v backup calls=%d", *waits, secondary.calls)
+	}
+}
+
+func TestFallbackProvider_FatalErrorNotRetried(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&HTTPError{StatusCode: 400, Body: "context length exceeded"}}}
+	secondary := &scriptedProvider{}
+	p, _ := newTestFallback(primary, testFallbackOptions)
+	p.AddBackend("backup", secondary, "backup-model")
+
+	_, err := p.Chat(context.Background(), nil, nil, "gpt-4o", nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 400 {
+		t.Fatalf("Expected the 400 error to be returned, got %v", err)
+	}
+	if secondary.calls != 0 {
+		t.Error("Expected no failover on a request error")
+	}
+}
+
+func TestFallbackProvider_CircuitBreakerSkipsBackend(t *testing.T) {
+	unavailable := &HTTPError{StatusCode: 502}
+	primary := &scriptedProvider{errs: []error{unavailable, unavailable, unavailable}}
+	secondary := &scriptedProvider{}
+	p, _ := newTestFallback(primary, testFallbackOptions)
+	p.AddBackend("backup", secondary, "backup-model")
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Chat(context.Background(), nil, nil, "gpt-4o", nil); err != nil {
+			t.Fatalf("Chat() #%d error: %v", i, err)
+		}
+	}
+	if primary.calls != 2 {
+		t.Errorf("Expected the breaker to open after 2 failures, primary called %d times", primary.calls)
+	}
+	if secondary.calls != 3 {
+		t.Errorf("Expected all 3 calls to be served by the backup, got %d", secondary.calls)
+	}
+}
+
+func TestFallbackProvider_AllBackendsFail(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&HTTPError{StatusCode: 500}}}
+	p, _ := newTestFallback(primary, testFallbackOptions)
+
+	_, err := p.Chat(context.Background(), nil, nil, "gpt-4o", nil)
+	if err == nil || !IsTransientError(err) {
+		t.Errorf("Expected a transient error after all backends failed, got %v", err)
+	}
+}
+
+func TestHTTPProvider_ReturnsHTTPErrorWithRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"rate limited"}`))
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("key", server.URL, "")
+	_, err := p.Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil)
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("Expected *HTTPError, got %T: %v", err, err)
+	}
+	if httpErr.StatusCode != 429 || httpErr.RetryAfter != 7*time.Second {
+		t.Errorf("Unexpected error fields: %+v", httpErr)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("12"); got != 12*time.Second {
+		t.Errorf("parseRetryAfter(12) = %v", got)
+	}
+	date := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 0 || got > 30*time.Second {
+		t.Errorf("parseRetryAfter(date) = %v, want ~30s", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("parseRetryAfter(soon) = %v, want 0", got)
+	}
+}
@@ -49,6 +49,45 @@ func NewHTTPProvider(apiKey, apiBase, proxy string) *HTTPProvider {
 }
 
 func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	requestBody, err := p.buildRequestBody(messages, tools, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.post(ctx, "/chat/completions", requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return p.parseResponse(body)
+}
+
+// ChatStream requests a server-sent event stream and forwards content deltas
+// to onDelta while assembling the complete response.
+func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta StreamCallback) (*LLMResponse, error) {
+	requestBody, err := p.buildRequestBody(messages, tools, model, options)
+	if err != nil {
+		return nil, err
+	}
+	requestBody["stream"] = true
+	requestBody["stream_options"] = map[string]interface{}{"include_usage": true}
+
+	resp, err := p.post(ctx, "/chat/completions", requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return parseStreamResponse(resp.Body, onDelta)
+}
+
+func (p *HTTPProvider) buildRequestBody(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (map[string]interface{}, error) {
 	if p.apiBase == "" {
 		return nil, fmt.Errorf("API base not configured")
 	}
@@ -63,7 +102,7 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 
 	requestBody := map[string]interface{}{
 		"model":    model,
-		"messages": messages,
+		"messages": openAIMessages(messages),
 	}
 
 	if len(tools) > 0 {
@@ -90,12 +129,35 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 		}
 	}
 
+	if topP, ok := options["top_p"].(float64); ok {
+		requestBody["top_p"] = topP
+	}
+
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		requestBody["stop"] = stop
+	}
+
+	// Claude models take no reasoning effort on Anthropic's own API, see
+	// buildClaudeParams; OpenRouter's "vendor/model" names map it themselves
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		if !strings.Contains(strings.ToLower(model), "claude") || strings.Contains(model, "/") {
+			requestBody["reasoning_effort"] = effort
+		}
+	}
+
+	return requestBody, nil
+}
+
+// post sends a request to an endpoint under apiBase, such as
+// "/chat/completions", and returns the response if the server accepted it.
+// The caller must close the body.
+func (p *HTTPProvider) post(ctx context.Context, path string, requestBody map[string]interface{}) (*http.Response, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/chat/completions", bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+path, bytes.NewReader(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -109,18 +171,21 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body))
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:       string(body),
+		}
 	}
 
-	return p.parseResponse(body)
+	return resp, nil
 }
 
 func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
@@ -193,10 +258,93 @@ func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 	}, nil
 }
 
+// openAIMessages converts messages to the chat completions wire format.
+// Messages with content parts are sent as a content array; everything else
+// is passed through unchanged.
+func openAIMessages(messages []Message) []interface{} {
+	out := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		if !msg.HasMedia() {
+			msg.Parts = nil
+			out = append(out, msg)
+			continue
+		}
+
+		content := make([]map[string]interface{}, 0, len(msg.Parts))
+		for _, part := range msg.Parts {
+			switch part.Type {
+			case PartText:
+				content = append(content, map[string]interface{}{
+					"type": "text",
+					"text": part.Text,
+				})
+			case PartImage:
+				content = append(content, map[string]interface{}{
+					"type":      "image_url",
+					"image_url": map[string]interface{}{"url": part.DataURL()},
+				})
+			case PartFile:
+				content = append(content, map[string]interface{}{
+					"type": "file",
+					"file": map[string]interface{}{
+						"filename":  part.Filename,
+						"file_data": part.DataURL(),
+					},
+				})
+			}
+		}
+
+		wire := map[string]interface{}{
+			"role":    msg.Role,
+			"content": content,
+		}
+		if msg.ToolCallID != "" {
+			wire["tool_call_id"] = msg.ToolCallID
+		}
+		out = append(out, wire)
+	}
+	return out
+}
+
 func (p *HTTPProvider) GetDefaultModel() string {
 	return ""
 }
 
+// Embed returns the embeddings of texts from the OpenAI-compatible
+// /embeddings endpoint.
+func (p *HTTPProvider) Embed(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	resp, err := p.post(ctx, "/embeddings", map[string]interface{}{
+		"model": model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResponse struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse embeddings response: %w", err)
+	}
+	if len(apiResponse.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(apiResponse.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range apiResponse.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
 func createClaudeAuthProvider() (LLMProvider, error) {
 	cred, err := auth.GetCredential("anthropic")
 	if err != nil {
@@ -220,8 +368,77 @@ func createCodexAuthProvider() (LLMProvider, error) {
 }
 
 func CreateProvider(cfg *config.Config) (LLMProvider, error) {
-	model := cfg.Agents.Defaults.Model
-	providerName := strings.ToLower(cfg.Agents.Defaults.Provider)
+	return CreateProviderFor(cfg, cfg.Agents.Defaults.Provider, cfg.Agents.Defaults.Model)
+}
+
+// CreateProviderFor creates the provider for an explicit provider name and
+// model, using the credentials in cfg. Named agents use it to run on a
+// different provider than agents.defaults. Unless retries and the fallback
+// chain are both disabled, the provider is wrapped in a FallbackProvider.
+func CreateProviderFor(cfg *config.Config, providerName, model string) (LLMProvider, error) {
+	primary, err := createProvider(cfg, providerName, model)
+	if err != nil {
+		return nil, err
+	}
+
+	fb := cfg.Fallback
+	if len(fb.Chain) == 0 && (fb.MaxRetries <= 0 || isCLIProvider(primary)) {
+		return primary, nil
+	}
+
+	fallback := NewFallbackProvider(backendName(providerName, model), primary, FallbackOptions{
+		MaxRetries:       fb.MaxRetries,
+		InitialBackoff:   time.Duration(fb.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:       time.Duration(fb.MaxBackoffMs) * time.Millisecond,
+		BreakerThreshold: fb.BreakerThreshold,
+		BreakerCooldown:  time.Duration(fb.BreakerCooldownSeconds) * time.Second,
+	})
+	for i, target := range fb.Chain {
+		if target.Model == "" {
+			return nil, fmt.Errorf("fallback.chain[%d]: model is required", i)
+		}
+		backend, err := createProvider(cfg, target.Provider, target.Model)
+		if err != nil {
+			return nil, fmt.Errorf("fallback.chain[%d]: %w", i, err)
+		}
+		fallback.AddBackend(backendName(target.Provider, target.Model), backend, target.Model)
+	}
+	return fallback, nil
+}
+
+// CreateEmbeddingProvider creates the provider for embedding requests. Only
+// providers with an OpenAI-compatible API support embeddings.
+func CreateEmbeddingProvider(cfg *config.Config, providerName, model string) (EmbeddingProvider, error) {
+	p, err := createProvider(cfg, providerName, model)
+	if err != nil {
+		return nil, err
+	}
+	embedder, ok := p.(EmbeddingProvider)
+	if !ok {
+		return nil, fmt.Errorf("provider %q does not support embeddings", backendName(providerName, model))
+	}
+	return embedder, nil
+}
+
+// isCLIProvider reports whether p shells out to a local CLI. Their errors are
+// never rate limits or outages, so retries alone are pointless.
+func isCLIProvider(p LLMProvider) bool {
+	switch p.(type) {
+	case *ClaudeCliProvider, *CodexCliProvider:
+		return true
+	}
+	return false
+}
+
+func backendName(providerName, model string) string {
+	if providerName == "" {
+		return model
+	}
+	return providerName + "/" + model
+}
+
+func createProvider(cfg *config.Config, providerName, model string) (LLMProvider, error) {
+	providerName = strings.ToLower(providerName)
 
 	var apiKey, apiBase, proxy string
 
@@ -0,0 +1,176 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPProvider_Chat_SerializesContentParts(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("key", server.URL, "")
+	messages := []Message{
+		{Role: "system", Content: "sys"},
+		{
+			Role:    "user",
+			Content: "look",
+			Parts: []ContentPart{
+				{Type: PartText, Text: "look"},
+				{Type: PartImage, MIMEType: "image/png", Data: "aW1n"},
+				{Type: PartFile, MIMEType: "application/pdf", Data: "cGRm", Filename: "a.pdf"},
+			},
+		},
+	}
+	if _, err := p.Chat(t.Context(), messages, nil, "gpt-4o", nil); err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+
+	wire := body["messages"].([]interface{})
+	if content, ok := wire[0].(map[string]interface{})["content"].(string); !ok || content != "sys" {
+		t.Errorf("plain message content = %v, want string %q", wire[0].(map[string]interface{})["content"], "sys")
+	}
+	if _, ok := wire[0].(map[string]interface{})["parts"]; ok {
+		t.Error("parts should not be sent on the wire")
+	}
+
+	parts, ok := wire[1].(map[string]interface{})["content"].([]interface{})
+	if !ok || len(parts) != 3 {
+		t.Fatalf("multimodal content = %v, want 3 parts", wire[1].(map[string]interface{})["content"])
+	}
+	image := parts[1].(map[string]interface{})
+	if image["type"] != "image_url" {
+		t.Errorf("parts[1].type = %v, want image_url", image["type"])
+	}
+	if url := image["image_url"].(map[string]interface{})["url"]; url != "data:image/png;base64,aW1n" {
+		t.Errorf("image url = %v", url)
+	}
+	file := parts[2].(map[string]interface{})
+	if file["type"] != "file" {
+		t.Errorf("parts[2].type = %v, want file", file["type"])
+	}
+}
+
+func TestHTTPProvider_ChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] != true {
+			t.Errorf("stream = %v, want true", req["stream"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"choices":[{"delta":{"content":"Hel"}}]}`,
+			`{"choices":[{"delta":{"content":"lo"}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read_file","arguments":"{\"pa"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"th\":\"a.txt\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+		}
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("key", server.URL, "")
+	var deltas []string
+	resp, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+
+	if strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("deltas = %v, want [Hel lo]", deltas)
+	}
+	if resp.Content != "Hello" {
+		t.Errorf("Content = %q, want %q", resp.Content, "Hello")
+	}
+	if resp.FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %q, want tool_calls", resp.FinishReason)
+	}
+	if len(resp.ToolCalls) != 1 {
+		t.Fatalf("len(ToolCalls) = %d, want 1", len(resp.ToolCalls))
+	}
+	if tc := resp.ToolCalls[0]; tc.ID != "call_1" || tc.Name != "read_file" || tc.Arguments["path"] != "a.txt" {
+		t.Errorf("ToolCall = %+v", tc)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 15 {
+		t.Errorf("Usage = %+v, want total 15", resp.Usage)
+	}
+}
+
+func TestHTTPProvider_BuildRequestBody_GenerationOptions(t *testing.T) {
+	p := NewHTTPProvider("key", "http://localhost", "")
+	body, err := p.buildRequestBody([]Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", map[string]interface{}{
+		"max_tokens":       512,
+		"temperature":      0.2,
+		"top_p":            0.8,
+		"stop":             []string{"END"},
+		"reasoning_effort": "low",
+	})
+	if err != nil {
+		t.Fatalf("buildRequestBody() error: %v", err)
+	}
+	if body["max_tokens"] != 512 || body["temperature"] != 0.2 || body["top_p"] != 0.8 {
+		t.Errorf("Unexpected sampling fields: %v", body)
+	}
+	if stop, ok := body["stop"].([]string); !ok || len(stop) != 1 {
+		t.Errorf("stop = %v, want [END]", body["stop"])
+	}
+	if body["reasoning_effort"] != "low" {
+		t.Errorf("reasoning_effort = %v, want low", body["reasoning_effort"])
+	}
+
+	body, _ = p.buildRequestBody([]Message{{Role: "user", Content: "hi"}}, nil, "claude-sonnet-4-5", map[string]interface{}{
+		"reasoning_effort": "low",
+	})
+	if _, ok := body["reasoning_effort"]; ok {
+		t.Error("reasoning_effort sent for a Claude model")
+	}
+}
+
+func TestHTTPProvider_Embed(t *testing.T) {
+	var body map[string]interface{}
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		// Out of order on purpose: results are matched by index
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("key", server.URL, "")
+	vectors, err := p.Embed(t.Context(), []string{"a", "b"}, "text-embedding-3-small")
+	if err != nil {
+		t.Fatalf("Embed() error: %v", err)
+	}
+	if path != "/embeddings" || body["model"] != "text-embedding-3-small" {
+		t.Errorf("request = %s %v", path, body)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("vectors = %v", vectors)
+	}
+
+	if _, err := p.Embed(t.Context(), []string{"a"}, "m"); err == nil {
+		t.Error("a mismatched number of embeddings should fail")
+	}
+}
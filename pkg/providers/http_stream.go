@@ -0,0 +1,124 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package providers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function *struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *UsageInfo `json:"usage"`
+}
+
+type partialToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// parseStreamResponse reads an OpenAI-style SSE stream, calling onDelta for
+// each content fragment, and assembles the final response including tool
+// calls, whose arguments arrive split across chunks.
+func parseStreamResponse(r io.Reader, onDelta StreamCallback) (*LLMResponse, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var content strings.Builder
+	var calls []*partialToolCall
+	var usage *UsageInfo
+	finishReason := "stop"
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "[DONE]" {
+			break
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				if onDelta != nil {
+					onDelta(choice.Delta.Content)
+				}
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				for len(calls) <= tc.Index {
+					calls = append(calls, &partialToolCall{})
+				}
+				call := calls[tc.Index]
+				if tc.ID != "" {
+					call.id = tc.ID
+				}
+				if tc.Function != nil {
+					if tc.Function.Name != "" {
+						call.name = tc.Function.Name
+					}
+					call.arguments.WriteString(tc.Function.Arguments)
+				}
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	toolCalls := make([]ToolCall, 0, len(calls))
+	for _, call := range calls {
+		arguments := make(map[string]interface{})
+		if raw := call.arguments.String(); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &arguments); err != nil {
+				arguments["raw"] = raw
+			}
+		}
+		toolCalls = append(toolCalls, ToolCall{
+			ID:        call.id,
+			Name:      call.name,
+			Arguments: arguments,
+		})
+	}
+
+	return &LLMResponse{
+		Content:      content.String(),
+		ToolCalls:    toolCalls,
+		FinishReason: finishReason,
+		Usage:        usage,
+	}, nil
+}
@@ -20,6 +20,8 @@ type LLMResponse struct {
 	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
 	FinishReason string     `json:"finish_reason"`
 	Usage        *UsageInfo `json:"usage,omitempty"`
+	Backend      string     `json:"backend,omitempty"` // Backend that served the call, set by FallbackProvider
+	Model        string     `json:"model,omitempty"`   // Model that served the call, set by FallbackProvider
 }
 
 type UsageInfo struct {
@@ -29,10 +31,43 @@ type UsageInfo struct {
 }
 
 type Message struct {
-	Role       string     `json:"role"`
-	Content    string     `json:"content"`
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	Parts      []ContentPart `json:"parts,omitempty"` // Multimodal content; Content remains the text-only fallback
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string        `json:"tool_call_id,omitempty"`
+}
+
+// Content part types.
+const (
+	PartText  = "text"
+	PartImage = "image"
+	PartFile  = "file"
+)
+
+// ContentPart is one piece of a multimodal message. Text parts carry Text;
+// image and file parts carry base64-encoded Data and its MIME type.
+type ContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Data     string `json:"data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// DataURL returns the part's payload as a data: URL.
+func (p ContentPart) DataURL() string {
+	return "data:" + p.MIMEType + ";base64," + p.Data
+}
+
+// HasMedia reports whether the message carries any image or file parts.
+func (m Message) HasMedia() bool {
+	for _, part := range m.Parts {
+		if part.Type != PartText {
+			return true
+		}
+	}
+	return false
 }
 
 type LLMProvider interface {
@@ -40,6 +75,23 @@ type LLMProvider interface {
 	GetDefaultModel() string
 }
 
+// StreamCallback receives incremental assistant text as it is generated.
+type StreamCallback func(delta string)
+
+// StreamingProvider is implemented by providers that can stream the response.
+// ChatStream calls onDelta for every text fragment and returns the same
+// complete response Chat would.
+type StreamingProvider interface {
+	LLMProvider
+	ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onDelta StreamCallback) (*LLMResponse, error)
+}
+
+// EmbeddingProvider is implemented by providers that can embed text for
+// semantic search.
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, texts []string, model string) ([][]float32, error)
+}
+
 type ToolDefinition struct {
 	Type     string                 `json:"type"`
 	Function ToolFunctionDefinition `json:"function"`
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Export formats.
+const (
+	FormatMarkdown = "markdown"
+	FormatJSONL    = "jsonl"
+)
+
+// exportHeader is the first line of a JSONL export.
+type exportHeader struct {
+	Key      string    `json:"key"`
+	Summary  string    `json:"summary,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Messages int       `json:"messages"`
+}
+
+// Export writes a session in the given format: FormatMarkdown for reading,
+// or FormatJSONL with a header line followed by one message per line.
+func Export(w io.Writer, s Session, format string) error {
+	switch format {
+	case FormatMarkdown, "md":
+		return exportMarkdown(w, s)
+	case FormatJSONL:
+		return exportJSONL(w, s)
+	default:
+		return fmt.Errorf("unknown export format %q (want %s or %s)", format, FormatMarkdown, FormatJSONL)
+	}
+}
+
+func exportJSONL(w io.Writer, s Session) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(exportHeader{
+		Key:      s.Key,
+		Summary:  s.Summary,
+		Created:  s.Created,
+		Updated:  s.Updated,
+		Messages: len(s.Messages),
+	}); err != nil {
+		return err
+	}
+	for _, msg := range s.Messages {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportMarkdown(w io.Writer, s Session) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Session %s\n\n", s.Key)
+	fmt.Fprintf(&sb, "- Created: %s\n", s.Created.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&sb, "- Updated: %s\n", s.Updated.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&sb, "- Messages: %d\n", len(s.Messages))
+
+	if s.Summary != "" {
+		fmt.Fprintf(&sb, "\n## Summary\n\n%s\n", strings.TrimSpace(s.Summary))
+	}
+
+	if len(s.Messages) > 0 {
+		sb.WriteString("\n## Conversation\n")
+	}
+	for _, msg := range s.Messages {
+		if msg.Role == "tool" {
+			sb.WriteString("\n### Tool result")
+			if msg.ToolCallID != "" {
+				fmt.Fprintf(&sb, " (%s)", msg.ToolCallID)
+			}
+			fmt.Fprintf(&sb, "\n\n```\n%s\n```\n", strings.TrimRight(msg.Content, "\n"))
+			continue
+		}
+		fmt.Fprintf(&sb, "\n### %s\n", roleTitle(msg.Role))
+		if content := strings.TrimSpace(msg.Content); content != "" {
+			fmt.Fprintf(&sb, "\n%s\n", content)
+		}
+		for _, tc := range msg.ToolCalls {
+			fmt.Fprintf(&sb, "\n→ `%s` %s\n", ToolCallName(tc), toolCallArguments(tc))
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func roleTitle(role string) string {
+	if role == "" {
+		return "Unknown"
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// ToolCallName returns the name of a tool call as stored in a session, where
+// it may only be set on the function.
+func ToolCallName(tc providers.ToolCall) string {
+	if tc.Name != "" {
+		return tc.Name
+	}
+	if tc.Function != nil {
+		return tc.Function.Name
+	}
+	return ""
+}
+
+func toolCallArguments(tc providers.ToolCall) string {
+	if tc.Function != nil && tc.Function.Arguments != "" {
+		return tc.Function.Arguments
+	}
+	data, _ := json.Marshal(tc.Arguments)
+	return string(data)
+}
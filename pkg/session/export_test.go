@@ -0,0 +1,74 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func exampleSession() Session {
+	created := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	return Session{
+		Key:     "telegram:42",
+		Summary: "The user is planning a trip.",
+		Created: created,
+		Updated: created.Add(time.Hour),
+		Messages: []providers.Message{
+			{Role: "user", Content: "What's the weather in Lisbon?"},
+			{Role: "assistant", ToolCalls: []providers.ToolCall{{
+				ID:       "call_1",
+				Function: &providers.FunctionCall{Name: "web_search", Arguments: `{"query":"Lisbon weather"}`},
+			}}},
+			{Role: "tool", ToolCallID: "call_1", Content: "Sunny, 24°C"},
+			{Role: "assistant", Content: "Sunny and 24°C."},
+		},
+	}
+}
+
+func TestExport_Markdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, exampleSession(), FormatMarkdown); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# Session telegram:42\n",
+		"## Summary\n\nThe user is planning a trip.\n",
+		"### User\n\nWhat's the weather in Lisbon?\n",
+		"→ `web_search` {\"query\":\"Lisbon weather\"}",
+		"### Tool result (call_1)\n\n```\nSunny, 24°C\n```\n",
+		"### Assistant\n\nSunny and 24°C.\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown export missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExport_JSONL(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, exampleSession(), FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want header + 4 messages", len(lines))
+	}
+
+	var header exportHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Key != "telegram:42" || header.Messages != 4 {
+		t.Errorf("header = %+v, %v", header, err)
+	}
+	var msg providers.Message
+	if err := json.Unmarshal([]byte(lines[3]), &msg); err != nil || msg.Role != "tool" || msg.ToolCallID != "call_1" {
+		t.Errorf("message = %+v, %v", msg, err)
+	}
+
+	if err := Export(&buf, exampleSession(), "pdf"); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
@@ -1,50 +1,125 @@
 package session
 
 import (
-	"encoding/json"
-	"os"
-	"path/filepath"
-	"strings"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/storage"
 )
 
 type Session struct {
 	Key      string              `json:"key"`
 	Messages []providers.Message `json:"messages"`
 	Summary  string              `json:"summary,omitempty"`
+	Metadata map[string]string   `json:"metadata,omitempty"` // see SetMetadata
 	Created  time.Time           `json:"created"`
 	Updated  time.Time           `json:"updated"`
+
+	// persisted is how many leading messages are known to be in the store;
+	// rev changes whenever those may have been rewritten.
+	persisted int
+	rev       int
+}
+
+// SessionInfo describes a session without its messages.
+type SessionInfo struct {
+	Key        string
+	Messages   int
+	HasSummary bool
+	Created    time.Time
+	Updated    time.Time
 }
 
+// SessionManager keeps conversation sessions in memory and persists them in
+// a storage.SessionStore. Stored sessions are only listed at startup; their
+// messages are loaded on first access.
 type SessionManager struct {
 	sessions map[string]*Session
+	unloaded map[string]storage.SessionMeta
 	mu       sync.RWMutex
-	storage  string
+	store    storage.SessionStore
 }
 
-func NewSessionManager(storage string) *SessionManager {
+// NewSessionManager creates a manager for the JSON session files in the
+// storage directory. An empty directory keeps sessions in memory only.
+func NewSessionManager(storageDir string) *SessionManager {
+	if storageDir == "" {
+		return NewSessionManagerWithStore(nil)
+	}
+	return NewSessionManagerWithStore(storage.NewJSONSessionStore(storageDir))
+}
+
+// NewSessionManagerWithStore creates a manager backed by store, which may be
+// nil to keep sessions in memory only.
+func NewSessionManagerWithStore(store storage.SessionStore) *SessionManager {
 	sm := &SessionManager{
 		sessions: make(map[string]*Session),
-		storage:  storage,
+		unloaded: make(map[string]storage.SessionMeta),
+		store:    store,
 	}
 
-	if storage != "" {
-		os.MkdirAll(storage, 0755)
-		sm.loadSessions()
+	if store != nil {
+		metas, err := store.ListSessions()
+		if err != nil {
+			logger.ErrorCF("session", "Failed to list sessions", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		for _, meta := range metas {
+			sm.unloaded[meta.Key] = meta
+		}
 	}
 
 	return sm
 }
 
+// lookup returns a session, loading it from the store on first access, or
+// nil if it does not exist. sm.mu must be held for writing.
+func (sm *SessionManager) lookup(key string) *Session {
+	if session, ok := sm.sessions[key]; ok {
+		return session
+	}
+	if _, ok := sm.unloaded[key]; !ok {
+		return nil
+	}
+	delete(sm.unloaded, key)
+
+	meta, messages, err := sm.store.LoadSession(key)
+	if err != nil || meta == nil {
+		if err != nil {
+			logger.ErrorCF("session", "Failed to load session", map[string]interface{}{
+				"session_key": key,
+				"error":       err.Error(),
+			})
+		}
+		return nil
+	}
+	if messages == nil {
+		messages = []providers.Message{}
+	}
+	session := &Session{
+		Key:       key,
+		Messages:  messages,
+		Summary:   meta.Summary,
+		Metadata:  meta.Metadata,
+		Created:   meta.Created,
+		Updated:   meta.Updated,
+		persisted: len(messages),
+	}
+	sm.sessions[key] = session
+	return session
+}
+
 func (sm *SessionManager) GetOrCreate(key string) *Session {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if ok {
+	session := sm.lookup(key)
+	if session != nil {
 		return session
 	}
 
@@ -72,8 +147,8 @@ func (sm *SessionManager) AddFullMessage(sessionKey string, msg providers.Messag
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[sessionKey]
-	if !ok {
+	session := sm.lookup(sessionKey)
+	if session == nil {
 		session = &Session{
 			Key:      sessionKey,
 			Messages: []providers.Message{},
@@ -87,11 +162,11 @@ func (sm *SessionManager) AddFullMessage(sessionKey string, msg providers.Messag
 }
 
 func (sm *SessionManager) GetHistory(key string) []providers.Message {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if !ok {
+	session := sm.lookup(key)
+	if session == nil {
 		return []providers.Message{}
 	}
 
@@ -104,164 +179,280 @@ func (sm *SessionManager) GetSummary(key string) string {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	session, ok := sm.sessions[key]
-	if !ok {
-		return ""
+	if session, ok := sm.sessions[key]; ok {
+		return session.Summary
 	}
-	return session.Summary
+	return sm.unloaded[key].Summary
 }
 
 func (sm *SessionManager) SetSummary(key string, summary string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if ok {
+	session := sm.lookup(key)
+	if session != nil {
 		session.Summary = summary
 		session.Updated = time.Now()
 	}
 }
 
-func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
+// GetMetadata returns the metadata entry name of a session.
+func (sm *SessionManager) GetMetadata(key, name string) string {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if !ok {
-		return
+	if session := sm.lookup(key); session != nil {
+		return session.Metadata[name]
 	}
+	return ""
+}
 
-	if keepLast <= 0 {
-		session.Messages = []providers.Message{}
-		session.Updated = time.Now()
-		return
+// SetMetadata sets the metadata entry name of a session, creating the
+// session if needed. Metadata holds per-conversation state of other
+// packages; it is saved with the session and cleared by Reset. An empty
+// value removes the entry.
+func (sm *SessionManager) SetMetadata(key, name, value string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session := sm.lookup(key)
+	if session == nil {
+		session = &Session{
+			Key:      key,
+			Messages: []providers.Message{},
+			Created:  time.Now(),
+		}
+		sm.sessions[key] = session
+	}
+	if value == "" {
+		delete(session.Metadata, name)
+	} else {
+		if session.Metadata == nil {
+			session.Metadata = make(map[string]string)
+		}
+		session.Metadata[name] = value
 	}
+	session.Updated = time.Now()
+}
 
-	if len(session.Messages) <= keepLast {
-		return
+// Summaries returns the summaries of all sessions that have one, keyed by
+// session key.
+func (sm *SessionManager) Summaries() map[string]string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	summaries := make(map[string]string)
+	for key, meta := range sm.unloaded {
+		if meta.Summary != "" {
+			summaries[key] = meta.Summary
+		}
+	}
+	for key, session := range sm.sessions {
+		if session.Summary != "" {
+			summaries[key] = session.Summary
+		}
 	}
+	return summaries
+}
 
-	session.Messages = session.Messages[len(session.Messages)-keepLast:]
+// rewrite replaces the messages of a session with ones that do not extend
+// what was stored before.
+func (session *Session) rewrite(messages []providers.Message) {
+	session.Messages = messages
 	session.Updated = time.Now()
+	session.persisted = 0
+	session.rev++
 }
 
-// sanitizeFilename converts a session key into a cross-platform safe filename.
-// Session keys use "channel:chatID" (e.g. "telegram:123456") but ':' is the
-// volume separator on Windows, so filepath.Base would misinterpret the key.
-// We replace it with '_'. The original key is preserved inside the JSON file,
-// so loadSessions still maps back to the right in-memory key.
-func sanitizeFilename(key string) string {
-	return strings.ReplaceAll(key, ":", "_")
-}
+func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-func (sm *SessionManager) Save(key string) error {
-	if sm.storage == "" {
-		return nil
+	session := sm.lookup(key)
+	if session == nil {
+		return
 	}
 
-	filename := sanitizeFilename(key)
-
-	// filepath.IsLocal rejects empty names, "..", absolute paths, and
-	// OS-reserved device names (NUL, COM1 … on Windows).
-	// The extra checks reject "." and any directory separators so that
-	// the session file is always written directly inside sm.storage.
-	if filename == "." || !filepath.IsLocal(filename) || strings.ContainsAny(filename, `/\`) {
-		return os.ErrInvalid
+	if keepLast <= 0 {
+		session.rewrite([]providers.Message{})
+		return
 	}
 
-	// Snapshot under read lock, then perform slow file I/O after unlock.
-	sm.mu.RLock()
-	stored, ok := sm.sessions[key]
-	if !ok {
-		sm.mu.RUnlock()
-		return nil
+	if len(session.Messages) <= keepLast {
+		return
 	}
 
+	session.rewrite(session.Messages[len(session.Messages)-keepLast:])
+}
+
+// copySession returns a copy of stored that shares no messages or metadata
+// with it.
+func copySession(stored *Session) Session {
 	snapshot := Session{
 		Key:     stored.Key,
 		Summary: stored.Summary,
 		Created: stored.Created,
 		Updated: stored.Updated,
 	}
+	if len(stored.Metadata) > 0 {
+		snapshot.Metadata = make(map[string]string, len(stored.Metadata))
+		for name, value := range stored.Metadata {
+			snapshot.Metadata[name] = value
+		}
+	}
 	if len(stored.Messages) > 0 {
 		snapshot.Messages = make([]providers.Message, len(stored.Messages))
 		copy(snapshot.Messages, stored.Messages)
 	} else {
 		snapshot.Messages = []providers.Message{}
 	}
-	sm.mu.RUnlock()
+	return snapshot
+}
 
-	data, err := json.MarshalIndent(snapshot, "", "  ")
-	if err != nil {
-		return err
+// Save persists a session. Stores that support it only append the messages
+// added since the last save.
+func (sm *SessionManager) Save(key string) error {
+	if sm.store == nil {
+		return nil
 	}
 
-	sessionPath := filepath.Join(sm.storage, filename+".json")
-	tmpFile, err := os.CreateTemp(sm.storage, "session-*.tmp")
-	if err != nil {
-		return err
+	// Snapshot under read lock, then perform slow I/O after unlock.
+	sm.mu.RLock()
+	stored, ok := sm.sessions[key]
+	if !ok {
+		sm.mu.RUnlock()
+		return nil
 	}
+	snapshot := copySession(stored)
+	persisted, rev := stored.persisted, stored.rev
+	sm.mu.RUnlock()
 
-	tmpPath := tmpFile.Name()
-	cleanup := true
-	defer func() {
-		if cleanup {
-			_ = os.Remove(tmpPath)
-		}
-	}()
-
-	if _, err := tmpFile.Write(data); err != nil {
-		_ = tmpFile.Close()
-		return err
-	}
-	if err := tmpFile.Chmod(0644); err != nil {
-		_ = tmpFile.Close()
-		return err
-	}
-	if err := tmpFile.Sync(); err != nil {
-		_ = tmpFile.Close()
-		return err
+	meta := storage.SessionMeta{
+		Key:      snapshot.Key,
+		Summary:  snapshot.Summary,
+		Metadata: snapshot.Metadata,
+		Created:  snapshot.Created,
+		Updated:  snapshot.Updated,
+		Messages: len(snapshot.Messages),
 	}
-	if err := tmpFile.Close(); err != nil {
+	if err := sm.store.SaveSession(meta, snapshot.Messages, persisted); err != nil {
 		return err
 	}
 
-	if err := os.Rename(tmpPath, sessionPath); err != nil {
-		return err
+	sm.mu.Lock()
+	if sm.sessions[key] == stored && stored.rev == rev {
+		stored.persisted = len(snapshot.Messages)
 	}
-	cleanup = false
+	sm.mu.Unlock()
 	return nil
 }
 
-func (sm *SessionManager) loadSessions() error {
-	files, err := os.ReadDir(sm.storage)
-	if err != nil {
-		return err
-	}
+// List returns all sessions, most recently updated first.
+func (sm *SessionManager) List() []SessionInfo {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+	infos := make([]SessionInfo, 0, len(sm.sessions)+len(sm.unloaded))
+	for _, meta := range sm.unloaded {
+		infos = append(infos, SessionInfo{
+			Key:        meta.Key,
+			Messages:   meta.Messages,
+			HasSummary: meta.Summary != "",
+			Created:    meta.Created,
+			Updated:    meta.Updated,
+		})
+	}
+	for _, session := range sm.sessions {
+		infos = append(infos, SessionInfo{
+			Key:        session.Key,
+			Messages:   len(session.Messages),
+			HasSummary: session.Summary != "",
+			Created:    session.Created,
+			Updated:    session.Updated,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].Updated.Equal(infos[j].Updated) {
+			return infos[i].Updated.After(infos[j].Updated)
 		}
+		return infos[i].Key < infos[j].Key
+	})
+	return infos
+}
 
-		if filepath.Ext(file.Name()) != ".json" {
-			continue
-		}
+// Get returns a copy of a session.
+func (sm *SessionManager) Get(key string) (Session, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-		sessionPath := filepath.Join(sm.storage, file.Name())
-		data, err := os.ReadFile(sessionPath)
-		if err != nil {
-			continue
-		}
+	session := sm.lookup(key)
+	if session == nil {
+		return Session{}, false
+	}
+	return copySession(session), true
+}
 
-		var session Session
-		if err := json.Unmarshal(data, &session); err != nil {
-			continue
-		}
+// Reset clears the history, summary and metadata of a session and saves it.
+func (sm *SessionManager) Reset(key string) error {
+	sm.mu.Lock()
+	session := sm.lookup(key)
+	if session != nil {
+		session.rewrite([]providers.Message{})
+		session.Summary = ""
+		session.Metadata = nil
+	}
+	sm.mu.Unlock()
+
+	if session == nil {
+		return nil
+	}
+	return sm.Save(key)
+}
 
-		sm.sessions[session.Key] = &session
+// Delete removes a session from memory and the store.
+func (sm *SessionManager) Delete(key string) error {
+	sm.mu.Lock()
+	_, loaded := sm.sessions[key]
+	_, stored := sm.unloaded[key]
+	delete(sm.sessions, key)
+	delete(sm.unloaded, key)
+	sm.mu.Unlock()
+
+	if !loaded && !stored {
+		return fmt.Errorf("session %q not found", key)
 	}
+	if sm.store == nil {
+		return nil
+	}
+	return sm.store.DeleteSession(key)
+}
 
+// Fork copies the history and summary of session src into a new session
+// dst and saves it. Later messages in either session do not affect the other.
+func (sm *SessionManager) Fork(src, dst string) error {
+	sm.mu.Lock()
+	source := sm.lookup(src)
+	if source == nil {
+		sm.mu.Unlock()
+		return fmt.Errorf("session %q not found", src)
+	}
+	if sm.lookup(dst) != nil {
+		sm.mu.Unlock()
+		return fmt.Errorf("session %q already exists", dst)
+	}
+	fork := copySession(source)
+	fork.Key = dst
+	fork.Created = time.Now()
+	fork.Updated = fork.Created
+	sm.sessions[dst] = &fork
+	sm.mu.Unlock()
+
+	if err := sm.Save(dst); err != nil {
+		sm.mu.Lock()
+		delete(sm.sessions, dst)
+		sm.mu.Unlock()
+		return fmt.Errorf("failed to save session %q: %w", dst, err)
+	}
 	return nil
 }
 
@@ -270,13 +461,12 @@ func (sm *SessionManager) SetHistory(key string, history []providers.Message) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, ok := sm.sessions[key]
-	if ok {
+	session := sm.lookup(key)
+	if session != nil {
 		// Create a deep copy to strictly isolate internal state
 		// from the caller's slice.
 		msgs := make([]providers.Message, len(history))
 		copy(msgs, history)
-		session.Messages = msgs
-		session.Updated = time.Now()
+		session.rewrite(msgs)
 	}
 }
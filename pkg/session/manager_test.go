@@ -1,33 +1,14 @@
 package session
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
-)
 
-func TestSanitizeFilename(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{"simple", "simple"},
-		{"telegram:123456", "telegram_123456"},
-		{"discord:987654321", "discord_987654321"},
-		{"slack:C01234", "slack_C01234"},
-		{"no-colons-here", "no-colons-here"},
-		{"multiple:colons:here", "multiple_colons_here"},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			got := sanitizeFilename(tt.input)
-			if got != tt.expected {
-				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.input, got, tt.expected)
-			}
-		})
-	}
-}
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/storage"
+)
 
 func TestSave_WithColonInKey(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -72,3 +53,107 @@ func TestSave_RejectsPathTraversal(t *testing.T) {
 		}
 	}
 }
+
+func TestResetDeleteFork(t *testing.T) {
+	tmpDir := t.TempDir()
+	sm := NewSessionManager(tmpDir)
+
+	sm.AddMessage("telegram:1", "user", "plan a trip")
+	sm.AddMessage("telegram:1", "assistant", "Where to?")
+	sm.SetSummary("telegram:1", "Planning a trip.")
+	sm.SetMetadata("telegram:1", "mode", "travel")
+	sm.Save("telegram:1")
+
+	if err := sm.Fork("telegram:1", "experiment"); err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	if err := sm.Fork("telegram:1", "experiment"); err == nil {
+		t.Error("forking onto an existing key should fail")
+	}
+	if err := sm.Fork("missing", "other"); err == nil {
+		t.Error("forking a missing session should fail")
+	}
+	sm.AddMessage("experiment", "user", "to Lisbon")
+	if got := len(sm.GetHistory("telegram:1")); got != 2 {
+		t.Errorf("fork changed the original: %d messages", got)
+	}
+
+	// The fork is saved and survives a reload
+	fork, ok := NewSessionManager(tmpDir).Get("experiment")
+	if !ok || len(fork.Messages) != 2 || fork.Summary != "Planning a trip." || fork.Metadata["mode"] != "travel" {
+		t.Errorf("reloaded fork = %+v", fork)
+	}
+
+	if err := sm.Reset("telegram:1"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	reloaded := NewSessionManager(tmpDir)
+	if len(reloaded.GetHistory("telegram:1")) != 0 || reloaded.GetSummary("telegram:1") != "" || reloaded.GetMetadata("telegram:1", "mode") != "" {
+		t.Error("reset session should be empty after reload")
+	}
+
+	infos := sm.List()
+	if len(infos) != 2 || infos[0].Key != "telegram:1" || infos[0].Messages != 0 || infos[1].Messages != 3 || !infos[1].HasSummary {
+		t.Errorf("List = %+v", infos)
+	}
+
+	if err := sm.Delete("experiment"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "experiment.json")); !os.IsNotExist(err) {
+		t.Error("session file should be removed")
+	}
+	if err := sm.Delete("experiment"); err == nil {
+		t.Error("deleting a missing session should fail")
+	}
+}
+
+// recordingStore records what a SessionManager asks its store for.
+type recordingStore struct {
+	storage.SessionStore
+	loads  []string
+	stored []int
+}
+
+func (s *recordingStore) LoadSession(key string) (*storage.SessionMeta, []providers.Message, error) {
+	s.loads = append(s.loads, key)
+	return s.SessionStore.LoadSession(key)
+}
+
+func (s *recordingStore) SaveSession(meta storage.SessionMeta, messages []providers.Message, stored int) error {
+	s.stored = append(s.stored, stored)
+	return s.SessionStore.SaveSession(meta, messages, stored)
+}
+
+func TestLazyLoadAndIncrementalSave(t *testing.T) {
+	tmpDir := t.TempDir()
+	sm := NewSessionManager(tmpDir)
+	sm.AddMessage("a", "user", "hello")
+	sm.SetSummary("a", "Greetings.")
+	sm.Save("a")
+	sm.AddMessage("b", "user", "other")
+	sm.Save("b")
+
+	store := &recordingStore{SessionStore: storage.NewJSONSessionStore(tmpDir)}
+	sm = NewSessionManagerWithStore(store)
+	if len(sm.List()) != 2 || sm.GetSummary("a") != "Greetings." || len(store.loads) != 0 {
+		t.Fatalf("sessions should be listed without loading them, loaded %v", store.loads)
+	}
+
+	sm.AddMessage("a", "assistant", "hi")
+	sm.Save("a")
+	sm.AddMessage("a", "user", "bye")
+	sm.Save("a")
+	sm.TruncateHistory("a", 1)
+	sm.Save("a")
+
+	if len(store.loads) != 1 || store.loads[0] != "a" {
+		t.Errorf("loads = %v, want only a", store.loads)
+	}
+	if want := []int{1, 2, 0}; fmt.Sprint(store.stored) != fmt.Sprint(want) {
+		t.Errorf("stored = %v, want %v", store.stored, want)
+	}
+	if history := NewSessionManager(tmpDir).GetHistory("a"); len(history) != 1 || history[0].Content != "bye" {
+		t.Errorf("reloaded history = %+v", history)
+	}
+}
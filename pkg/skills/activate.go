@@ -0,0 +1,180 @@
+package skills
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Activation modes, see Activator.
+const (
+	ActivateOff        = "off"
+	ActivateKeywords   = "keywords"
+	ActivateEmbeddings = "embeddings"
+)
+
+// Embedder turns texts into vectors. providers.EmbeddingProvider implements it.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string, model string) ([][]float32, error)
+}
+
+// Activator picks the skills a message is about, so their instructions can
+// be loaded before the model asks for them. In keywords mode a skill matches
+// when the message mentions its name or one of its frontmatter keywords; in
+// embeddings mode when its name and description are similar enough to the
+// message.
+type Activator struct {
+	loader    *SkillsLoader
+	mode      string
+	embedder  Embedder
+	model     string
+	threshold float64
+
+	mu      sync.Mutex
+	vectors map[string][]float32 // by skill text, see skillText
+}
+
+// NewActivator creates an activator over the skills of loader. Embeddings
+// mode falls back to keywords until SetEmbedder is called.
+func NewActivator(loader *SkillsLoader, mode string) *Activator {
+	return &Activator{
+		loader:  loader,
+		mode:    mode,
+		vectors: make(map[string][]float32),
+	}
+}
+
+// SetEmbedder sets the embedding model used in embeddings mode, and the
+// cosine similarity a skill needs to be activated.
+func (a *Activator) SetEmbedder(embedder Embedder, model string, threshold float64) {
+	a.embedder = embedder
+	a.model = model
+	a.threshold = threshold
+}
+
+// Match returns the names of up to max skills relevant to message, the most
+// relevant first.
+func (a *Activator) Match(ctx context.Context, message string, max int) []string {
+	if a == nil || a.mode == "" || a.mode == ActivateOff || strings.TrimSpace(message) == "" || max <= 0 {
+		return nil
+	}
+	all := a.loader.ListSkills()
+	if len(all) == 0 {
+		return nil
+	}
+
+	var scores []float64
+	if a.mode == ActivateEmbeddings && a.embedder != nil {
+		var err error
+		if scores, err = a.similarities(ctx, all, message); err != nil {
+			slog.Warn("skill embeddings failed, matching keywords", "error", err)
+			scores = nil
+		}
+	}
+	if scores == nil {
+		scores = keywordScores(all, message)
+	}
+
+	order := make([]int, 0, len(all))
+	for i, score := range scores {
+		if score > 0 {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	var names []string
+	for _, i := range order {
+		if len(names) == max {
+			break
+		}
+		names = append(names, all[i].Name)
+	}
+	return names
+}
+
+// keywordScores counts the terms of each skill, its name and keywords, that
+// appear in message as whole words.
+func keywordScores(all []SkillInfo, message string) []float64 {
+	text := " " + strings.Join(words(message), " ") + " "
+	scores := make([]float64, len(all))
+	for i, s := range all {
+		for _, term := range append([]string{s.Name}, s.Keywords...) {
+			if w := words(term); len(w) > 0 && strings.Contains(text, " "+strings.Join(w, " ")+" ") {
+				scores[i]++
+			}
+		}
+	}
+	return scores
+}
+
+// words splits s into lower case words of letters and digits.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// similarities returns the cosine similarity of each skill to message, or 0
+// below the threshold. Skill embeddings are computed once.
+func (a *Activator) similarities(ctx context.Context, all []SkillInfo, message string) ([]float64, error) {
+	texts := []string{message}
+	a.mu.Lock()
+	for _, s := range all {
+		if _, ok := a.vectors[skillText(s)]; !ok {
+			texts = append(texts, skillText(s))
+		}
+	}
+	a.mu.Unlock()
+
+	vectors, err := a.embedder.Embed(ctx, texts, a.model)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(vectors), len(texts))
+	}
+	query := vectors[0]
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, text := range texts[1:] {
+		a.vectors[text] = vectors[i+1]
+	}
+	scores := make([]float64, len(all))
+	for i, s := range all {
+		if sim := cosine(query, a.vectors[skillText(s)]); sim >= a.threshold {
+			scores[i] = sim
+		}
+	}
+	return scores, nil
+}
+
+func skillText(s SkillInfo) string {
+	text := s.Name + ": " + s.Description
+	if len(s.Keywords) > 0 {
+		text += " (" + strings.Join(s.Keywords, ", ") + ")"
+	}
+	return text
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,91 @@
+package skills
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newActivatorWorkspace(t *testing.T) *SkillsLoader {
+	t.Helper()
+	workspace := t.TempDir()
+	skillsDir := filepath.Join(workspace, "skills")
+	writeSkill(t, skillsDir, "weather", "---\nname: weather\ndescription: Weather forecasts\nkeywords: [forecast, \"rain\"]\n---\n# Weather", map[string]string{})
+	writeSkill(t, skillsDir, "image-gen", "---\nname: image-gen\ndescription: Generate images\nkeywords: draw a picture\n---\n# Images", map[string]string{})
+	writeSkill(t, skillsDir, "notes", `---
+{"name": "notes", "description": "Take notes", "keywords": ["remember", "note"]}
+---
+# Notes`, map[string]string{})
+	return NewSkillsLoader(workspace, "", "")
+}
+
+func TestActivator_Keywords(t *testing.T) {
+	activator := NewActivator(newActivatorWorkspace(t), ActivateKeywords)
+	ctx := context.Background()
+
+	testcases := []struct {
+		message string
+		want    []string
+	}{
+		{message: "Will it RAIN tomorrow?", want: []string{"weather"}},
+		{message: "Use image-gen to draw a picture of the weather forecast", want: []string{"image-gen", "weather"}},
+		{message: "note that down", want: []string{"notes"}},
+		{message: "rainbow notebook", want: nil}, // whole words only
+		{message: "", want: nil},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.want, activator.Match(ctx, tc.message, 3), tc.message)
+	}
+	assert.Equal(t, []string{"image-gen"}, activator.Match(ctx, "Use image-gen to draw a picture of the weather forecast", 1))
+	assert.Nil(t, NewActivator(activator.loader, ActivateOff).Match(ctx, "rain", 3))
+}
+
+// fakeEmbedder maps texts to fixed vectors and counts the texts embedded
+type fakeEmbedder struct {
+	vectors  map[string][]float32
+	embedded int
+	err      error
+}
+
+func (e *fakeEmbedder) Embed(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.embedded += len(texts)
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		if v, ok := e.vectors[text]; ok {
+			out[i] = v
+		} else {
+			out[i] = []float32{0, 0, 1}
+		}
+	}
+	return out, nil
+}
+
+func TestActivator_Embeddings(t *testing.T) {
+	embedder := &fakeEmbedder{vectors: map[string][]float32{
+		"weather: Weather forecasts (forecast, rain)": {1, 0, 0},
+		"notes: Take notes (remember, note)":          {0, 1, 0},
+		"image-gen: Generate images (draw a picture)": {-1, 0, 0},
+		"should I bring an umbrella?":                 {0.9, 0.3, 0},
+	}}
+	activator := NewActivator(newActivatorWorkspace(t), ActivateEmbeddings)
+	activator.SetEmbedder(embedder, "test-embedding", 0.3)
+	ctx := context.Background()
+
+	assert.Equal(t, []string{"weather", "notes"}, activator.Match(ctx, "should I bring an umbrella?", 3))
+	assert.Equal(t, 4, embedder.embedded)
+
+	// Skill embeddings are cached
+	require.Empty(t, activator.Match(ctx, "hello", 3))
+	assert.Equal(t, 5, embedder.embedded)
+
+	// Keywords are matched when the embedding model fails
+	embedder.err = errors.New("unavailable")
+	assert.Equal(t, []string{"weather"}, activator.Match(ctx, "rain again", 3))
+}
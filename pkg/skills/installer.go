@@ -4,16 +4,25 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
+// DefaultRegistryURL is the index of skills installable by name.
+const DefaultRegistryURL = "https://raw.githubusercontent.com/sipeed/picoclaw-skills/main/skills.json"
+
 type SkillInstaller struct {
-	workspace string
+	workspace  string
+	registries []string // local registry directories, searched first
+	indexURL   string
+
+	client       *http.Client
+	githubAPIURL string
+	codeloadURL  string
 }
 
 type AvailableSkill struct {
@@ -22,98 +31,392 @@ type AvailableSkill struct {
 	Description string   `json:"description"`
 	Author      string   `json:"author"`
 	Tags        []string `json:"tags"`
+	Version     string   `json:"version,omitempty"`
 }
 
 type BuiltinSkill struct {
-	Name    string `json:"name"`
-	Path    string `json:"path"`
-	Enabled bool   `json:"enabled"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// InstalledSkill is a skill written by Install or Update.
+type InstalledSkill struct {
+	Name string
+	LockEntry
+}
+
+// SkillUpdate is the state of an installed skill compared to its source.
+type SkillUpdate struct {
+	Name     string
+	Current  LockEntry
+	Latest   LockEntry // empty when a GitHub ref still points to the installed commit
+	Modified bool      // the installed files differ from the lockfile
+	Err      error
+}
+
+// Outdated reports whether the source has changed since the install.
+func (u SkillUpdate) Outdated() bool {
+	return u.Latest.Checksum != "" && u.Latest.Checksum != u.Current.Checksum
 }
 
 func NewSkillInstaller(workspace string) *SkillInstaller {
 	return &SkillInstaller{
-		workspace: workspace,
+		workspace:    workspace,
+		indexURL:     DefaultRegistryURL,
+		client:       &http.Client{Timeout: 2 * time.Minute},
+		githubAPIURL: "https://api.github.com",
+		codeloadURL:  "https://codeload.github.com",
 	}
 }
 
-func (si *SkillInstaller) InstallFromGitHub(ctx context.Context, repo string) error {
-	skillDir := filepath.Join(si.workspace, "skills", filepath.Base(repo))
+// SetRegistries sets the local registry directories searched for skills
+// installed by name, and the URL of the remote index searched after them.
+func (si *SkillInstaller) SetRegistries(dirs []string, indexURL string) {
+	si.registries = dirs
+	if indexURL != "" {
+		si.indexURL = indexURL
+	}
+}
 
-	if _, err := os.Stat(skillDir); err == nil {
-		return fmt.Errorf("skill '%s' already exists", filepath.Base(repo))
+func (si *SkillInstaller) skillsDir() string {
+	return filepath.Join(si.workspace, "skills")
+}
+
+// Install installs the skill at source, see ParseSource, into the workspace
+// together with the skills it depends on, and records them in the lockfile.
+// An installed skill is only replaced with force.
+func (si *SkillInstaller) Install(ctx context.Context, source string, force bool) ([]InstalledSkill, error) {
+	lock, err := ReadLock(si.workspace)
+	if err != nil {
+		return nil, err
+	}
+	run := &installRun{si: si, lock: lock, visiting: map[string]bool{}}
+	_, err = run.install(ctx, source, force, false)
+	if len(run.installed) > 0 {
+		if werr := lock.Write(si.workspace); werr != nil && err == nil {
+			err = fmt.Errorf("failed to write %s: %w", LockFile, werr)
+		}
 	}
+	return run.installed, err
+}
 
-	url := fmt.Sprintf("https://raw.githubusercontent.com/%s/main/SKILL.md", repo)
+type installRun struct {
+	si        *SkillInstaller
+	lock      *Lock
+	visiting  map[string]bool // sources being installed, to catch cycles
+	installed []InstalledSkill
+}
 
-	client := &http.Client{Timeout: 15 * time.Second}
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// install installs source and returns the skill's name. Dependencies that
+// are already installed are kept.
+func (r *installRun) install(ctx context.Context, source string, force, dependency bool) (string, error) {
+	src, err := ParseSource(source)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", err
+	}
+	if r.visiting[source] {
+		return "", fmt.Errorf("dependency cycle at %s", source)
+	}
+	if dependency {
+		for name, entry := range r.lock.Skills {
+			if entry.Source == source {
+				return name, nil
+			}
+		}
+		if src.Kind == SourceRegistry && r.si.exists(src.Name) {
+			return src.Name, nil
+		}
 	}
 
-	resp, err := client.Do(req)
+	tmp, err := r.si.tempDir()
 	if err != nil {
-		return fmt.Errorf("failed to fetch skill: %w", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	defer os.RemoveAll(tmp)
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to fetch skill: HTTP %d", resp.StatusCode)
+	f, err := r.si.fetch(ctx, src, tmp, "")
+	if err != nil {
+		return "", err
+	}
+	name := f.meta.Name
+	if r.si.exists(name) {
+		if dependency {
+			return name, nil
+		}
+		if !force {
+			return "", fmt.Errorf("skill '%s' already exists", name)
+		}
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	if locked, ok := r.lock.Skills[name]; ok {
+		if err := checkCommit(locked, f.entry); err != nil {
+			return "", err
+		}
+	}
+
+	r.visiting[source] = true
+	defer delete(r.visiting, source)
+	if err := r.dependencies(ctx, name, f); err != nil {
+		return "", err
+	}
+	if err := r.si.replace(f.dir, name, tmp); err != nil {
+		return "", err
+	}
+	r.record(name, f.entry)
+	return name, nil
+}
+
+// dependencies installs the dependencies of a fetched skill and records
+// their names in its lock entry.
+func (r *installRun) dependencies(ctx context.Context, name string, f *fetched) error {
+	for _, dep := range f.meta.Dependencies {
+		depName, err := r.install(ctx, dep, false, true)
+		if err != nil {
+			return fmt.Errorf("dependency %s of %s: %w", dep, name, err)
+		}
+		f.entry.Dependencies = append(f.entry.Dependencies, depName)
+	}
+	return nil
+}
+
+func (r *installRun) record(name string, entry LockEntry) {
+	entry.InstalledAt = time.Now().UTC().Truncate(time.Second)
+	r.lock.Skills[name] = entry
+	r.installed = append(r.installed, InstalledSkill{Name: name, LockEntry: entry})
+}
+
+// Outdated compares the skills in the lockfile with their sources.
+func (si *SkillInstaller) Outdated(ctx context.Context) ([]SkillUpdate, error) {
+	lock, err := ReadLock(si.workspace)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return nil, err
+	}
+	names := make([]string, 0, len(lock.Skills))
+	for name := range lock.Skills {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	if err := os.MkdirAll(skillDir, 0755); err != nil {
-		return fmt.Errorf("failed to create skill directory: %w", err)
+	updates := make([]SkillUpdate, 0, len(names))
+	for _, name := range names {
+		update, f, tmp := si.check(ctx, name, lock.Skills[name])
+		if tmp != "" {
+			os.RemoveAll(tmp)
+		}
+		if f != nil {
+			update.Latest = f.entry
+		}
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
+
+// check fetches the source of an installed skill. The caller removes tmp.
+func (si *SkillInstaller) check(ctx context.Context, name string, entry LockEntry) (SkillUpdate, *fetched, string) {
+	update := SkillUpdate{Name: name, Current: entry}
+	if checksum, err := DirChecksum(filepath.Join(si.skillsDir(), name)); err != nil || checksum != entry.Checksum {
+		update.Modified = true
 	}
 
-	skillPath := filepath.Join(skillDir, "SKILL.md")
-	if err := os.WriteFile(skillPath, body, 0644); err != nil {
-		return fmt.Errorf("failed to write skill file: %w", err)
+	src, err := ParseSource(entry.Source)
+	if err != nil {
+		update.Err = err
+		return update, nil, ""
+	}
+	tmp, err := si.tempDir()
+	if err != nil {
+		update.Err = err
+		return update, nil, ""
+	}
+	f, err := si.fetch(ctx, src, tmp, entry.Commit)
+	update.Err = err
+	return update, f, tmp
+}
+
+// Update reinstalls the named skills, or all in the lockfile, whose source
+// has changed. Skills with local changes are skipped unless force is set.
+func (si *SkillInstaller) Update(ctx context.Context, names []string, force bool) ([]SkillUpdate, []InstalledSkill, error) {
+	lock, err := ReadLock(si.workspace)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(names) == 0 {
+		for name := range lock.Skills {
+			names = append(names, name)
+		}
+		sort.Strings(names)
 	}
 
+	run := &installRun{si: si, lock: lock, visiting: map[string]bool{}}
+	var updates []SkillUpdate
+	for _, name := range names {
+		entry, ok := lock.Skills[name]
+		if !ok {
+			updates = append(updates, SkillUpdate{Name: name, Err: fmt.Errorf("not in %s, install it again to track its source", LockFile)})
+			continue
+		}
+		update, f, tmp := si.check(ctx, name, entry)
+		if f != nil {
+			update.Latest = f.entry
+		}
+		switch {
+		case update.Err != nil || !update.Outdated():
+		case update.Modified && !force:
+			update.Err = fmt.Errorf("has local changes, use --force to overwrite them")
+		case f.meta.Name != name:
+			update.Err = fmt.Errorf("source now provides skill %q", f.meta.Name)
+		default:
+			run.visiting[entry.Source] = true
+			if err := checkCommit(entry, f.entry); err != nil {
+				update.Err = err
+			} else if err := run.dependencies(ctx, name, f); err != nil {
+				update.Err = err
+			} else if err := si.replace(f.dir, name, tmp); err != nil {
+				update.Err = err
+			} else {
+				run.record(name, f.entry)
+			}
+			delete(run.visiting, entry.Source)
+		}
+		if tmp != "" {
+			os.RemoveAll(tmp)
+		}
+		updates = append(updates, update)
+	}
+
+	if len(run.installed) > 0 {
+		if err := lock.Write(si.workspace); err != nil {
+			return updates, run.installed, fmt.Errorf("failed to write %s: %w", LockFile, err)
+		}
+	}
+	return updates, run.installed, nil
+}
+
+// checkCommit fails when the files fetched at the commit of a lock entry are
+// not the ones it recorded. A commit can't change, so the download was
+// tampered with.
+func checkCommit(locked, fetched LockEntry) error {
+	if locked.Commit == "" || locked.Commit != fetched.Commit || locked.Checksum == fetched.Checksum {
+		return nil
+	}
+	return fmt.Errorf("files of %s at commit %s don't match %s (got %s, want %s)",
+		fetched.Source, fetched.Commit, LockFile, fetched.Checksum, locked.Checksum)
+}
+
+func (si *SkillInstaller) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(si.skillsDir(), name))
+	return err == nil
+}
+
+// tempDir creates a directory next to the installed skills, so finished
+// installs can be moved into place. The loader does not list its content.
+func (si *SkillInstaller) tempDir() (string, error) {
+	if err := os.MkdirAll(si.skillsDir(), 0755); err != nil {
+		return "", fmt.Errorf("failed to create skills directory: %w", err)
+	}
+	return os.MkdirTemp(si.skillsDir(), ".install-")
+}
+
+// replace moves dir into place as the skill name. A previous install is
+// moved into tmp, where it is removed with it.
+func (si *SkillInstaller) replace(dir, name, tmp string) error {
+	target := filepath.Join(si.skillsDir(), name)
+	if _, err := os.Stat(target); err == nil {
+		if err := os.Rename(target, filepath.Join(tmp, "previous")); err != nil {
+			return fmt.Errorf("failed to replace skill: %w", err)
+		}
+	}
+	if err := os.Rename(dir, target); err != nil {
+		return fmt.Errorf("failed to install skill: %w", err)
+	}
 	return nil
 }
 
+// Uninstall removes a skill and its lockfile entry. Skills other installed
+// skills depend on are kept.
 func (si *SkillInstaller) Uninstall(skillName string) error {
-	skillDir := filepath.Join(si.workspace, "skills", skillName)
+	skillDir := filepath.Join(si.skillsDir(), skillName)
 
 	if _, err := os.Stat(skillDir); os.IsNotExist(err) {
 		return fmt.Errorf("skill '%s' not found", skillName)
 	}
 
+	lock, err := ReadLock(si.workspace)
+	if err != nil {
+		return err
+	}
+	var dependents []string
+	for name, entry := range lock.Skills {
+		for _, dep := range entry.Dependencies {
+			if dep == skillName && name != skillName {
+				dependents = append(dependents, name)
+			}
+		}
+	}
+	if len(dependents) > 0 {
+		sort.Strings(dependents)
+		return fmt.Errorf("skill '%s' is required by %s", skillName, strings.Join(dependents, ", "))
+	}
+
 	if err := os.RemoveAll(skillDir); err != nil {
 		return fmt.Errorf("failed to remove skill: %w", err)
 	}
 
+	if _, ok := lock.Skills[skillName]; ok {
+		delete(lock.Skills, skillName)
+		if err := lock.Write(si.workspace); err != nil {
+			return fmt.Errorf("failed to write %s: %w", LockFile, err)
+		}
+	}
 	return nil
 }
 
+// ListAvailableSkills lists the skills of the local registries followed by
+// those of the remote index. If the index can't be fetched, the local
+// skills are returned with the error.
 func (si *SkillInstaller) ListAvailableSkills(ctx context.Context) ([]AvailableSkill, error) {
-	url := "https://raw.githubusercontent.com/sipeed/picoclaw-skills/main/skills.json"
-
-	client := &http.Client{Timeout: 15 * time.Second}
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch skills list: %w", err)
+	var skills []AvailableSkill
+	for _, registry := range si.registries {
+		dir := expandHome(registry)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			skillDir := filepath.Join(dir, e.Name())
+			if _, err := os.Stat(filepath.Join(skillDir, "SKILL.md")); err != nil {
+				if latest := latestVersion(skillDir); latest != "" {
+					skillDir = filepath.Join(skillDir, latest)
+				} else {
+					continue
+				}
+			}
+			meta := (&SkillsLoader{}).getSkillMetadata(filepath.Join(skillDir, "SKILL.md"))
+			if meta == nil {
+				continue
+			}
+			skills = append(skills, AvailableSkill{
+				Name:        e.Name(),
+				Repository:  skillDir,
+				Description: meta.Description,
+				Version:     meta.Version,
+			})
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to fetch skills list: HTTP %d", resp.StatusCode)
-	}
+	index, err := si.fetchIndex(ctx)
+	return append(skills, index...), err
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (si *SkillInstaller) fetchIndex(ctx context.Context) ([]AvailableSkill, error) {
+	body, err := si.get(ctx, si.indexURL, "", 10<<20)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to fetch skills list: %w", err)
 	}
 
 	var skills []AvailableSkill
@@ -124,6 +427,8 @@ func (si *SkillInstaller) ListAvailableSkills(ctx context.Context) ([]AvailableS
 	return skills, nil
 }
 
+// ListBuiltinSkills lists the skills shipped with PicoClaw, which
+// install-builtin copies into the workspace.
 func (si *SkillInstaller) ListBuiltinSkills() []BuiltinSkill {
 	builtinSkillsDir := filepath.Join(filepath.Dir(si.workspace), "picoclaw", "skills")
 
@@ -134,38 +439,20 @@ func (si *SkillInstaller) ListBuiltinSkills() []BuiltinSkill {
 
 	var skills []BuiltinSkill
 	for _, entry := range entries {
-		if entry.IsDir() {
-			_ = entry
-			skillName := entry.Name()
-			skillFile := filepath.Join(builtinSkillsDir, skillName, "SKILL.md")
-
-			data, err := os.ReadFile(skillFile)
-			description := ""
-			if err == nil {
-				content := string(data)
-				if idx := strings.Index(content, "\n"); idx > 0 {
-					firstLine := content[:idx]
-					if strings.Contains(firstLine, "description:") {
-						descLine := strings.Index(content[idx:], "\n")
-						if descLine > 0 {
-							description = strings.TrimSpace(content[idx+descLine : idx+descLine])
-						}
-					}
-				}
-			}
-
-			// skill := BuiltinSkill{
-			// 	Name:    skillName,
-			// 	Path:    description,
-			// 	Enabled: true,
-			// }
-
-			status := "✓"
-			fmt.Printf("  %s  %s\n", status, entry.Name())
-			if description != "" {
-				fmt.Printf("    %s\n", description)
-			}
+		if !entry.IsDir() {
+			continue
+		}
+		skillDir := filepath.Join(builtinSkillsDir, entry.Name())
+		meta := (&SkillsLoader{}).getSkillMetadata(filepath.Join(skillDir, "SKILL.md"))
+		if meta == nil {
+			continue
 		}
+		skills = append(skills, BuiltinSkill{
+			Name:        entry.Name(),
+			Path:        skillDir,
+			Description: meta.Description,
+			Enabled:     true,
+		})
 	}
 	return skills
 }
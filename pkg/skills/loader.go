@@ -19,15 +19,19 @@ const (
 )
 
 type SkillMetadata struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Version      string   `json:"version,omitempty"`
+	Dependencies []string `json:"dependencies,omitempty"` // install sources of required skills
+	Keywords     []string `json:"keywords,omitempty"`     // activate the skill when a message mentions them
 }
 
 type SkillInfo struct {
-	Name        string `json:"name"`
-	Path        string `json:"path"`
-	Source      string `json:"source"`
-	Description string `json:"description"`
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Source      string   `json:"source"`
+	Description string   `json:"description"`
+	Keywords    []string `json:"keywords,omitempty"`
 }
 
 func (info SkillInfo) validate() error {
@@ -85,6 +89,7 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 						if metadata != nil {
 							info.Description = metadata.Description
 							info.Name = metadata.Name
+							info.Keywords = metadata.Keywords
 						}
 						if err := info.validate(); err != nil {
 							slog.Warn("invalid skill from workspace", "name", info.Name, "error", err)
@@ -125,6 +130,7 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 						if metadata != nil {
 							info.Description = metadata.Description
 							info.Name = metadata.Name
+							info.Keywords = metadata.Keywords
 						}
 						if err := info.validate(); err != nil {
 							slog.Warn("invalid skill from global", "name", info.Name, "error", err)
@@ -164,6 +170,7 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 						if metadata != nil {
 							info.Description = metadata.Description
 							info.Name = metadata.Name
+							info.Keywords = metadata.Keywords
 						}
 						if err := info.validate(); err != nil {
 							slog.Warn("invalid skill from builtin", "name", info.Name, "error", err)
@@ -204,6 +211,15 @@ func (sl *SkillsLoader) LoadSkill(name string) (string, bool) {
 		}
 	}
 
+	// 4. 目录名与 skill 名称不同的 skills
+	for _, s := range sl.ListSkills() {
+		if s.Name == name {
+			if content, err := os.ReadFile(s.Path); err == nil {
+				return sl.stripFrontmatter(string(content)), true
+			}
+		}
+	}
+
 	return "", false
 }
 
@@ -262,23 +278,36 @@ func (sl *SkillsLoader) getSkillMetadata(skillPath string) *SkillMetadata {
 	}
 
 	// Try JSON first (for backward compatibility)
-	var jsonMeta struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-	}
+	var jsonMeta SkillMetadata
 	if err := json.Unmarshal([]byte(frontmatter), &jsonMeta); err == nil {
-		return &SkillMetadata{
-			Name:        jsonMeta.Name,
-			Description: jsonMeta.Description,
-		}
+		return &jsonMeta
 	}
 
 	// Fall back to simple YAML parsing
 	yamlMeta := sl.parseSimpleYAML(frontmatter)
 	return &SkillMetadata{
-		Name:        yamlMeta["name"],
-		Description: yamlMeta["description"],
+		Name:         yamlMeta["name"],
+		Description:  yamlMeta["description"],
+		Version:      yamlMeta["version"],
+		Dependencies: parseList(yamlMeta["dependencies"]),
+		Keywords:     parseList(yamlMeta["keywords"]),
+	}
+}
+
+// parseList parses an inline YAML list value: a JSON array, a flow
+// sequence such as [a, b] or a comma separated list.
+func parseList(value string) []string {
+	var list []string
+	if json.Unmarshal([]byte(value), &list) == nil {
+		return list
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), "\"'"); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
 }
 
 // parseSimpleYAML parses simple key: value YAML format
@@ -317,7 +346,7 @@ func (sl *SkillsLoader) extractFrontmatter(content string) string {
 }
 
 func (sl *SkillsLoader) stripFrontmatter(content string) string {
-	re := regexp.MustCompile(`^---\n.*?\n---\n`)
+	re := regexp.MustCompile(`(?s)^---\n.*?\n---\n`)
 	return re.ReplaceAllString(content, "")
 }
 
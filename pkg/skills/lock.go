@@ -0,0 +1,111 @@
+package skills
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// LockFile is the workspace file recording where installed skills came
+// from, so they can be checked for updates and for local changes.
+const LockFile = "skills.lock.json"
+
+// Lock is the content of LockFile.
+type Lock struct {
+	Version int                  `json:"version"`
+	Skills  map[string]LockEntry `json:"skills"`
+}
+
+// LockEntry records the install of one skill.
+type LockEntry struct {
+	Source       string    `json:"source"`           // as given to install, e.g. "owner/repo/weather@v1.2.0"
+	Resolved     string    `json:"resolved"`         // what was fetched: archive URL or directory
+	Commit       string    `json:"commit,omitempty"` // for GitHub sources
+	Version      string    `json:"version,omitempty"`
+	Checksum     string    `json:"checksum"` // of the installed files, see DirChecksum
+	Dependencies []string  `json:"dependencies,omitempty"`
+	InstalledAt  time.Time `json:"installed_at"`
+}
+
+// ReadLock reads the lockfile of workspace. A missing file is an empty lock.
+func ReadLock(workspace string) (*Lock, error) {
+	lock := &Lock{Version: 1, Skills: map[string]LockEntry{}}
+	data, err := os.ReadFile(filepath.Join(workspace, LockFile))
+	if os.IsNotExist(err) {
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", LockFile, err)
+	}
+	if lock.Skills == nil {
+		lock.Skills = map[string]LockEntry{}
+	}
+	return lock, nil
+}
+
+// Write saves the lock to the lockfile of workspace.
+func (l *Lock) Write(workspace string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(workspace, LockFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// DirChecksum hashes the regular files under dir with their relative paths
+// and executable bits, as "sha256:<hex>".
+func DirChecksum(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	sum := sha256.New()
+	for _, rel := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		fileSum := sha256.New()
+		_, err = io.Copy(fileSum, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(sum, "%s\x00%t\x00%x\n", rel, info.Mode()&0111 != 0, fileSum.Sum(nil))
+	}
+	return "sha256:" + hex.EncodeToString(sum.Sum(nil)), nil
+}
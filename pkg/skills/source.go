@@ -0,0 +1,451 @@
+package skills
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of install sources.
+const (
+	SourceGitHub   = "github"   // owner/repo[/path][@ref]
+	SourceArchive  = "archive"  // https://host/skill.tar.gz[#path]
+	SourceDir      = "dir"      // ./path, /path or ~/path
+	SourceRegistry = "registry" // name[@version]
+)
+
+// maxArchiveBytes limits the unpacked size of a downloaded skill.
+const maxArchiveBytes = 100 << 20
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Source is where a skill is installed from.
+type Source struct {
+	Kind string
+	Raw  string
+
+	Owner, Repo string // SourceGitHub
+	URL         string // SourceArchive
+	Dir         string // SourceDir
+	Path        string // skill directory inside the repository or archive
+	Ref         string // SourceGitHub: tag, branch or commit, default the default branch
+	Name        string // SourceRegistry
+	Version     string // SourceRegistry: default the latest
+}
+
+// ParseSource parses an install source:
+//
+//	owner/repo[/path][@ref]   a GitHub repository at a tag, branch or commit
+//	https://host/x.tar.gz#dir a gzipped tarball, optionally a directory in it
+//	./dir, /dir, ~/dir        a local directory
+//	name[@version]            a skill from the registries
+func ParseSource(s string) (Source, error) {
+	src := Source{Raw: s}
+	switch {
+	case s == "":
+		return src, fmt.Errorf("empty skill source")
+	case strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://"):
+		src.Kind = SourceArchive
+		src.URL, src.Path, _ = strings.Cut(s, "#")
+	case s == "." || strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../") || strings.HasPrefix(s, "~") || filepath.IsAbs(s):
+		src.Kind = SourceDir
+		src.Dir = expandHome(s)
+	case strings.Contains(s, "/"):
+		src.Kind = SourceGitHub
+		repo, ref, _ := strings.Cut(s, "@")
+		parts := strings.Split(strings.Trim(repo, "/"), "/")
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return src, fmt.Errorf("invalid GitHub source %q, use owner/repo[/path][@ref]", s)
+		}
+		src.Owner, src.Repo, src.Ref = parts[0], parts[1], ref
+		src.Path = strings.Join(parts[2:], "/")
+	default:
+		src.Kind = SourceRegistry
+		src.Name, src.Version, _ = strings.Cut(s, "@")
+		if !namePattern.MatchString(src.Name) {
+			return src, fmt.Errorf("invalid skill name %q", src.Name)
+		}
+	}
+	if strings.Contains("/"+src.Path+"/", "/../") {
+		return src, fmt.Errorf("invalid path in skill source %q", s)
+	}
+	return src, nil
+}
+
+// Pinned reports whether the source always yields the same files.
+func (s Source) Pinned() bool {
+	return s.Kind == SourceGitHub && commitPattern.MatchString(s.Ref)
+}
+
+// fetched is a skill fetched into a temporary directory.
+type fetched struct {
+	dir   string
+	meta  SkillMetadata
+	entry LockEntry
+}
+
+// fetch puts the skill at src into a new directory under tmp. For GitHub
+// sources whose ref still resolves to knownCommit nothing is downloaded
+// and nil is returned.
+func (si *SkillInstaller) fetch(ctx context.Context, src Source, tmp, knownCommit string) (*fetched, error) {
+	entry := LockEntry{Source: src.Raw}
+	var dir string
+
+	switch src.Kind {
+	case SourceGitHub:
+		commit, err := si.resolveCommit(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		if commit == knownCommit {
+			return nil, nil
+		}
+		entry.Commit = commit
+		entry.Resolved = fmt.Sprintf("%s/%s/%s/tar.gz/%s", si.codeloadURL, src.Owner, src.Repo, commit)
+		if err := si.extractArchive(ctx, entry.Resolved, filepath.Join(tmp, "archive")); err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(tmp, "archive", filepath.FromSlash(src.Path))
+	case SourceArchive:
+		entry.Resolved = src.URL
+		if err := si.extractArchive(ctx, src.URL, filepath.Join(tmp, "archive")); err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(tmp, "archive", filepath.FromSlash(src.Path))
+	case SourceDir, SourceRegistry:
+		from := src.Dir
+		if src.Kind == SourceRegistry {
+			found := si.findInRegistries(src.Name, src.Version)
+			if found == "" {
+				repo, err := si.findInIndex(ctx, src.Name, src.Version)
+				if err != nil {
+					return nil, err
+				}
+				f, err := si.fetch(ctx, repo, tmp, knownCommit)
+				if f != nil {
+					f.entry.Source = src.Raw
+				}
+				return f, err
+			}
+			from = found
+		}
+		abs, err := filepath.Abs(from)
+		if err != nil {
+			return nil, err
+		}
+		entry.Resolved = abs
+		dir = filepath.Join(tmp, "skill")
+		if err := copyTree(abs, dir); err != nil {
+			return nil, fmt.Errorf("failed to copy skill: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown source kind %q", src.Kind)
+	}
+
+	skillFile := filepath.Join(dir, "SKILL.md")
+	if _, err := os.Stat(skillFile); err != nil {
+		return nil, fmt.Errorf("no SKILL.md in %s", src.Raw)
+	}
+	meta := (&SkillsLoader{}).getSkillMetadata(skillFile)
+	if meta == nil {
+		return nil, fmt.Errorf("failed to read SKILL.md of %s", src.Raw)
+	}
+	if err := (SkillInfo{Name: meta.Name, Description: meta.Description}).validate(); err != nil {
+		return nil, fmt.Errorf("invalid skill %s: %w", src.Raw, err)
+	}
+	checksum, err := DirChecksum(dir)
+	if err != nil {
+		return nil, err
+	}
+	entry.Version = meta.Version
+	entry.Checksum = checksum
+	return &fetched{dir: dir, meta: *meta, entry: entry}, nil
+}
+
+// resolveCommit asks GitHub for the commit a ref points to.
+func (si *SkillInstaller) resolveCommit(ctx context.Context, src Source) (string, error) {
+	if src.Pinned() {
+		return src.Ref, nil
+	}
+	ref := src.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	url := fmt.Sprintf("%s/repos/%s/%s/commits/%s", si.githubAPIURL, src.Owner, src.Repo, ref)
+	body, err := si.get(ctx, url, "application/vnd.github.sha", 1024)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", src.Raw, err)
+	}
+	commit := strings.TrimSpace(string(body))
+	if !commitPattern.MatchString(commit) {
+		return "", fmt.Errorf("failed to resolve %s: unexpected answer %q", src.Raw, commit)
+	}
+	return commit, nil
+}
+
+func (si *SkillInstaller) get(ctx context.Context, url, accept string, limit int64) ([]byte, error) {
+	resp, err := si.open(ctx, url, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+func (si *SkillInstaller) open(ctx context.Context, url, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	// Raises GitHub's rate limit
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && strings.HasPrefix(url, si.githubAPIURL) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := si.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	}
+	return resp, nil
+}
+
+// extractArchive unpacks the gzipped tarball at url into dest. A single
+// top-level directory, as in GitHub archives, is left out.
+func (si *SkillInstaller) extractArchive(ctx context.Context, url, dest string) error {
+	resp, err := si.open(ctx, url, "")
+	if err != nil {
+		return fmt.Errorf("failed to download skill: %w", err)
+	}
+	defer resp.Body.Close()
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	type entry struct {
+		name string
+		dir  bool
+		mode int64
+		data []byte
+	}
+	var entries []entry
+	var total int64
+	prefix := ""
+	common, nested := true, false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue // links, devices and pax headers
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		first, _, inDir := strings.Cut(name, "/")
+		if prefix == "" {
+			prefix = first
+		} else if first != prefix {
+			common = false
+		}
+		nested = nested || inDir
+
+		e := entry{name: name, dir: hdr.Typeflag == tar.TypeDir, mode: hdr.Mode}
+		if !e.dir {
+			total += hdr.Size
+			if total > maxArchiveBytes {
+				return fmt.Errorf("archive exceeds %d MB", maxArchiveBytes>>20)
+			}
+			if e.data, err = io.ReadAll(tr); err != nil {
+				return fmt.Errorf("failed to read archive: %w", err)
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	strip := common && nested
+	for _, e := range entries {
+		name := e.name
+		if strip {
+			if name == prefix {
+				continue
+			}
+			name = strings.TrimPrefix(name, prefix+"/")
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		if e.dir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		perm := os.FileMode(0644)
+		if e.mode&0111 != 0 {
+			perm = 0755
+		}
+		if err := os.WriteFile(target, e.data, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findInRegistries returns the directory of a skill in the local
+// registries, or "". A registry holds <name>/SKILL.md, or one directory
+// per version as <name>/<version>/SKILL.md.
+func (si *SkillInstaller) findInRegistries(name, version string) string {
+	for _, registry := range si.registries {
+		base := filepath.Join(expandHome(registry), name)
+		if _, err := os.Stat(filepath.Join(base, "SKILL.md")); err == nil {
+			meta := (&SkillsLoader{}).getSkillMetadata(filepath.Join(base, "SKILL.md"))
+			if version == "" || (meta != nil && meta.Version == version) {
+				return base
+			}
+			continue
+		}
+		if version != "" {
+			if _, err := os.Stat(filepath.Join(base, version, "SKILL.md")); err == nil {
+				return filepath.Join(base, version)
+			}
+			continue
+		}
+		if latest := latestVersion(base); latest != "" {
+			return filepath.Join(base, latest)
+		}
+	}
+	return ""
+}
+
+// latestVersion returns the highest version directory under dir.
+func latestVersion(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	latest := ""
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), "SKILL.md")); err != nil {
+			continue
+		}
+		if latest == "" || CompareVersions(e.Name(), latest) > 0 {
+			latest = e.Name()
+		}
+	}
+	return latest
+}
+
+// findInIndex looks a skill up in the remote index and returns its
+// repository as a GitHub source, at the tag version if one is given.
+func (si *SkillInstaller) findInIndex(ctx context.Context, name, version string) (Source, error) {
+	index, err := si.fetchIndex(ctx)
+	if err != nil {
+		return Source{}, fmt.Errorf("skill %q is not in a local registry, and %w", name, err)
+	}
+	for _, skill := range index {
+		if skill.Name != name {
+			continue
+		}
+		raw := skill.Repository
+		if version != "" {
+			raw += "@" + version
+		}
+		src, err := ParseSource(raw)
+		if err != nil || src.Kind != SourceGitHub {
+			return Source{}, fmt.Errorf("registry entry of %s: unsupported repository %q", name, skill.Repository)
+		}
+		return src, nil
+	}
+	return Source{}, fmt.Errorf("skill %q not found in the registries", name)
+}
+
+// CompareVersions compares dotted versions such as v1.10.0 and 1.9,
+// numerically where both parts are numbers. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case xerr == nil && yerr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (xerr != nil || yerr != nil) && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// copyTree copies the regular files and directories under src to dst.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, p[1:])
+	}
+	return p
+}
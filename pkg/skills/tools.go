@@ -0,0 +1,107 @@
+package skills
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// ToolsFile is the file in a skill directory declaring its tools, as an
+// array of tools.CustomToolSpec or an object with a "tools" array.
+const ToolsFile = "tools.json"
+
+// SkillTool is a tool declared by a skill.
+type SkillTool struct {
+	Skill string
+	Dir   string // the skill directory
+	Spec  tools.CustomToolSpec
+}
+
+// ListTools returns the tools declared by the skills of ListSkills, from
+// the "tools" key of their frontmatter and their tools.json. In YAML
+// frontmatter the value is inline JSON:
+//
+//	tools: [{"name": "forecast", "description": "...", "command": "./forecast.sh {{city}}"}]
+func (sl *SkillsLoader) ListTools() []SkillTool {
+	var list []SkillTool
+	for _, skill := range sl.ListSkills() {
+		specs, err := sl.loadToolSpecs(skill.Path)
+		if err != nil {
+			slog.Warn("invalid skill tools", "skill", skill.Name, "error", err)
+			continue
+		}
+		for _, spec := range specs {
+			list = append(list, SkillTool{Skill: skill.Name, Dir: filepath.Dir(skill.Path), Spec: spec})
+		}
+	}
+	return list
+}
+
+// LoadTools creates the tools declared by the skills, working in the
+// loader's workspace. Invalid and duplicate declarations are skipped with a
+// warning.
+func (sl *SkillsLoader) LoadTools(restrict bool) []*tools.CustomTool {
+	var list []*tools.CustomTool
+	seen := make(map[string]string)
+	for _, st := range sl.ListTools() {
+		if other, ok := seen[st.Spec.Name]; ok {
+			slog.Warn("duplicate skill tool", "tool", st.Spec.Name, "skill", st.Skill, "declared_by", other)
+			continue
+		}
+		tool, err := tools.NewCustomTool(st.Spec, st.Dir, sl.workspace, restrict)
+		if err != nil {
+			slog.Warn("invalid skill tool", "skill", st.Skill, "error", err)
+			continue
+		}
+		seen[st.Spec.Name] = st.Skill
+		list = append(list, tool)
+	}
+	return list
+}
+
+func (sl *SkillsLoader) loadToolSpecs(skillFile string) ([]tools.CustomToolSpec, error) {
+	content, err := os.ReadFile(skillFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []tools.CustomToolSpec
+	if frontmatter := sl.extractFrontmatter(string(content)); frontmatter != "" {
+		var jsonMeta struct {
+			Tools []tools.CustomToolSpec `json:"tools"`
+		}
+		if err := json.Unmarshal([]byte(frontmatter), &jsonMeta); err == nil {
+			specs = jsonMeta.Tools
+		} else if inline := sl.parseSimpleYAML(frontmatter)["tools"]; inline != "" {
+			if err := json.Unmarshal([]byte(inline), &specs); err != nil {
+				return nil, fmt.Errorf("frontmatter tools: %w", err)
+			}
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(skillFile), ToolsFile))
+	if os.IsNotExist(err) {
+		return specs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var fileSpecs []tools.CustomToolSpec
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		var wrapped struct {
+			Tools []tools.CustomToolSpec `json:"tools"`
+		}
+		err = json.Unmarshal(data, &wrapped)
+		fileSpecs = wrapped.Tools
+	} else {
+		err = json.Unmarshal(data, &fileSpecs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ToolsFile, err)
+	}
+	return append(specs, fileSpecs...), nil
+}
@@ -0,0 +1,64 @@
+package skills
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSkill(t *testing.T, root, name, skillMD string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files["SKILL.md"] = skillMD
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadTools(t *testing.T) {
+	workspace := t.TempDir()
+	global := t.TempDir()
+	skillsDir := filepath.Join(workspace, "skills")
+
+	writeSkill(t, skillsDir, "weather", `---
+name: weather
+description: Weather forecasts
+tools: [{"name": "forecast", "description": "Get the forecast", "command": "./forecast.sh {{city}}"}]
+---
+# Weather`, map[string]string{
+		"tools.json": `{"tools": [{"name": "alerts", "description": "Weather alerts", "http": {"url": "https://example.com/alerts"}}]}`,
+	})
+	writeSkill(t, skillsDir, "notes", `---
+{"name": "notes", "description": "Notes", "tools": [{"name": "add_note", "description": "Add a note", "command": ["./add.sh"]}]}
+---
+# Notes`, map[string]string{
+		"tools.json": `[{"name": "forecast", "description": "Duplicate name", "command": "true"}, {"name": "broken"}]`,
+	})
+	writeSkill(t, skillsDir, "bad-json", "---\nname: bad-json\ndescription: Bad\ntools: [not json]\n---\n", map[string]string{})
+	writeSkill(t, global, "plain", "---\nname: plain\ndescription: No tools\n---\n", map[string]string{})
+
+	loader := NewSkillsLoader(workspace, global, "")
+	var listed []string
+	for _, st := range loader.ListTools() {
+		listed = append(listed, st.Skill+"/"+st.Spec.Name)
+		assert.Equal(t, filepath.Join(skillsDir, st.Skill), st.Dir)
+	}
+	assert.ElementsMatch(t, []string{"weather/forecast", "weather/alerts", "notes/add_note", "notes/forecast", "notes/broken"}, listed)
+
+	var names []string
+	for _, tool := range loader.LoadTools(true) {
+		names = append(names, tool.Name())
+	}
+	assert.ElementsMatch(t, []string{"forecast", "alerts", "add_note"}, names)
+}
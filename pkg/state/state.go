@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/storage"
 )
 
 // State represents the persistent state for a workspace.
@@ -25,10 +27,9 @@ type State struct {
 
 // Manager manages persistent state with atomic saves.
 type Manager struct {
-	workspace string
-	state     *State
-	mu        sync.RWMutex
-	stateFile string
+	state *State
+	mu    sync.RWMutex
+	docs  storage.DocumentStore
 }
 
 // NewManager creates a new state manager for the given workspace.
@@ -41,9 +42,8 @@ func NewManager(workspace string) *Manager {
 	os.MkdirAll(stateDir, 0755)
 
 	sm := &Manager{
-		workspace: workspace,
-		stateFile: stateFile,
-		state:     &State{},
+		state: &State{},
+		docs:  storage.NewFileDocuments(map[string]string{storage.DocState: stateFile}),
 	}
 
 	// Try to load from new location first
@@ -64,6 +64,19 @@ func NewManager(workspace string) *Manager {
 	return sm
 }
 
+// NewManagerWithStore creates a state manager that keeps its state in the
+// storage.DocState document of docs.
+func NewManagerWithStore(docs storage.DocumentStore) *Manager {
+	sm := &Manager{
+		state: &State{},
+		docs:  docs,
+	}
+	if err := sm.load(); err != nil {
+		log.Printf("[WARN] state: %v", err)
+	}
+	return sm
+}
+
 // SetLastChannel atomically updates the last channel and saves the state.
 // This method uses a temp file + rename pattern for atomic writes,
 // ensuring that the state file is never corrupted even if the process crashes.
@@ -121,47 +134,28 @@ func (sm *Manager) GetTimestamp() time.Time {
 	return sm.state.Timestamp
 }
 
-// saveAtomic performs an atomic save using temp file + rename.
-// This ensures that the state file is never corrupted:
-// 1. Write to a temp file
-// 2. Rename temp file to target (atomic on POSIX systems)
-// 3. If rename fails, cleanup the temp file
+// saveAtomic saves the state. The file store writes a temp file and
+// renames it over the target, so the state file is never corrupted even if
+// the process crashes.
 //
 // Must be called with the lock held.
 func (sm *Manager) saveAtomic() error {
-	// Create temp file in the same directory as the target
-	tempFile := sm.stateFile + ".tmp"
-
-	// Marshal state to JSON
 	data, err := json.MarshalIndent(sm.state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
-
-	// Write to temp file
-	if err := os.WriteFile(tempFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write temp file: %w", err)
-	}
-
-	// Atomic rename from temp to target
-	if err := os.Rename(tempFile, sm.stateFile); err != nil {
-		// Cleanup temp file if rename fails
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to rename temp file: %w", err)
-	}
-
-	return nil
+	return sm.docs.SaveDocument(storage.DocState, data)
 }
 
-// load loads the state from disk.
+// load loads the state from the store.
 func (sm *Manager) load() error {
-	data, err := os.ReadFile(sm.stateFile)
+	data, err := sm.docs.LoadDocument(storage.DocState)
 	if err != nil {
-		// File doesn't exist yet, that's OK
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return fmt.Errorf("failed to read state file: %w", err)
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	// Not stored yet, that's OK
+	if data == nil {
+		return nil
 	}
 
 	if err := json.Unmarshal(data, sm.state); err != nil {
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// jsonSession is the format of a session file.
+type jsonSession struct {
+	Key      string              `json:"key"`
+	Messages []providers.Message `json:"messages"`
+	Summary  string              `json:"summary,omitempty"`
+	Metadata map[string]string   `json:"metadata,omitempty"`
+	Created  time.Time           `json:"created"`
+	Updated  time.Time           `json:"updated"`
+}
+
+// JSONSessionStore keeps one JSON file per session in a directory. Every
+// save rewrites the whole file.
+type JSONSessionStore struct {
+	dir string
+}
+
+// NewJSONSessionStore creates a store for the session files in dir.
+func NewJSONSessionStore(dir string) *JSONSessionStore {
+	os.MkdirAll(dir, 0755)
+	return &JSONSessionStore{dir: dir}
+}
+
+// sanitizeFilename converts a session key into a cross-platform safe filename.
+// Session keys use "channel:chatID" (e.g. "telegram:123456") but ':' is the
+// volume separator on Windows, so filepath.Base would misinterpret the key.
+// We replace it with '_'. The original key is preserved inside the JSON file,
+// so ListSessions still maps back to the right key.
+func sanitizeFilename(key string) string {
+	return strings.ReplaceAll(key, ":", "_")
+}
+
+// sessionPath returns the file a session is stored in.
+func (s *JSONSessionStore) sessionPath(key string) (string, error) {
+	filename := sanitizeFilename(key)
+
+	// filepath.IsLocal rejects empty names, "..", absolute paths, and
+	// OS-reserved device names (NUL, COM1 … on Windows).
+	// The extra checks reject "." and any directory separators so that
+	// the session file is always written directly inside s.dir.
+	if filename == "." || !filepath.IsLocal(filename) || strings.ContainsAny(filename, `/\`) {
+		return "", os.ErrInvalid
+	}
+	return filepath.Join(s.dir, filename+".json"), nil
+}
+
+func (s *JSONSessionStore) ListSessions() ([]SessionMeta, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var metas []SessionMeta
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		session, err := readSessionFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		metas = append(metas, session.meta())
+	}
+	return metas, nil
+}
+
+func (s *JSONSessionStore) LoadSession(key string) (*SessionMeta, []providers.Message, error) {
+	path, err := s.sessionPath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := readSessionFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if session.Key != key {
+		// Another key that sanitizes to the same filename
+		return nil, nil, nil
+	}
+	meta := session.meta()
+	return &meta, session.Messages, nil
+}
+
+func (s *JSONSessionStore) SaveSession(meta SessionMeta, messages []providers.Message, stored int) error {
+	path, err := s.sessionPath(meta.Key)
+	if err != nil {
+		return err
+	}
+	if messages == nil {
+		messages = []providers.Message{}
+	}
+	data, err := json.MarshalIndent(jsonSession{
+		Key:      meta.Key,
+		Messages: messages,
+		Summary:  meta.Summary,
+		Metadata: meta.Metadata,
+		Created:  meta.Created,
+		Updated:  meta.Updated,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+func (s *JSONSessionStore) DeleteSession(key string) error {
+	path, err := s.sessionPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func readSessionFile(path string) (*jsonSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session jsonSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *jsonSession) meta() SessionMeta {
+	return SessionMeta{
+		Key:      s.Key,
+		Summary:  s.Summary,
+		Created:  s.Created,
+		Updated:  s.Updated,
+		Messages: len(s.Messages),
+		Metadata: s.Metadata,
+	}
+}
+
+// FileDocuments stores each document in a file of its own.
+type FileDocuments struct {
+	paths map[string]string
+}
+
+// NewFileDocuments creates a document store with a file path per document
+// name.
+func NewFileDocuments(paths map[string]string) *FileDocuments {
+	return &FileDocuments{paths: paths}
+}
+
+func (d *FileDocuments) path(name string) (string, error) {
+	path, ok := d.paths[name]
+	if !ok {
+		return "", fmt.Errorf("no file for document %q", name)
+	}
+	return path, nil
+}
+
+func (d *FileDocuments) LoadDocument(name string) ([]byte, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (d *FileDocuments) SaveDocument(name string, data []byte) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// writeFileAtomic writes data to a temp file in the target directory and
+// renames it over path, so a crash never leaves a partial file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmpFile.Name()
+	cleanup := true
+	defer func() {
+		if cleanup {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(perm); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	cleanup = false
+	return nil
+}
+
+// JSONBackend stores sessions and documents as JSON files in a workspace:
+// sessions/<key>.json, state/state.json and cron/jobs.json.
+type JSONBackend struct {
+	*JSONSessionStore
+	*FileDocuments
+}
+
+// NewJSONBackend creates the JSON backend for workspace.
+func NewJSONBackend(workspace string) *JSONBackend {
+	os.MkdirAll(filepath.Join(workspace, "state"), 0755)
+	return &JSONBackend{
+		JSONSessionStore: NewJSONSessionStore(filepath.Join(workspace, "sessions")),
+		FileDocuments:    NewFileDocuments(jsonDocumentPaths(workspace)),
+	}
+}
+
+func jsonDocumentPaths(workspace string) map[string]string {
+	return map[string]string{
+		DocState: filepath.Join(workspace, "state", "state.json"),
+		DocCron:  filepath.Join(workspace, "cron", "jobs.json"),
+	}
+}
+
+func (b *JSONBackend) Name() string {
+	return BackendJSON
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// docMigrated marks a backend that MigrateJSON already ran on.
+const docMigrated = "migrated_from_json"
+
+// MigrationResult reports what MigrateJSON copied.
+type MigrationResult struct {
+	Sessions  int
+	Documents []string
+}
+
+// MigrateJSON copies the JSON files of workspace into dst: the sessions dst
+// does not have yet, and the state and cron documents if dst has none. The
+// files themselves are left in place.
+func MigrateJSON(dst Backend, workspace string) (MigrationResult, error) {
+	var result MigrationResult
+	src := NewJSONBackend(workspace)
+
+	metas, err := src.ListSessions()
+	if err != nil {
+		return result, err
+	}
+	for _, meta := range metas {
+		existing, _, err := dst.LoadSession(meta.Key)
+		if err != nil {
+			return result, err
+		}
+		if existing != nil {
+			continue
+		}
+		loaded, messages, err := src.LoadSession(meta.Key)
+		if err != nil || loaded == nil {
+			continue
+		}
+		if err := dst.SaveSession(*loaded, messages, 0); err != nil {
+			return result, err
+		}
+		result.Sessions++
+	}
+
+	paths := jsonDocumentPaths(workspace)
+	for _, name := range []string{DocState, DocCron} {
+		existing, err := dst.LoadDocument(name)
+		if err != nil {
+			return result, err
+		}
+		if existing != nil {
+			continue
+		}
+		data, err := os.ReadFile(paths[name])
+		if os.IsNotExist(err) && name == DocState {
+			// state.Manager used to keep the file in the workspace root
+			data, err = os.ReadFile(filepath.Join(workspace, "state.json"))
+		}
+		if err != nil {
+			continue
+		}
+		if err := dst.SaveDocument(name, data); err != nil {
+			return result, err
+		}
+		result.Documents = append(result.Documents, name)
+	}
+	return result, nil
+}
+
+// migrateOnce runs MigrateJSON unless dst was migrated before.
+func migrateOnce(dst Backend, workspace string) error {
+	marker, err := dst.LoadDocument(docMigrated)
+	if err != nil || marker != nil {
+		return err
+	}
+
+	result, err := MigrateJSON(dst, workspace)
+	if err != nil {
+		return err
+	}
+	if result.Sessions > 0 || len(result.Documents) > 0 {
+		logger.InfoCF("storage", "Migrated JSON files", map[string]interface{}{
+			"backend":   dst.Name(),
+			"sessions":  result.Sessions,
+			"documents": result.Documents,
+		})
+	}
+
+	marker, _ = json.Marshal(map[string]interface{}{
+		"time":      time.Now(),
+		"sessions":  result.Sessions,
+		"documents": result.Documents,
+	})
+	return dst.SaveDocument(docMigrated, marker)
+}
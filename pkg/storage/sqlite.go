@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+
+	_ "modernc.org/sqlite" // pure-Go driver, no cgo needed for cross-compiled boards
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	key      TEXT PRIMARY KEY,
+	summary  TEXT NOT NULL DEFAULT '',
+	metadata TEXT NOT NULL DEFAULT '',
+	created  INTEGER NOT NULL,
+	updated  INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	session_key TEXT NOT NULL,
+	seq         INTEGER NOT NULL,
+	data        TEXT NOT NULL,
+	PRIMARY KEY (session_key, seq)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS documents (
+	name    TEXT PRIMARY KEY,
+	data    BLOB NOT NULL,
+	updated INTEGER NOT NULL
+);
+`
+
+// SQLiteBackend stores sessions and documents in a SQLite database. Each
+// message is a row of its own, so saving a session after a turn only
+// inserts the new messages.
+type SQLiteBackend struct {
+	db   *sql.DB
+	path string
+}
+
+// OpenSQLite opens or creates the database at path.
+func OpenSQLite(path string) (*SQLiteBackend, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes writers within the process; other processes
+	// wait on busy_timeout
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize %s: %w", path, err)
+	}
+	// Databases created before sessions had metadata
+	var hasMetadata int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name = 'metadata'`).Scan(&hasMetadata); err == nil && hasMetadata == 0 {
+		if _, err := db.Exec(`ALTER TABLE sessions ADD COLUMN metadata TEXT NOT NULL DEFAULT ''`); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to upgrade %s: %w", path, err)
+		}
+	}
+	return &SQLiteBackend{db: db, path: path}, nil
+}
+
+var (
+	sharedMu     sync.Mutex
+	sharedSQLite = make(map[string]*SQLiteBackend)
+)
+
+// openSQLiteShared returns the process-wide backend for path, opening it
+// and migrating workspace's JSON files into it on first use.
+func openSQLiteShared(path, workspace string) (*SQLiteBackend, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	if b, ok := sharedSQLite[path]; ok {
+		return b, nil
+	}
+	b, err := OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateOnce(b, workspace); err != nil {
+		b.Close()
+		return nil, err
+	}
+	sharedSQLite[path] = b
+	return b, nil
+}
+
+// Path returns the database file.
+func (b *SQLiteBackend) Path() string {
+	return b.path
+}
+
+func (b *SQLiteBackend) Name() string {
+	return BackendSQLite
+}
+
+// Close closes the database.
+func (b *SQLiteBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *SQLiteBackend) ListSessions() ([]SessionMeta, error) {
+	rows, err := b.db.Query(`SELECT s.key, s.summary, s.metadata, s.created, s.updated,
+		(SELECT COUNT(*) FROM messages m WHERE m.session_key = s.key)
+		FROM sessions s`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metas []SessionMeta
+	for rows.Next() {
+		var meta SessionMeta
+		var metadata string
+		var created, updated int64
+		if err := rows.Scan(&meta.Key, &meta.Summary, &metadata, &created, &updated, &meta.Messages); err != nil {
+			return nil, err
+		}
+		meta.Metadata = decodeMetadata(meta.Key, metadata)
+		meta.Created, meta.Updated = time.Unix(0, created), time.Unix(0, updated)
+		metas = append(metas, meta)
+	}
+	return metas, rows.Err()
+}
+
+func (b *SQLiteBackend) LoadSession(key string) (*SessionMeta, []providers.Message, error) {
+	meta := SessionMeta{Key: key}
+	var metadata string
+	var created, updated int64
+	err := b.db.QueryRow(`SELECT summary, metadata, created, updated FROM sessions WHERE key = ?`, key).
+		Scan(&meta.Summary, &metadata, &created, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	meta.Metadata = decodeMetadata(key, metadata)
+	meta.Created, meta.Updated = time.Unix(0, created), time.Unix(0, updated)
+
+	rows, err := b.db.Query(`SELECT data FROM messages WHERE session_key = ? ORDER BY seq`, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	messages := []providers.Message{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, nil, err
+		}
+		var msg providers.Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			logger.WarnCF("storage", "Skipping unreadable message", map[string]interface{}{
+				"session_key": key,
+				"error":       err.Error(),
+			})
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	meta.Messages = len(messages)
+	return &meta, messages, nil
+}
+
+func (b *SQLiteBackend) SaveSession(meta SessionMeta, messages []providers.Message, stored int) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var metadata []byte
+	if len(meta.Metadata) > 0 {
+		if metadata, err = json.Marshal(meta.Metadata); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO sessions (key, summary, metadata, created, updated) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET summary = excluded.summary, metadata = excluded.metadata, updated = excluded.updated`,
+		meta.Key, meta.Summary, string(metadata), meta.Created.UnixNano(), meta.Updated.UnixNano()); err != nil {
+		return err
+	}
+
+	if stored < 0 || stored > len(messages) {
+		stored = 0
+	}
+	if stored > 0 {
+		// Only append if the stored prefix is really there, e.g. not
+		// deleted by another process in the meantime
+		var have int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE session_key = ? AND seq < ?`, meta.Key, stored).Scan(&have); err != nil {
+			return err
+		}
+		if have != stored {
+			stored = 0
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM messages WHERE session_key = ? AND seq >= ?`, meta.Key, stored); err != nil {
+		return err
+	}
+	if stored < len(messages) {
+		stmt, err := tx.Prepare(`INSERT INTO messages (session_key, seq, data) VALUES (?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for i := stored; i < len(messages); i++ {
+			data, err := json.Marshal(messages[i])
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(meta.Key, i, string(data)); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// decodeMetadata parses the metadata column of a session.
+func decodeMetadata(key, data string) map[string]string {
+	if data == "" {
+		return nil
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+		logger.WarnCF("storage", "Skipping unreadable session metadata", map[string]interface{}{
+			"session_key": key,
+			"error":       err.Error(),
+		})
+		return nil
+	}
+	return metadata
+}
+
+func (b *SQLiteBackend) DeleteSession(key string) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM messages WHERE session_key = ?`, key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE key = ?`, key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *SQLiteBackend) LoadDocument(name string) ([]byte, error) {
+	var data []byte
+	err := b.db.QueryRow(`SELECT data FROM documents WHERE name = ?`, name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (b *SQLiteBackend) SaveDocument(name string, data []byte) error {
+	_, err := b.db.Exec(`INSERT INTO documents (name, data, updated) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET data = excluded.data, updated = excluded.updated`,
+		name, data, time.Now().UnixNano())
+	return err
+}
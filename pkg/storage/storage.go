@@ -0,0 +1,84 @@
+// Package storage persists sessions, workspace state and cron jobs. The
+// JSON backend keeps the historical one-file-per-session layout of the
+// workspace; the SQLite backend stores everything in <workspace>/picoclaw.db,
+// appending new messages instead of rewriting whole sessions.
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Backend names used in config.StorageConfig.
+const (
+	BackendJSON   = "json"
+	BackendSQLite = "sqlite"
+)
+
+// Names of the documents stored by other packages.
+const (
+	DocState = "state" // state.Manager
+	DocCron  = "cron"  // the cron job store
+)
+
+// SessionMeta describes a stored session without its messages.
+type SessionMeta struct {
+	Key      string
+	Summary  string
+	Created  time.Time
+	Updated  time.Time
+	Messages int
+	Metadata map[string]string // Per-session state of other packages, e.g. the active skills
+}
+
+// SessionStore persists conversation sessions.
+type SessionStore interface {
+	// ListSessions returns the metadata of all stored sessions.
+	ListSessions() ([]SessionMeta, error)
+
+	// LoadSession returns a session and its messages, or nil if it is not
+	// stored.
+	LoadSession(key string) (*SessionMeta, []providers.Message, error)
+
+	// SaveSession stores a session. messages is the full history, of which
+	// the first stored messages are unchanged since the last save; stores
+	// that can append only write the rest.
+	SaveSession(meta SessionMeta, messages []providers.Message, stored int) error
+
+	// DeleteSession removes a session. Deleting a missing session is not an
+	// error.
+	DeleteSession(key string) error
+}
+
+// DocumentStore persists small named JSON documents such as DocState.
+type DocumentStore interface {
+	// LoadDocument returns the document, or nil if it is not stored.
+	LoadDocument(name string) ([]byte, error)
+	SaveDocument(name string, data []byte) error
+}
+
+// Backend stores everything an agent workspace persists.
+type Backend interface {
+	SessionStore
+	DocumentStore
+	Name() string
+}
+
+// Open returns the configured backend for workspace. SQLite databases are
+// shared within the process and migrated from the workspace's JSON files
+// the first time they are opened.
+func Open(cfg config.StorageConfig, workspace string) (Backend, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", BackendJSON:
+		return NewJSONBackend(workspace), nil
+	case BackendSQLite:
+		return openSQLiteShared(filepath.Join(workspace, "picoclaw.db"), workspace)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want %s or %s)", cfg.Backend, BackendJSON, BackendSQLite)
+	}
+}
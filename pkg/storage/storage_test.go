@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"simple", "simple"},
+		{"telegram:123456", "telegram_123456"},
+		{"discord:987654321", "discord_987654321"},
+		{"slack:C01234", "slack_C01234"},
+		{"no-colons-here", "no-colons-here"},
+		{"multiple:colons:here", "multiple_colons_here"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := sanitizeFilename(tt.input)
+			if got != tt.expected {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func messages(contents ...string) []providers.Message {
+	var msgs []providers.Message
+	for _, c := range contents {
+		msgs = append(msgs, providers.Message{Role: "user", Content: c})
+	}
+	return msgs
+}
+
+func testBackend(t *testing.T, b Backend) {
+	t.Helper()
+	now := time.Now().Truncate(time.Millisecond)
+	meta := SessionMeta{Key: "telegram:1", Summary: "A trip.", Created: now, Updated: now, Metadata: map[string]string{"k": "v"}}
+
+	if got, _, err := b.LoadSession("telegram:1"); err != nil || got != nil {
+		t.Fatalf("LoadSession on empty store = %v, %v", got, err)
+	}
+
+	msgs := messages("one", "two")
+	if err := b.SaveSession(meta, msgs, 0); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	msgs = append(msgs, messages("three")...)
+	if err := b.SaveSession(meta, msgs, 2); err != nil {
+		t.Fatalf("SaveSession (append) failed: %v", err)
+	}
+
+	got, loaded, err := b.LoadSession("telegram:1")
+	if err != nil || got == nil {
+		t.Fatalf("LoadSession = %v, %v", got, err)
+	}
+	if got.Summary != "A trip." || got.Metadata["k"] != "v" || !got.Created.Equal(now) || len(loaded) != 3 || loaded[2].Content != "three" {
+		t.Errorf("loaded %+v with %+v", got, loaded)
+	}
+
+	// A rewrite with stored = 0 replaces the history
+	if err := b.SaveSession(meta, messages("only"), 0); err != nil {
+		t.Fatalf("SaveSession (rewrite) failed: %v", err)
+	}
+	metas, err := b.ListSessions()
+	if err != nil || len(metas) != 1 || metas[0].Messages != 1 {
+		t.Errorf("ListSessions = %+v, %v", metas, err)
+	}
+
+	if err := b.DeleteSession("telegram:1"); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if got, _, _ := b.LoadSession("telegram:1"); got != nil {
+		t.Error("deleted session is still stored")
+	}
+	if err := b.DeleteSession("telegram:1"); err != nil {
+		t.Errorf("deleting a missing session failed: %v", err)
+	}
+
+	if data, err := b.LoadDocument(DocState); err != nil || data != nil {
+		t.Errorf("LoadDocument on empty store = %q, %v", data, err)
+	}
+	if err := b.SaveDocument(DocState, []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("SaveDocument failed: %v", err)
+	}
+	if data, _ := b.LoadDocument(DocState); string(data) != `{"a":1}` {
+		t.Errorf("LoadDocument = %q", data)
+	}
+}
+
+func TestJSONBackend(t *testing.T) {
+	testBackend(t, NewJSONBackend(t.TempDir()))
+}
+
+func TestSQLiteBackend(t *testing.T) {
+	b, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite failed: %v", err)
+	}
+	defer b.Close()
+	testBackend(t, b)
+}
+
+func TestSQLiteBackend_AppendFallsBackToRewrite(t *testing.T) {
+	b, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite failed: %v", err)
+	}
+	defer b.Close()
+
+	meta := SessionMeta{Key: "k", Created: time.Now(), Updated: time.Now()}
+	// Claims two stored messages that are not there
+	if err := b.SaveSession(meta, messages("one", "two", "three"), 2); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	if _, loaded, _ := b.LoadSession("k"); len(loaded) != 3 || loaded[0].Content != "one" {
+		t.Errorf("loaded %+v", loaded)
+	}
+}
+
+func TestSQLiteBackend_AddsMetadataColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE sessions (key TEXT PRIMARY KEY, summary TEXT NOT NULL DEFAULT '', created INTEGER NOT NULL, updated INTEGER NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	b, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite failed: %v", err)
+	}
+	defer b.Close()
+	meta := SessionMeta{Key: "k", Created: time.Now(), Updated: time.Now(), Metadata: map[string]string{"k": "v"}}
+	if err := b.SaveSession(meta, messages("one"), 0); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	if got, _, _ := b.LoadSession("k"); got == nil || got.Metadata["k"] != "v" {
+		t.Errorf("loaded %+v", got)
+	}
+}
+
+func TestOpen_MigratesJSON(t *testing.T) {
+	workspace := t.TempDir()
+	old := NewJSONBackend(workspace)
+	now := time.Now()
+	old.SaveSession(SessionMeta{Key: "telegram:1", Created: now, Updated: now}, messages("hi", "there"), 0)
+	old.SaveDocument(DocCron, []byte(`{"version":1,"jobs":[]}`))
+	os.WriteFile(filepath.Join(workspace, "state.json"), []byte(`{"last_channel":"telegram"}`), 0600)
+
+	b, err := Open(config.StorageConfig{Backend: "sqlite"}, workspace)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if b.Name() != BackendSQLite {
+		t.Fatalf("Name = %q", b.Name())
+	}
+	if _, loaded, _ := b.LoadSession("telegram:1"); len(loaded) != 2 {
+		t.Errorf("migrated session has %d messages", len(loaded))
+	}
+	if data, _ := b.LoadDocument(DocCron); string(data) != `{"version":1,"jobs":[]}` {
+		t.Errorf("migrated cron = %q", data)
+	}
+	if data, _ := b.LoadDocument(DocState); string(data) != `{"last_channel":"telegram"}` {
+		t.Errorf("migrated state = %q", data)
+	}
+
+	// The backend is shared, and a migration only runs once
+	b.DeleteSession("telegram:1")
+	again, _ := Open(config.StorageConfig{Backend: "sqlite"}, workspace)
+	if again != b {
+		t.Error("Open should return the shared backend")
+	}
+	if err := migrateOnce(b, workspace); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := b.LoadSession("telegram:1"); got != nil {
+		t.Error("migration ran twice")
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "sessions", "telegram_1.json")); err != nil {
+		t.Error("JSON files should be kept")
+	}
+}
+
+func TestOpen_UnknownBackend(t *testing.T) {
+	if _, err := Open(config.StorageConfig{Backend: "redis"}, t.TempDir()); err == nil {
+		t.Error("expected an error")
+	}
+}
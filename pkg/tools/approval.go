@@ -0,0 +1,109 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// ApprovalRequest describes a tool call waiting for a human decision.
+type ApprovalRequest struct {
+	Tool    string
+	Args    map[string]interface{}
+	Channel string // where the turn came from, and where the prompt goes
+	ChatID  string
+	Rule    string // the policy rule that matched
+}
+
+// ApprovalDecision is the outcome of an ApprovalRequest.
+type ApprovalDecision struct {
+	Approved bool
+	Reason   string // why the call was not approved, shown to the model
+}
+
+// Approver asks a human to approve a tool call. RequestApproval blocks until
+// the call is approved, denied, times out or ctx is cancelled.
+type Approver interface {
+	RequestApproval(ctx context.Context, req ApprovalRequest) ApprovalDecision
+}
+
+// ApprovalPolicy decides which tool calls need approval.
+type ApprovalPolicy struct {
+	rules []approvalRule
+}
+
+type approvalRule struct {
+	tool    string
+	arg     string
+	pattern *regexp.Regexp // nil matches every call
+	desc    string
+}
+
+// NewApprovalPolicy compiles rules. A rule whose pattern does not compile
+// is kept without its pattern, so it errs on the side of asking, and the
+// compile errors are returned.
+func NewApprovalPolicy(rules []config.ApprovalRule) (*ApprovalPolicy, error) {
+	p := &ApprovalPolicy{}
+	var errs []error
+	for _, r := range rules {
+		if r.Tool == "" {
+			continue
+		}
+		rule := approvalRule{tool: r.Tool, arg: r.Arg, desc: r.Tool}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("approval rule for %q: %w", r.Tool, err))
+			} else {
+				rule.pattern = re
+				if r.Arg != "" {
+					rule.desc = fmt.Sprintf("%s %s =~ %s", r.Tool, r.Arg, r.Pattern)
+				} else {
+					rule.desc = fmt.Sprintf("%s =~ %s", r.Tool, r.Pattern)
+				}
+			}
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, errors.Join(errs...)
+}
+
+// Match returns the first rule requiring approval for a call of tool with
+// args.
+func (p *ApprovalPolicy) Match(tool string, args map[string]interface{}) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	for _, r := range p.rules {
+		if r.tool != "*" && r.tool != tool {
+			continue
+		}
+		if r.pattern == nil {
+			return r.desc, true
+		}
+		var subject string
+		if r.arg != "" {
+			v, ok := args[r.arg]
+			if !ok {
+				continue
+			}
+			if s, ok := v.(string); ok {
+				subject = s
+			} else {
+				data, _ := json.Marshal(v)
+				subject = string(data)
+			}
+		} else {
+			data, _ := json.Marshal(args)
+			subject = string(data)
+		}
+		if r.pattern.MatchString(subject) {
+			return r.desc, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestApprovalPolicy_Match(t *testing.T) {
+	policy, err := NewApprovalPolicy([]config.ApprovalRule{
+		{Tool: "exec", Arg: "command", Pattern: `\brm\b`},
+		{Tool: "write_file", Arg: "path", Pattern: `^/etc/`},
+		{Tool: "spawn"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tool string
+		args map[string]interface{}
+		want bool
+	}{
+		{"exec", map[string]interface{}{"command": "rm -rf build"}, true},
+		{"exec", map[string]interface{}{"command": "ls -la"}, false},
+		{"exec", map[string]interface{}{}, false},
+		{"write_file", map[string]interface{}{"path": "/etc/hosts"}, true},
+		{"write_file", map[string]interface{}{"path": "notes.md"}, false},
+		{"spawn", map[string]interface{}{"task": "anything"}, true},
+		{"read_file", map[string]interface{}{"path": "/etc/hosts"}, false},
+	}
+	for _, tt := range tests {
+		if _, got := policy.Match(tt.tool, tt.args); got != tt.want {
+			t.Errorf("Match(%s, %v) = %v, want %v", tt.tool, tt.args, got, tt.want)
+		}
+	}
+
+	var nilPolicy *ApprovalPolicy
+	if _, ok := nilPolicy.Match("exec", nil); ok {
+		t.Error("nil policy should match nothing")
+	}
+}
+
+func TestApprovalPolicy_WildcardAndInvalidPattern(t *testing.T) {
+	policy, err := NewApprovalPolicy([]config.ApprovalRule{
+		{Tool: "exec", Pattern: `([`},
+		{Tool: "*", Pattern: `secret`},
+	})
+	if err == nil {
+		t.Fatal("expected an error for the invalid pattern")
+	}
+
+	if _, ok := policy.Match("exec", map[string]interface{}{"command": "ls"}); !ok {
+		t.Error("a rule with an invalid pattern should require approval for every call")
+	}
+	if _, ok := policy.Match("web_fetch", map[string]interface{}{"url": "https://example.com/secret"}); !ok {
+		t.Error("wildcard rule should match any tool")
+	}
+	if _, ok := policy.Match("web_fetch", map[string]interface{}{"url": "https://example.com/"}); ok {
+		t.Error("wildcard rule should still apply its pattern")
+	}
+}
+
+type echoTool struct{}
+
+func (t *echoTool) Name() string        { return "echo" }
+func (t *echoTool) Description() string { return "echoes text" }
+func (t *echoTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+
+func (t *echoTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	text, _ := args["text"].(string)
+	return SilentResult(text)
+}
+
+type stubApprover struct {
+	approve bool
+	got     ApprovalRequest
+}
+
+func (a *stubApprover) RequestApproval(ctx context.Context, req ApprovalRequest) ApprovalDecision {
+	a.got = req
+	if a.approve {
+		return ApprovalDecision{Approved: true}
+	}
+	return ApprovalDecision{Reason: "denied by the user"}
+}
+
+func TestToolRegistry_Approval(t *testing.T) {
+	policy, _ := NewApprovalPolicy([]config.ApprovalRule{{Tool: "echo"}})
+	reg := NewToolRegistry()
+	reg.Register(&echoTool{})
+	reg.SetApprovalPolicy(policy)
+
+	result := reg.ExecuteWithContext(context.Background(), "echo", map[string]interface{}{"text": "hi"}, "telegram", "42", nil)
+	if !result.IsError || result.Err == nil {
+		t.Fatalf("without an approver the call should be refused, got %+v", result)
+	}
+
+	approver := &stubApprover{}
+	reg.SetApprover(approver)
+	result = reg.ExecuteWithContext(context.Background(), "echo", map[string]interface{}{"text": "hi"}, "telegram", "42", nil)
+	if !result.IsError {
+		t.Fatalf("denied call should fail, got %+v", result)
+	}
+	if approver.got.Channel != "telegram" || approver.got.ChatID != "42" || approver.got.Tool != "echo" {
+		t.Errorf("approval request = %+v", approver.got)
+	}
+
+	approver.approve = true
+	result = reg.ExecuteWithContext(context.Background(), "echo", map[string]interface{}{"text": "hi"}, "telegram", "42", nil)
+	if result.IsError || result.ForLLM != "hi" {
+		t.Errorf("approved call should run, got %+v", result)
+	}
+}
@@ -11,7 +11,12 @@ type Tool interface {
 }
 
 // ContextualTool is an optional interface that tools can implement
-// to receive the current message context (channel, chatID)
+// to receive a default message context (channel, chatID).
+//
+// The registry no longer calls SetContext on every execution, since tool
+// instances are shared between concurrent turns. Tools should read the
+// per-turn target with ExecutionContextFrom(ctx) and treat SetContext values
+// as a fallback.
 type ContextualTool interface {
 	Tool
 	SetContext(channel, chatID string)
@@ -41,7 +46,8 @@ type AsyncCallback func(ctx context.Context, result *ToolResult)
 // asynchronous execution with completion callbacks.
 //
 // Async tools return immediately with an AsyncResult, then notify completion
-// via the callback set by SetCallback.
+// via the callback for the current call (AsyncCallbackFrom(ctx)), falling back
+// to the one set by SetCallback.
 //
 // This is useful for:
 // - Long-running operations that shouldn't block the agent loop
@@ -69,6 +75,17 @@ type AsyncTool interface {
 	SetCallback(cb AsyncCallback)
 }
 
+// ParallelSafeTool is an optional interface for tools that must not run
+// concurrently with other tool calls from the same LLM response, e.g. because
+// they modify the workspace or run commands. When ParallelSafe returns false,
+// the call waits for all earlier calls to finish and runs alone.
+//
+// Tools that don't implement this interface are assumed to be parallel safe.
+type ParallelSafeTool interface {
+	Tool
+	ParallelSafe() bool
+}
+
 func ToolToSchema(tool Tool) map[string]interface{} {
 	return map[string]interface{}{
 		"type": "function",
@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ExecutionContext carries per-turn state for tool execution.
+//
+// Tool instances are shared across concurrently running agent turns, so
+// anything that depends on the originating chat (target channel, whether the
+// message tool already replied, ...) travels with the request context instead
+// of living in mutable fields on the tool.
+type ExecutionContext struct {
+	Channel    string
+	ChatID     string
+	SessionKey string // Set by the agent loop once the session is resolved
+
+	messageSent atomic.Bool
+}
+
+type executionContextKey struct{}
+
+type asyncCallbackKey struct{}
+
+// NewExecutionContext creates the per-turn state for a channel/chat pair.
+func NewExecutionContext(channel, chatID string) *ExecutionContext {
+	return &ExecutionContext{
+		Channel: channel,
+		ChatID:  chatID,
+	}
+}
+
+// MarkMessageSent records that a tool delivered a message to the user during this turn.
+func (ec *ExecutionContext) MarkMessageSent() {
+	ec.messageSent.Store(true)
+}
+
+// MessageSent reports whether a tool delivered a message to the user during this turn.
+func (ec *ExecutionContext) MessageSent() bool {
+	return ec.messageSent.Load()
+}
+
+// WithExecutionContext returns a copy of ctx carrying ec.
+func WithExecutionContext(ctx context.Context, ec *ExecutionContext) context.Context {
+	return context.WithValue(ctx, executionContextKey{}, ec)
+}
+
+// ExecutionContextFrom returns the per-turn state stored in ctx, or nil.
+func ExecutionContextFrom(ctx context.Context) *ExecutionContext {
+	if ctx == nil {
+		return nil
+	}
+	ec, _ := ctx.Value(executionContextKey{}).(*ExecutionContext)
+	return ec
+}
+
+// targetFromContext resolves the channel/chat a tool should act on, preferring
+// the per-turn context and falling back to the values set via SetContext.
+func targetFromContext(ctx context.Context, defaultChannel, defaultChatID string) (string, string) {
+	if ec := ExecutionContextFrom(ctx); ec != nil && ec.Channel != "" && ec.ChatID != "" {
+		return ec.Channel, ec.ChatID
+	}
+	return defaultChannel, defaultChatID
+}
+
+// withAsyncCallback returns a copy of ctx carrying the completion callback for an async tool call.
+func withAsyncCallback(ctx context.Context, cb AsyncCallback) context.Context {
+	return context.WithValue(ctx, asyncCallbackKey{}, cb)
+}
+
+// AsyncCallbackFrom returns the completion callback for the current async
+// tool call, or nil if the caller did not provide one.
+func AsyncCallbackFrom(ctx context.Context) AsyncCallback {
+	cb, _ := ctx.Value(asyncCallbackKey{}).(AsyncCallback)
+	return cb
+}
@@ -21,22 +21,30 @@ type CronTool struct {
 	cronService *cron.CronService
 	executor    JobExecutor
 	msgBus      *bus.MessageBus
-	execTool    *ExecTool
+	commands    *ToolRegistry // runs scheduled commands as exec calls
+	agent       string        // recorded in the jobs this tool creates
 	channel     string
 	chatID      string
 	mu          sync.RWMutex
 }
 
-// NewCronTool creates a new CronTool
-func NewCronTool(cronService *cron.CronService, executor JobExecutor, msgBus *bus.MessageBus, workspace string, restrict bool) *CronTool {
+// NewCronTool creates a new CronTool. Scheduled commands run as calls of
+// the exec tool of commands, so its sandbox and approval policy apply.
+func NewCronTool(cronService *cron.CronService, executor JobExecutor, msgBus *bus.MessageBus, commands *ToolRegistry) *CronTool {
 	return &CronTool{
 		cronService: cronService,
 		executor:    executor,
 		msgBus:      msgBus,
-		execTool:    NewExecTool(workspace, restrict),
+		commands:    commands,
 	}
 }
 
+// SetAgent sets the name of the agent the tool belongs to. Jobs it creates
+// record it, so they run on that agent.
+func (t *CronTool) SetAgent(name string) {
+	t.agent = name
+}
+
 // Name returns the tool name
 func (t *CronTool) Name() string {
 	return "cron"
@@ -107,7 +115,7 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) *To
 
 	switch action {
 	case "add":
-		return t.addJob(args)
+		return t.addJob(ctx, args)
 	case "list":
 		return t.listJobs()
 	case "remove":
@@ -121,10 +129,9 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) *To
 	}
 }
 
-func (t *CronTool) addJob(args map[string]interface{}) *ToolResult {
+func (t *CronTool) addJob(ctx context.Context, args map[string]interface{}) *ToolResult {
 	t.mu.RLock()
-	channel := t.channel
-	chatID := t.chatID
+	channel, chatID := targetFromContext(ctx, t.channel, t.chatID)
 	t.mu.RUnlock()
 
 	if channel == "" || chatID == "" {
@@ -195,8 +202,9 @@ func (t *CronTool) addJob(args map[string]interface{}) *ToolResult {
 		return ErrorResult(fmt.Sprintf("Error adding job: %v", err))
 	}
 
-	if command != "" {
+	if command != "" || t.agent != "" {
 		job.Payload.Command = command
+		job.Payload.Agent = t.agent
 		// Need to save the updated payload
 		t.cronService.UpdateJob(job)
 	}
@@ -279,7 +287,7 @@ func (t *CronTool) ExecuteJob(ctx context.Context, job *cron.CronJob) string {
 			"command": job.Payload.Command,
 		}
 
-		result := t.execTool.Execute(ctx, args)
+		result := t.commands.ExecuteWithContext(ctx, "exec", args, channel, chatID, nil)
 		var output string
 		if result.IsError {
 			output = fmt.Sprintf("Error executing scheduled command: %s", result.ForLLM)
@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/cron"
+)
+
+// fakeExecTool records the commands it is asked to run
+type fakeExecTool struct{ ran []string }
+
+func (t *fakeExecTool) Name() string        { return "exec" }
+func (t *fakeExecTool) Description() string { return "runs commands" }
+func (t *fakeExecTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+
+func (t *fakeExecTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	command, _ := args["command"].(string)
+	t.ran = append(t.ran, command)
+	return NewToolResult("done")
+}
+
+func TestCronTool_CommandNeedsApproval(t *testing.T) {
+	exec := &fakeExecTool{}
+	registry := NewToolRegistry()
+	registry.Register(exec)
+	policy, _ := NewApprovalPolicy([]config.ApprovalRule{{Tool: "exec", Arg: "command", Pattern: `\brm\b`}})
+	registry.SetApprovalPolicy(policy)
+	approver := &stubApprover{}
+	registry.SetApprover(approver)
+
+	msgBus := bus.NewMessageBus()
+	service := cron.NewCronService(filepath.Join(t.TempDir(), "jobs.json"), nil)
+	tool := NewCronTool(service, nil, msgBus, registry)
+
+	run := func(command string) string {
+		t.Helper()
+		tool.ExecuteJob(context.Background(), &cron.CronJob{Payload: cron.CronPayload{Command: command, Channel: "telegram", To: "42"}})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		msg, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatal("no report of the scheduled command")
+		}
+		return msg.Content
+	}
+
+	if got := run("rm -rf /tmp/x"); !strings.Contains(got, "not approved") {
+		t.Errorf("report = %q", got)
+	}
+	if approver.got.Channel != "telegram" || approver.got.ChatID != "42" {
+		t.Errorf("approval asked in %s:%s", approver.got.Channel, approver.got.ChatID)
+	}
+	if got := run("df -h"); !strings.HasSuffix(got, "done") {
+		t.Errorf("report = %q", got)
+	}
+	if len(exec.ran) != 1 || exec.ran[0] != "df -h" {
+		t.Errorf("ran %v", exec.ran)
+	}
+}
+
+func TestCronTool_AddRecordsAgent(t *testing.T) {
+	service := cron.NewCronService(filepath.Join(t.TempDir(), "jobs.json"), nil)
+	tool := NewCronTool(service, nil, bus.NewMessageBus(), NewToolRegistry())
+	tool.SetAgent("ops")
+	tool.SetContext("telegram", "42")
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"action":     "add",
+		"message":    "check the backups",
+		"at_seconds": float64(600),
+	})
+	if result.IsError {
+		t.Fatalf("add failed: %s", result.ForLLM)
+	}
+	jobs := service.ListJobs(true)
+	if len(jobs) != 1 || jobs[0].Payload.Agent != "ops" {
+		t.Fatalf("jobs = %+v, want one job of agent ops", jobs)
+	}
+}
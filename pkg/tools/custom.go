@@ -0,0 +1,500 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+const (
+	defaultCustomToolTimeout = 60 * time.Second
+	maxCustomToolOutput      = MaxOutputLength
+)
+
+var (
+	customToolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	placeholderPattern    = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+)
+
+// CustomToolSpec declares a tool backed by a command or an HTTP endpoint,
+// as skills do in their frontmatter or tools.json:
+//
+//	{
+//	  "name": "weather_forecast",
+//	  "description": "Get the forecast for a city",
+//	  "parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]},
+//	  "command": ["./forecast.sh", "--city", "{{city}}"],
+//	  "timeout": 30
+//	}
+//
+// {{name}} placeholders are replaced with the arguments. Commands run
+// without a shell, so an argument always stays a single argv element, and
+// one that would start an element with "-" is refused unless it comes after
+// a "--" element, so it can't pass as an option.
+type CustomToolSpec struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"` // JSON schema of the arguments
+	Command     CommandTemplate        `json:"command,omitempty"`
+	HTTP        *HTTPEndpoint          `json:"http,omitempty"`
+	Timeout     int                    `json:"timeout,omitempty"` // seconds, default 60
+}
+
+// CommandTemplate is the argv of a command. In JSON it is an array, or a
+// string split at whitespace. A program starting with ./ is looked up in
+// the directory of the declaring skill; an element that is only the
+// placeholder of an omitted optional argument is dropped.
+type CommandTemplate []string
+
+func (c *CommandTemplate) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = strings.Fields(s)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("command must be a string or an array of strings")
+	}
+	*c = list
+	return nil
+}
+
+// HTTPEndpoint is called with the arguments: placeholders in the URL are
+// replaced with escaped values, the remaining arguments are sent as the
+// query of GET and DELETE requests and as a JSON body otherwise. Header
+// values may reference the environment variables listed in Env as ${VAR},
+// for API keys.
+type HTTPEndpoint struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"` // default GET
+	Headers map[string]string `json:"headers,omitempty"`
+	Env     []string          `json:"env,omitempty"`
+}
+
+// CustomTool runs a CustomToolSpec. Arguments are checked against the
+// parameter schema before anything runs. Commands work in the workspace;
+// string parameters with "format": "path" are resolved against it and,
+// when restricted, must stay inside it.
+type CustomTool struct {
+	spec       CustomToolSpec
+	dir        string
+	workspace  string
+	restrict   bool
+	timeout    time.Duration
+	sandbox    *Sandbox
+	sandboxErr error // set when a sandbox was requested but is unavailable
+	client     *http.Client
+}
+
+// NewCustomTool creates the tool declared by spec. dir is the directory
+// the declaration came from, where ./ programs are looked up.
+func NewCustomTool(spec CustomToolSpec, dir, workspace string, restrict bool) (*CustomTool, error) {
+	if !customToolNamePattern.MatchString(spec.Name) {
+		return nil, fmt.Errorf("invalid tool name %q: use 1-64 letters, digits, _ or -", spec.Name)
+	}
+	if spec.Description == "" {
+		return nil, fmt.Errorf("tool %s: description is required", spec.Name)
+	}
+	if (len(spec.Command) == 0) == (spec.HTTP == nil) {
+		return nil, fmt.Errorf("tool %s: exactly one of command and http is required", spec.Name)
+	}
+	if spec.HTTP != nil {
+		if spec.HTTP.URL == "" {
+			return nil, fmt.Errorf("tool %s: http.url is required", spec.Name)
+		}
+		spec.HTTP.Method = strings.ToUpper(spec.HTTP.Method)
+		if spec.HTTP.Method == "" {
+			spec.HTTP.Method = http.MethodGet
+		}
+		for key, value := range spec.HTTP.Headers {
+			if name := undeclaredEnv(value, spec.HTTP.Env); name != "" {
+				return nil, fmt.Errorf("tool %s: header %s uses ${%s}, which is not listed in http.env", spec.Name, key, name)
+			}
+		}
+	}
+	if spec.Parameters == nil {
+		spec.Parameters = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	} else if t, ok := spec.Parameters["type"]; ok && t != "object" {
+		return nil, fmt.Errorf("tool %s: parameters must be an object schema", spec.Name)
+	}
+	if spec.Timeout < 0 {
+		return nil, fmt.Errorf("tool %s: timeout must not be negative", spec.Name)
+	}
+
+	timeout := defaultCustomToolTimeout
+	if spec.Timeout > 0 {
+		timeout = time.Duration(spec.Timeout) * time.Second
+	}
+	return &CustomTool{
+		spec:      spec,
+		dir:       dir,
+		workspace: workspace,
+		restrict:  restrict,
+		timeout:   timeout,
+		client:    &http.Client{},
+	}, nil
+}
+
+func (t *CustomTool) Name() string {
+	return t.spec.Name
+}
+
+func (t *CustomTool) Description() string {
+	return t.spec.Description
+}
+
+func (t *CustomTool) Parameters() map[string]interface{} {
+	return t.spec.Parameters
+}
+
+// SetSandbox runs commands in the sandbox described by cfg, as the exec
+// tool does. If the sandbox is not available the error is returned and
+// commands are refused.
+func (t *CustomTool) SetSandbox(cfg config.ExecSandboxConfig) error {
+	t.sandbox, t.sandboxErr = NewSandbox(cfg, t.workspace)
+	return t.sandboxErr
+}
+
+func (t *CustomTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	args, err := t.checkArgs(args)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("Invalid arguments: %v", err)).WithError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var output string
+	if t.spec.HTTP != nil {
+		output, err = t.call(ctx, args)
+	} else {
+		output, err = t.run(ctx, args)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrorResult(fmt.Sprintf("%s timed out after %v", t.spec.Name, t.timeout)).WithError(ctx.Err())
+	}
+
+	if output == "" {
+		output = "(no output)"
+	}
+	output = TruncateOutput(output)
+	if err != nil {
+		return ErrorResult(output).WithError(err)
+	}
+	return NewToolResult(output)
+}
+
+// run executes the command template and returns its output.
+func (t *CustomTool) run(ctx context.Context, args map[string]interface{}) (string, error) {
+	argv := make([]string, 0, len(t.spec.Command))
+	options := true // until a "--" element
+	for _, elem := range t.spec.Command {
+		if m := placeholderPattern.FindStringSubmatch(elem); m != nil && m[0] == strings.TrimSpace(elem) {
+			if _, ok := args[m[1]]; !ok {
+				continue
+			}
+		}
+		arg := expandPlaceholders(elem, args, nil)
+		if options && strings.HasPrefix(arg, "-") && !strings.HasPrefix(elem, "-") {
+			err := fmt.Errorf("argument %q starts with -", arg)
+			return fmt.Sprintf("Command not run: %v", err), err
+		}
+		if elem == "--" {
+			options = false
+		}
+		argv = append(argv, arg)
+	}
+	if len(argv) == 0 {
+		return "", errors.New("empty command")
+	}
+	if strings.HasPrefix(argv[0], "./") {
+		argv[0] = filepath.Join(t.dir, argv[0])
+	}
+
+	// Never fall back to running unsandboxed when a sandbox was asked for
+	if t.sandboxErr != nil {
+		return fmt.Sprintf("Command not run: exec sandbox unavailable: %v", t.sandboxErr), t.sandboxErr
+	}
+
+	var cmd *exec.Cmd
+	if t.sandbox != nil {
+		sandboxed, cleanup, err := t.sandbox.Command(ctx, t.workspace, shellJoin(argv))
+		if err != nil {
+			return fmt.Sprintf("Command not run: %v", err), err
+		}
+		defer cleanup()
+		cmd = sandboxed
+	} else {
+		cmd = exec.CommandContext(ctx, argv[0], argv[1:]...)
+		cmd.Dir = t.workspace
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	output := stdout.String()
+	if stderr.Len() > 0 {
+		output += "\nSTDERR:\n" + stderr.String()
+	}
+	if err != nil {
+		output += fmt.Sprintf("\nExit code: %v", err)
+	}
+	return output, err
+}
+
+// call sends the arguments to the HTTP endpoint and returns the response.
+func (t *CustomTool) call(ctx context.Context, args map[string]interface{}) (string, error) {
+	endpoint := t.spec.HTTP
+	used := make(map[string]bool)
+	rawURL := expandPlaceholders(endpoint.URL, args, func(name, value string) string {
+		used[name] = true
+		// %20 is valid in both the path and the query
+		return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	})
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+
+	rest := make(map[string]interface{})
+	for name, value := range args {
+		if !used[name] {
+			rest[name] = value
+		}
+	}
+
+	var body io.Reader
+	if endpoint.Method == http.MethodGet || endpoint.Method == http.MethodDelete {
+		query := u.Query()
+		for name, value := range rest {
+			query.Set(name, formatArg(value))
+		}
+		u.RawQuery = query.Encode()
+	} else {
+		data, err := json.Marshal(rest)
+		if err != nil {
+			return "", err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, endpoint.Method, u.String(), body)
+	if err != nil {
+		return "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range endpoint.Headers {
+		req.Header.Set(key, os.Expand(value, func(name string) string {
+			if !containsString(endpoint.Env, name) {
+				return ""
+			}
+			return os.Getenv(name)
+		}))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*maxCustomToolOutput))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Sprintf("HTTP %d: %s", resp.StatusCode, data), fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return string(data), nil
+}
+
+// checkArgs validates args against the parameter schema and returns them
+// with path parameters resolved.
+func (t *CustomTool) checkArgs(args map[string]interface{}) (map[string]interface{}, error) {
+	properties, _ := t.spec.Parameters["properties"].(map[string]interface{})
+	var errs []string
+
+	for _, name := range schemaRequired(t.spec.Parameters) {
+		if _, ok := args[name]; !ok {
+			errs = append(errs, fmt.Sprintf("%s is required", name))
+		}
+	}
+
+	checked := make(map[string]interface{}, len(args))
+	for name, value := range args {
+		schema, ok := properties[name].(map[string]interface{})
+		if !ok {
+			if t.spec.Parameters["additionalProperties"] == false {
+				errs = append(errs, fmt.Sprintf("unknown argument %s", name))
+			}
+			checked[name] = value
+			continue
+		}
+		if err := checkValue(schema, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s %v", name, err))
+			continue
+		}
+		if s, ok := value.(string); ok && schema["format"] == "path" {
+			resolved, err := validatePath(s, t.workspace, t.restrict)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			value = resolved
+		}
+		checked[name] = value
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return checked, nil
+}
+
+// undeclaredEnv returns the first variable referenced in value that is not
+// in declared, or "".
+func undeclaredEnv(value string, declared []string) string {
+	var undeclared string
+	os.Expand(value, func(name string) string {
+		if undeclared == "" && !containsString(declared, name) {
+			undeclared = name
+		}
+		return ""
+	})
+	return undeclared
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaRequired(schema map[string]interface{}) []string {
+	switch required := schema["required"].(type) {
+	case []string:
+		return required
+	case []interface{}:
+		names := make([]string, 0, len(required))
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// checkValue checks the type and enum of a JSON schema.
+func checkValue(schema map[string]interface{}, value interface{}) error {
+	if typ, ok := schema["type"].(string); ok && !hasJSONType(value, typ) {
+		return fmt.Errorf("must be of type %s", typ)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %v", enum)
+	}
+	return nil
+}
+
+func hasJSONType(value interface{}, typ string) bool {
+	switch typ {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		switch value.(type) {
+		case float64, float32, int, int64:
+			return true
+		}
+	case "integer":
+		switch v := value.(type) {
+		case float64:
+			return v == float64(int64(v))
+		case int, int64:
+			return true
+		}
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return true
+	}
+	return false
+}
+
+// expandPlaceholders replaces {{name}} in s with the formatted argument,
+// passed through escape if given. Omitted arguments become empty.
+func expandPlaceholders(s string, args map[string]interface{}, escape func(name, value string) string) string {
+	return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		value, ok := args[name]
+		if !ok {
+			return ""
+		}
+		formatted := formatArg(value)
+		if escape != nil {
+			return escape(name, formatted)
+		}
+		return formatted
+	})
+}
+
+// formatArg renders an argument as text: strings as they are, numbers
+// without exponents and anything else as JSON.
+func formatArg(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// shellJoin quotes argv for sh -c, as the sandbox takes a shell command.
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCustomTool(t *testing.T, spec string, dir, workspace string) *CustomTool {
+	t.Helper()
+	var s CustomToolSpec
+	if err := json.Unmarshal([]byte(spec), &s); err != nil {
+		t.Fatalf("spec: %v", err)
+	}
+	tool, err := NewCustomTool(s, dir, workspace, true)
+	if err != nil {
+		t.Fatalf("NewCustomTool: %v", err)
+	}
+	return tool
+}
+
+func TestCustomTool_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	dir := t.TempDir()
+	workspace := t.TempDir()
+	script := "#!/bin/sh\nfor a in \"$@\"; do echo \"[$a]\"; done\npwd\n"
+	if err := os.WriteFile(filepath.Join(dir, "args.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := newCustomTool(t, `{
+		"name": "args",
+		"description": "Print the arguments",
+		"parameters": {"type": "object", "properties": {
+			"text": {"type": "string"},
+			"count": {"type": "integer"},
+			"file": {"type": "string", "format": "path"}
+		}, "required": ["text"]},
+		"command": ["./args.sh", "{{text}}", "-n={{count}}", "{{file}}"]
+	}`, dir, workspace)
+
+	// Arguments stay single argv elements, without shell interpretation
+	got := tool.Execute(context.Background(), map[string]interface{}{"text": "a b; $(id)", "count": float64(3)})
+	want := "[a b; $(id)]\n[-n=3]\n"
+	if got.IsError || !strings.HasPrefix(got.ForLLM, want) {
+		t.Errorf("output = %q, want prefix %q", got.ForLLM, want)
+	}
+	realWorkspace, _ := filepath.EvalSymlinks(workspace)
+	if !strings.Contains(got.ForLLM, realWorkspace) && !strings.Contains(got.ForLLM, workspace) {
+		t.Errorf("command did not run in the workspace: %q", got.ForLLM)
+	}
+
+	if got := tool.Execute(context.Background(), map[string]interface{}{"text": "x", "file": "notes.txt"}); !strings.Contains(got.ForLLM, filepath.Join(workspace, "notes.txt")) {
+		t.Errorf("path not resolved: %q", got.ForLLM)
+	}
+
+	// An argument can't pass as an option
+	if got := tool.Execute(context.Background(), map[string]interface{}{"text": "--help"}); !got.IsError || !strings.HasPrefix(got.ForLLM, "Command not run") {
+		t.Errorf("option argument: got %+v", got)
+	}
+	separated := newCustomTool(t, `{
+		"name": "separated",
+		"description": "Print the arguments",
+		"command": ["./args.sh", "--", "{{text}}"]
+	}`, dir, workspace)
+	if got := separated.Execute(context.Background(), map[string]interface{}{"text": "-n"}); got.IsError || !strings.HasPrefix(got.ForLLM, "[--]\n[-n]\n") {
+		t.Errorf("argument after --: got %+v", got)
+	}
+
+	for name, args := range map[string]map[string]interface{}{
+		"missing required": {"count": float64(1)},
+		"wrong type":       {"text": "x", "count": "three"},
+		"fraction":         {"text": "x", "count": 1.5},
+		"outside":          {"text": "x", "file": "../secret"},
+	} {
+		if got := tool.Execute(context.Background(), args); !got.IsError || !strings.HasPrefix(got.ForLLM, "Invalid arguments") {
+			t.Errorf("%s: got %+v", name, got)
+		}
+	}
+}
+
+func TestCustomTool_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+	tool := newCustomTool(t, `{"name": "slow", "description": "Sleep", "command": "sleep 5"}`, t.TempDir(), t.TempDir())
+	tool.timeout = 100 * time.Millisecond
+	got := tool.Execute(context.Background(), nil)
+	if !got.IsError || !strings.Contains(got.ForLLM, "timed out") {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestCustomTool_HTTP(t *testing.T) {
+	t.Setenv("CUSTOM_TOOL_TOKEN", "secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.EscapedPath() + "?" + r.URL.RawQuery + " " + string(body)))
+	}))
+	defer srv.Close()
+
+	get := newCustomTool(t, `{
+		"name": "lookup",
+		"description": "Look up a city",
+		"parameters": {"type": "object", "properties": {"city": {"type": "string"}, "units": {"type": "string", "enum": ["metric", "imperial"]}}},
+		"http": {"url": "`+srv.URL+`/cities/{{city}}", "headers": {"Authorization": "Bearer ${CUSTOM_TOOL_TOKEN}"}, "env": ["CUSTOM_TOOL_TOKEN"]}
+	}`, "", t.TempDir())
+	got := get.Execute(context.Background(), map[string]interface{}{"city": "New York", "units": "metric"})
+	if got.IsError || got.ForLLM != "GET /cities/New%20York?units=metric " {
+		t.Errorf("GET = %+v", got)
+	}
+	if got := get.Execute(context.Background(), map[string]interface{}{"units": "kelvin"}); !got.IsError {
+		t.Errorf("value outside enum accepted: %+v", got)
+	}
+
+	post := newCustomTool(t, `{
+		"name": "create",
+		"description": "Create a note",
+		"http": {"url": "`+srv.URL+`/notes", "method": "post", "headers": {"Authorization": "Bearer ${CUSTOM_TOOL_TOKEN}"}, "env": ["CUSTOM_TOOL_TOKEN"]}
+	}`, "", t.TempDir())
+	got = post.Execute(context.Background(), map[string]interface{}{"text": "hi"})
+	if got.IsError || got.ForLLM != `POST /notes? {"text":"hi"}` {
+		t.Errorf("POST = %+v", got)
+	}
+
+	t.Setenv("CUSTOM_TOOL_TOKEN", "wrong")
+	if got := post.Execute(context.Background(), nil); !got.IsError || !strings.HasPrefix(got.ForLLM, "HTTP 401") {
+		t.Errorf("unauthorized = %+v", got)
+	}
+}
+
+func TestNewCustomTool_Invalid(t *testing.T) {
+	for _, spec := range []CustomToolSpec{
+		{Name: "bad name", Description: "x", Command: CommandTemplate{"true"}},
+		{Name: "nodesc", Command: CommandTemplate{"true"}},
+		{Name: "neither", Description: "x"},
+		{Name: "both", Description: "x", Command: CommandTemplate{"true"}, HTTP: &HTTPEndpoint{URL: "http://x"}},
+		{Name: "array", Description: "x", Command: CommandTemplate{"true"}, Parameters: map[string]interface{}{"type": "array"}},
+		{Name: "env", Description: "x", HTTP: &HTTPEndpoint{URL: "http://x", Headers: map[string]string{"X-Other": "${CUSTOM_TOOL_OTHER}"}}},
+	} {
+		if _, err := NewCustomTool(spec, "", "", false); err == nil {
+			t.Errorf("spec %s accepted", spec.Name)
+		}
+	}
+}
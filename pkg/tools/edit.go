@@ -26,6 +26,11 @@ func (t *EditFileTool) Name() string {
 	return "edit_file"
 }
 
+// ParallelSafe reports false: the tool edits the workspace, so calls are serialized.
+func (t *EditFileTool) ParallelSafe() bool {
+	return false
+}
+
 func (t *EditFileTool) Description() string {
 	return "Edit a file by replacing old_text with new_text. The old_text must exist exactly in the file."
 }
@@ -114,6 +119,11 @@ func (t *AppendFileTool) Name() string {
 	return "append_file"
 }
 
+// ParallelSafe reports false: the tool edits the workspace, so calls are serialized.
+func (t *AppendFileTool) ParallelSafe() bool {
+	return false
+}
+
 func (t *AppendFileTool) Description() string {
 	return "Append content to the end of a file"
 }
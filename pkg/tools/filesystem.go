@@ -139,6 +139,11 @@ func (t *WriteFileTool) Name() string {
 	return "write_file"
 }
 
+// ParallelSafe reports false: the tool edits the workspace, so calls are serialized.
+func (t *WriteFileTool) ParallelSafe() bool {
+	return false
+}
+
 func (t *WriteFileTool) Description() string {
 	return "Write content to a file"
 }
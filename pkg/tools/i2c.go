@@ -20,6 +20,11 @@ func (t *I2CTool) Name() string {
 	return "i2c"
 }
 
+// ParallelSafe reports false: the tool drives shared bus hardware, so calls are serialized.
+func (t *I2CTool) ParallelSafe() bool {
+	return false
+}
+
 func (t *I2CTool) Description() string {
 	return "Interact with I2C bus devices for reading sensors and controlling peripherals. Actions: detect (list buses), scan (find devices on a bus), read (read bytes from device), write (send bytes to device). Linux only."
 }
@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+// MemorySearchTool searches long-term memory, daily notes and the summary of
+// the current conversation.
+type MemorySearchTool struct {
+	index *memory.Index
+	topK  int
+}
+
+func NewMemorySearchTool(index *memory.Index, topK int) *MemorySearchTool {
+	if topK <= 0 {
+		topK = 5
+	}
+	return &MemorySearchTool{index: index, topK: topK}
+}
+
+func (t *MemorySearchTool) Name() string {
+	return "memory_search"
+}
+
+func (t *MemorySearchTool) Description() string {
+	return "Search your long-term memory, daily notes and the summary of earlier messages in this conversation. Use it to recall facts, preferences or earlier decisions that are not in the current conversation."
+}
+
+func (t *MemorySearchTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query": map[string]interface{}{
+				"type":        "string",
+				"description": "What to look for, in keywords or a short question",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum number of snippets to return (default %d)", t.topK),
+				"minimum":     1,
+				"maximum":     20,
+			},
+		},
+		"required": []string{"query"},
+	}
+}
+
+func (t *MemorySearchTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return ErrorResult("query is required")
+	}
+	limit := t.topK
+	if l, ok := args["limit"].(float64); ok && int(l) > 0 {
+		limit = int(l)
+		if limit > 20 {
+			limit = 20
+		}
+	}
+
+	var sessionKey string
+	if ec := ExecutionContextFrom(ctx); ec != nil {
+		sessionKey = ec.SessionKey
+	}
+	results, err := t.index.Search(ctx, sessionKey, query, limit)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("memory search failed: %v", err)).WithError(err)
+	}
+	if len(results) == 0 {
+		return SilentResult(fmt.Sprintf("No memories found for %q.", query))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Found %d memories for %q:\n", len(results), query)
+	for i, r := range results {
+		fmt.Fprintf(&sb, "\n%d. [%s]\n%s\n", i+1, r.Title(), r.Text)
+	}
+	return SilentResult(sb.String())
+}
+
+// MemorySaveTool appends a note to long-term memory or today's daily note.
+type MemorySaveTool struct {
+	index *memory.Index
+}
+
+func NewMemorySaveTool(index *memory.Index) *MemorySaveTool {
+	return &MemorySaveTool{index: index}
+}
+
+func (t *MemorySaveTool) Name() string {
+	return "memory_save"
+}
+
+func (t *MemorySaveTool) Description() string {
+	return "Save something to remember in later conversations. Use long_term for lasting facts about the user, their preferences and decisions; use daily for notes about what happened today. Write self-contained notes, ideally under a short markdown heading."
+}
+
+func (t *MemorySaveTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"content": map[string]interface{}{
+				"type":        "string",
+				"description": "The note to save, in markdown",
+			},
+			"target": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{memory.KindLongTerm, memory.KindDaily},
+				"description": "Where to save it (default long_term)",
+			},
+		},
+		"required": []string{"content"},
+	}
+}
+
+func (t *MemorySaveTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	content, _ := args["content"].(string)
+	target, _ := args["target"].(string)
+	if target == "" {
+		target = memory.KindLongTerm
+	}
+
+	path, err := t.index.Save(target, content)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("failed to save memory: %v", err)).WithError(err)
+	}
+	return SilentResult(fmt.Sprintf("Saved to %s", path))
+}
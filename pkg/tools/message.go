@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync/atomic"
 )
 
 // SendCallback sends a plain-text message to a channel/chat.
@@ -23,7 +24,7 @@ type MessageTool struct {
 	synthesizeCallback SynthesizeCallback
 	defaultChannel     string
 	defaultChatID      string
-	sentInRound        bool
+	sentInRound        atomic.Bool
 }
 
 func NewMessageTool() *MessageTool {
@@ -68,11 +69,22 @@ func (t *MessageTool) Parameters() map[string]interface{} {
 func (t *MessageTool) SetContext(channel, chatID string) {
 	t.defaultChannel = channel
 	t.defaultChatID = chatID
-	t.sentInRound = false
+	t.sentInRound.Store(false)
 }
 
+// HasSentInRound reports whether a message was sent since the last SetContext.
+// Turns executed with an ExecutionContext record this on the context instead.
 func (t *MessageTool) HasSentInRound() bool {
-	return t.sentInRound
+	return t.sentInRound.Load()
+}
+
+// markSent records a delivered message on the per-turn context when present.
+func (t *MessageTool) markSent(ctx context.Context) {
+	if ec := ExecutionContextFrom(ctx); ec != nil {
+		ec.MarkMessageSent()
+		return
+	}
+	t.sentInRound.Store(true)
 }
 
 func (t *MessageTool) SetSendCallback(callback SendCallback) {
@@ -97,11 +109,12 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 	channel, _ := args["channel"].(string)
 	chatID, _ := args["chat_id"].(string)
 
+	defaultChannel, defaultChatID := targetFromContext(ctx, t.defaultChannel, t.defaultChatID)
 	if channel == "" {
-		channel = t.defaultChannel
+		channel = defaultChannel
 	}
 	if chatID == "" {
-		chatID = t.defaultChatID
+		chatID = defaultChatID
 	}
 
 	if channel == "" || chatID == "" {
@@ -131,7 +144,7 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 			}
 		}
 
-		t.sentInRound = true
+		t.markSent(ctx)
 		return &ToolResult{
 			ForLLM: fmt.Sprintf("Voice message sent to %s:%s", channel, chatID),
 			Silent: true,
@@ -151,7 +164,7 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 		}
 	}
 
-	t.sentInRound = true
+	t.markSent(ctx)
 	return &ToolResult{
 		ForLLM: fmt.Sprintf("Message sent to %s:%s", channel, chatID),
 		Silent: true,
@@ -257,3 +257,35 @@ func TestMessageTool_Parameters(t *testing.T) {
 		t.Error("Expected chat_id type to be 'string'")
 	}
 }
+
+func TestMessageTool_Execute_UsesExecutionContext(t *testing.T) {
+	tool := NewMessageTool()
+	tool.SetContext("default-channel", "default-chat-id")
+
+	var sentChannel, sentChatID string
+	tool.SetSendCallback(func(channel, chatID, content string) error {
+		sentChannel = channel
+		sentChatID = chatID
+		return nil
+	})
+
+	execCtx := NewExecutionContext("turn-channel", "turn-chat-id")
+	ctx := WithExecutionContext(context.Background(), execCtx)
+
+	result := tool.Execute(ctx, map[string]interface{}{"content": "hi"})
+	if result.IsError {
+		t.Fatalf("Expected success, got error: %s", result.ForLLM)
+	}
+
+	if sentChannel != "turn-channel" || sentChatID != "turn-chat-id" {
+		t.Errorf("Expected turn-channel:turn-chat-id, got %s:%s", sentChannel, sentChatID)
+	}
+
+	// The sent flag is recorded on the turn, not the shared tool instance
+	if !execCtx.MessageSent() {
+		t.Error("Expected execution context to record the sent message")
+	}
+	if tool.HasSentInRound() {
+		t.Error("Expected shared tool state to stay untouched")
+	}
+}
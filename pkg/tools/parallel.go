@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// IsParallelSafe reports whether the named tool may run concurrently with
+// other tool calls. Unknown tools are treated as safe; executing them only
+// produces a "not found" error.
+func (r *ToolRegistry) IsParallelSafe(name string) bool {
+	tool, ok := r.Get(name)
+	if !ok {
+		return true
+	}
+	if pt, ok := tool.(ParallelSafeTool); ok {
+		return pt.ParallelSafe()
+	}
+	return true
+}
+
+// ExecuteToolCalls runs run for every call, at most maxParallel at a time,
+// and returns the results in call order so they can be paired with their
+// ToolCallIDs. Calls to tools that aren't parallel safe act as barriers: they
+// start after every earlier call has finished, and later calls wait for them.
+// A maxParallel of 1 or less executes the calls sequentially.
+func (r *ToolRegistry) ExecuteToolCalls(ctx context.Context, calls []providers.ToolCall, maxParallel int, run func(ctx context.Context, tc providers.ToolCall) *ToolResult) []*ToolResult {
+	results := make([]*ToolResult, len(calls))
+
+	if maxParallel <= 1 || len(calls) <= 1 {
+		for i, tc := range calls {
+			results[i] = run(ctx, tc)
+		}
+		return results
+	}
+
+	slots := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i, tc := range calls {
+		if !r.IsParallelSafe(tc.Name) {
+			wg.Wait()
+			results[i] = run(ctx, tc)
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, tc providers.ToolCall) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = run(ctx, tc)
+		}(i, tc)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,145 @@
+package tools
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// trackingTool sleeps briefly and records how many calls overlap
+type trackingTool struct {
+	name     string
+	serial   bool
+	active   *atomic.Int32
+	maxSeen  *atomic.Int32
+	mu       *sync.Mutex
+	overlaps *[]string // names of serial tools that ran alongside another call
+}
+
+func (t *trackingTool) Name() string                       { return t.name }
+func (t *trackingTool) Description() string                { return "tracking tool" }
+func (t *trackingTool) Parameters() map[string]interface{} { return map[string]interface{}{} }
+func (t *trackingTool) ParallelSafe() bool                 { return !t.serial }
+
+func (t *trackingTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	n := t.active.Add(1)
+	defer t.active.Add(-1)
+	for {
+		seen := t.maxSeen.Load()
+		if n <= seen || t.maxSeen.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+	if t.serial && n > 1 {
+		t.mu.Lock()
+		*t.overlaps = append(*t.overlaps, t.name)
+		t.mu.Unlock()
+	}
+	time.Sleep(50 * time.Millisecond)
+	return SilentResult(args["id"].(string))
+}
+
+func newTrackingRegistry(serial ...string) (*ToolRegistry, *atomic.Int32, *[]string) {
+	active := &atomic.Int32{}
+	maxSeen := &atomic.Int32{}
+	overlaps := &[]string{}
+	mu := &sync.Mutex{}
+
+	registry := NewToolRegistry()
+	registry.Register(&trackingTool{name: "fetch", active: active, maxSeen: maxSeen, mu: mu, overlaps: overlaps})
+	for _, name := range serial {
+		registry.Register(&trackingTool{name: name, serial: true, active: active, maxSeen: maxSeen, mu: mu, overlaps: overlaps})
+	}
+	return registry, maxSeen, overlaps
+}
+
+func runCalls(registry *ToolRegistry, calls []providers.ToolCall, maxParallel int) []*ToolResult {
+	return registry.ExecuteToolCalls(context.Background(), calls, maxParallel, func(ctx context.Context, tc providers.ToolCall) *ToolResult {
+		return registry.Execute(ctx, tc.Name, tc.Arguments)
+	})
+}
+
+// TestExecuteToolCalls_RunsConcurrentlyInOrder verifies independent calls
+// overlap, respect the cap, and return results in call order
+func TestExecuteToolCalls_RunsConcurrentlyInOrder(t *testing.T) {
+	registry, maxSeen, _ := newTrackingRegistry()
+
+	calls := make([]providers.ToolCall, 4)
+	for i, id := range []string{"a", "b", "c", "d"} {
+		calls[i] = providers.ToolCall{ID: id, Name: "fetch", Arguments: map[string]interface{}{"id": id}}
+	}
+
+	results := runCalls(registry, calls, 2)
+
+	for i, id := range []string{"a", "b", "c", "d"} {
+		if results[i].ForLLM != id {
+			t.Errorf("results[%d] = %q, want %q", i, results[i].ForLLM, id)
+		}
+	}
+	if got := maxSeen.Load(); got != 2 {
+		t.Errorf("Expected calls to overlap up to the cap of 2, saw %d", got)
+	}
+}
+
+// TestExecuteToolCalls_SerialToolRunsAlone verifies non-parallel-safe tools
+// never overlap with other calls
+func TestExecuteToolCalls_SerialToolRunsAlone(t *testing.T) {
+	registry, maxSeen, overlaps := newTrackingRegistry("edit")
+
+	calls := []providers.ToolCall{
+		{ID: "1", Name: "fetch", Arguments: map[string]interface{}{"id": "1"}},
+		{ID: "2", Name: "fetch", Arguments: map[string]interface{}{"id": "2"}},
+		{ID: "3", Name: "edit", Arguments: map[string]interface{}{"id": "3"}},
+		{ID: "4", Name: "fetch", Arguments: map[string]interface{}{"id": "4"}},
+	}
+
+	results := runCalls(registry, calls, 4)
+
+	if len(*overlaps) != 0 {
+		t.Errorf("Serial tool overlapped with other calls: %v", *overlaps)
+	}
+	if got := maxSeen.Load(); got != 2 {
+		t.Errorf("Expected the two leading fetches to overlap, max concurrency was %d", got)
+	}
+	for i, tc := range calls {
+		if results[i].ForLLM != tc.ID {
+			t.Errorf("results[%d] = %q, want %q", i, results[i].ForLLM, tc.ID)
+		}
+	}
+}
+
+// TestExecuteToolCalls_SequentialWhenCapIsOne verifies a cap of 1 keeps the old behavior
+func TestExecuteToolCalls_SequentialWhenCapIsOne(t *testing.T) {
+	registry, maxSeen, _ := newTrackingRegistry()
+
+	calls := []providers.ToolCall{
+		{ID: "1", Name: "fetch", Arguments: map[string]interface{}{"id": "1"}},
+		{ID: "2", Name: "fetch", Arguments: map[string]interface{}{"id": "2"}},
+	}
+	runCalls(registry, calls, 1)
+
+	if got := maxSeen.Load(); got != 1 {
+		t.Errorf("Expected sequential execution, max concurrency was %d", got)
+	}
+}
+
+func TestExecuteToolCalls_BuiltinSerialTools(t *testing.T) {
+	registry := NewToolRegistry()
+	registry.Register(NewReadFileTool("", false))
+	registry.Register(NewEditFileTool("", false))
+	registry.Register(NewExecTool("", false))
+
+	if !registry.IsParallelSafe("read_file") {
+		t.Error("read_file should be parallel safe")
+	}
+	if registry.IsParallelSafe("edit_file") {
+		t.Error("edit_file should not be parallel safe")
+	}
+	if registry.IsParallelSafe("exec") {
+		t.Error("exec should not be parallel safe")
+	}
+}
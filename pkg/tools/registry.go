@@ -11,8 +11,11 @@ import (
 )
 
 type ToolRegistry struct {
-	tools map[string]Tool
-	mu    sync.RWMutex
+	tools    map[string]Tool
+	allowed  map[string]bool // nil allows every tool
+	policy   *ApprovalPolicy // nil when no call needs approval
+	approver Approver
+	mu       sync.RWMutex
 }
 
 func NewToolRegistry() *ToolRegistry {
@@ -24,9 +27,57 @@ func NewToolRegistry() *ToolRegistry {
 func (r *ToolRegistry) Register(tool Tool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.allowed != nil && !r.allowed[tool.Name()] {
+		return
+	}
 	r.tools[tool.Name()] = tool
 }
 
+// Unregister removes a tool, e.g. one offered by an MCP server that was
+// removed from the config.
+func (r *ToolRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tools, name)
+}
+
+// SetAllowlist restricts the registry to the named tools. Registered tools
+// that are not listed are removed and later registrations of them are
+// ignored. An empty list allows every tool.
+func (r *ToolRegistry) SetAllowlist(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(names) == 0 {
+		r.allowed = nil
+		return
+	}
+	r.allowed = make(map[string]bool, len(names))
+	for _, name := range names {
+		r.allowed[name] = true
+	}
+	for name := range r.tools {
+		if !r.allowed[name] {
+			delete(r.tools, name)
+		}
+	}
+}
+
+// SetApprovalPolicy makes calls matched by policy wait for an approver
+// before they run. Nil turns approvals off.
+func (r *ToolRegistry) SetApprovalPolicy(policy *ApprovalPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+}
+
+// SetApprover sets who is asked when the approval policy matches a call.
+// Without an approver such calls are denied.
+func (r *ToolRegistry) SetApprover(approver Approver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.approver = approver
+}
+
 func (r *ToolRegistry) Get(name string) (Tool, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -57,14 +108,19 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 		return ErrorResult(fmt.Sprintf("tool %q not found", name)).WithError(fmt.Errorf("tool not found"))
 	}
 
-	// If tool implements ContextualTool, set context
-	if contextualTool, ok := tool.(ContextualTool); ok && channel != "" && chatID != "" {
-		contextualTool.SetContext(channel, chatID)
+	// Attach channel/chatID to the request context rather than mutating the
+	// shared tool instance, so concurrent turns can't leak targets into each other.
+	if ExecutionContextFrom(ctx) == nil && channel != "" && chatID != "" {
+		ctx = WithExecutionContext(ctx, NewExecutionContext(channel, chatID))
+	}
+
+	if denied := r.checkApproval(ctx, name, args); denied != nil {
+		return denied
 	}
 
-	// If tool implements AsyncTool and callback is provided, set callback
-	if asyncTool, ok := tool.(AsyncTool); ok && asyncCallback != nil {
-		asyncTool.SetCallback(asyncCallback)
+	// If tool implements AsyncTool and callback is provided, pass it along with the call
+	if _, ok := tool.(AsyncTool); ok && asyncCallback != nil {
+		ctx = withAsyncCallback(ctx, asyncCallback)
 		logger.DebugCF("tool", "Async callback injected",
 			map[string]interface{}{
 				"tool": name,
@@ -101,6 +157,48 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 	return result
 }
 
+// checkApproval asks for approval when the policy requires it and returns
+// the result to give the model if the call must not run.
+func (r *ToolRegistry) checkApproval(ctx context.Context, name string, args map[string]interface{}) *ToolResult {
+	r.mu.RLock()
+	policy, approver := r.policy, r.approver
+	r.mu.RUnlock()
+
+	rule, ok := policy.Match(name, args)
+	if !ok {
+		return nil
+	}
+
+	req := ApprovalRequest{Tool: name, Args: args, Rule: rule}
+	if ec := ExecutionContextFrom(ctx); ec != nil {
+		req.Channel, req.ChatID = ec.Channel, ec.ChatID
+	}
+
+	var decision ApprovalDecision
+	if approver == nil {
+		decision.Reason = "no approver is configured"
+	} else {
+		logger.InfoCF("tool", "Tool call waiting for approval",
+			map[string]interface{}{
+				"tool":    name,
+				"rule":    rule,
+				"channel": req.Channel,
+			})
+		decision = approver.RequestApproval(ctx, req)
+	}
+	if decision.Approved {
+		return nil
+	}
+
+	logger.WarnCF("tool", "Tool call not approved",
+		map[string]interface{}{
+			"tool":   name,
+			"reason": decision.Reason,
+		})
+	msg := fmt.Sprintf("The %s call requires approval and was not approved (%s). It was not executed.", name, decision.Reason)
+	return ErrorResult(msg).WithError(fmt.Errorf("tool call not approved: %s", decision.Reason))
+}
+
 func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
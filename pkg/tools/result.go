@@ -1,6 +1,22 @@
 package tools
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MaxOutputLength is how much of a command's or server's output the exec,
+// skill and MCP tools hand to the LLM.
+const MaxOutputLength = 10000
+
+// TruncateOutput cuts output to MaxOutputLength bytes, noting how much was
+// left out.
+func TruncateOutput(output string) string {
+	if len(output) <= MaxOutputLength {
+		return output
+	}
+	return output[:MaxOutputLength] + fmt.Sprintf("\n... (truncated, %d more chars)", len(output)-MaxOutputLength)
+}
 
 // ToolResult represents the structured return value from tool execution.
 // It provides clear semantics for different types of results and supports
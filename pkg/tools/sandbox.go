@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// Sandbox modes for the exec tool (tools.exec.sandbox.mode).
+const (
+	SandboxOff        = "off"
+	SandboxAuto       = "auto"       // bwrap if installed, otherwise namespaces
+	SandboxNamespaces = "namespaces" // user/mount/pid/net namespaces set up by PicoClaw itself
+	SandboxBwrap      = "bwrap"      // bubblewrap
+)
+
+// Sandbox runs shell commands isolated from the host: the workspace and any
+// configured writable paths are mounted read-write, the rest of the
+// filesystem read-only, the network is cut off unless allowed, and CPU,
+// memory and process limits are applied through a cgroup.
+//
+// The exec tool's pattern checks still run first; the sandbox is what holds
+// when a command slips past them.
+type Sandbox struct {
+	backend      string
+	writable     []string
+	allowNetwork bool
+	limits       cgroupLimits
+	cgroupParent string
+}
+
+type cgroupLimits struct {
+	memoryMB int
+	cpus     float64
+	maxPids  int
+}
+
+func (l cgroupLimits) any() bool {
+	return l.memoryMB > 0 || l.cpus > 0 || l.maxPids > 0
+}
+
+// NewSandbox creates the sandbox described by cfg for commands working in
+// workspace. It returns nil when the sandbox is off, and an error when the
+// requested isolation is not available on this host.
+func NewSandbox(cfg config.ExecSandboxConfig, workspace string) (*Sandbox, error) {
+	if cfg.Mode == "" || cfg.Mode == SandboxOff {
+		return nil, nil
+	}
+
+	s := &Sandbox{
+		allowNetwork: cfg.AllowNetwork,
+		limits: cgroupLimits{
+			memoryMB: cfg.MemoryMB,
+			cpus:     cfg.CPUs,
+			maxPids:  cfg.MaxPids,
+		},
+		cgroupParent: cfg.CgroupParent,
+	}
+	if s.cgroupParent == "" {
+		s.cgroupParent = "/sys/fs/cgroup/picoclaw"
+	}
+
+	for _, p := range append([]string{workspace}, cfg.WritablePaths...) {
+		if p == "" {
+			continue
+		}
+		// Mount points are reported with symlinks resolved.
+		abs, err := filepath.Abs(expandHome(p))
+		if err != nil {
+			return nil, fmt.Errorf("writable path %q: %w", p, err)
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, fmt.Errorf("writable path %q: %w", p, err)
+		}
+		s.writable = append(s.writable, real)
+	}
+
+	backend, err := s.resolveBackend(cfg.Mode)
+	if err != nil {
+		return nil, err
+	}
+	s.backend = backend
+
+	if s.limits.any() {
+		if err := s.checkCgroups(); err != nil {
+			return nil, fmt.Errorf("sandbox resource limits: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Backend returns the isolation in use, SandboxBwrap or SandboxNamespaces.
+func (s *Sandbox) Backend() string {
+	return s.backend
+}
+
+func expandHome(path string) string {
+	if path == "" {
+		return path
+	}
+	if path[0] == '~' {
+		home, _ := os.UserHomeDir()
+		if len(path) > 1 && path[1] == '/' {
+			return home + path[1:]
+		}
+		return home
+	}
+	return path
+}
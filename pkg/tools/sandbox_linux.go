@@ -0,0 +1,449 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// sandboxInitArg marks a re-execution of the current binary as the init
+// process of a namespace sandbox (see runSandboxInit).
+const sandboxInitArg = "__picoclaw_sandbox_init"
+
+func init() {
+	if len(os.Args) >= 4 && os.Args[1] == sandboxInitArg {
+		runSandboxInit(os.Args[2], os.Args[3], os.Args[4:])
+	}
+}
+
+// prctl and capset constants from <linux/prctl.h> and <linux/capability.h>
+const (
+	prSetNoNewPrivs       = 38
+	prCapAmbient          = 47
+	prCapAmbientClearAll  = 4
+	linuxCapabilityV3     = 0x20080522
+	sandboxInitExitStatus = 126
+)
+
+func (s *Sandbox) resolveBackend(mode string) (string, error) {
+	switch mode {
+	case SandboxBwrap:
+		if _, err := exec.LookPath("bwrap"); err != nil {
+			return "", fmt.Errorf("sandbox mode %q: bubblewrap is not installed", mode)
+		}
+		return SandboxBwrap, nil
+	case SandboxNamespaces:
+		if err := s.probeNamespaces(); err != nil {
+			return "", fmt.Errorf("sandbox mode %q: %w", mode, err)
+		}
+		return SandboxNamespaces, nil
+	case SandboxAuto:
+		if _, err := exec.LookPath("bwrap"); err == nil {
+			return SandboxBwrap, nil
+		}
+		if err := s.probeNamespaces(); err != nil {
+			return "", fmt.Errorf("sandbox mode %q: bubblewrap is not installed and %w", mode, err)
+		}
+		return SandboxNamespaces, nil
+	default:
+		return "", fmt.Errorf("unknown sandbox mode %q (want off, auto, namespaces or bwrap)", mode)
+	}
+}
+
+// probeNamespaces runs a no-op command in a namespace sandbox, which fails
+// when unprivileged user namespaces are disabled.
+func (s *Sandbox) probeNamespaces() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := s.namespacesCommand(ctx, "/", "true")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("user namespaces are not usable: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Command returns the command running the shell command in the sandbox with
+// dir as working directory. cleanup must be called once the command has
+// finished.
+func (s *Sandbox) Command(ctx context.Context, dir, command string) (*exec.Cmd, func(), error) {
+	var cmd *exec.Cmd
+	if s.backend == SandboxBwrap {
+		cmd = s.bwrapCommand(ctx, dir, command)
+	} else {
+		cmd = s.namespacesCommand(ctx, dir, command)
+	}
+
+	cleanup := func() {}
+	if s.limits.any() {
+		fd, remove, err := s.newCgroup()
+		if err != nil {
+			return nil, nil, fmt.Errorf("sandbox resource limits: %w", err)
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = fd
+		cleanup = remove
+	}
+	return cmd, cleanup, nil
+}
+
+func (s *Sandbox) bwrapCommand(ctx context.Context, dir, command string) *exec.Cmd {
+	args := []string{
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		// Inbound media are saved under the temp dir; keep them readable.
+		"--ro-bind-try", utils.MediaDir(), utils.MediaDir(),
+	}
+	for _, p := range s.writable {
+		args = append(args, "--bind", p, p)
+	}
+	args = append(args,
+		"--unshare-user", "--unshare-pid", "--unshare-ipc", "--unshare-uts", "--unshare-cgroup-try",
+		"--die-with-parent", "--new-session", "--cap-drop", "ALL",
+	)
+	if !s.allowNetwork {
+		args = append(args, "--unshare-net")
+	}
+	args = append(args, "--chdir", dir, "--", "sh", "-c", command)
+	return exec.CommandContext(ctx, "bwrap", args...)
+}
+
+func (s *Sandbox) namespacesCommand(ctx context.Context, dir, command string) *exec.Cmd {
+	args := append([]string{sandboxInitArg, dir, command}, s.writable...)
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", args...)
+
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if !s.allowNetwork {
+		flags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: uintptr(flags),
+		// Root inside the namespace is needed to set up mounts; it maps to
+		// the current user, so files written to the workspace are ours.
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+	return cmd
+}
+
+// runSandboxInit runs as PID 1 of fresh user, mount and PID namespaces. It
+// makes every mount read-only except the writable paths, mounts a private
+// /proc and /tmp with the media directory bound read-only, drops all
+// capabilities and execs the shell command.
+func runSandboxInit(dir, command string, writable []string) {
+	// Capability changes are per thread and must be made on the thread
+	// that calls execve.
+	runtime.LockOSThread()
+
+	fail := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "sandbox: "+format+"\n", args...)
+		os.Exit(sandboxInitExitStatus)
+	}
+
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		fail("make mounts private: %v", err)
+	}
+
+	// The writable paths are bound onto themselves first, so they are
+	// separate mounts that the read-only pass below can leave alone.
+	for _, p := range writable {
+		if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			fail("bind %s: %v", p, err)
+		}
+	}
+
+	mounts, err := readMountInfo()
+	if err != nil {
+		fail("read mounts: %v", err)
+	}
+	for _, m := range mounts {
+		if withinAny(m.point, writable) {
+			continue
+		}
+		flags := uintptr(syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY) | m.flags
+		if err := syscall.Mount("", m.point, "", flags, ""); err != nil {
+			// Kernel filesystems may refuse; they are replaced or harmless.
+			if withinAny(m.point, []string{"/proc", "/sys", "/dev"}) {
+				continue
+			}
+			fail("make %s read-only: %v", m.point, err)
+		}
+	}
+
+	// A /proc for the new PID namespace, so only sandboxed processes are
+	// visible. Failure leaves the read-only host /proc in place.
+	syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+
+	// Scratch space, unless that would hide a writable path. The media
+	// directory is kept open so it can be bound back into the new /tmp.
+	if !anyWithin(writable, "/tmp") {
+		media, mediaErr := os.Open(utils.MediaDir())
+		if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err == nil && mediaErr == nil {
+			bindMedia(media, mounts)
+		}
+		if mediaErr == nil {
+			media.Close()
+		}
+	}
+
+	if err := dropCapabilities(); err != nil {
+		fail("drop capabilities: %v", err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		fail("chdir: %v", err)
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		fail("%v", err)
+	}
+	if err := syscall.Exec(sh, []string{"sh", "-c", command}, os.Environ()); err != nil {
+		fail("exec: %v", err)
+	}
+}
+
+// bindMedia binds the open media directory read-only at its path, which the
+// /tmp tmpfs has hidden. mounts is the mount table from before, for the flags
+// of the mount the directory came from.
+func bindMedia(media *os.File, mounts []mountInfo) {
+	path := media.Name()
+	if !withinAny(path, []string{"/tmp"}) {
+		return
+	}
+	var flags uintptr
+	longest := -1
+	for _, m := range mounts {
+		if withinAny(path, []string{m.point}) && len(m.point) > longest {
+			flags, longest = m.flags, len(m.point)
+		}
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return
+	}
+	source := fmt.Sprintf("/proc/self/fd/%d", media.Fd())
+	if err := syscall.Mount(source, path, "", syscall.MS_BIND, ""); err != nil {
+		return
+	}
+	syscall.Mount("", path, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|flags, "")
+}
+
+// dropCapabilities clears the bounding, ambient and process capability sets.
+// The shell still runs as root of the user namespace, but without
+// capabilities it cannot undo the read-only mounts.
+func dropCapabilities() error {
+	lastCap := 40
+	if data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			lastCap = n
+		}
+	}
+	for c := 0; c <= lastCap; c++ {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(c), 0); errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("drop bounding capability %d: %v", c, errno)
+		}
+	}
+	syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs: %v", errno)
+	}
+
+	hdr := struct {
+		version uint32
+		pid     int32
+	}{version: linuxCapabilityV3}
+	var data [2]struct {
+		effective, permitted, inheritable uint32
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %v", errno)
+	}
+	return nil
+}
+
+type mountInfo struct {
+	point string
+	flags uintptr // per-mount flags that a remount must keep
+}
+
+var mountOptionFlags = map[string]uintptr{
+	"nosuid":      syscall.MS_NOSUID,
+	"nodev":       syscall.MS_NODEV,
+	"noexec":      syscall.MS_NOEXEC,
+	"noatime":     syscall.MS_NOATIME,
+	"nodiratime":  syscall.MS_NODIRATIME,
+	"relatime":    syscall.MS_RELATIME,
+	"strictatime": syscall.MS_STRICTATIME,
+}
+
+func readMountInfo() ([]mountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		m := mountInfo{point: unescapeMountPath(fields[4])}
+		for _, opt := range strings.Split(fields[5], ",") {
+			m.flags |= mountOptionFlags[opt]
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space etc.) used in
+// /proc/self/mountinfo.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// withinAny reports whether path is one of dirs or below one of them.
+func withinAny(path string, dirs []string) bool {
+	for _, d := range dirs {
+		if path == d || strings.HasPrefix(path, strings.TrimSuffix(d, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// anyWithin reports whether any of paths is dir or below it.
+func anyWithin(paths []string, dir string) bool {
+	for _, p := range paths {
+		if withinAny(p, []string{dir}) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCgroups enables the limit controllers for the groups below
+// cgroupParent and verifies that per-command cgroups can be created.
+func (s *Sandbox) checkCgroups() error {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return fmt.Errorf("cgroup v2 is not mounted at /sys/fs/cgroup")
+	}
+	if err := os.MkdirAll(s.cgroupParent, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.cgroupParent, "cgroup.subtree_control"), []byte(s.limits.controllers()), 0644); err != nil {
+		return fmt.Errorf("enable controllers in %s: %w", s.cgroupParent, err)
+	}
+
+	_, remove, err := s.newCgroup()
+	if err != nil {
+		return err
+	}
+	remove()
+	return nil
+}
+
+// newCgroup creates a cgroup v2 group below cgroupParent, set up by
+// checkCgroups, with the configured limits. It returns an open descriptor of the group for
+// SysProcAttr.CgroupFD and a function that kills anything left in the group
+// and removes it.
+func (s *Sandbox) newCgroup() (int, func(), error) {
+	dir, err := os.MkdirTemp(s.cgroupParent, "exec-")
+	if err != nil {
+		return -1, nil, err
+	}
+	removeDir := func() {
+		os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644)
+		for i := 0; i < 50; i++ {
+			if err := os.Remove(dir); err == nil || os.IsNotExist(err) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for file, value := range s.limits.files() {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			removeDir()
+			return -1, nil, fmt.Errorf("set %s: %w", file, err)
+		}
+	}
+
+	if s.limits.memoryMB > 0 {
+		// Absent without swap accounting; memory.max still applies.
+		os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+	}
+
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		removeDir()
+		return -1, nil, err
+	}
+	return fd, func() {
+		syscall.Close(fd)
+		removeDir()
+	}, nil
+}
+
+func (l cgroupLimits) controllers() string {
+	var c []string
+	if l.cpus > 0 {
+		c = append(c, "+cpu")
+	}
+	if l.memoryMB > 0 {
+		c = append(c, "+memory")
+	}
+	if l.maxPids > 0 {
+		c = append(c, "+pids")
+	}
+	return strings.Join(c, " ")
+}
+
+func (l cgroupLimits) files() map[string]string {
+	files := make(map[string]string)
+	if l.cpus > 0 {
+		const period = 100000
+		files["cpu.max"] = fmt.Sprintf("%d %d", int(l.cpus*period), period)
+	}
+	if l.memoryMB > 0 {
+		files["memory.max"] = strconv.Itoa(l.memoryMB * 1024 * 1024)
+	}
+	if l.maxPids > 0 {
+		files["pids.max"] = strconv.Itoa(l.maxPids)
+	}
+	return files
+}
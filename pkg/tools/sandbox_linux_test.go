@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// newSandboxedExecTool returns an exec tool using the namespaces sandbox,
+// skipping the test where user namespaces are unavailable.
+func newSandboxedExecTool(t *testing.T, workspace string, cfg config.ExecSandboxConfig) *ExecTool {
+	t.Helper()
+	cfg.Mode = SandboxNamespaces
+	tool := NewExecTool(workspace, false)
+	if err := tool.SetSandbox(cfg); err != nil {
+		t.Skipf("namespaces sandbox unavailable: %v", err)
+	}
+	return tool
+}
+
+// TestSandbox_WorkspaceWritableRestReadOnly verifies that only the workspace can be written
+func TestSandbox_WorkspaceWritableRestReadOnly(t *testing.T) {
+	workspace := t.TempDir()
+	outside := t.TempDir()
+	tool := newSandboxedExecTool(t, workspace, config.ExecSandboxConfig{})
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"command": "echo inside > note.txt && cat note.txt",
+	})
+	if result.IsError || !strings.Contains(result.ForLLM, "inside") {
+		t.Fatalf("writing to the workspace failed: %s", result.ForLLM)
+	}
+	if data, err := os.ReadFile(filepath.Join(workspace, "note.txt")); err != nil || string(data) != "inside\n" {
+		t.Errorf("workspace file = %q, %v", data, err)
+	}
+
+	// An obfuscated command gets past the pattern checks, the sandbox still holds.
+	target := filepath.Join(outside, "escaped.txt")
+	result = tool.Execute(context.Background(), map[string]interface{}{
+		"command": `sh -c "$(echo ZWNobyB4ID4g | base64 -d)` + target + `"`,
+	})
+	if !result.IsError {
+		t.Errorf("expected writing outside the workspace to fail, got: %s", result.ForLLM)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("file outside the workspace was created")
+	}
+}
+
+// TestSandbox_MediaReadable verifies that received media stay readable, but
+// not writable, under the private /tmp
+func TestSandbox_MediaReadable(t *testing.T) {
+	if !withinAny(utils.MediaDir(), []string{"/tmp"}) {
+		t.Skip("media are not saved under /tmp")
+	}
+	// The workspace must be outside /tmp, or /tmp is left as it is
+	cwd, _ := os.Getwd()
+	workspace, err := os.MkdirTemp(cwd, "sandbox-workspace-")
+	if err != nil || withinAny(workspace, []string{"/tmp"}) {
+		t.Skip("no workspace outside /tmp")
+	}
+	t.Cleanup(func() { os.RemoveAll(workspace) })
+
+	if err := os.MkdirAll(utils.MediaDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	media := filepath.Join(utils.MediaDir(), "sandbox-test.txt")
+	if err := os.WriteFile(media, []byte("photo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(media) })
+
+	tool := newSandboxedExecTool(t, workspace, config.ExecSandboxConfig{})
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"command": "cat " + media,
+	})
+	if result.IsError || !strings.Contains(result.ForLLM, "photo") {
+		t.Fatalf("reading media failed: %s", result.ForLLM)
+	}
+	result = tool.Execute(context.Background(), map[string]interface{}{
+		"command": "echo x > " + media,
+	})
+	if !result.IsError {
+		t.Errorf("expected writing media to fail, got: %s", result.ForLLM)
+	}
+}
+
+// TestSandbox_CannotRemountWritable verifies that capabilities are dropped
+func TestSandbox_CannotRemountWritable(t *testing.T) {
+	tool := newSandboxedExecTool(t, t.TempDir(), config.ExecSandboxConfig{})
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"command": "mount -o remount,rw / && touch /picoclaw-sandbox-escape",
+	})
+	if !result.IsError {
+		t.Errorf("expected remount to fail, got: %s", result.ForLLM)
+	}
+	if _, err := os.Stat("/picoclaw-sandbox-escape"); err == nil {
+		os.Remove("/picoclaw-sandbox-escape")
+		t.Fatal("sandboxed command remounted / read-write")
+	}
+}
+
+// TestSandbox_IsolatedProcessesAndNetwork verifies the PID and network namespaces
+func TestSandbox_IsolatedProcessesAndNetwork(t *testing.T) {
+	tool := newSandboxedExecTool(t, t.TempDir(), config.ExecSandboxConfig{})
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"command": "echo pid=$$; tail -n +3 /proc/net/dev | cut -d: -f1",
+	})
+	if result.IsError {
+		t.Fatalf("command failed: %s", result.ForLLM)
+	}
+	if !strings.Contains(result.ForLLM, "pid=1\n") {
+		t.Errorf("expected the shell to be PID 1 of its namespace: %s", result.ForLLM)
+	}
+	if strings.TrimSpace(strings.Replace(result.ForLLM, "pid=1", "", 1)) != "lo" {
+		t.Errorf("expected only the loopback interface: %s", result.ForLLM)
+	}
+}
+
+// TestSandbox_UnavailableRefusesCommands verifies that a failed sandbox does not fall back to running unsandboxed
+func TestSandbox_UnavailableRefusesCommands(t *testing.T) {
+	tool := NewExecTool(t.TempDir(), false)
+	if err := tool.SetSandbox(config.ExecSandboxConfig{Mode: "chroot"}); err == nil {
+		t.Fatal("expected an error for an unknown sandbox mode")
+	}
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"command": "echo hello",
+	})
+	if !result.IsError || strings.Contains(result.ForLLM, "hello") {
+		t.Errorf("expected the command to be refused, got: %s", result.ForLLM)
+	}
+}
+
+// TestSandbox_Off verifies that mode off runs commands directly
+func TestSandbox_Off(t *testing.T) {
+	sb, err := NewSandbox(config.ExecSandboxConfig{Mode: SandboxOff}, t.TempDir())
+	if sb != nil || err != nil {
+		t.Errorf("NewSandbox(off) = %v, %v; want nil, nil", sb, err)
+	}
+}
+
+func TestCgroupLimitsFiles(t *testing.T) {
+	l := cgroupLimits{memoryMB: 256, cpus: 0.5, maxPids: 64}
+	files := l.files()
+	if files["memory.max"] != "268435456" || files["cpu.max"] != "50000 100000" || files["pids.max"] != "64" {
+		t.Errorf("files = %v", files)
+	}
+	if got := l.controllers(); got != "+cpu +memory +pids" {
+		t.Errorf("controllers = %q", got)
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	if got := unescapeMountPath(`/mnt/my\040disk`); got != "/mnt/my disk" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// resolveBackend is a stub for non-Linux platforms.
+func (s *Sandbox) resolveBackend(mode string) (string, error) {
+	return "", fmt.Errorf("exec sandbox is only supported on Linux")
+}
+
+// checkCgroups is a stub for non-Linux platforms.
+func (s *Sandbox) checkCgroups() error {
+	return fmt.Errorf("cgroups are only supported on Linux")
+}
+
+// Command is a stub for non-Linux platforms.
+func (s *Sandbox) Command(ctx context.Context, dir, command string) (*exec.Cmd, func(), error) {
+	return nil, nil, fmt.Errorf("exec sandbox is only supported on Linux")
+}
@@ -11,6 +11,8 @@ import (
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
 )
 
 type ExecTool struct {
@@ -19,6 +21,8 @@ type ExecTool struct {
 	denyPatterns        []*regexp.Regexp
 	allowPatterns       []*regexp.Regexp
 	restrictToWorkspace bool
+	sandbox             *Sandbox
+	sandboxErr          error // set when a sandbox was requested but is unavailable
 }
 
 func NewExecTool(workingDir string, restrict bool) *ExecTool {
@@ -46,6 +50,11 @@ func (t *ExecTool) Name() string {
 	return "exec"
 }
 
+// ParallelSafe reports false: the tool runs arbitrary commands, so calls are serialized.
+func (t *ExecTool) ParallelSafe() bool {
+	return false
+}
+
 func (t *ExecTool) Description() string {
 	return "Execute a shell command and return its output. Use with caution."
 }
@@ -89,17 +98,31 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) *To
 		return ErrorResult(guardError)
 	}
 
+	// Never fall back to running unsandboxed when a sandbox was asked for.
+	if t.sandboxErr != nil {
+		return ErrorResult(fmt.Sprintf("Command not run: exec sandbox unavailable: %v", t.sandboxErr))
+	}
+
 	cmdCtx, cancel := context.WithTimeout(ctx, t.timeout)
 	defer cancel()
 
 	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(cmdCtx, "powershell", "-NoProfile", "-NonInteractive", "-Command", command)
+	if t.sandbox != nil {
+		sandboxed, cleanup, err := t.sandbox.Command(cmdCtx, cwd, command)
+		if err != nil {
+			return ErrorResult(fmt.Sprintf("Command not run: %v", err))
+		}
+		defer cleanup()
+		cmd = sandboxed
 	} else {
-		cmd = exec.CommandContext(cmdCtx, "sh", "-c", command)
-	}
-	if cwd != "" {
-		cmd.Dir = cwd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(cmdCtx, "powershell", "-NoProfile", "-NonInteractive", "-Command", command)
+		} else {
+			cmd = exec.CommandContext(cmdCtx, "sh", "-c", command)
+		}
+		if cwd != "" {
+			cmd.Dir = cwd
+		}
 	}
 
 	var stdout, stderr bytes.Buffer
@@ -128,10 +151,7 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) *To
 		output = "(no output)"
 	}
 
-	maxLen := 10000
-	if len(output) > maxLen {
-		output = output[:maxLen] + fmt.Sprintf("\n... (truncated, %d more chars)", len(output)-maxLen)
-	}
+	output = TruncateOutput(output)
 
 	if err != nil {
 		return &ToolResult{
@@ -212,6 +232,14 @@ func (t *ExecTool) SetRestrictToWorkspace(restrict bool) {
 	t.restrictToWorkspace = restrict
 }
 
+// SetSandbox runs commands in the sandbox described by cfg, with the
+// working directory mounted read-write. If the sandbox is not available the
+// error is returned and commands are refused until a later call succeeds.
+func (t *ExecTool) SetSandbox(cfg config.ExecSandboxConfig) error {
+	t.sandbox, t.sandboxErr = NewSandbox(cfg, t.workingDir)
+	return t.sandboxErr
+}
+
 func (t *ExecTool) SetAllowPatterns(patterns []string) error {
 	t.allowPatterns = make([]*regexp.Regexp, 0, len(patterns))
 	for _, p := range patterns {
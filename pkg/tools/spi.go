@@ -20,6 +20,11 @@ func (t *SPITool) Name() string {
 	return "spi"
 }
 
+// ParallelSafe reports false: the tool drives shared bus hardware, so calls are serialized.
+func (t *SPITool) ParallelSafe() bool {
+	return false
+}
+
 func (t *SPITool) Description() string {
 	return "Interact with SPI bus devices for high-speed peripheral communication. Actions: list (find SPI devices), transfer (full-duplex send/receive), read (receive bytes). Linux only."
 }
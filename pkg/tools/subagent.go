@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/usage"
 )
 
 type SubagentTask struct {
@@ -30,6 +32,8 @@ type SubagentManager struct {
 	workspace     string
 	tools         *ToolRegistry
 	maxIterations int
+	maxParallel   int            // Concurrent tool calls per LLM response
+	llmOptions    map[string]any // Generation options for subagent LLM calls
 	nextID        int
 }
 
@@ -42,7 +46,11 @@ func NewSubagentManager(provider providers.LLMProvider, defaultModel, workspace
 		workspace:     workspace,
 		tools:         NewToolRegistry(),
 		maxIterations: 10,
-		nextID:        1,
+		llmOptions: map[string]any{
+			"max_tokens":  4096,
+			"temperature": 0.7,
+		},
+		nextID: 1,
 	}
 }
 
@@ -54,6 +62,28 @@ func (sm *SubagentManager) SetTools(tools *ToolRegistry) {
 	sm.tools = tools
 }
 
+// SetProvider replaces the provider and model used by new subagent tasks.
+func (sm *SubagentManager) SetProvider(provider providers.LLMProvider, model string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.provider = provider
+	sm.defaultModel = model
+}
+
+// SetMaxParallelTools caps how many tool calls from one LLM response run concurrently.
+func (sm *SubagentManager) SetMaxParallelTools(n int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.maxParallel = n
+}
+
+// SetLLMOptions sets the options passed to the provider on every subagent LLM call.
+func (sm *SubagentManager) SetLLMOptions(options map[string]any) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.llmOptions = options
+}
+
 // RegisterTool registers a tool for subagent execution.
 func (sm *SubagentManager) RegisterTool(tool Tool) {
 	sm.mu.Lock()
@@ -91,6 +121,7 @@ func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel
 func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask, callback AsyncCallback) {
 	task.Status = "running"
 	task.Created = time.Now().UnixMilli()
+	ctx = usage.WithPurpose(ctx, config.PurposeSubagent)
 
 	// Build system prompt for subagent
 	systemPrompt := `You are a subagent. Complete the given task independently and report the result.
@@ -123,17 +154,18 @@ After completing the task, provide a clear summary of what was done.`
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	maxParallel := sm.maxParallel
+	llmOptions := sm.llmOptions
+	provider, model := sm.provider, sm.defaultModel
 	sm.mu.RUnlock()
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
+		Provider:         provider,
+		Model:            model,
+		Tools:            tools,
+		MaxIterations:    maxIter,
+		MaxParallelTools: maxParallel,
+		LLMOptions:       llmOptions,
 	}, messages, task.OriginChannel, task.OriginChatID)
 
 	sm.mu.Lock()
@@ -276,23 +308,27 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 		},
 	}
 
+	originChannel, originChatID := targetFromContext(ctx, t.originChannel, t.originChatID)
+	ctx = usage.WithPurpose(ctx, config.PurposeSubagent)
+
 	// Use RunToolLoop to execute with tools (same as async SpawnTool)
 	sm := t.manager
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	maxParallel := sm.maxParallel
+	llmOptions := sm.llmOptions
+	provider, model := sm.provider, sm.defaultModel
 	sm.mu.RUnlock()
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
-	}, messages, t.originChannel, t.originChatID)
+		Provider:         provider,
+		Model:            model,
+		Tools:            tools,
+		MaxIterations:    maxIter,
+		MaxParallelTools: maxParallel,
+		LLMOptions:       llmOptions,
+	}, messages, originChannel, originChatID)
 
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("Subagent execution failed: %v", err)).WithError(err)
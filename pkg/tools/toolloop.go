@@ -18,11 +18,12 @@ import (
 
 // ToolLoopConfig configures the tool execution loop.
 type ToolLoopConfig struct {
-	Provider      providers.LLMProvider
-	Model         string
-	Tools         *ToolRegistry
-	MaxIterations int
-	LLMOptions    map[string]any
+	Provider         providers.LLMProvider
+	Model            string
+	Tools            *ToolRegistry
+	MaxIterations    int
+	MaxParallelTools int // Concurrent tool calls per LLM response; <= 1 runs them in order
+	LLMOptions       map[string]any
 }
 
 // ToolLoopResult contains the result of running the tool loop.
@@ -37,6 +38,10 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 	iteration := 0
 	var finalContent string
 
+	// Give the loop its own per-turn state so tools run here don't report
+	// into (or inherit targets from) a parent agent turn.
+	ctx = WithExecutionContext(ctx, NewExecutionContext(channel, chatID))
+
 	for iteration < config.MaxIterations {
 		iteration++
 
@@ -114,7 +119,18 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 		messages = append(messages, assistantMsg)
 
 		// 7. Execute tool calls
-		for _, tc := range response.ToolCalls {
+		if config.Tools == nil {
+			for _, tc := range response.ToolCalls {
+				messages = append(messages, providers.Message{
+					Role:       "tool",
+					Content:    "No tools available",
+					ToolCallID: tc.ID,
+				})
+			}
+			continue
+		}
+
+		results := config.Tools.ExecuteToolCalls(ctx, response.ToolCalls, config.MaxParallelTools, func(ctx context.Context, tc providers.ToolCall) *ToolResult {
 			argsJSON, _ := json.Marshal(tc.Arguments)
 			argsPreview := utils.Truncate(string(argsJSON), 200)
 			logger.InfoCF("toolloop", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
@@ -124,12 +140,11 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 				})
 
 			// Execute tool (no async callback for subagents - they run independently)
-			var toolResult *ToolResult
-			if config.Tools != nil {
-				toolResult = config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil)
-			} else {
-				toolResult = ErrorResult("No tools available")
-			}
+			return config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil)
+		})
+
+		for i, tc := range response.ToolCalls {
+			toolResult := results[i]
 
 			// Determine content for LLM
 			contentForLLM := toolResult.ForLLM
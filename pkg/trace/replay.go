@@ -0,0 +1,221 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// Sources of a replayed tool result.
+const (
+	SourceRecorded    = "recorded"    // same tool and arguments as in the trace
+	SourceLive        = "live"        // executed with ReplayOptions.Registry
+	SourceMissing     = "missing"     // not in the trace and no registry to run it
+	SourceUnavailable = "unavailable" // the tool was not offered to the model
+)
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	Provider providers.LLMProvider
+	Model    string // defaults to the provider's default model
+
+	// Tools limits the tools offered to the model to these names. Empty
+	// offers the recorded tools, or the registry's when one is set.
+	Tools []string
+
+	// Registry executes tool calls that have no recorded result. Without it
+	// such calls get an error result and nothing is executed.
+	Registry *tools.ToolRegistry
+
+	Options       map[string]interface{} // LLM options, defaults to the recorded ones
+	MaxIterations int                    // defaults to 20
+	Recorder      *Recorder              // records the replay as a new trace when set
+}
+
+// ReplayedCall is a tool call made during a replay.
+type ReplayedCall struct {
+	Name   string
+	Args   map[string]interface{}
+	Source string
+}
+
+// ReplayResult is the outcome of a replay.
+type ReplayResult struct {
+	TraceID    string // ID of the replay's own trace, if recorded
+	Content    string
+	Iterations int
+	ToolCalls  []ReplayedCall
+}
+
+// Replay runs the turn recorded in t again, starting from the conversation
+// of its first LLM request, with opts.Provider and opts.Model. Tool calls
+// the model repeats with the same arguments get their recorded results, so
+// a replay is safe to run by default.
+func Replay(ctx context.Context, t *Trace, opts ReplayOptions) (*ReplayResult, error) {
+	messages := t.Messages()
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("trace %s has no LLM request to replay", t.Info.ID)
+	}
+	model := opts.Model
+	if model == "" {
+		model = opts.Provider.GetDefaultModel()
+	}
+	options := opts.Options
+	if options == nil {
+		options = t.firstOptions()
+	}
+	maxIterations := opts.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = 20
+	}
+
+	toolDefs := t.ToolDefinitions()
+	if opts.Registry != nil {
+		toolDefs = opts.Registry.ToProviderDefs()
+	}
+	toolDefs = filterTools(toolDefs, opts.Tools)
+	offered := make(map[string]bool, len(toolDefs))
+	for _, def := range toolDefs {
+		offered[def.Function.Name] = true
+	}
+
+	recorded := make(map[string][]ToolCall)
+	for _, call := range t.ToolCalls() {
+		key := callKey(call.Name, call.Args)
+		recorded[key] = append(recorded[key], call)
+	}
+
+	turn := opts.Recorder.Start(TurnInfo{
+		SessionKey:  t.Info.SessionKey,
+		Channel:     t.Info.Channel,
+		ChatID:      t.Info.ChatID,
+		Model:       model,
+		Purpose:     t.Info.Purpose,
+		UserMessage: t.Info.UserMessage,
+		Media:       t.Info.Media,
+		ReplayOf:    t.Info.ID,
+	})
+	result := &ReplayResult{TraceID: turn.ID()}
+	ctx = tools.WithExecutionContext(ctx, tools.NewExecutionContext(t.Info.Channel, t.Info.ChatID))
+
+	for result.Iterations < maxIterations {
+		result.Iterations++
+
+		turn.LLMRequest(result.Iterations, model, messages, toolDefs, options)
+		start := time.Now()
+		resp, err := opts.Provider.Chat(ctx, messages, toolDefs, model, options)
+		turn.LLMResponse(result.Iterations, resp, err, time.Since(start))
+		if err != nil {
+			turn.End("", result.Iterations, err)
+			return result, fmt.Errorf("LLM call failed: %w", err)
+		}
+
+		if len(resp.ToolCalls) == 0 {
+			result.Content = resp.Content
+			break
+		}
+
+		assistant := providers.Message{Role: "assistant", Content: resp.Content}
+		for _, tc := range resp.ToolCalls {
+			args, _ := json.Marshal(tc.Arguments)
+			assistant.ToolCalls = append(assistant.ToolCalls, providers.ToolCall{
+				ID:       tc.ID,
+				Type:     "function",
+				Function: &providers.FunctionCall{Name: tc.Name, Arguments: string(args)},
+			})
+		}
+		messages = append(messages, assistant)
+
+		for _, tc := range resp.ToolCalls {
+			start := time.Now()
+			res, source := replayToolCall(ctx, tc, offered, recorded, opts.Registry, t.Info)
+			turn.ToolCall(result.Iterations, tc, res, time.Since(start))
+			result.ToolCalls = append(result.ToolCalls, ReplayedCall{Name: tc.Name, Args: tc.Arguments, Source: source})
+
+			content := res.ForLLM
+			if content == "" && res.Err != nil {
+				content = res.Err.Error()
+			}
+			messages = append(messages, providers.Message{Role: "tool", Content: content, ToolCallID: tc.ID})
+		}
+	}
+
+	turn.End(result.Content, result.Iterations, nil)
+	return result, nil
+}
+
+func replayToolCall(ctx context.Context, tc providers.ToolCall, offered map[string]bool, recorded map[string][]ToolCall, registry *tools.ToolRegistry, info TurnInfo) (*tools.ToolResult, string) {
+	if !offered[tc.Name] {
+		return tools.ErrorResult(fmt.Sprintf("tool %q not found", tc.Name)), SourceUnavailable
+	}
+
+	key := callKey(tc.Name, tc.Arguments)
+	if calls := recorded[key]; len(calls) > 0 {
+		call := calls[0]
+		recorded[key] = calls[1:]
+		return &tools.ToolResult{ForLLM: call.ForLLM, ForUser: call.ForUser, IsError: call.IsError, Async: call.Async}, SourceRecorded
+	}
+
+	if registry != nil {
+		return registry.ExecuteWithContext(ctx, tc.Name, tc.Arguments, info.Channel, info.ChatID, nil), SourceLive
+	}
+	return tools.ErrorResult(fmt.Sprintf("The %s call has no recorded result in this trace, so it was not executed during the replay.", tc.Name)), SourceMissing
+}
+
+// callKey identifies a call by tool name and arguments. encoding/json sorts
+// map keys, so equal arguments give equal keys.
+func callKey(name string, args map[string]interface{}) string {
+	data, _ := json.Marshal(args)
+	return name + "\x00" + string(data)
+}
+
+func filterTools(defs []providers.ToolDefinition, names []string) []providers.ToolDefinition {
+	if len(names) == 0 {
+		return defs
+	}
+	allowed := make(map[string]bool, len(names))
+	for _, n := range names {
+		allowed[n] = true
+	}
+	var filtered []providers.ToolDefinition
+	for _, def := range defs {
+		if allowed[def.Function.Name] {
+			filtered = append(filtered, def)
+		}
+	}
+	return filtered
+}
+
+// firstOptions returns the options of the first LLM request, with the types
+// the providers expect restored after the JSON round trip.
+func (t *Trace) firstOptions() map[string]interface{} {
+	for _, ev := range t.Events {
+		if ev.Type != EventLLMRequest || ev.Options == nil {
+			continue
+		}
+		options := make(map[string]interface{}, len(ev.Options))
+		for k, v := range ev.Options {
+			switch val := v.(type) {
+			case float64:
+				if k == "max_tokens" {
+					v = int(val)
+				}
+			case []interface{}:
+				strs := make([]string, 0, len(val))
+				for _, item := range val {
+					if s, ok := item.(string); ok {
+						strs = append(strs, s)
+					}
+				}
+				v = strs
+			}
+			options[k] = v
+		}
+		return options
+	}
+	return nil
+}
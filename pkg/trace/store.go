@@ -0,0 +1,219 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Trace is a trace read back from disk.
+type Trace struct {
+	Info   TurnInfo
+	Events []Event
+}
+
+// Summary is the overview of a trace shown by "picoclaw trace list".
+type Summary struct {
+	ID          string
+	Time        time.Time
+	Agent       string
+	SessionKey  string
+	Model       string
+	UserMessage string
+	LLMCalls    int
+	ToolCalls   int
+	Iterations  int
+	Duration    time.Duration
+	Error       string
+	Complete    bool // the turn_end event was written
+	ReplayOf    string
+}
+
+// Load reads the trace with the given ID from dir. A unique prefix of the
+// ID, or its random suffix, is enough.
+func Load(dir, id string) (*Trace, error) {
+	path, err := resolve(dir, id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &Trace{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			// A turn cut short by a crash may leave a partial last line
+			continue
+		}
+		if ev.Type == EventTurnStart && ev.Turn != nil {
+			t.Info = *ev.Turn
+		}
+		t.Events = append(t.Events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if t.Info.ID == "" {
+		return nil, fmt.Errorf("trace %s has no turn_start event", id)
+	}
+	return t, nil
+}
+
+// List returns the summaries of the traces in dir, newest first, at most
+// limit of them (0 for all).
+func List(dir string, limit int) ([]Summary, error) {
+	ids, err := listIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	summaries := make([]Summary, 0, len(ids))
+	for _, id := range ids {
+		t, err := Load(dir, id)
+		if err != nil {
+			continue
+		}
+		summaries = append(summaries, t.Summary())
+	}
+	return summaries, nil
+}
+
+// Summary summarizes the trace.
+func (t *Trace) Summary() Summary {
+	s := Summary{
+		ID:          t.Info.ID,
+		Agent:       t.Info.Agent,
+		SessionKey:  t.Info.SessionKey,
+		Model:       t.Info.Model,
+		UserMessage: t.Info.UserMessage,
+		ReplayOf:    t.Info.ReplayOf,
+	}
+	for _, ev := range t.Events {
+		switch ev.Type {
+		case EventTurnStart:
+			s.Time = ev.Time
+		case EventLLMResponse:
+			s.LLMCalls++
+			if ev.Error != "" {
+				s.Error = ev.Error
+			}
+		case EventToolCall:
+			s.ToolCalls++
+		case EventTurnEnd:
+			s.Complete = true
+			s.Iterations = ev.Iterations
+			s.Duration = time.Duration(ev.DurationMS) * time.Millisecond
+			s.Error = ev.Error
+		}
+	}
+	return s
+}
+
+// Messages returns the conversation as sent in the first LLM request: the
+// system prompt, history and the user message.
+func (t *Trace) Messages() []providers.Message {
+	for _, ev := range t.Events {
+		if ev.Type == EventLLMRequest {
+			return append([]providers.Message(nil), ev.Messages...)
+		}
+	}
+	return nil
+}
+
+// ToolDefinitions returns the tools offered to the model.
+func (t *Trace) ToolDefinitions() []providers.ToolDefinition {
+	for _, ev := range t.Events {
+		if ev.Type == EventLLMRequest && ev.Tools != nil {
+			return ev.Tools
+		}
+	}
+	return nil
+}
+
+// ToolCalls returns the recorded tool calls in the order they finished.
+func (t *Trace) ToolCalls() []ToolCall {
+	var calls []ToolCall
+	for _, ev := range t.Events {
+		if ev.Type == EventToolCall && ev.Tool != nil {
+			calls = append(calls, *ev.Tool)
+		}
+	}
+	return calls
+}
+
+// FinalContent returns the reply the turn ended with.
+func (t *Trace) FinalContent() string {
+	for i := len(t.Events) - 1; i >= 0; i-- {
+		if t.Events[i].Type == EventTurnEnd {
+			return t.Events[i].Content
+		}
+	}
+	return ""
+}
+
+func listIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if name := e.Name(); !e.IsDir() && strings.HasSuffix(name, ".jsonl") {
+			ids = append(ids, strings.TrimSuffix(name, ".jsonl"))
+		}
+	}
+	return ids, nil
+}
+
+func resolve(dir, id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid trace ID %q", id)
+	}
+	path := filepath.Join(dir, id+".jsonl")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	ids, err := listIDs(dir)
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, candidate := range ids {
+		if strings.HasPrefix(candidate, id) || strings.HasSuffix(candidate, id) {
+			matches = append(matches, candidate)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("trace %s not found", id)
+	case 1:
+		return filepath.Join(dir, matches[0]+".jsonl"), nil
+	default:
+		return "", fmt.Errorf("trace ID %s is ambiguous (%d matches)", id, len(matches))
+	}
+}
@@ -0,0 +1,333 @@
+// Package trace records a structured trace of every agent turn: the inbound
+// message, each LLM request and response, and each tool call with its full
+// arguments, result and duration. Traces are JSONL files in the workspace and
+// can be listed, shown and replayed against another model or tool set.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// Event types.
+const (
+	EventTurnStart   = "turn_start"
+	EventLLMRequest  = "llm_request"
+	EventLLMResponse = "llm_response"
+	EventToolCall    = "tool_call"
+	EventTurnEnd     = "turn_end"
+)
+
+// TurnInfo describes the turn a trace belongs to. It is the first event of
+// every trace.
+type TurnInfo struct {
+	ID          string   `json:"id"`
+	Agent       string   `json:"agent,omitempty"`
+	SessionKey  string   `json:"session_key,omitempty"`
+	Channel     string   `json:"channel,omitempty"`
+	ChatID      string   `json:"chat_id,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Purpose     string   `json:"purpose,omitempty"`
+	UserMessage string   `json:"user_message"`
+	Media       []string `json:"media,omitempty"`
+	ReplayOf    string   `json:"replay_of,omitempty"` // set on traces written by Replay
+}
+
+// ToolCall is a tool execution and its result.
+type ToolCall struct {
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name"`
+	Args    map[string]interface{} `json:"args"`
+	ForLLM  string                 `json:"for_llm,omitempty"`
+	ForUser string                 `json:"for_user,omitempty"`
+	IsError bool                   `json:"is_error,omitempty"`
+	Async   bool                   `json:"async,omitempty"`
+}
+
+// Event is one line of a trace. Which fields are set depends on Type.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Iteration  int       `json:"iteration,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+
+	Turn *TurnInfo `json:"turn,omitempty"` // turn_start
+
+	// llm_request. Messages holds the messages added since the previous
+	// request, or the whole conversation when Reset is set (the first request,
+	// and after history was compressed).
+	Model    string                     `json:"model,omitempty"`
+	Messages []providers.Message        `json:"messages,omitempty"`
+	Reset    bool                       `json:"reset,omitempty"`
+	Tools    []providers.ToolDefinition `json:"tools,omitempty"` // first request only
+	Options  map[string]interface{}     `json:"options,omitempty"`
+
+	Response *providers.LLMResponse `json:"response,omitempty"` // llm_response
+	Tool     *ToolCall              `json:"tool,omitempty"`     // tool_call
+
+	// turn_end
+	Content    string `json:"content,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+}
+
+// Recorder starts traces in a directory, one file per turn.
+type Recorder struct {
+	dir   string
+	agent string
+}
+
+// NewRecorder creates a recorder writing to dir for the named agent.
+func NewRecorder(dir, agent string) *Recorder {
+	return &Recorder{dir: dir, agent: agent}
+}
+
+// Dir returns the directory traces are written to.
+func (r *Recorder) Dir() string {
+	return r.dir
+}
+
+// Start begins the trace of a turn. A nil Recorder returns a nil Turn, on
+// which every method is a no-op.
+func (r *Recorder) Start(info TurnInfo) *Turn {
+	if r == nil {
+		return nil
+	}
+	if info.ID == "" {
+		info.ID = NewID()
+	}
+	if info.Agent == "" {
+		info.Agent = r.agent
+	}
+	t := &Turn{
+		id:    info.ID,
+		path:  filepath.Join(r.dir, info.ID+".jsonl"),
+		start: time.Now(),
+	}
+	t.write(Event{Type: EventTurnStart, Turn: &info})
+	return t
+}
+
+// Prune removes traces older than maxAge and returns how many were removed.
+func (r *Recorder) Prune(maxAge time.Duration) (int, error) {
+	if r == nil || maxAge <= 0 {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".jsonl" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.dir, e.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Turn writes the events of one turn. Its methods are safe for concurrent
+// use, since tool calls may run in parallel, and do nothing on a nil Turn.
+type Turn struct {
+	id    string
+	path  string
+	start time.Time
+
+	mu          sync.Mutex
+	sent        int               // messages already in the trace
+	last        providers.Message // the last of them, to detect rebuilt conversations
+	toolsLogged bool
+	failed      bool // stop writing after the first error
+}
+
+// ID returns the trace ID, or "" for a nil Turn.
+func (t *Turn) ID() string {
+	if t == nil {
+		return ""
+	}
+	return t.id
+}
+
+// LLMRequest records a request about to be sent to the provider.
+func (t *Turn) LLMRequest(iteration int, model string, messages []providers.Message, toolDefs []providers.ToolDefinition, options map[string]interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	ev := Event{Type: EventLLMRequest, Iteration: iteration, Model: model, Options: options}
+	if t.sent == 0 || len(messages) < t.sent || !sameMessage(messages[t.sent-1], t.last) {
+		ev.Reset = true
+		ev.Messages = stripMediaData(messages)
+	} else {
+		ev.Messages = stripMediaData(messages[t.sent:])
+	}
+	if len(messages) > 0 {
+		t.sent = len(messages)
+		t.last = messages[len(messages)-1]
+	}
+	if !t.toolsLogged {
+		ev.Tools = toolDefs
+		t.toolsLogged = true
+	}
+	t.mu.Unlock()
+	t.write(ev)
+}
+
+// LLMResponse records the provider's response, or the error it returned.
+func (t *Turn) LLMResponse(iteration int, resp *providers.LLMResponse, err error, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.write(Event{
+		Type:       EventLLMResponse,
+		Iteration:  iteration,
+		DurationMS: d.Milliseconds(),
+		Error:      errString(err),
+		Response:   resp,
+	})
+}
+
+// ToolCall records a tool execution.
+func (t *Turn) ToolCall(iteration int, tc providers.ToolCall, result *tools.ToolResult, d time.Duration) {
+	if t == nil {
+		return
+	}
+	call := &ToolCall{ID: tc.ID, Name: tc.Name, Args: tc.Arguments}
+	ev := Event{Type: EventToolCall, Iteration: iteration, DurationMS: d.Milliseconds(), Tool: call}
+	if result != nil {
+		call.ForLLM = result.ForLLM
+		if !result.Silent {
+			call.ForUser = result.ForUser
+		}
+		call.IsError = result.IsError
+		call.Async = result.Async
+		ev.Error = errString(result.Err)
+	}
+	t.write(ev)
+}
+
+// End records the final reply, or the error the turn failed with.
+func (t *Turn) End(content string, iterations int, err error) {
+	if t == nil {
+		return
+	}
+	t.write(Event{
+		Type:       EventTurnEnd,
+		DurationMS: time.Since(t.start).Milliseconds(),
+		Error:      errString(err),
+		Content:    content,
+		Iterations: iterations,
+	})
+}
+
+func (t *Turn) write(ev Event) {
+	ev.Time = time.Now()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		logger.WarnCF("trace", "Failed to encode trace event", map[string]interface{}{
+			"trace": t.id,
+			"type":  ev.Type,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed {
+		return
+	}
+	if err := appendLine(t.path, data); err != nil {
+		t.failed = true
+		logger.WarnCF("trace", "Failed to write trace", map[string]interface{}{
+			"trace": t.id,
+			"error": err.Error(),
+		})
+	}
+}
+
+func appendLine(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+type turnKey struct{}
+
+// WithTurn returns a context carrying t.
+func WithTurn(ctx context.Context, t *Turn) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, turnKey{}, t)
+}
+
+// FromContext returns the turn carried by ctx, or nil.
+func FromContext(ctx context.Context) *Turn {
+	t, _ := ctx.Value(turnKey{}).(*Turn)
+	return t
+}
+
+// NewID returns a trace ID. IDs sort by the time they were created.
+func NewID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+func sameMessage(a, b providers.Message) bool {
+	return a.Role == b.Role && a.Content == b.Content && a.ToolCallID == b.ToolCallID && len(a.ToolCalls) == len(b.ToolCalls)
+}
+
+// stripMediaData drops the base64 payload of attachments, which would make
+// traces huge. The part's type, MIME type and file name are kept.
+func stripMediaData(messages []providers.Message) []providers.Message {
+	out := make([]providers.Message, len(messages))
+	for i, m := range messages {
+		if m.HasMedia() {
+			parts := make([]providers.ContentPart, len(m.Parts))
+			for j, p := range m.Parts {
+				p.Data = ""
+				parts[j] = p
+			}
+			m.Parts = parts
+		}
+		out[i] = m
+	}
+	return out
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,228 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// recordTurn writes a turn in which the model read a file, then answered.
+func recordTurn(t *testing.T, dir string) string {
+	t.Helper()
+	r := NewRecorder(dir, "default")
+	turn := r.Start(TurnInfo{SessionKey: "telegram:42", Channel: "telegram", ChatID: "42", Model: "old-model", UserMessage: "summarize notes.md"})
+
+	messages := []providers.Message{
+		{Role: "system", Content: "You are PicoClaw."},
+		{Role: "user", Content: "summarize notes.md", Parts: []providers.ContentPart{{Type: providers.PartImage, MIMEType: "image/png", Data: "aGVsbG8="}}},
+	}
+	defs := []providers.ToolDefinition{
+		{Type: "function", Function: providers.ToolFunctionDefinition{Name: "read_file"}},
+		{Type: "function", Function: providers.ToolFunctionDefinition{Name: "exec"}},
+	}
+	call := providers.ToolCall{ID: "c1", Name: "read_file", Arguments: map[string]interface{}{"path": "notes.md"}}
+
+	turn.LLMRequest(1, "old-model", messages, defs, map[string]interface{}{"max_tokens": 512, "stop": []string{"END"}})
+	turn.LLMResponse(1, &providers.LLMResponse{ToolCalls: []providers.ToolCall{call}}, nil, 120*time.Millisecond)
+	turn.ToolCall(1, call, tools.SilentResult("buy milk"), 3*time.Millisecond)
+
+	messages = append(messages,
+		providers.Message{Role: "assistant", ToolCalls: []providers.ToolCall{call}},
+		providers.Message{Role: "tool", Content: "buy milk", ToolCallID: "c1"})
+	turn.LLMRequest(2, "old-model", messages, defs, nil)
+	turn.LLMResponse(2, &providers.LLMResponse{Content: "You need milk."}, nil, 80*time.Millisecond)
+	turn.End("You need milk.", 2, nil)
+	return turn.ID()
+}
+
+func TestRecorder_WritesAndLoads(t *testing.T) {
+	dir := t.TempDir()
+	id := recordTurn(t, dir)
+
+	tr, err := Load(dir, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Info.Agent != "default" || tr.Info.UserMessage != "summarize notes.md" {
+		t.Errorf("info = %+v", tr.Info)
+	}
+
+	first, second := tr.Events[1], tr.Events[4]
+	if !first.Reset || len(first.Messages) != 2 || len(first.Tools) != 2 {
+		t.Errorf("first request: reset = %v, %d messages, %d tools", first.Reset, len(first.Messages), len(first.Tools))
+	}
+	if part := first.Messages[1].Parts[0]; part.Data != "" || part.MIMEType != "image/png" {
+		t.Errorf("attachment data should be dropped, got %+v", part)
+	}
+	if second.Reset || len(second.Messages) != 2 || second.Tools != nil {
+		t.Errorf("second request: reset = %v, %d messages, tools = %v", second.Reset, len(second.Messages), second.Tools)
+	}
+
+	s := tr.Summary()
+	if !s.Complete || s.LLMCalls != 2 || s.ToolCalls != 1 || s.Iterations != 2 || s.Error != "" {
+		t.Errorf("summary = %+v", s)
+	}
+
+	// Traces hold whole conversations: only the owner may read them
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && path != dir && info.Mode().Perm()&0077 != 0 {
+			t.Errorf("%s is accessible to others: %v", path, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func TestTurn_ResetAfterRebuild(t *testing.T) {
+	dir := t.TempDir()
+	turn := NewRecorder(dir, "default").Start(TurnInfo{UserMessage: "hi"})
+	turn.LLMRequest(1, "m", []providers.Message{{Role: "system", Content: "a"}, {Role: "user", Content: "hi"}}, nil, nil)
+	// Compressed history: fewer, different messages
+	turn.LLMRequest(1, "m", []providers.Message{{Role: "system", Content: "a (summary)"}}, nil, nil)
+	turn.LLMResponse(1, nil, errors.New("context length exceeded"), time.Millisecond)
+	turn.End("", 1, errors.New("LLM call failed"))
+
+	tr, err := Load(dir, turn.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.Events[2].Reset || len(tr.Events[2].Messages) != 1 {
+		t.Errorf("rebuilt conversation should be recorded in full, got %+v", tr.Events[2])
+	}
+	if s := tr.Summary(); s.Error != "LLM call failed" {
+		t.Errorf("summary error = %q", s.Error)
+	}
+}
+
+func TestNilRecorderAndTurn(t *testing.T) {
+	var r *Recorder
+	turn := r.Start(TurnInfo{})
+	turn.LLMRequest(1, "m", nil, nil, nil)
+	turn.ToolCall(1, providers.ToolCall{}, nil, 0)
+	turn.End("", 0, nil)
+	if turn.ID() != "" || FromContext(WithTurn(context.Background(), turn)) != nil {
+		t.Error("a nil recorder should trace nothing")
+	}
+}
+
+func TestListAndResolve(t *testing.T) {
+	dir := t.TempDir()
+	first := recordTurn(t, dir)
+	time.Sleep(1100 * time.Millisecond) // IDs have second resolution
+	second := recordTurn(t, dir)
+
+	summaries, err := List(dir, 0)
+	if err != nil || len(summaries) != 2 || summaries[0].ID != second || summaries[1].ID != first {
+		t.Fatalf("List = %+v, %v", summaries, err)
+	}
+	if limited, _ := List(dir, 1); len(limited) != 1 {
+		t.Errorf("limit ignored: %d summaries", len(limited))
+	}
+
+	suffix := second[strings.LastIndex(second, "-")+1:]
+	if tr, err := Load(dir, suffix); err != nil || tr.Info.ID != second {
+		t.Errorf("Load by suffix = %v, %v", tr, err)
+	}
+	if _, err := Load(dir, "2"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ambiguous prefix: err = %v", err)
+	}
+	if _, err := Load(dir, "../etc/passwd"); err == nil {
+		t.Error("paths must be rejected")
+	}
+}
+
+func TestRecorder_Prune(t *testing.T) {
+	dir := t.TempDir()
+	old := recordTurn(t, dir)
+	recent := recordTurn(t, dir)
+	past := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(dir, old+".jsonl"), past, past)
+
+	n, err := NewRecorder(dir, "default").Prune(24 * time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("Prune = %d, %v", n, err)
+	}
+	if _, err := Load(dir, recent); err != nil {
+		t.Errorf("recent trace was removed: %v", err)
+	}
+}
+
+type scriptedProvider struct {
+	responses []*providers.LLMResponse
+	requests  [][]providers.Message
+	tools     []providers.ToolDefinition
+	options   map[string]interface{}
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	p.requests = append(p.requests, append([]providers.Message(nil), messages...))
+	p.tools, p.options = tools, options
+	resp := p.responses[0]
+	p.responses = p.responses[1:]
+	return resp, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string { return "new-model" }
+
+func TestReplay_UsesRecordedResults(t *testing.T) {
+	dir := t.TempDir()
+	tr, err := Load(dir, recordTurn(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &scriptedProvider{responses: []*providers.LLMResponse{
+		{ToolCalls: []providers.ToolCall{
+			{ID: "r1", Name: "read_file", Arguments: map[string]interface{}{"path": "notes.md"}},
+			{ID: "r2", Name: "read_file", Arguments: map[string]interface{}{"path": "todo.md"}},
+			{ID: "r3", Name: "exec", Arguments: map[string]interface{}{"command": "rm -rf /"}},
+		}},
+		{Content: "Milk, apparently."},
+	}}
+
+	result, err := Replay(context.Background(), tr, ReplayOptions{
+		Provider: provider,
+		Tools:    []string{"read_file"},
+		Recorder: NewRecorder(dir, "default"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Content != "Milk, apparently." || result.Iterations != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	var sources []string
+	for _, c := range result.ToolCalls {
+		sources = append(sources, c.Source)
+	}
+	if got := strings.Join(sources, ","); got != "recorded,missing,unavailable" {
+		t.Errorf("sources = %s", got)
+	}
+	if len(provider.tools) != 1 || provider.tools[0].Function.Name != "read_file" {
+		t.Errorf("offered tools = %+v", provider.tools)
+	}
+	if _, ok := provider.options["max_tokens"].(int); !ok {
+		t.Errorf("max_tokens should be an int again, got %T", provider.options["max_tokens"])
+	}
+	if stop, ok := provider.options["stop"].([]string); !ok || len(stop) != 1 {
+		t.Errorf("stop should be a []string again, got %#v", provider.options["stop"])
+	}
+	if got := provider.requests[1][3].Content; got != "buy milk" {
+		t.Errorf("recorded tool result not replayed, got %q", got)
+	}
+
+	replayTrace, err := Load(dir, result.TraceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayTrace.Info.ReplayOf != tr.Info.ID || replayTrace.Info.Model != "new-model" || replayTrace.FinalContent() != "Milk, apparently." {
+		t.Errorf("replay trace = %+v", replayTrace.Info)
+	}
+}
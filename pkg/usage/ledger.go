@@ -0,0 +1,278 @@
+// Package usage records token usage and cost of every LLM call in a ledger
+// shared by all agents and enforces daily budgets.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+const dayFormat = "2006-01-02"
+
+// Record is one LLM call in the ledger.
+type Record struct {
+	Time             time.Time `json:"time"`
+	Agent            string    `json:"agent,omitempty"`
+	SessionKey       string    `json:"session,omitempty"`
+	Channel          string    `json:"channel,omitempty"`
+	ChatID           string    `json:"chat_id,omitempty"`
+	Purpose          string    `json:"purpose,omitempty"`
+	Model            string    `json:"model"`
+	Backend          string    `json:"backend,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	Cost             float64   `json:"cost"` // USD, zero if the model has no price
+}
+
+// Totals aggregates a set of records.
+type Totals struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (t *Totals) Add(rec Record) {
+	t.Calls++
+	t.PromptTokens += rec.PromptTokens
+	t.CompletionTokens += rec.CompletionTokens
+	t.TotalTokens += rec.TotalTokens
+	t.Cost += rec.Cost
+}
+
+// Ledger appends records to one JSONL file per day under dir and keeps
+// today's totals in memory for budget checks.
+type Ledger struct {
+	dir string
+
+	mu     sync.Mutex
+	prices map[string]config.ModelPrice
+	day    string
+	today  Totals
+}
+
+var (
+	ledgersMu sync.Mutex
+	ledgers   = map[string]*Ledger{}
+)
+
+// OpenLedger returns the ledger in dir, opening it on first use. Callers in
+// the same process get the same ledger for a dir, so the agents writing to
+// it share today's totals and the daily budgets.
+func OpenLedger(dir string, prices map[string]config.ModelPrice) *Ledger {
+	dir = filepath.Clean(dir)
+	ledgersMu.Lock()
+	defer ledgersMu.Unlock()
+	if l, ok := ledgers[dir]; ok {
+		return l
+	}
+	l := NewLedger(dir, prices)
+	ledgers[dir] = l
+	return l
+}
+
+// NewLedger opens the ledger in dir, loading today's totals if the file exists.
+func NewLedger(dir string, prices map[string]config.ModelPrice) *Ledger {
+	l := &Ledger{dir: dir, prices: prices}
+	l.day = time.Now().Format(dayFormat)
+	if records, err := readDay(dir, l.day); err == nil {
+		for _, rec := range records {
+			l.today.Add(rec)
+		}
+	}
+	return l
+}
+
+// Dir returns the directory the ledger writes to.
+func (l *Ledger) Dir() string {
+	return l.dir
+}
+
+// SetPrices replaces the prices of calls recorded from now on, e.g. after a
+// config reload. Records already written keep their cost.
+func (l *Ledger) SetPrices(prices map[string]config.ModelPrice) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prices = prices
+}
+
+// Price returns the cost in USD of a call to model, or 0 if the model has no
+// configured price. Models are matched exactly, then without a vendor prefix
+// ("openrouter/anthropic/claude-x" matches "claude-x").
+func (l *Ledger) Price(model string, promptTokens, completionTokens int) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	price, ok := l.prices[model]
+	if !ok {
+		if idx := strings.LastIndex(model, "/"); idx >= 0 {
+			price, ok = l.prices[model[idx+1:]]
+		}
+	}
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.InputPerMillion + float64(completionTokens)*price.OutputPerMillion) / 1e6
+}
+
+// Add prices and appends a record.
+func (l *Ledger) Add(rec Record) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	if rec.TotalTokens == 0 {
+		rec.TotalTokens = rec.PromptTokens + rec.CompletionTokens
+	}
+	if rec.Cost == 0 {
+		rec.Cost = l.Price(rec.Model, rec.PromptTokens, rec.CompletionTokens)
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	day := rec.Time.Format(dayFormat)
+	if day != l.day {
+		l.day = day
+		l.today = Totals{}
+	}
+	l.today.Add(rec)
+
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(l.dir, day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Today returns the totals of all calls made today.
+func (l *Ledger) Today() Totals {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if day := time.Now().Format(dayFormat); day != l.day {
+		l.day = day
+		l.today = Totals{}
+	}
+	return l.today
+}
+
+// Load reads all records from dir made on or after since.
+func Load(dir string, since time.Time) ([]Record, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sinceDay := since.Format(dayFormat)
+	var records []Record
+	for _, entry := range entries {
+		day, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || day < sinceDay {
+			continue
+		}
+		dayRecords, err := readDay(dir, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range dayRecords {
+			if !rec.Time.Before(since) {
+				records = append(records, rec)
+			}
+		}
+	}
+	return records, nil
+}
+
+func readDay(dir, day string) ([]Record, error) {
+	f, err := os.Open(filepath.Join(dir, day+".jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // skip a line torn by a crash mid-write
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// Group is one row of a breakdown.
+type Group struct {
+	Key string
+	Totals
+}
+
+// Grouping functions for Breakdown.
+var (
+	ByDay     = func(r Record) string { return r.Time.Format(dayFormat) }
+	ByAgent   = agentOf
+	BySession = func(r Record) string { return r.SessionKey }
+	ByChannel = func(r Record) string { return r.Channel }
+	ByModel   = func(r Record) string { return r.Model }
+)
+
+// agentOf returns the agent that made the call. Records without one predate
+// agents being recorded and come from the default agent.
+func agentOf(r Record) string {
+	if r.Agent == "" {
+		return config.DefaultAgentName
+	}
+	return r.Agent
+}
+
+// Breakdown aggregates records by key, sorted by key.
+func Breakdown(records []Record, key func(Record) string) []Group {
+	index := map[string]int{}
+	var groups []Group
+	for _, rec := range records {
+		k := key(rec)
+		if k == "" {
+			k = "-"
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, Group{Key: k})
+		}
+		groups[i].Add(rec)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups
+}
+
+// FormatCost formats a USD amount for display.
+func FormatCost(cost float64) string {
+	if cost > 0 && cost < 0.01 {
+		return fmt.Sprintf("$%.4f", cost)
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
@@ -0,0 +1,162 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+var testPrices = map[string]config.ModelPrice{
+	"gpt-4o":   {InputPerMillion: 2.5, OutputPerMillion: 10},
+	"claude-x": {InputPerMillion: 3, OutputPerMillion: 15},
+}
+
+func newTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "usage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return NewLedger(dir, testPrices)
+}
+
+func TestLedger_Price(t *testing.T) {
+	l := newTestLedger(t)
+	if got := l.Price("gpt-4o", 1_000_000, 100_000); got != 3.5 {
+		t.Errorf("Price(gpt-4o) = %v, want 3.5", got)
+	}
+	if got := l.Price("openrouter/anthropic/claude-x", 1_000_000, 0); got != 3 {
+		t.Errorf("Price with vendor prefix = %v, want 3", got)
+	}
+	if got := l.Price("unknown", 1000, 1000); got != 0 {
+		t.Errorf("Price(unknown) = %v, want 0", got)
+	}
+
+	l.SetPrices(map[string]config.ModelPrice{"unknown": {InputPerMillion: 1}})
+	if got := l.Price("unknown", 1_000_000, 0); got != 1 {
+		t.Errorf("Price after SetPrices = %v, want 1", got)
+	}
+}
+
+func TestLedger_AddPersistsAndReloads(t *testing.T) {
+	l := newTestLedger(t)
+	l.Add(Record{SessionKey: "telegram:1", Channel: "telegram", Model: "gpt-4o", PromptTokens: 1000, CompletionTokens: 500})
+	l.Add(Record{SessionKey: "slack:2", Channel: "slack", Model: "gpt-4o", PromptTokens: 2000, CompletionTokens: 100})
+
+	today := l.Today()
+	if today.Calls != 2 || today.TotalTokens != 3600 {
+		t.Errorf("Unexpected totals: %+v", today)
+	}
+
+	// A new ledger on the same directory picks up today's totals
+	reopened := NewLedger(l.Dir(), testPrices)
+	if reopened.Today() != today {
+		t.Errorf("Reloaded totals %+v, want %+v", reopened.Today(), today)
+	}
+
+	records, err := Load(l.Dir(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	groups := Breakdown(records, ByChannel)
+	if len(groups) != 2 || groups[0].Key != "slack" || groups[1].Key != "telegram" {
+		t.Fatalf("Unexpected channel breakdown: %+v", groups)
+	}
+	if groups[1].Cost != l.Price("gpt-4o", 1000, 500) {
+		t.Errorf("telegram cost = %v", groups[1].Cost)
+	}
+}
+
+func TestOpenLedger_Shared(t *testing.T) {
+	dir := t.TempDir()
+	l := OpenLedger(dir, testPrices)
+	if OpenLedger(dir+"/", testPrices) != l {
+		t.Fatal("Expected the same ledger for the same directory")
+	}
+
+	l.Add(Record{Agent: "ops", Model: "gpt-4o", PromptTokens: 10})
+	l.Add(Record{Model: "gpt-4o", PromptTokens: 20})
+	records, _ := Load(dir, time.Now().Add(-time.Hour))
+	groups := Breakdown(records, ByAgent)
+	if len(groups) != 2 || groups[0].Key != "default" || groups[1].Key != "ops" {
+		t.Errorf("Unexpected agent breakdown: %+v", groups)
+	}
+}
+
+func TestBudgetStatus(t *testing.T) {
+	cfg := config.UsageConfig{DailyBudgetUSD: 1, DailyTokenBudget: 10000}
+	if over, _ := BudgetStatus(cfg, Totals{Cost: 0.5, TotalTokens: 500}); over {
+		t.Error("Expected usage under budget")
+	}
+	if over, reason := BudgetStatus(cfg, Totals{Cost: 1.2}); !over || reason == "" {
+		t.Error("Expected cost budget to be exceeded")
+	}
+	if over, _ := BudgetStatus(cfg, Totals{TotalTokens: 20000}); !over {
+		t.Error("Expected token budget to be exceeded")
+	}
+}
+
+type usageMockProvider struct {
+	models []string
+}
+
+func (m *usageMockProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.models = append(m.models, model)
+	return &providers.LLMResponse{
+		Content: "ok",
+		Usage:   &providers.UsageInfo{PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120},
+	}, nil
+}
+
+func (m *usageMockProvider) GetDefaultModel() string {
+	return "gpt-4o"
+}
+
+func TestProvider_RecordsScope(t *testing.T) {
+	l := newTestLedger(t)
+	p := NewProvider(&usageMockProvider{}, l, "ops", config.UsageConfig{})
+
+	ctx := WithScope(context.Background(), Scope{SessionKey: "telegram:1", Channel: "telegram", ChatID: "1", Purpose: "chat"})
+	ctx = WithPurpose(ctx, "subagent")
+	if _, err := p.Chat(ctx, nil, nil, "gpt-4o", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	records, _ := Load(l.Dir(), time.Now().Add(-time.Hour))
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	if rec.Agent != "ops" || rec.SessionKey != "telegram:1" || rec.Purpose != "subagent" || rec.TotalTokens != 120 || rec.Cost == 0 {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+}
+
+func TestProvider_EnforcesBudget(t *testing.T) {
+	l := newTestLedger(t)
+	l.Add(Record{Model: "gpt-4o", PromptTokens: 500})
+	ctx := WithScope(context.Background(), Scope{Purpose: "summarize"})
+
+	mock := &usageMockProvider{}
+	p := NewProvider(mock, l, "", config.UsageConfig{DailyTokenBudget: 100})
+	if _, err := p.Chat(ctx, nil, nil, "gpt-4o", nil); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Chat over budget: err = %v, want ErrBudgetExceeded", err)
+	}
+	if len(mock.models) != 0 {
+		t.Error("Expected no call to the inner provider")
+	}
+
+	p = NewProvider(mock, l, "", config.UsageConfig{DailyTokenBudget: 100, BudgetAction: "downgrade", DowngradeModel: "gpt-4o-mini"})
+	if _, err := p.Chat(ctx, nil, nil, "gpt-4o", nil); err != nil {
+		t.Fatalf("Chat with downgrade failed: %v", err)
+	}
+	if len(mock.models) != 1 || mock.models[0] != "gpt-4o-mini" {
+		t.Errorf("Inner provider got models %v, want the downgrade model", mock.models)
+	}
+}
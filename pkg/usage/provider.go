@@ -0,0 +1,143 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Scope identifies who an LLM call is made for. The agent attaches it to the
+// context of every call so the ledger can break usage down.
+type Scope struct {
+	SessionKey string
+	Channel    string
+	ChatID     string
+	Purpose    string
+}
+
+type scopeKey struct{}
+
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// WithPurpose keeps the current scope but changes its purpose, e.g. for a
+// subagent started from a chat turn.
+func WithPurpose(ctx context.Context, purpose string) context.Context {
+	scope := ScopeFrom(ctx)
+	scope.Purpose = purpose
+	return WithScope(ctx, scope)
+}
+
+func ScopeFrom(ctx context.Context) Scope {
+	scope, _ := ctx.Value(scopeKey{}).(Scope)
+	return scope
+}
+
+// ErrBudgetExceeded is returned for calls refused because today's usage is
+// over the daily budget.
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// Provider wraps an LLMProvider and records the usage of every successful
+// call in a Ledger, on behalf of agent. Every call is checked against the
+// daily budgets first, so summaries and subagents are held to them as well
+// as chat turns.
+type Provider struct {
+	inner  providers.LLMProvider
+	ledger *Ledger
+	agent  string
+	budget config.UsageConfig
+}
+
+func NewProvider(inner providers.LLMProvider, ledger *Ledger, agent string, budget config.UsageConfig) *Provider {
+	return &Provider{inner: inner, ledger: ledger, agent: agent, budget: budget}
+}
+
+func (p *Provider) GetDefaultModel() string {
+	return p.inner.GetDefaultModel()
+}
+
+func (p *Provider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	model, err := p.allow(model)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.inner.Chat(ctx, messages, tools, model, options)
+	if err == nil {
+		p.record(ctx, model, resp)
+	}
+	return resp, err
+}
+
+func (p *Provider) ChatStream(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}, onDelta providers.StreamCallback) (*providers.LLMResponse, error) {
+	sp, ok := p.inner.(providers.StreamingProvider)
+	if !ok {
+		return p.Chat(ctx, messages, tools, model, options)
+	}
+	model, err := p.allow(model)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sp.ChatStream(ctx, messages, tools, model, options, onDelta)
+	if err == nil {
+		p.record(ctx, model, resp)
+	}
+	return resp, err
+}
+
+// allow checks today's usage before a call to model. Over budget, the call
+// is moved to the downgrade model or refused with ErrBudgetExceeded.
+func (p *Provider) allow(model string) (string, error) {
+	over, reason := BudgetStatus(p.budget, p.ledger.Today())
+	if !over {
+		return model, nil
+	}
+	if p.budget.BudgetAction == "downgrade" && p.budget.DowngradeModel != "" {
+		return p.budget.DowngradeModel, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrBudgetExceeded, reason)
+}
+
+func (p *Provider) record(ctx context.Context, model string, resp *providers.LLMResponse) {
+	scope := ScopeFrom(ctx)
+	rec := Record{
+		Agent:      p.agent,
+		SessionKey: scope.SessionKey,
+		Channel:    scope.Channel,
+		ChatID:     scope.ChatID,
+		Purpose:    scope.Purpose,
+		Model:      model,
+		Backend:    resp.Backend,
+	}
+	if resp.Model != "" {
+		rec.Model = resp.Model
+	}
+	if resp.Usage != nil {
+		rec.PromptTokens = resp.Usage.PromptTokens
+		rec.CompletionTokens = resp.Usage.CompletionTokens
+		rec.TotalTokens = resp.Usage.TotalTokens
+	}
+	if err := p.ledger.Add(rec); err != nil {
+		logger.WarnCF("usage", "Failed to record usage", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// BudgetStatus reports whether today's usage exceeds the configured caps.
+// It returns a human readable reason when over budget.
+func BudgetStatus(cfg config.UsageConfig, today Totals) (bool, string) {
+	if cfg.DailyBudgetUSD > 0 && today.Cost >= cfg.DailyBudgetUSD {
+		return true, fmt.Sprintf("daily budget of %s reached (%s spent today)",
+			FormatCost(cfg.DailyBudgetUSD), FormatCost(today.Cost))
+	}
+	if cfg.DailyTokenBudget > 0 && today.TotalTokens >= cfg.DailyTokenBudget {
+		return true, fmt.Sprintf("daily budget of %d tokens reached (%d used today)",
+			cfg.DailyTokenBudget, today.TotalTokens)
+	}
+	return false, ""
+}